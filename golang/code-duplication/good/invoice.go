@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidInvoice = errors.New("invalid invoice")
+	ErrOverCredit     = errors.New("credit exceeds what was invoiced")
+)
+
+type DocumentKind string
+
+const (
+	KindInvoice    DocumentKind = "invoice"
+	KindCreditNote DocumentKind = "credit_note"
+)
+
+const defaultPaymentTerms = 30
+
+// InvoiceSequence hands out document numbers per prefix and year. Numbers
+// are only drawn once an invoice has been fully priced, so a failed invoice
+// never leaves a hole in the series.
+type InvoiceSequence struct {
+	mu   sync.Mutex
+	last map[string]int
+}
+
+func NewInvoiceSequence() *InvoiceSequence {
+	return &InvoiceSequence{last: map[string]int{}}
+}
+
+// Resume continues a series from the last number already issued, e.g. one
+// read back from storage at startup.
+func (s *InvoiceSequence) Resume(prefix string, year, last int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last[sequenceKey(prefix, year)] = last
+}
+
+func (s *InvoiceSequence) Next(prefix string, year int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := sequenceKey(prefix, year)
+	s.last[key]++
+	return fmt.Sprintf("%s-%d-%06d", prefix, year, s.last[key])
+}
+
+func sequenceKey(prefix string, year int) string {
+	return fmt.Sprintf("%s-%d", prefix, year)
+}
+
+type Party struct {
+	Name    string
+	Address []string
+}
+
+type InvoiceRequest struct {
+	Customer    Party
+	Destination Destination
+	Lines       []LineItemRequest
+	Weight      float64
+	Carrier     string
+	DueInDays   int
+	Notes       string
+}
+
+type Invoice struct {
+	Number      string
+	Kind        DocumentKind
+	Seller      Party
+	Customer    Party
+	Destination Destination
+	IssuedAt    time.Time
+	DueAt       time.Time
+	Lines       []LineItem
+	Shipping    *ShippingCharge
+	Totals
+	Notes string
+	// Credits is the invoice number a credit note reverses.
+	Credits string
+}
+
+func (inv Invoice) Title() string {
+	if inv.Kind == KindCreditNote {
+		return "Credit Note"
+	}
+	return "Invoice"
+}
+
+func (inv Invoice) ShippingTotal() Money {
+	if inv.Shipping == nil {
+		return NewMoney(0, inv.Total.Currency())
+	}
+	return inv.Shipping.Total
+}
+
+type InvoiceGenerator struct {
+	pricing  *PricingEngine
+	sequence *InvoiceSequence
+	seller   Party
+	now      func() time.Time
+
+	mu       sync.Mutex
+	issued   map[string]*Invoice
+	credited map[string][]int
+	shipping map[string]bool
+}
+
+func NewInvoiceGenerator(pricing *PricingEngine, sequence *InvoiceSequence, seller Party) *InvoiceGenerator {
+	return &InvoiceGenerator{
+		pricing:  pricing,
+		sequence: sequence,
+		seller:   seller,
+		now:      time.Now,
+		issued:   map[string]*Invoice{},
+		credited: map[string][]int{},
+		shipping: map[string]bool{},
+	}
+}
+
+func (ig *InvoiceGenerator) CalculateTax(price Money, category string, destination Destination) (Money, error) {
+	return ig.pricing.CalculateTax(price, category, destination)
+}
+
+func (ig *InvoiceGenerator) CalculateShipping(weight float64, destination Destination, carrier string) (ShippingCharge, error) {
+	return ig.pricing.CalculateShipping(weight, destination, carrier)
+}
+
+// Issue prices every line, adds shipping when a weight is given, and only
+// then numbers the invoice.
+func (ig *InvoiceGenerator) Issue(request InvoiceRequest) (*Invoice, error) {
+	if strings.TrimSpace(request.Customer.Name) == "" {
+		return nil, fmt.Errorf("%w: customer name is required", ErrInvalidInvoice)
+	}
+	if len(request.Lines) == 0 {
+		return nil, fmt.Errorf("%w: at least one line is required", ErrInvalidInvoice)
+	}
+
+	currency := ig.pricing.Currency()
+	invoice := &Invoice{
+		Kind:        KindInvoice,
+		Seller:      ig.seller,
+		Customer:    request.Customer,
+		Destination: request.Destination,
+		Notes:       request.Notes,
+	}
+	for i, lineRequest := range request.Lines {
+		line, err := ig.pricing.PriceLine(lineRequest, request.Destination)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		invoice.Lines = append(invoice.Lines, line)
+	}
+	if request.Weight > 0 {
+		charge, err := ig.CalculateShipping(request.Weight, request.Destination, request.Carrier)
+		if err != nil {
+			return nil, err
+		}
+		invoice.Shipping = &charge
+	}
+	totals, err := SumLines(invoice.Lines, invoice.Shipping, currency)
+	if err != nil {
+		return nil, err
+	}
+	invoice.Totals = totals
+
+	terms := request.DueInDays
+	if terms <= 0 {
+		terms = defaultPaymentTerms
+	}
+
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+	invoice.IssuedAt = ig.now()
+	invoice.DueAt = invoice.IssuedAt.AddDate(0, 0, terms)
+	invoice.Number = ig.sequence.Next("INV", invoice.IssuedAt.Year())
+	ig.issued[invoice.Number] = invoice
+	ig.credited[invoice.Number] = make([]int, len(invoice.Lines))
+	return invoice, nil
+}
+
+// CreditNote reverses some or all of an issued invoice. quantities maps
+// line index to units credited; nil credits everything not yet credited,
+// shipping included. Partial credits take each line's discount and tax in
+// proportion.
+func (ig *InvoiceGenerator) CreditNote(invoiceNumber, reason string, quantities map[int]int) (*Invoice, error) {
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+
+	original, ok := ig.issued[invoiceNumber]
+	if !ok || original.Kind != KindInvoice {
+		return nil, fmt.Errorf("%w: no invoice %q", ErrInvalidInvoice, invoiceNumber)
+	}
+	credited := ig.credited[invoiceNumber]
+	full := quantities == nil
+	if full {
+		quantities = map[int]int{}
+		for i, line := range original.Lines {
+			if remaining := line.Quantity - credited[i]; remaining > 0 {
+				quantities[i] = remaining
+			}
+		}
+	}
+
+	currency := original.Total.Currency()
+	note := &Invoice{
+		Kind:        KindCreditNote,
+		Seller:      original.Seller,
+		Customer:    original.Customer,
+		Destination: original.Destination,
+		Notes:       reason,
+		Credits:     original.Number,
+	}
+	for i, quantity := range quantities {
+		if i < 0 || i >= len(original.Lines) {
+			return nil, fmt.Errorf("%w: no line %d on %s", ErrInvalidInvoice, i+1, original.Number)
+		}
+		if line := original.Lines[i]; quantity < 0 || credited[i]+quantity > line.Quantity {
+			return nil, fmt.Errorf("%w: line %d has %d of %d units left to credit", ErrOverCredit, i+1, line.Quantity-credited[i], line.Quantity)
+		}
+	}
+	for i, line := range original.Lines {
+		if quantity := quantities[i]; quantity > 0 {
+			credit, err := creditLine(line, credited[i], quantity)
+			if err != nil {
+				return nil, err
+			}
+			note.Lines = append(note.Lines, credit)
+		}
+	}
+	if full && original.Shipping != nil && !ig.shipping[invoiceNumber] {
+		refund := ShippingCharge{
+			Carrier: original.Shipping.Carrier,
+			Zone:    original.Shipping.Zone,
+			Base:    original.Shipping.Base.Negate(),
+			Total:   original.Shipping.Total.Negate(),
+		}
+		for _, surcharge := range original.Shipping.Surcharges {
+			refund.Surcharges = append(refund.Surcharges, Surcharge{Name: surcharge.Name, Amount: surcharge.Amount.Negate()})
+		}
+		note.Shipping = &refund
+	}
+	if len(note.Lines) == 0 && note.Shipping == nil {
+		return nil, fmt.Errorf("%w: nothing left to credit on %s", ErrOverCredit, original.Number)
+	}
+	totals, err := SumLines(note.Lines, note.Shipping, currency)
+	if err != nil {
+		return nil, err
+	}
+	note.Totals = totals
+
+	note.IssuedAt = ig.now()
+	note.DueAt = note.IssuedAt
+	note.Number = ig.sequence.Next("CN", note.IssuedAt.Year())
+	for i, quantity := range quantities {
+		credited[i] += quantity
+	}
+	if note.Shipping != nil {
+		ig.shipping[invoiceNumber] = true
+	}
+	ig.issued[note.Number] = note
+	return note, nil
+}
+
+// creditLine negates the share of a line being credited. Amounts are
+// worked out cumulatively, so crediting the last units of a line settles
+// any rounding left by earlier partial credits.
+func creditLine(line LineItem, alreadyCredited, quantity int) (LineItem, error) {
+	before, err := lineShare(line, alreadyCredited)
+	if err != nil {
+		return LineItem{}, err
+	}
+	after, err := lineShare(line, alreadyCredited+quantity)
+	if err != nil {
+		return LineItem{}, err
+	}
+
+	credit := LineItem{
+		Description: line.Description,
+		Category:    line.Category,
+		Quantity:    -quantity,
+		UnitPrice:   line.UnitPrice,
+		TaxRate:     line.TaxRate,
+	}
+	if credit.Gross, err = before.Gross.Subtract(after.Gross); err != nil {
+		return LineItem{}, err
+	}
+	if credit.Discount, err = before.Discount.Subtract(after.Discount); err != nil {
+		return LineItem{}, err
+	}
+	if credit.Tax, err = before.Tax.Subtract(after.Tax); err != nil {
+		return LineItem{}, err
+	}
+	if credit.Net, err = credit.Gross.Subtract(credit.Discount); err != nil {
+		return LineItem{}, err
+	}
+	if credit.Total, err = credit.Net.Add(credit.Tax); err != nil {
+		return LineItem{}, err
+	}
+	return credit, nil
+}
+
+func lineShare(line LineItem, quantity int) (LineItem, error) {
+	if quantity == line.Quantity {
+		return line, nil
+	}
+	var share LineItem
+	var err error
+	if share.Gross, err = line.UnitPrice.Multiply(float64(quantity), RoundHalfUp); err != nil {
+		return LineItem{}, err
+	}
+	if share.Discount, err = line.Discount.Multiply(float64(quantity)/float64(line.Quantity), RoundHalfUp); err != nil {
+		return LineItem{}, err
+	}
+	net, err := share.Gross.Subtract(share.Discount)
+	if err != nil {
+		return LineItem{}, err
+	}
+	if share.Tax, err = net.Multiply(line.TaxRate, RoundHalfUp); err != nil {
+		return LineItem{}, err
+	}
+	return share, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"strconv"
+)
+
+var invoiceTemplate = template.Must(template.New("invoice").Funcs(template.FuncMap{
+	"money":   func(m Money) string { return m.Format() },
+	"date":    func(inv *Invoice) string { return inv.IssuedAt.Format("2006-01-02") },
+	"due":     func(inv *Invoice) string { return inv.DueAt.Format("2006-01-02") },
+	"percent": func(rate float64) string { return strconv.FormatFloat(rate*100, 'f', -1, 64) + "%" },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} {{.Number}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { padding: 4px 8px; border-bottom: 1px solid #ddd; }
+td.amount, th.amount { text-align: right; }
+</style>
+</head>
+<body>
+<h1>{{.Title}} {{.Number}}</h1>
+{{if .Credits}}<p>Credits invoice {{.Credits}}</p>{{end}}
+<p>Issued {{date .}}{{if eq .Kind "invoice"}}, due {{due .}}{{end}}</p>
+<table>
+<tr><td><strong>{{.Seller.Name}}</strong>{{range .Seller.Address}}<br>{{.}}{{end}}</td>
+<td><strong>Bill to: {{.Customer.Name}}</strong>{{range .Customer.Address}}<br>{{.}}{{end}}</td></tr>
+</table>
+<table>
+<tr><th>Description</th><th class="amount">Qty</th><th class="amount">Unit price</th><th class="amount">Discount</th><th class="amount">Tax</th><th class="amount">Total</th></tr>
+{{range .Lines}}<tr><td>{{.Description}}</td><td class="amount">{{.Quantity}}</td><td class="amount">{{money .UnitPrice}}</td><td class="amount">{{money .Discount}}</td><td class="amount">{{money .Tax}} ({{percent .TaxRate}})</td><td class="amount">{{money .Total}}</td></tr>
+{{end}}{{with .Shipping}}<tr><td>Shipping ({{.Carrier}}, zone {{.Zone}})</td><td></td><td></td><td></td><td></td><td class="amount">{{money .Total}}</td></tr>
+{{end}}</table>
+<table>
+<tr><td>Subtotal</td><td class="amount">{{money .Subtotal}}</td></tr>
+<tr><td>Discounts</td><td class="amount">{{money .Discounts}}</td></tr>
+<tr><td>Tax</td><td class="amount">{{money .Tax}}</td></tr>
+<tr><td>Shipping</td><td class="amount">{{money .ShippingTotal}}</td></tr>
+<tr><td><strong>Total</strong></td><td class="amount"><strong>{{money .Total}}</strong></td></tr>
+</table>
+{{if .Notes}}<p>{{.Notes}}</p>{{end}}
+</body>
+</html>
+`))
+
+func RenderInvoiceHTML(w io.Writer, invoice *Invoice) error {
+	return invoiceTemplate.Execute(w, invoice)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	pdfPageWidth  = 612 // US Letter in points
+	pdfPageHeight = 792
+	pdfMargin     = 54
+	pdfLineHeight = 14
+)
+
+// winAnsi maps the few non-Latin-1 characters invoices use onto
+// WinAnsiEncoding; anything else outside Latin-1 prints as "?".
+var winAnsi = map[rune]byte{'€': 0x80, '–': 0x96, '—': 0x97}
+
+// pdfWriter lays out text lines top to bottom, breaking pages as needed.
+// It only uses the standard Helvetica and Courier fonts, so no font data
+// has to be embedded.
+type pdfWriter struct {
+	pages   []*bytes.Buffer
+	current *bytes.Buffer
+	y       int
+}
+
+func (p *pdfWriter) newPage() {
+	p.current = &bytes.Buffer{}
+	p.pages = append(p.pages, p.current)
+	p.y = pdfPageHeight - pdfMargin
+}
+
+func (p *pdfWriter) text(font string, size int, s string) {
+	if p.current == nil || p.y < pdfMargin {
+		p.newPage()
+	}
+	fmt.Fprintf(p.current, "BT /%s %d Tf %d %d Td (%s) Tj ET\n", font, size, pdfMargin, p.y, pdfEscape(s))
+	p.y -= pdfLineHeight
+	if size > 12 {
+		p.y -= size - 12
+	}
+}
+
+func (p *pdfWriter) gap() {
+	p.y -= pdfLineHeight / 2
+}
+
+func pdfEscape(s string) string {
+	var out strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			out.WriteByte('\\')
+			out.WriteRune(r)
+		case r < 0x20:
+			out.WriteByte(' ')
+		case r < 0x80:
+			out.WriteRune(r)
+		case winAnsi[r] != 0:
+			fmt.Fprintf(&out, "\\%03o", winAnsi[r])
+		case r <= 0xFF:
+			fmt.Fprintf(&out, "\\%03o", r)
+		default:
+			out.WriteByte('?')
+		}
+	}
+	return out.String()
+}
+
+// write emits the document: catalog, page tree, two fonts, then one page
+// and content stream per page, followed by the cross-reference table.
+func (p *pdfWriter) write(w io.Writer) error {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	const firstPage = 5
+	kids := make([]string, len(p.pages))
+	for i := range p.pages {
+		kids[i] = strconv.Itoa(firstPage+2*i) + " 0 R"
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	for i, page := range p.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /H 3 0 R /C 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, firstPage+2*i+1))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// RenderInvoicePDF writes the invoice as a self-contained PDF. The table is
+// set in Courier so columns line up without font metrics.
+func RenderInvoicePDF(w io.Writer, invoice *Invoice) error {
+	p := &pdfWriter{}
+	p.text("H", 18, invoice.Title()+" "+invoice.Number)
+	if invoice.Credits != "" {
+		p.text("C", 10, "Credits invoice "+invoice.Credits)
+	}
+	issued := "Issued " + invoice.IssuedAt.Format("2006-01-02")
+	if invoice.Kind == KindInvoice {
+		issued += ", due " + invoice.DueAt.Format("2006-01-02")
+	}
+	p.text("C", 10, issued)
+	p.gap()
+
+	p.text("H", 11, invoice.Seller.Name)
+	for _, line := range invoice.Seller.Address {
+		p.text("C", 10, line)
+	}
+	p.gap()
+	p.text("H", 11, "Bill to: "+invoice.Customer.Name)
+	for _, line := range invoice.Customer.Address {
+		p.text("C", 10, line)
+	}
+	p.gap()
+
+	row := func(description, quantity, unit, discount, tax, total string) string {
+		if runes := []rune(description); len(runes) > 28 {
+			description = string(runes[:27]) + "~"
+		}
+		return fmt.Sprintf("%-28s %5s %11s %10s %10s %12s", description, quantity, unit, discount, tax, total)
+	}
+	p.text("C", 9, row("Description", "Qty", "Unit", "Discount", "Tax", "Total"))
+	p.text("C", 9, strings.Repeat("-", 81))
+	for _, line := range invoice.Lines {
+		p.text("C", 9, row(line.Description, strconv.Itoa(line.Quantity), line.UnitPrice.Format(),
+			line.Discount.Format(), line.Tax.Format(), line.Total.Format()))
+	}
+	if invoice.Shipping != nil {
+		label := fmt.Sprintf("Shipping (%s, zone %d)", invoice.Shipping.Carrier, invoice.Shipping.Zone)
+		p.text("C", 9, row(label, "", "", "", "", invoice.Shipping.Total.Format()))
+	}
+	p.text("C", 9, strings.Repeat("-", 81))
+
+	total := func(label string, amount Money) string {
+		return fmt.Sprintf("%68s %12s", label, amount.Format())
+	}
+	p.text("C", 9, total("Subtotal", invoice.Subtotal))
+	p.text("C", 9, total("Discounts", invoice.Discounts))
+	p.text("C", 9, total("Tax", invoice.Tax))
+	p.text("C", 9, total("Shipping", invoice.ShippingTotal()))
+	p.text("C", 10, total("Total", invoice.Total))
+	if invoice.Notes != "" {
+		p.gap()
+		p.text("C", 9, invoice.Notes)
+	}
+	return p.write(w)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestInvoiceGenerator(t *testing.T) (*InvoiceGenerator, *InvoiceSequence, *time.Time) {
+	t.Helper()
+	clock := time.Date(2026, time.December, 30, 9, 0, 0, 0, time.UTC)
+	sequence := NewInvoiceSequence()
+	ig := NewInvoiceGenerator(testPricingEngine(t), sequence, Party{Name: "Acme Supplies"})
+	ig.now = func() time.Time { return clock }
+	return ig, sequence, &clock
+}
+
+func lampInvoice() InvoiceRequest {
+	return InvoiceRequest{
+		Customer:    Party{Name: "Jane Doe"},
+		Destination: Destination{State: "CA", County: "Los Angeles", Zip: "90012"},
+		Lines: []LineItemRequest{
+			{Description: "Desk lamp", Category: "general", Quantity: 3, UnitPrice: NewMoney(2999, "USD"), DiscountPercent: 10},
+			{Description: "Coffee beans", Category: "groceries", Quantity: 2, UnitPrice: NewMoney(1450, "USD")},
+		},
+		Weight:  6,
+		Carrier: "usps",
+	}
+}
+
+func TestFailedInvoicesLeaveNoGapInNumbering(t *testing.T) {
+	ig, sequence, clock := newTestInvoiceGenerator(t)
+
+	badLine := lampInvoice()
+	badLine.Lines[1].Quantity = 0
+	if _, err := ig.Issue(badLine); !errors.Is(err, ErrInvalidLineItem) {
+		t.Fatalf("invoice with a zero quantity: got %v, want ErrInvalidLineItem", err)
+	}
+	untaxable := lampInvoice()
+	untaxable.Destination = Destination{State: "AK", Zip: "99501"}
+	if _, err := ig.Issue(untaxable); !errors.Is(err, ErrUnknownJurisdiction) {
+		t.Fatalf("invoice to an unknown jurisdiction: got %v, want ErrUnknownJurisdiction", err)
+	}
+	if _, err := ig.Issue(InvoiceRequest{Customer: Party{Name: "Jane Doe"}}); !errors.Is(err, ErrInvalidInvoice) {
+		t.Fatalf("invoice without lines: got %v, want ErrInvalidInvoice", err)
+	}
+
+	var numbers []string
+	for range 2 {
+		invoice, err := ig.Issue(lampInvoice())
+		if err != nil {
+			t.Fatal(err)
+		}
+		numbers = append(numbers, invoice.Number)
+	}
+	*clock = clock.AddDate(0, 0, 3)
+	invoice, err := ig.Issue(lampInvoice())
+	if err != nil {
+		t.Fatal(err)
+	}
+	numbers = append(numbers, invoice.Number)
+	want := []string{"INV-2026-000001", "INV-2026-000002", "INV-2027-000001"}
+	for i := range want {
+		if numbers[i] != want[i] {
+			t.Errorf("invoice %d numbered %s, want %s", i+1, numbers[i], want[i])
+		}
+	}
+
+	sequence.Resume("INV", 2027, 41)
+	if invoice, err = ig.Issue(lampInvoice()); err != nil {
+		t.Fatal(err)
+	}
+	if invoice.Number != "INV-2027-000042" {
+		t.Errorf("after resuming at 41 got %s, want INV-2027-000042", invoice.Number)
+	}
+}
+
+func TestCreditNotesCannotExceedTheInvoice(t *testing.T) {
+	ig, _, _ := newTestInvoiceGenerator(t)
+	invoice, err := ig.Issue(lampInvoice())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ig.CreditNote(invoice.Number, "too many", map[int]int{0: 4}); !errors.Is(err, ErrOverCredit) {
+		t.Fatalf("crediting 4 of 3 units: got %v, want ErrOverCredit", err)
+	}
+	if _, err := ig.CreditNote(invoice.Number, "no such line", map[int]int{5: 1}); !errors.Is(err, ErrInvalidInvoice) {
+		t.Fatalf("crediting a missing line: got %v, want ErrInvalidInvoice", err)
+	}
+
+	// Three single-unit credits must add up to the line exactly, even though
+	// each share of the discount and tax is rounded.
+	credited := NewMoney(0, "USD")
+	for i := range 3 {
+		note, err := ig.CreditNote(invoice.Number, "returned", map[int]int{0: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("CN-2026-%06d", i+1); note.Number != want {
+			t.Errorf("credit note numbered %s, want %s", note.Number, want)
+		}
+		if note.Shipping != nil {
+			t.Errorf("partial credit %s refunds shipping", note.Number)
+		}
+		if credited, err = credited.Add(note.Total); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if credited != invoice.Lines[0].Total.Negate() {
+		t.Errorf("credited %s in total, want %s", credited.Format(), invoice.Lines[0].Total.Negate().Format())
+	}
+	if _, err := ig.CreditNote(invoice.Number, "one more", map[int]int{0: 1}); !errors.Is(err, ErrOverCredit) {
+		t.Fatalf("crediting a fully credited line: got %v, want ErrOverCredit", err)
+	}
+
+	rest, err := ig.CreditNote(invoice.Number, "order cancelled", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest.Lines) != 1 || rest.Lines[0].Quantity != -2 || rest.Shipping == nil {
+		t.Fatalf("full credit = %d lines, shipping %v; want the remaining coffee and the shipping", len(rest.Lines), rest.Shipping)
+	}
+	want, err := invoice.Lines[1].Total.Add(invoice.Shipping.Total)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rest.Total != want.Negate() {
+		t.Errorf("full credit total %s, want %s", rest.Total.Format(), want.Negate().Format())
+	}
+	if _, err := ig.CreditNote(invoice.Number, "again", nil); !errors.Is(err, ErrOverCredit) {
+		t.Errorf("crediting a fully credited invoice: got %v, want ErrOverCredit", err)
+	}
+	if _, err := ig.CreditNote(rest.Number, "credit of a credit", nil); !errors.Is(err, ErrInvalidInvoice) {
+		t.Errorf("crediting a credit note: got %v, want ErrInvalidInvoice", err)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidLineItem = errors.New("invalid line item")
+
+// LineItemRequest is one item to be priced. A line takes either a percentage
+// or a fixed discount, not both.
+type LineItemRequest struct {
+	Description     string
+	Category        string
+	Quantity        int
+	UnitPrice       Money
+	DiscountPercent float64
+	DiscountAmount  Money
+}
+
+type LineItem struct {
+	Description string
+	Category    string
+	Quantity    int
+	UnitPrice   Money
+	Gross       Money
+	Discount    Money
+	Net         Money
+	TaxRate     float64
+	Tax         Money
+	Total       Money
+}
+
+// Totals sums a document's lines and shipping.
+type Totals struct {
+	Subtotal  Money
+	Discounts Money
+	Tax       Money
+	Total     Money
+}
+
+// It fails if any amount is not in currency.
+func SumLines(lines []LineItem, shipping *ShippingCharge, currency string) (Totals, error) {
+	totals := Totals{
+		Subtotal:  NewMoney(0, currency),
+		Discounts: NewMoney(0, currency),
+		Tax:       NewMoney(0, currency),
+	}
+	var err error
+	for _, line := range lines {
+		if totals.Subtotal, err = totals.Subtotal.Add(line.Gross); err != nil {
+			return Totals{}, err
+		}
+		if totals.Discounts, err = totals.Discounts.Add(line.Discount); err != nil {
+			return Totals{}, err
+		}
+		if totals.Tax, err = totals.Tax.Add(line.Tax); err != nil {
+			return Totals{}, err
+		}
+	}
+	if totals.Total, err = totals.Subtotal.Subtract(totals.Discounts); err != nil {
+		return Totals{}, err
+	}
+	if totals.Total, err = totals.Total.Add(totals.Tax); err != nil {
+		return Totals{}, err
+	}
+	if shipping != nil {
+		if totals.Total, err = totals.Total.Add(shipping.Total); err != nil {
+			return Totals{}, err
+		}
+	}
+	return totals, nil
+}
+
+// PriceLine applies the line's discount and the destination's tax rate for
+// its category.
+func (pe PricingEngine) PriceLine(request LineItemRequest, destination Destination) (LineItem, error) {
+	currency := pe.currency
+	if strings.TrimSpace(request.Description) == "" {
+		return LineItem{}, fmt.Errorf("%w: description is required", ErrInvalidLineItem)
+	}
+	if request.Quantity <= 0 {
+		return LineItem{}, fmt.Errorf("%w: quantity must be positive", ErrInvalidLineItem)
+	}
+	if request.UnitPrice.Currency() != currency || request.UnitPrice.IsNegative() {
+		return LineItem{}, fmt.Errorf("%w: unit price must be a non-negative %s amount", ErrInvalidLineItem, currency)
+	}
+	if request.DiscountPercent < 0 || request.DiscountPercent > 100 {
+		return LineItem{}, fmt.Errorf("%w: discount percent must be between 0 and 100", ErrInvalidLineItem)
+	}
+	if request.DiscountPercent > 0 && !request.DiscountAmount.IsZero() {
+		return LineItem{}, fmt.Errorf("%w: use either a discount percent or amount", ErrInvalidLineItem)
+	}
+	if !request.DiscountAmount.IsZero() && request.DiscountAmount.Currency() != currency {
+		return LineItem{}, fmt.Errorf("%w: discount must be in %s", ErrInvalidLineItem, currency)
+	}
+
+	gross, err := request.UnitPrice.Multiply(float64(request.Quantity), RoundHalfUp)
+	if err != nil {
+		return LineItem{}, err
+	}
+	line := LineItem{
+		Description: request.Description,
+		Category:    request.Category,
+		Quantity:    request.Quantity,
+		UnitPrice:   request.UnitPrice,
+		Gross:       gross,
+		Discount:    NewMoney(0, currency),
+	}
+	switch {
+	case request.DiscountPercent > 0:
+		if line.Discount, err = line.Gross.Multiply(request.DiscountPercent/100, RoundHalfUp); err != nil {
+			return LineItem{}, err
+		}
+	case !request.DiscountAmount.IsZero():
+		line.Discount = request.DiscountAmount
+	}
+	if line.Net, err = line.Gross.Subtract(line.Discount); err != nil {
+		return LineItem{}, err
+	}
+	if line.Discount.IsNegative() || line.Net.IsNegative() {
+		return LineItem{}, fmt.Errorf("%w: discount must be between zero and the line amount", ErrInvalidLineItem)
+	}
+
+	rate, err := pe.TaxRate(request.Category, destination)
+	if err != nil {
+		return LineItem{}, err
+	}
+	line.TaxRate = rate
+	if line.Tax, err = line.Net.Multiply(rate, RoundHalfUp); err != nil {
+		return LineItem{}, err
+	}
+	if line.Total, err = line.Net.Add(line.Tax); err != nil {
+		return LineItem{}, err
+	}
+	return line, nil
+}
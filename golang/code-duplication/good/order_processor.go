@@ -1,110 +1,208 @@
 package main
 
-import "fmt"
-
-type TaxCalculator struct {
-	taxRates map[string]float64
-}
-
-func NewTaxCalculator() *TaxCalculator {
-	return &TaxCalculator{
-		taxRates: map[string]float64{
-			"CA": 0.0825,
-			"NY": 0.04,
-			"TX": 0.0625,
-		},
-	}
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var ErrInvalidOrder = errors.New("invalid order")
+
+// OrderRequest carries lines that are already priced, e.g. the locked-in
+// lines of an accepted quote.
+type OrderRequest struct {
+	Customer    Party
+	Destination Destination
+	Lines       []LineItem
+	Shipping    *ShippingCharge
+	QuoteNumber string
+}
+
+type Order struct {
+	Id          string
+	QuoteNumber string
+	Customer    Party
+	Destination Destination
+	Lines       []LineItem
+	Shipping    *ShippingCharge
+	Totals
+	PlacedAt time.Time
 }
 
-func (tc TaxCalculator) CalculateTax(price float64, state string) float64 {
-	taxRate := tc.taxRates[state]
-	return price * taxRate
+type OrderProcessor struct {
+	pricing  *PricingEngine
+	sequence *InvoiceSequence
+	now      func() time.Time
 }
 
-type ShippingCalculator struct {
-	baseRate               float64
-	weightRatePerUnit      float64
-	distanceRatePerUnit    float64
+func NewOrderProcessor(pricing *PricingEngine, sequence *InvoiceSequence) *OrderProcessor {
+	return &OrderProcessor{pricing: pricing, sequence: sequence, now: time.Now}
 }
 
-func NewShippingCalculator() *ShippingCalculator {
-	return &ShippingCalculator{
-		baseRate:            5.0,
-		weightRatePerUnit:   0.5,
-		distanceRatePerUnit: 0.1,
+func (op *OrderProcessor) PlaceOrder(request OrderRequest) (*Order, error) {
+	if len(request.Lines) == 0 {
+		return nil, fmt.Errorf("%w: at least one line is required", ErrInvalidOrder)
+	}
+	totals, err := SumLines(request.Lines, request.Shipping, op.pricing.Currency())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOrder, err)
+	}
+	order := &Order{
+		QuoteNumber: request.QuoteNumber,
+		Customer:    request.Customer,
+		Destination: request.Destination,
+		Lines:       append([]LineItem(nil), request.Lines...),
+		Shipping:    request.Shipping,
+		Totals:      totals,
+		PlacedAt:    op.now(),
 	}
+	order.Id = op.sequence.Next("ORD", order.PlacedAt.Year())
+	return order, nil
 }
 
-func (sc ShippingCalculator) CalculateShipping(weight float64, distance float64) float64 {
-	weightRate := weight * sc.weightRatePerUnit
-	distanceRate := distance * sc.distanceRatePerUnit
-
-	return sc.baseRate + weightRate + distanceRate
+func (op OrderProcessor) CalculateTax(price Money, category string, destination Destination) (Money, error) {
+	return op.pricing.CalculateTax(price, category, destination)
 }
 
-type OrderProcessor struct {
-	taxCalculator      *TaxCalculator
-	shippingCalculator *ShippingCalculator
+func (op OrderProcessor) CalculateShipping(weight float64, destination Destination, carrier string) (ShippingCharge, error) {
+	return op.pricing.CalculateShipping(weight, destination, carrier)
 }
 
-func NewOrderProcessor() *OrderProcessor {
-	return &OrderProcessor{
-		taxCalculator:      NewTaxCalculator(),
-		shippingCalculator: NewShippingCalculator(),
+func main() {
+	// Example usage
+	pricing, err := DefaultPricingEngine()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not load pricing rules:", err)
+		os.Exit(1)
 	}
-}
-
-func (op OrderProcessor) CalculateTax(price float64, state string) float64 {
-	return op.taxCalculator.CalculateTax(price, state)
-}
 
-func (op OrderProcessor) CalculateShipping(weight float64, distance float64) float64 {
-	return op.shippingCalculator.CalculateShipping(weight, distance)
-}
+	sequence := NewInvoiceSequence()
+	op := NewOrderProcessor(pricing, sequence)
+	losAngeles := Destination{State: "CA", County: "Los Angeles", Zip: "90012"}
+	tax, err := op.CalculateTax(NewMoney(10000, "USD"), "general", losAngeles)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Tax for $100 in Los Angeles, CA: %s\n", tax.Format())
 
-type InvoiceGenerator struct {
-	taxCalculator      *TaxCalculator
-	shippingCalculator *ShippingCalculator
-}
+	groceries, _ := op.CalculateTax(NewMoney(10000, "USD"), "groceries", losAngeles)
+	fmt.Printf("Tax for $100 of groceries in CA: %s\n", groceries.Format())
 
-func NewInvoiceGenerator() *InvoiceGenerator {
-	return &InvoiceGenerator{
-		taxCalculator:      NewTaxCalculator(),
-		shippingCalculator: NewShippingCalculator(),
+	shipping, err := op.CalculateShipping(10, losAngeles, "ups")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Shipping for 10lbs to %s by %s (zone %d): %s\n", losAngeles.Zip, shipping.Carrier, shipping.Zone, shipping.Total.Format())
+	for _, surcharge := range shipping.Surcharges {
+		fmt.Printf("  includes %s: %s\n", surcharge.Name, surcharge.Amount.Format())
 	}
-}
-
-func (ig InvoiceGenerator) CalculateTax(price float64, state string) float64 {
-	return ig.taxCalculator.CalculateTax(price, state)
-}
 
-func (ig InvoiceGenerator) CalculateShipping(weight float64, distance float64) float64 {
-	return ig.shippingCalculator.CalculateShipping(weight, distance)
-}
+	anchorage := Destination{State: "AK", Zip: "99501"}
+	ig := NewInvoiceGenerator(pricing, sequence, Party{Name: "Acme Supplies", Address: []string{"1 Market St", "San Francisco, CA 94105"}})
+	if _, err := ig.CalculateTax(NewMoney(10000, "USD"), "general", anchorage); errors.Is(err, ErrUnknownJurisdiction) {
+		fmt.Println("Invoice rejected:", err)
+	}
 
-type QuoteGenerator struct {
-	taxCalculator      *TaxCalculator
-	shippingCalculator *ShippingCalculator
-}
+	invoice, err := ig.Issue(InvoiceRequest{
+		Customer:    Party{Name: "Jane Doe", Address: []string{"200 N Spring St", "Los Angeles, CA 90012"}},
+		Destination: losAngeles,
+		Lines: []LineItemRequest{
+			{Description: "Desk lamp", Category: "general", Quantity: 3, UnitPrice: NewMoney(2999, "USD"), DiscountPercent: 10},
+			{Description: "Coffee beans", Category: "groceries", Quantity: 2, UnitPrice: NewMoney(1450, "USD")},
+		},
+		Weight:  6,
+		Carrier: "usps",
+	})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Issued %s for %s, due %s\n", invoice.Number, invoice.Total.Format(), invoice.DueAt.Format("2006-01-02"))
 
-func NewQuoteGenerator() *QuoteGenerator {
-	return &QuoteGenerator{
-		taxCalculator:      NewTaxCalculator(),
-		shippingCalculator: NewShippingCalculator(),
+	note, err := ig.CreditNote(invoice.Number, "One lamp returned damaged", map[int]int{0: 1})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Issued %s crediting %s: %s\n", note.Number, note.Credits, note.Total.Format())
+
+	renderers := []struct {
+		ext    string
+		render func(io.Writer, *Invoice) error
+	}{{".html", RenderInvoiceHTML}, {".pdf", RenderInvoicePDF}}
+	for _, document := range []*Invoice{invoice, note} {
+		for _, renderer := range renderers {
+			path := filepath.Join(os.TempDir(), document.Number+renderer.ext)
+			if err := writeDocument(path, document, renderer.render); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			fmt.Println("Wrote", path)
+		}
 	}
-}
 
-func (qg QuoteGenerator) CalculateTax(price float64, state string) float64 {
-	return qg.taxCalculator.CalculateTax(price, state)
-}
+	prices := NewPriceList(
+		CatalogItem{SKU: "LAMP-01", Description: "Desk lamp", Category: "general", UnitPrice: NewMoney(2999, "USD")},
+		CatalogItem{SKU: "CHAIR-02", Description: "Office chair", Category: "general", UnitPrice: NewMoney(18900, "USD")},
+	)
+	qg := NewQuoteGenerator(pricing, prices, sequence, op)
+	clock := time.Date(2026, time.October, 1, 9, 0, 0, 0, time.UTC)
+	qg.now = func() time.Time { return clock }
+
+	newYork := Destination{State: "NY", County: "New York", Zip: "10001"}
+	quote, err := qg.CreateQuote(Party{Name: "Initech"}, QuoteRequest{
+		Destination: newYork,
+		Lines:       []QuoteLineRequest{{SKU: "CHAIR-02", Quantity: 4}},
+		Weight:      80,
+		Carrier:     "fedex",
+		ValidFor:    14 * 24 * time.Hour,
+	})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	quote, _ = qg.Revise(quote.Number, QuoteRequest{
+		Destination: newYork,
+		Lines:       []QuoteLineRequest{{SKU: "CHAIR-02", Quantity: 4, DiscountPercent: 5}, {SKU: "LAMP-01", Quantity: 4}},
+		Weight:      92,
+		Carrier:     "fedex",
+		ValidFor:    14 * 24 * time.Hour,
+	})
+	current := quote.Current()
+	fmt.Printf("Quote %s v%d: %s, valid until %s\n", quote.Number, current.Version, current.Total.Format(), current.ValidUntil.Format("2006-01-02"))
+
+	if _, err := qg.Accept(quote.Number, 1); errors.Is(err, ErrStaleRevision) {
+		fmt.Println("Acceptance refused:", err)
+	}
+	if _, err := qg.Accept(quote.Number, current.Version); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
 
-func (qg QuoteGenerator) CalculateShipping(weight float64, distance float64) float64 {
-	return qg.shippingCalculator.CalculateShipping(weight, distance)
+	prices.Set(CatalogItem{SKU: "CHAIR-02", Description: "Office chair", Category: "general", UnitPrice: NewMoney(19900, "USD")})
+	clock = clock.AddDate(0, 0, 20)
+	order, err := qg.ConvertToOrder(quote.Number)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	quote, _ = qg.Quote(quote.Number)
+	fmt.Printf("Quote lapsed before conversion; repriced as v%d and placed %s for %s\n", quote.Current().Version, order.Id, order.Total.Format())
 }
 
-func main() {
-	// Example usage
-	op := NewOrderProcessor()
-	fmt.Printf("Tax for $100 in CA: $%.2f\n", op.CalculateTax(100, "CA"))
-	fmt.Printf("Shipping for 10lbs over 50 miles: $%.2f\n", op.CalculateShipping(10, 50))
+func writeDocument(path string, invoice *Invoice, render func(io.Writer, *Invoice) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := render(file, invoice); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var ErrUnknownProduct = errors.New("unknown product")
+
+type CatalogItem struct {
+	SKU         string
+	Description string
+	Category    string
+	UnitPrice   Money
+}
+
+// PriceList is the current selling price of each product. Quotes copy
+// prices out of it, so later changes only reach a quote when it is
+// repriced.
+type PriceList interface {
+	Item(sku string) (CatalogItem, error)
+}
+
+type StaticPriceList struct {
+	mu    sync.RWMutex
+	items map[string]CatalogItem
+}
+
+func NewPriceList(items ...CatalogItem) *StaticPriceList {
+	pl := &StaticPriceList{items: map[string]CatalogItem{}}
+	for _, item := range items {
+		pl.Set(item)
+	}
+	return pl
+}
+
+func (pl *StaticPriceList) Set(item CatalogItem) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	pl.items[strings.ToUpper(item.SKU)] = item
+}
+
+func (pl *StaticPriceList) Item(sku string) (CatalogItem, error) {
+	pl.mu.RLock()
+	defer pl.mu.RUnlock()
+	item, ok := pl.items[strings.ToUpper(sku)]
+	if !ok {
+		return CatalogItem{}, fmt.Errorf("%w: %q", ErrUnknownProduct, sku)
+	}
+	return item, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// defaultPricingRules is the rule set the examples ship with, built into
+// the binary so it does not depend on the working directory.
+//
+//go:embed pricing_rules.json
+var defaultPricingRules string
+
+// PricingRules is the data file shared by every document that prices an
+// order: sales tax tables, shipping zones, weight tiers and carriers.
+type PricingRules struct {
+	Currency      string            `json:"currency"`
+	Tax           []TaxJurisdiction `json:"tax"`
+	ShippingZones []ShippingZone    `json:"shipping_zones"`
+	WeightTiers   []WeightTier      `json:"weight_tiers"`
+	Carriers      []CarrierRule     `json:"carriers"`
+}
+
+func DecodePricingRules(r io.Reader) (PricingRules, error) {
+	var rules PricingRules
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&rules); err != nil {
+		return PricingRules{}, err
+	}
+	return rules, nil
+}
+
+// PricingEngine answers tax and shipping questions from one set of rules,
+// so orders, invoices and quotes can never disagree.
+type PricingEngine struct {
+	currency string
+	tax      *TaxCalculator
+	shipping *ShippingCalculator
+}
+
+func NewPricingEngine(rules PricingRules) (*PricingEngine, error) {
+	currency := strings.ToUpper(rules.Currency)
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	tax, err := NewTaxCalculator(rules.Tax)
+	if err != nil {
+		return nil, err
+	}
+	shipping, err := NewShippingCalculator(currency, rules.ShippingZones, rules.WeightTiers, rules.Carriers)
+	if err != nil {
+		return nil, err
+	}
+	return &PricingEngine{currency: currency, tax: tax, shipping: shipping}, nil
+}
+
+func DefaultPricingEngine() (*PricingEngine, error) {
+	engine, err := decodePricingEngine(strings.NewReader(defaultPricingRules))
+	if err != nil {
+		return nil, fmt.Errorf("pricing_rules.json: %w", err)
+	}
+	return engine, nil
+}
+
+// LoadPricingEngine reads a rules file that overrides the built-in one.
+func LoadPricingEngine(path string) (*PricingEngine, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	engine, err := decodePricingEngine(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return engine, nil
+}
+
+func decodePricingEngine(r io.Reader) (*PricingEngine, error) {
+	rules, err := DecodePricingRules(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewPricingEngine(rules)
+}
+
+func (pe PricingEngine) Currency() string {
+	return pe.currency
+}
+
+func (pe PricingEngine) TaxRate(category string, destination Destination) (float64, error) {
+	return pe.tax.TaxRate(category, destination)
+}
+
+func (pe PricingEngine) CalculateTax(price Money, category string, destination Destination) (Money, error) {
+	return pe.tax.CalculateTax(price, category, destination)
+}
+
+func (pe PricingEngine) CalculateShipping(weight float64, destination Destination, carrier string) (ShippingCharge, error) {
+	return pe.shipping.CalculateShipping(weight, destination, carrier)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testPricingEngine(t *testing.T) *PricingEngine {
+	t.Helper()
+	pricing, err := DefaultPricingEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pricing
+}
+
+func TestSalesTaxRules(t *testing.T) {
+	pricing := testPricingEngine(t)
+	price := NewMoney(10000, "USD")
+
+	tests := []struct {
+		name        string
+		category    string
+		destination Destination
+		want        int64
+	}{
+		{"county rate added to state rate", "general", Destination{State: "CA", County: "Los Angeles", Zip: "90012"}, 950},
+		{"county suffix and case ignored", "general", Destination{State: "ca", County: "los angeles county", Zip: "90012"}, 950},
+		{"zip rate replaces county rate", "general", Destination{State: "CA", County: "Los Angeles", Zip: "90401-1234"}, 1025},
+		{"county without an entry pays the state rate", "general", Destination{State: "CA", County: "Fresno", Zip: "93650"}, 725},
+		{"exempt category", "Groceries", Destination{State: "CA", County: "Los Angeles", Zip: "90401"}, 0},
+		{"state without sales tax", "general", Destination{State: "OR", Zip: "97201"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tax, err := pricing.CalculateTax(price, tt.category, tt.destination)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tax.MinorUnits() != tt.want {
+				t.Errorf("tax = %s, want %d cents", tax.Format(), tt.want)
+			}
+		})
+	}
+
+	if _, err := pricing.CalculateTax(price, "general", Destination{State: "AK", Zip: "99501"}); !errors.Is(err, ErrUnknownJurisdiction) {
+		t.Errorf("tax for a state without a table: got %v, want ErrUnknownJurisdiction", err)
+	}
+}
+
+func TestShippingRules(t *testing.T) {
+	pricing := testPricingEngine(t)
+
+	tests := []struct {
+		name        string
+		weight      float64
+		destination Destination
+		carrier     string
+		zone        int
+		want        int64
+		surcharges  []string
+	}{
+		{"fuel and handling on the base price", 10, Destination{State: "CA", Zip: "90012"}, "UPS", 1, 1718, []string{"fuel", "handling"}},
+		{"remote zip prefix beats the state", 10, Destination{State: "AK", Zip: "99501"}, "fedex", 4, 4892, []string{"fuel", "handling", "remote area"}},
+		{"open-ended tier charges per pound over", 80, Destination{State: "NY", Zip: "10001"}, "usps", 3, 5925, nil},
+		{"tier limits are inclusive", 5, Destination{State: "TX", Zip: "77002"}, "usps", 2, 1063, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			charge, err := pricing.CalculateShipping(tt.weight, tt.destination, tt.carrier)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if charge.Zone != tt.zone || charge.Total.MinorUnits() != tt.want {
+				t.Errorf("zone %d total %s, want zone %d total %d cents", charge.Zone, charge.Total.Format(), tt.zone, tt.want)
+			}
+			var names []string
+			for _, surcharge := range charge.Surcharges {
+				names = append(names, surcharge.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.surcharges, ",") {
+				t.Errorf("surcharges = %v, want %v", names, tt.surcharges)
+			}
+		})
+	}
+
+	if _, err := pricing.CalculateShipping(1, Destination{State: "CA", Zip: "90012"}, "pigeon"); !errors.Is(err, ErrUnknownCarrier) {
+		t.Errorf("unknown carrier: got %v, want ErrUnknownCarrier", err)
+	}
+	if _, err := pricing.CalculateShipping(1, Destination{State: "FL", Zip: "33101"}, "usps"); !errors.Is(err, ErrUnknownJurisdiction) {
+		t.Errorf("state outside every zone: got %v, want ErrUnknownJurisdiction", err)
+	}
+}
+
+func TestPricingRulesAreValidated(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		want  string
+	}{
+		{"unknown field", `{"currency": "USD", "vat": []}`, "unknown field"},
+		{"state listed twice", `{"tax": [{"state": "CA"}, {"state": "ca"}], "weight_tiers": [{"price": "1.00"}]}`, "listed twice"},
+		{"state in two zones", `{"shipping_zones": [{"zone": 1, "multiplier": 1, "states": ["CA"]}, {"zone": 2, "multiplier": 1, "states": ["CA"]}], "weight_tiers": [{"price": "1.00"}]}`, "zones 1 and 2"},
+		{"open-ended tier before the last", `{"weight_tiers": [{"price": "1.00"}, {"up_to": 5, "price": "2.00"}]}`, "only the last tier"},
+		{"no weight tiers", `{}`, "no weight tiers"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodePricingEngine(strings.NewReader(tt.rules))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQuoteNotFound = errors.New("quote not found")
+	ErrQuoteExpired  = errors.New("quote has expired")
+	ErrQuoteState    = errors.New("quote cannot change from its current status")
+	ErrStaleRevision = errors.New("quote has been revised since")
+	ErrInvalidQuote  = errors.New("invalid quote")
+)
+
+const defaultQuoteValidity = 30 * 24 * time.Hour
+
+type QuoteStatus string
+
+const (
+	QuoteOpen      QuoteStatus = "open"
+	QuoteExpired   QuoteStatus = "expired"
+	QuoteAccepted  QuoteStatus = "accepted"
+	QuoteRejected  QuoteStatus = "rejected"
+	QuoteConverted QuoteStatus = "converted"
+)
+
+type QuoteLineRequest struct {
+	SKU             string
+	Quantity        int
+	DiscountPercent float64
+}
+
+type QuoteRequest struct {
+	Destination Destination
+	Lines       []QuoteLineRequest
+	Weight      float64
+	Carrier     string
+	ValidFor    time.Duration
+}
+
+// QuoteRevision is one priced version of a quote. Its lines keep the unit
+// prices and tax rates in force when it was created, and the request is
+// kept so the revision can be repriced after it lapses.
+type QuoteRevision struct {
+	Version    int
+	Request    QuoteRequest
+	CreatedAt  time.Time
+	ValidUntil time.Time
+	Lines      []LineItem
+	Shipping   *ShippingCharge
+	Totals
+	// Repriced marks a revision made because an accepted quote expired
+	// before it was converted.
+	Repriced bool
+}
+
+type Quote struct {
+	Number    string
+	Customer  Party
+	Status    QuoteStatus
+	Revisions []QuoteRevision
+	DecidedAt time.Time
+	Reason    string
+	OrderId   string
+}
+
+func (q Quote) Current() QuoteRevision {
+	return q.Revisions[len(q.Revisions)-1]
+}
+
+// StatusAt reports an open quote past its validity window as expired.
+func (q Quote) StatusAt(at time.Time) QuoteStatus {
+	if q.Status == QuoteOpen && at.After(q.Current().ValidUntil) {
+		return QuoteExpired
+	}
+	return q.Status
+}
+
+type QuoteGenerator struct {
+	pricing  *PricingEngine
+	prices   PriceList
+	sequence *InvoiceSequence
+	orders   *OrderProcessor
+	now      func() time.Time
+
+	mu     sync.Mutex
+	quotes map[string]*Quote
+}
+
+func NewQuoteGenerator(pricing *PricingEngine, prices PriceList, sequence *InvoiceSequence, orders *OrderProcessor) *QuoteGenerator {
+	return &QuoteGenerator{
+		pricing:  pricing,
+		prices:   prices,
+		sequence: sequence,
+		orders:   orders,
+		now:      time.Now,
+		quotes:   map[string]*Quote{},
+	}
+}
+
+func (qg *QuoteGenerator) CalculateTax(price Money, category string, destination Destination) (Money, error) {
+	return qg.pricing.CalculateTax(price, category, destination)
+}
+
+func (qg *QuoteGenerator) CalculateShipping(weight float64, destination Destination, carrier string) (ShippingCharge, error) {
+	return qg.pricing.CalculateShipping(weight, destination, carrier)
+}
+
+func (qg *QuoteGenerator) CreateQuote(customer Party, request QuoteRequest) (*Quote, error) {
+	if strings.TrimSpace(customer.Name) == "" {
+		return nil, fmt.Errorf("%w: customer name is required", ErrInvalidQuote)
+	}
+
+	qg.mu.Lock()
+	defer qg.mu.Unlock()
+	revision, err := qg.price(request, 1)
+	if err != nil {
+		return nil, err
+	}
+	quote := &Quote{
+		Number:    qg.sequence.Next("Q", revision.CreatedAt.Year()),
+		Customer:  customer.clone(),
+		Status:    QuoteOpen,
+		Revisions: []QuoteRevision{revision},
+	}
+	qg.quotes[quote.Number] = quote
+	return quote.clone(), nil
+}
+
+// Revise replaces the terms of an open quote with a new version priced at
+// today's rules. Expired quotes can be revised to renew them.
+func (qg *QuoteGenerator) Revise(number string, request QuoteRequest) (*Quote, error) {
+	qg.mu.Lock()
+	defer qg.mu.Unlock()
+	quote, err := qg.find(number)
+	if err != nil {
+		return nil, err
+	}
+	if quote.Status != QuoteOpen {
+		return nil, fmt.Errorf("%w: %s is %s", ErrQuoteState, number, quote.Status)
+	}
+	revision, err := qg.price(request, quote.Current().Version+1)
+	if err != nil {
+		return nil, err
+	}
+	quote.Revisions = append(quote.Revisions, revision)
+	return quote.clone(), nil
+}
+
+// Accept records the customer's acceptance of a specific version, so an
+// acceptance of terms that have since been revised is refused.
+func (qg *QuoteGenerator) Accept(number string, version int) (*Quote, error) {
+	qg.mu.Lock()
+	defer qg.mu.Unlock()
+	quote, err := qg.find(number)
+	if err != nil {
+		return nil, err
+	}
+	now := qg.now()
+	switch quote.StatusAt(now) {
+	case QuoteOpen:
+	case QuoteExpired:
+		return nil, fmt.Errorf("%w: %s lapsed on %s", ErrQuoteExpired, number, quote.Current().ValidUntil.Format("2006-01-02"))
+	default:
+		return nil, fmt.Errorf("%w: %s is %s", ErrQuoteState, number, quote.Status)
+	}
+	if current := quote.Current().Version; version != current {
+		return nil, fmt.Errorf("%w: accepted version %d, current is %d", ErrStaleRevision, version, current)
+	}
+	quote.Status = QuoteAccepted
+	quote.DecidedAt = now
+	return quote.clone(), nil
+}
+
+func (qg *QuoteGenerator) Reject(number, reason string) (*Quote, error) {
+	qg.mu.Lock()
+	defer qg.mu.Unlock()
+	quote, err := qg.find(number)
+	if err != nil {
+		return nil, err
+	}
+	if quote.Status != QuoteOpen {
+		return nil, fmt.Errorf("%w: %s is %s", ErrQuoteState, number, quote.Status)
+	}
+	quote.Status = QuoteRejected
+	quote.DecidedAt = qg.now()
+	quote.Reason = reason
+	return quote.clone(), nil
+}
+
+// ConvertToOrder hands an accepted quote to fulfilment at its locked-in
+// prices. If the quote lapsed between acceptance and conversion it is
+// repriced first, and the order carries the new revision's prices; the
+// repriced revision is only kept once the order has been placed.
+func (qg *QuoteGenerator) ConvertToOrder(number string) (*Order, error) {
+	qg.mu.Lock()
+	defer qg.mu.Unlock()
+	quote, err := qg.find(number)
+	if err != nil {
+		return nil, err
+	}
+	if quote.Status != QuoteAccepted {
+		return nil, fmt.Errorf("%w: %s is %s, only accepted quotes become orders", ErrQuoteState, number, quote.Status)
+	}
+
+	current := quote.Current()
+	repriced := false
+	if qg.now().After(current.ValidUntil) {
+		current, err = qg.price(current.Request, current.Version+1)
+		if err != nil {
+			return nil, fmt.Errorf("repricing %s: %w", number, err)
+		}
+		current.Repriced = true
+		repriced = true
+	}
+
+	// The order gets its own copies so it cannot alias the stored quote.
+	ordered := current.clone()
+	order, err := qg.orders.PlaceOrder(OrderRequest{
+		Customer:    quote.Customer.clone(),
+		Destination: ordered.Request.Destination,
+		Lines:       ordered.Lines,
+		Shipping:    ordered.Shipping,
+		QuoteNumber: quote.Number,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if repriced {
+		quote.Revisions = append(quote.Revisions, current)
+	}
+	quote.Status = QuoteConverted
+	quote.OrderId = order.Id
+	return order, nil
+}
+
+func (qg *QuoteGenerator) Quote(number string) (*Quote, error) {
+	qg.mu.Lock()
+	defer qg.mu.Unlock()
+	quote, err := qg.find(number)
+	if err != nil {
+		return nil, err
+	}
+	return quote.clone(), nil
+}
+
+func (qg *QuoteGenerator) find(number string) (*Quote, error) {
+	quote, ok := qg.quotes[number]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrQuoteNotFound, number)
+	}
+	return quote, nil
+}
+
+// price looks every line up in the price list and runs it through the
+// pricing engine, producing a revision valid from now.
+func (qg *QuoteGenerator) price(request QuoteRequest, version int) (QuoteRevision, error) {
+	if len(request.Lines) == 0 {
+		return QuoteRevision{}, fmt.Errorf("%w: at least one line is required", ErrInvalidQuote)
+	}
+	validFor := request.ValidFor
+	if validFor <= 0 {
+		validFor = defaultQuoteValidity
+	}
+
+	now := qg.now()
+	request.Lines = append([]QuoteLineRequest(nil), request.Lines...)
+	revision := QuoteRevision{Version: version, Request: request, CreatedAt: now, ValidUntil: now.Add(validFor)}
+	for i, lineRequest := range request.Lines {
+		item, err := qg.prices.Item(lineRequest.SKU)
+		if err != nil {
+			return QuoteRevision{}, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		line, err := qg.pricing.PriceLine(LineItemRequest{
+			Description:     item.Description,
+			Category:        item.Category,
+			Quantity:        lineRequest.Quantity,
+			UnitPrice:       item.UnitPrice,
+			DiscountPercent: lineRequest.DiscountPercent,
+		}, request.Destination)
+		if err != nil {
+			return QuoteRevision{}, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		revision.Lines = append(revision.Lines, line)
+	}
+	if request.Weight > 0 {
+		charge, err := qg.pricing.CalculateShipping(request.Weight, request.Destination, request.Carrier)
+		if err != nil {
+			return QuoteRevision{}, err
+		}
+		revision.Shipping = &charge
+	}
+	totals, err := SumLines(revision.Lines, revision.Shipping, qg.pricing.Currency())
+	if err != nil {
+		return QuoteRevision{}, err
+	}
+	revision.Totals = totals
+	return revision, nil
+}
+
+// clone copies a quote all the way down, so callers can change what they
+// are given without touching the stored quote.
+func (q *Quote) clone() *Quote {
+	copied := *q
+	copied.Customer = q.Customer.clone()
+	copied.Revisions = make([]QuoteRevision, len(q.Revisions))
+	for i, revision := range q.Revisions {
+		copied.Revisions[i] = revision.clone()
+	}
+	return &copied
+}
+
+func (r QuoteRevision) clone() QuoteRevision {
+	r.Request.Lines = append([]QuoteLineRequest(nil), r.Request.Lines...)
+	r.Lines = append([]LineItem(nil), r.Lines...)
+	if r.Shipping != nil {
+		shipping := *r.Shipping
+		shipping.Surcharges = append([]Surcharge(nil), shipping.Surcharges...)
+		r.Shipping = &shipping
+	}
+	return r
+}
+
+func (p Party) clone() Party {
+	p.Address = append([]string(nil), p.Address...)
+	return p
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestQuoteGenerator(t *testing.T) (*QuoteGenerator, *StaticPriceList, *time.Time) {
+	t.Helper()
+	clock := time.Date(2026, time.October, 1, 9, 0, 0, 0, time.UTC)
+	pricing := testPricingEngine(t)
+	sequence := NewInvoiceSequence()
+	prices := NewPriceList(CatalogItem{SKU: "CHAIR-02", Description: "Office chair", Category: "general", UnitPrice: NewMoney(18900, "USD")})
+	qg := NewQuoteGenerator(pricing, prices, sequence, NewOrderProcessor(pricing, sequence))
+	qg.now = func() time.Time { return clock }
+	qg.orders.now = qg.now
+	return qg, prices, &clock
+}
+
+func chairQuote(quantity int) QuoteRequest {
+	return QuoteRequest{
+		Destination: Destination{State: "OR", Zip: "97201"},
+		Lines:       []QuoteLineRequest{{SKU: "CHAIR-02", Quantity: quantity}},
+		ValidFor:    14 * 24 * time.Hour,
+	}
+}
+
+func TestExpiredQuoteMustBeRevisedBeforeAcceptance(t *testing.T) {
+	qg, _, clock := newTestQuoteGenerator(t)
+	quote, err := qg.CreateQuote(Party{Name: "Initech"}, chairQuote(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	*clock = clock.AddDate(0, 0, 14)
+	if status := quote.StatusAt(*clock); status != QuoteOpen {
+		t.Fatalf("status on the last valid day = %s, want open", status)
+	}
+	*clock = clock.Add(time.Second)
+	if status := quote.StatusAt(*clock); status != QuoteExpired {
+		t.Fatalf("status after the validity window = %s, want expired", status)
+	}
+	if _, err := qg.Accept(quote.Number, 1); !errors.Is(err, ErrQuoteExpired) {
+		t.Fatalf("accepting a lapsed quote: got %v, want ErrQuoteExpired", err)
+	}
+
+	renewed, err := qg.Revise(quote.Number, chairQuote(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := qg.Accept(quote.Number, 1); !errors.Is(err, ErrStaleRevision) {
+		t.Fatalf("accepting the superseded version: got %v, want ErrStaleRevision", err)
+	}
+	accepted, err := qg.Accept(quote.Number, renewed.Current().Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accepted.Status != QuoteAccepted || accepted.Current().Version != 2 {
+		t.Errorf("accepted quote is %s at v%d, want accepted at v2", accepted.Status, accepted.Current().Version)
+	}
+	if _, err := qg.Revise(quote.Number, chairQuote(6)); !errors.Is(err, ErrQuoteState) {
+		t.Errorf("revising an accepted quote: got %v, want ErrQuoteState", err)
+	}
+}
+
+func TestConvertingAQuoteKeepsItsPricesUntilItLapses(t *testing.T) {
+	qg, prices, clock := newTestQuoteGenerator(t)
+	quote, err := qg.CreateQuote(Party{Name: "Initech"}, chairQuote(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := qg.ConvertToOrder(quote.Number); !errors.Is(err, ErrQuoteState) {
+		t.Fatalf("converting an open quote: got %v, want ErrQuoteState", err)
+	}
+	if _, err := qg.Accept(quote.Number, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	prices.Set(CatalogItem{SKU: "CHAIR-02", Description: "Office chair", Category: "general", UnitPrice: NewMoney(19900, "USD")})
+	*clock = clock.AddDate(0, 0, 7)
+	order, err := qg.ConvertToOrder(quote.Number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Total.MinorUnits() != 75600 || order.QuoteNumber != quote.Number {
+		t.Errorf("order %s for %s, want $756.00 from %s", order.Id, order.Total.Format(), quote.Number)
+	}
+	converted, err := qg.Quote(quote.Number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if converted.Status != QuoteConverted || converted.OrderId != order.Id || len(converted.Revisions) != 1 {
+		t.Errorf("quote after conversion: %s, order %q, %d revisions", converted.Status, converted.OrderId, len(converted.Revisions))
+	}
+}
+
+func TestLapsedQuoteIsRepricedOnConversion(t *testing.T) {
+	qg, prices, clock := newTestQuoteGenerator(t)
+	quote, err := qg.CreateQuote(Party{Name: "Initech"}, chairQuote(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := qg.Accept(quote.Number, 1); err != nil {
+		t.Fatal(err)
+	}
+	*clock = clock.AddDate(0, 0, 20)
+
+	// A repricing that fails must leave the quote exactly as accepted.
+	qg.prices = NewPriceList()
+	if _, err := qg.ConvertToOrder(quote.Number); !errors.Is(err, ErrUnknownProduct) {
+		t.Fatalf("repricing without a price: got %v, want ErrUnknownProduct", err)
+	}
+	unchanged, _ := qg.Quote(quote.Number)
+	if unchanged.Status != QuoteAccepted || len(unchanged.Revisions) != 1 {
+		t.Fatalf("after a failed conversion the quote is %s with %d revisions", unchanged.Status, len(unchanged.Revisions))
+	}
+
+	qg.prices = prices
+	prices.Set(CatalogItem{SKU: "CHAIR-02", Description: "Office chair", Category: "general", UnitPrice: NewMoney(19900, "USD")})
+	order, err := qg.ConvertToOrder(quote.Number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Total.MinorUnits() != 79600 {
+		t.Errorf("order total %s, want the repriced $796.00", order.Total.Format())
+	}
+	converted, _ := qg.Quote(quote.Number)
+	current := converted.Current()
+	if len(converted.Revisions) != 2 || current.Version != 2 || !current.Repriced || current.Total != order.Total {
+		t.Errorf("repriced revision = v%d repriced=%v total %s, want v2 matching the order", current.Version, current.Repriced, current.Total.Format())
+	}
+	if converted.Revisions[0].Total.MinorUnits() != 75600 {
+		t.Errorf("original revision changed to %s", converted.Revisions[0].Total.Format())
+	}
+}
+
+func TestQuotesAreCopiedOut(t *testing.T) {
+	qg, _, _ := newTestQuoteGenerator(t)
+	quote, err := qg.CreateQuote(Party{Name: "Initech", Address: []string{"1 Main St"}}, chairQuote(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	quote.Customer.Address[0] = "changed"
+	quote.Revisions[0].Lines[0].Quantity = 99
+	quote.Revisions[0].Request.Lines[0].SKU = "changed"
+
+	stored, _ := qg.Quote(quote.Number)
+	if stored.Customer.Address[0] != "1 Main St" || stored.Current().Lines[0].Quantity != 4 || stored.Current().Request.Lines[0].SKU != "CHAIR-02" {
+		t.Errorf("changing a returned quote reached the stored one: %+v", stored)
+	}
+}
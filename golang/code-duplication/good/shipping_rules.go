@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+var (
+	ErrUnknownCarrier = errors.New("unknown carrier")
+	ErrOverweight     = errors.New("shipment exceeds heaviest weight tier")
+)
+
+// ShippingZone groups destinations priced alike. ZIP prefixes take
+// precedence over states, so outlying areas of a state can sit in a
+// farther zone.
+type ShippingZone struct {
+	Zone        int      `json:"zone"`
+	Multiplier  float64  `json:"multiplier"`
+	States      []string `json:"states,omitempty"`
+	ZipPrefixes []string `json:"zip_prefixes,omitempty"`
+	Remote      bool     `json:"remote,omitempty"`
+}
+
+// WeightTier prices every shipment up to UpTo pounds at Price. The last
+// tier may leave UpTo empty and charge PerUnitOver for each pound (or part)
+// beyond the previous tier.
+type WeightTier struct {
+	UpTo        *float64 `json:"up_to,omitempty"`
+	Price       string   `json:"price"`
+	PerUnitOver string   `json:"per_unit_over,omitempty"`
+}
+
+// CarrierRule lists what a carrier adds on top of the zone price: a fuel
+// surcharge as a fraction of it, a flat handling fee, and a fee for remote
+// zones.
+type CarrierRule struct {
+	Name          string  `json:"name"`
+	FuelSurcharge float64 `json:"fuel_surcharge"`
+	Handling      string  `json:"handling,omitempty"`
+	RemoteArea    string  `json:"remote_area,omitempty"`
+}
+
+type Surcharge struct {
+	Name   string
+	Amount Money
+}
+
+type ShippingCharge struct {
+	Carrier    string
+	Zone       int
+	Base       Money
+	Surcharges []Surcharge
+	Total      Money
+}
+
+type weightTier struct {
+	upTo        float64
+	price       Money
+	perUnitOver Money
+}
+
+type carrierRule struct {
+	name          string
+	fuelSurcharge float64
+	handling      Money
+	remoteArea    Money
+}
+
+type ShippingCalculator struct {
+	currency    string
+	stateZones  map[string]ShippingZone
+	zipZones    map[string]ShippingZone
+	weightTiers []weightTier
+	carriers    map[string]carrierRule
+}
+
+func NewShippingCalculator(currency string, zones []ShippingZone, tiers []WeightTier, carriers []CarrierRule) (*ShippingCalculator, error) {
+	sc := &ShippingCalculator{
+		currency:   currency,
+		stateZones: map[string]ShippingZone{},
+		zipZones:   map[string]ShippingZone{},
+		carriers:   map[string]carrierRule{},
+	}
+
+	for _, zone := range zones {
+		if zone.Multiplier <= 0 {
+			return nil, fmt.Errorf("shipping zone %d: multiplier must be positive", zone.Zone)
+		}
+		for _, state := range zone.States {
+			state = strings.ToUpper(strings.TrimSpace(state))
+			if other, ok := sc.stateZones[state]; ok {
+				return nil, fmt.Errorf("state %s is in zones %d and %d", state, other.Zone, zone.Zone)
+			}
+			sc.stateZones[state] = zone
+		}
+		for _, prefix := range zone.ZipPrefixes {
+			sc.zipZones[strings.TrimSpace(prefix)] = zone
+		}
+	}
+
+	previous := 0.0
+	for i, tier := range tiers {
+		price, err := ParseMoney(tier.Price, currency)
+		if err != nil {
+			return nil, fmt.Errorf("weight tier %d: %w", i+1, err)
+		}
+		compiled := weightTier{upTo: math.Inf(1), price: price, perUnitOver: NewMoney(0, currency)}
+		if tier.UpTo != nil {
+			compiled.upTo = *tier.UpTo
+		} else if i != len(tiers)-1 {
+			return nil, fmt.Errorf("weight tier %d: only the last tier may be open-ended", i+1)
+		}
+		if compiled.upTo <= previous {
+			return nil, fmt.Errorf("weight tier %d: tiers must increase in weight", i+1)
+		}
+		if tier.PerUnitOver != "" {
+			if compiled.perUnitOver, err = ParseMoney(tier.PerUnitOver, currency); err != nil {
+				return nil, fmt.Errorf("weight tier %d: %w", i+1, err)
+			}
+		}
+		previous = compiled.upTo
+		sc.weightTiers = append(sc.weightTiers, compiled)
+	}
+	if len(sc.weightTiers) == 0 {
+		return nil, fmt.Errorf("no weight tiers defined")
+	}
+
+	for _, carrier := range carriers {
+		name := strings.ToLower(strings.TrimSpace(carrier.Name))
+		rule := carrierRule{name: name, fuelSurcharge: carrier.FuelSurcharge, handling: NewMoney(0, currency), remoteArea: NewMoney(0, currency)}
+		var err error
+		if carrier.Handling != "" {
+			if rule.handling, err = ParseMoney(carrier.Handling, currency); err != nil {
+				return nil, fmt.Errorf("carrier %s: %w", name, err)
+			}
+		}
+		if carrier.RemoteArea != "" {
+			if rule.remoteArea, err = ParseMoney(carrier.RemoteArea, currency); err != nil {
+				return nil, fmt.Errorf("carrier %s: %w", name, err)
+			}
+		}
+		sc.carriers[name] = rule
+	}
+	return sc, nil
+}
+
+// Zone finds the destination's zone, preferring the longest matching ZIP
+// prefix over the state.
+func (sc ShippingCalculator) Zone(destination Destination) (ShippingZone, error) {
+	zip := destination.zip5()
+	for length := len(zip); length > 0; length-- {
+		if zone, ok := sc.zipZones[zip[:length]]; ok {
+			return zone, nil
+		}
+	}
+	if zone, ok := sc.stateZones[strings.ToUpper(strings.TrimSpace(destination.State))]; ok {
+		return zone, nil
+	}
+	return ShippingZone{}, fmt.Errorf("%w: no shipping zone for %s %s", ErrUnknownJurisdiction, destination.State, destination.Zip)
+}
+
+func (sc ShippingCalculator) weightPrice(weight float64) (Money, error) {
+	previous := 0.0
+	for _, tier := range sc.weightTiers {
+		if weight <= tier.upTo {
+			extraUnits := 0.0
+			if math.IsInf(tier.upTo, 1) {
+				extraUnits = math.Ceil(weight - previous)
+			}
+			extra, err := tier.perUnitOver.Multiply(extraUnits, RoundHalfUp)
+			if err != nil {
+				return Money{}, err
+			}
+			return tier.price.Add(extra)
+		}
+		previous = tier.upTo
+	}
+	return Money{}, fmt.Errorf("%w: %v lbs over the %v lbs limit", ErrOverweight, weight, previous)
+}
+
+func (sc ShippingCalculator) CalculateShipping(weight float64, destination Destination, carrier string) (ShippingCharge, error) {
+	if weight <= 0 {
+		return ShippingCharge{}, fmt.Errorf("shipment weight must be positive, got %v", weight)
+	}
+	rule, ok := sc.carriers[strings.ToLower(strings.TrimSpace(carrier))]
+	if !ok {
+		return ShippingCharge{}, fmt.Errorf("%w: %q", ErrUnknownCarrier, carrier)
+	}
+	zone, err := sc.Zone(destination)
+	if err != nil {
+		return ShippingCharge{}, err
+	}
+	tierPrice, err := sc.weightPrice(weight)
+	if err != nil {
+		return ShippingCharge{}, err
+	}
+
+	base, err := tierPrice.Multiply(zone.Multiplier, RoundHalfUp)
+	if err != nil {
+		return ShippingCharge{}, err
+	}
+	fuel, err := base.Multiply(rule.fuelSurcharge, RoundHalfUp)
+	if err != nil {
+		return ShippingCharge{}, err
+	}
+	charge := ShippingCharge{Carrier: rule.name, Zone: zone.Zone, Base: base, Total: base}
+	surcharges := []Surcharge{{Name: "fuel", Amount: fuel}, {Name: "handling", Amount: rule.handling}}
+	if zone.Remote {
+		surcharges = append(surcharges, Surcharge{Name: "remote area", Amount: rule.remoteArea})
+	}
+	for _, surcharge := range surcharges {
+		if surcharge.Amount.IsZero() {
+			continue
+		}
+		if charge.Total, err = charge.Total.Add(surcharge.Amount); err != nil {
+			return ShippingCharge{}, err
+		}
+		charge.Surcharges = append(charge.Surcharges, surcharge)
+	}
+	return charge, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrUnknownJurisdiction = errors.New("unknown jurisdiction")
+
+// Destination is where goods are delivered; it decides both sales tax and
+// shipping zone.
+type Destination struct {
+	State  string
+	County string
+	Zip    string
+}
+
+func (d Destination) zip5() string {
+	zip := strings.TrimSpace(d.Zip)
+	if len(zip) > 5 {
+		zip = zip[:5]
+	}
+	return zip
+}
+
+// TaxJurisdiction is one state's sales tax table. County rates are added to
+// the state rate; a ZIP rate replaces both, for places with district taxes
+// that do not follow county lines. Exempt categories are untaxed anywhere
+// in the state.
+type TaxJurisdiction struct {
+	State            string             `json:"state"`
+	Rate             float64            `json:"rate"`
+	Counties         map[string]float64 `json:"counties,omitempty"`
+	Zips             map[string]float64 `json:"zips,omitempty"`
+	ExemptCategories []string           `json:"exempt_categories,omitempty"`
+}
+
+type TaxCalculator struct {
+	jurisdictions map[string]TaxJurisdiction
+}
+
+func NewTaxCalculator(jurisdictions []TaxJurisdiction) (*TaxCalculator, error) {
+	tc := &TaxCalculator{jurisdictions: map[string]TaxJurisdiction{}}
+	for _, jurisdiction := range jurisdictions {
+		state := strings.ToUpper(strings.TrimSpace(jurisdiction.State))
+		if state == "" {
+			return nil, fmt.Errorf("tax jurisdiction has no state")
+		}
+		if _, ok := tc.jurisdictions[state]; ok {
+			return nil, fmt.Errorf("tax jurisdiction %s listed twice", state)
+		}
+		if jurisdiction.Rate < 0 || jurisdiction.Rate >= 1 {
+			return nil, fmt.Errorf("tax jurisdiction %s: rate %v out of range", state, jurisdiction.Rate)
+		}
+
+		counties := map[string]float64{}
+		for county, rate := range jurisdiction.Counties {
+			counties[normalizeCounty(county)] = rate
+		}
+		jurisdiction.State = state
+		jurisdiction.Counties = counties
+		tc.jurisdictions[state] = jurisdiction
+	}
+	return tc, nil
+}
+
+func normalizeCounty(county string) string {
+	county = strings.ToUpper(strings.TrimSpace(county))
+	return strings.TrimSuffix(county, " COUNTY")
+}
+
+// TaxRate is the combined rate for a product category at a destination.
+// Counties without their own entry pay the state rate.
+func (tc TaxCalculator) TaxRate(category string, destination Destination) (float64, error) {
+	state := strings.ToUpper(strings.TrimSpace(destination.State))
+	jurisdiction, ok := tc.jurisdictions[state]
+	if !ok {
+		return 0, fmt.Errorf("%w: no sales tax table for state %q", ErrUnknownJurisdiction, destination.State)
+	}
+
+	for _, exempt := range jurisdiction.ExemptCategories {
+		if strings.EqualFold(exempt, category) {
+			return 0, nil
+		}
+	}
+	if rate, ok := jurisdiction.Zips[destination.zip5()]; ok {
+		return rate, nil
+	}
+	return jurisdiction.Rate + jurisdiction.Counties[normalizeCounty(destination.County)], nil
+}
+
+func (tc TaxCalculator) CalculateTax(price Money, category string, destination Destination) (Money, error) {
+	rate, err := tc.TaxRate(category, destination)
+	if err != nil {
+		return Money{}, err
+	}
+	return price.Multiply(rate, RoundHalfUp)
+}
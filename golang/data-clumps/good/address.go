@@ -0,0 +1,318 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidAddress = errors.New("invalid address")
+
+type AddressIssue struct {
+	Field   string
+	Problem string
+}
+
+// AddressError lists everything wrong with an address at once, so a form
+// can flag every field in one round trip.
+type AddressError struct {
+	Issues []AddressIssue
+}
+
+func (e *AddressError) Error() string {
+	parts := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		parts[i] = issue.Field + ": " + issue.Problem
+	}
+	return "invalid address: " + strings.Join(parts, "; ")
+}
+
+func (e *AddressError) Unwrap() error {
+	return ErrInvalidAddress
+}
+
+type Address struct {
+	street  string
+	city    string
+	state   string
+	zipCode string
+	country string
+}
+
+func NewAddress(street, city, state, zipCode string) *Address {
+	return &Address{
+		street:  street,
+		city:    city,
+		state:   state,
+		zipCode: zipCode,
+		country: "US",
+	}
+}
+
+// NewInternationalAddress takes the region (state, province, county) and
+// postal code in whatever form the country uses.
+func NewInternationalAddress(street, city, region, postalCode, country string) *Address {
+	return &Address{
+		street:  street,
+		city:    city,
+		state:   region,
+		zipCode: postalCode,
+		country: country,
+	}
+}
+
+func (a Address) GetStreet() string {
+	return a.street
+}
+
+func (a Address) GetCity() string {
+	return a.city
+}
+
+func (a Address) GetState() string {
+	return a.state
+}
+
+func (a Address) GetZipCode() string {
+	return a.zipCode
+}
+
+func (a Address) GetCountry() string {
+	if a.country == "" {
+		return "US"
+	}
+	return a.country
+}
+
+// Normalize returns the address in standard form: ISO country code, state
+// and province codes, formatted postal code and, for North American
+// addresses, USPS street suffix and unit abbreviations.
+func (a Address) Normalize() *Address {
+	country := normalizeCountry(a.country)
+	normalized := &Address{
+		street:  collapseSpaces(a.street),
+		city:    collapseSpaces(a.city),
+		state:   strings.ToUpper(collapseSpaces(a.state)),
+		zipCode: strings.ToUpper(collapseSpaces(a.zipCode)),
+		country: country,
+	}
+
+	switch country {
+	case "US":
+		if code, ok := usStateNames[normalized.state]; ok {
+			normalized.state = code
+		}
+		normalized.street = normalizeStreet(normalized.street)
+	case "CA":
+		if code, ok := canadianProvinceNames[normalized.state]; ok {
+			normalized.state = code
+		}
+		normalized.street = normalizeStreet(normalized.street)
+	}
+	if format, ok := countryFormats[country]; ok && normalized.zipCode != "" {
+		normalized.zipCode = format.Format(normalized.zipCode)
+	}
+	return normalized
+}
+
+// Validate normalizes the address and checks it against the country's
+// postal rules; US ZIP codes must also belong to the given state.
+func (a Address) Validate() error {
+	n := a.Normalize()
+	var issues []AddressIssue
+	add := func(field, problem string, args ...interface{}) {
+		issues = append(issues, AddressIssue{Field: field, Problem: fmt.Sprintf(problem, args...)})
+	}
+
+	if n.street == "" {
+		add("street", "is required")
+	}
+	if n.city == "" {
+		add("city", "is required")
+	}
+	format, ok := countryFormats[n.country]
+	if !ok {
+		add("country", "%q is not supported", a.country)
+		return &AddressError{Issues: issues}
+	}
+
+	regionOk := true
+	switch {
+	case n.state == "" && format.RegionRequired:
+		add("state", "is required in %s", format.Name)
+		regionOk = false
+	case n.country == "US":
+		if _, known := usStates[n.state]; !known {
+			add("state", "%q is not a US state", a.state)
+			regionOk = false
+		}
+	case format.Regions != nil:
+		if _, known := format.Regions[n.state]; !known {
+			add("state", "%q is not a region of %s", a.state, format.Name)
+			regionOk = false
+		}
+	}
+
+	switch {
+	case n.zipCode == "":
+		add("zip_code", "is required")
+	case !format.PostalPattern.MatchString(n.zipCode):
+		add("zip_code", "%q is not a valid %s postal code", a.zipCode, format.Name)
+	case regionOk && n.country == "US" && !usStates[n.state].ownsZip(n.zipCode):
+		add("zip_code", "%s is not in %s", n.zipCode, usStates[n.state].name)
+	case regionOk && n.country == "CA" && !strings.ContainsRune(canadianProvinces[n.state], rune(n.zipCode[0])):
+		add("zip_code", "%s is not in province %s", n.zipCode, n.state)
+	}
+
+	if len(issues) > 0 {
+		return &AddressError{Issues: issues}
+	}
+	return nil
+}
+
+func (a Address) IsValid() bool {
+	return a.Validate() == nil
+}
+
+func (a Address) ToString() string {
+	s := fmt.Sprintf("%s, %s, %s %s", a.street, a.city, a.state, a.zipCode)
+	if country := a.GetCountry(); country != "US" {
+		s += ", " + country
+	}
+	return s
+}
+
+// ToLabelFormat prints the address the way carriers read it: normalized,
+// upper case, without punctuation, the locality line laid out for the
+// destination country, and the country name last for anything abroad.
+func (a Address) ToLabelFormat() string {
+	n := a.Normalize()
+	format, known := countryFormats[n.country]
+	street, city, region := labelText(n.street), labelText(n.city), labelText(n.state)
+
+	lines := []string{street}
+	switch {
+	case !known:
+		lines = append(lines, strings.TrimSpace(city+" "+region+" "+n.zipCode))
+	case format.Layout == PostalCity:
+		lines = append(lines, n.zipCode+" "+city)
+	case format.Layout == CityThenPostal:
+		lines = append(lines, city, n.zipCode)
+	default:
+		lines = append(lines, strings.Join(strings.Fields(city+" "+region+" "+n.zipCode), " "))
+	}
+
+	switch {
+	case n.country == "US":
+	case known:
+		lines = append(lines, strings.ToUpper(format.Name))
+	default:
+		lines = append(lines, strings.ToUpper(n.country))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func labelText(s string) string {
+	s = strings.NewReplacer(".", "", ",", "").Replace(s)
+	return strings.ToUpper(collapseSpaces(s))
+}
+
+func collapseSpaces(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func normalizeCountry(country string) string {
+	country = strings.ToUpper(collapseSpaces(strings.ReplaceAll(country, ".", "")))
+	if country == "" {
+		return "US"
+	}
+	if code, ok := countryAliases[country]; ok {
+		return code
+	}
+	return country
+}
+
+var canadianProvinceNames = map[string]string{
+	"ALBERTA": "AB", "BRITISH COLUMBIA": "BC", "MANITOBA": "MB", "NEW BRUNSWICK": "NB",
+	"NEWFOUNDLAND AND LABRADOR": "NL", "NOVA SCOTIA": "NS", "NORTHWEST TERRITORIES": "NT",
+	"NUNAVUT": "NU", "ONTARIO": "ON", "PRINCE EDWARD ISLAND": "PE", "QUEBEC": "QC",
+	"SASKATCHEWAN": "SK", "YUKON": "YT",
+}
+
+// USPS Publication 28 abbreviations, keyed by the lower-case spelled-out
+// word and its common variants.
+var streetSuffixes = map[string]string{
+	"street": "St", "st": "St", "str": "St",
+	"avenue": "Ave", "ave": "Ave", "av": "Ave",
+	"boulevard": "Blvd", "blvd": "Blvd",
+	"road": "Rd", "rd": "Rd",
+	"drive": "Dr", "dr": "Dr",
+	"lane": "Ln", "ln": "Ln",
+	"court": "Ct", "ct": "Ct",
+	"place": "Pl", "pl": "Pl",
+	"terrace": "Ter", "ter": "Ter",
+	"parkway": "Pkwy", "pkwy": "Pkwy",
+	"highway": "Hwy", "hwy": "Hwy",
+	"circle": "Cir", "cir": "Cir",
+	"square": "Sq", "sq": "Sq",
+	"trail": "Trl", "trl": "Trl",
+	"way":        "Way",
+	"expressway": "Expy", "freeway": "Fwy",
+}
+
+var directionals = map[string]string{
+	"north": "N", "n": "N", "south": "S", "s": "S", "east": "E", "e": "E", "west": "W", "w": "W",
+	"northeast": "NE", "ne": "NE", "northwest": "NW", "nw": "NW",
+	"southeast": "SE", "se": "SE", "southwest": "SW", "sw": "SW",
+}
+
+var unitDesignators = map[string]string{
+	"apartment": "Apt", "apt": "Apt", "suite": "Ste", "ste": "Ste", "unit": "Unit",
+	"floor": "Fl", "fl": "Fl", "building": "Bldg", "bldg": "Bldg", "room": "Rm", "rm": "Rm",
+}
+
+// normalizeStreet abbreviates the suffix (last word before any unit),
+// pre- and post-directionals, and unit designators. Words elsewhere in the
+// street name are left alone.
+func normalizeStreet(street string) string {
+	words := strings.Fields(strings.ReplaceAll(street, ",", " "))
+	for i, word := range words {
+		words[i] = strings.TrimSuffix(word, ".")
+	}
+
+	end := len(words)
+	for i, word := range words {
+		if _, ok := unitDesignators[strings.ToLower(word)]; ok || strings.HasPrefix(word, "#") {
+			end = i
+			break
+		}
+	}
+	for i := end; i < len(words); i++ {
+		if unit, ok := unitDesignators[strings.ToLower(words[i])]; ok {
+			words[i] = unit
+		}
+	}
+
+	last := end - 1
+	if last >= 2 {
+		if dir, ok := directionals[strings.ToLower(words[last])]; ok {
+			words[last] = dir
+			last--
+		}
+	}
+	if last >= 1 {
+		if suffix, ok := streetSuffixes[strings.ToLower(words[last])]; ok {
+			words[last] = suffix
+		}
+	}
+	if end > 2 && isHouseNumber(words[0]) {
+		if dir, ok := directionals[strings.ToLower(words[1])]; ok {
+			words[1] = dir
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func isHouseNumber(word string) bool {
+	return word != "" && word[0] >= '0' && word[0] <= '9'
+}
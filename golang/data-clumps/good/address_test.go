@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeStreet(t *testing.T) {
+	tests := []struct {
+		street string
+		want   string
+	}{
+		{"123 main street", "123 main St"},
+		{"45 North Elm Avenue Apt. 4", "45 N Elm Ave Apt 4"},
+		{"700 Park Ave., Suite 300", "700 Park Ave Ste 300"},
+		{"1600 Pennsylvania Avenue NW", "1600 Pennsylvania Ave NW"},
+		{"9 Avenue Road", "9 Avenue Rd"},
+		{"10 Main Street #5", "10 Main St #5"},
+	}
+	for _, tt := range tests {
+		if got := normalizeStreet(tt.street); got != tt.want {
+			t.Errorf("normalizeStreet(%q) = %q, want %q", tt.street, got, tt.want)
+		}
+	}
+}
+
+func TestValidateAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address *Address
+		fields  []string
+		problem string
+	}{
+		{"state name and ZIP+4", NewAddress("1 Main St", "Albany", "New York", "12207 1234"), nil, ""},
+		{"ZIP from another state", NewAddress("1 Main St", "Beverly Hills", "NY", "90210"), []string{"zip_code"}, "90210 is not in New York"},
+		{"unknown state skips the ZIP range check", NewAddress("1 Main St", "Springfield", "ZZ", "90210"), []string{"state"}, ""},
+		{"malformed ZIP", NewAddress("9 Elm St", "Austin", "TX", "7870"), []string{"zip_code"}, "not a valid United States postal code"},
+		{"every missing field at once", NewAddress(" ", "", "CA", ""), []string{"street", "city", "zip_code"}, ""},
+		{"Canadian province by name", NewInternationalAddress("24 Sussex Dr", "Ottawa", "Ontario", "k1a0b1", "Canada"), nil, ""},
+		{"postal code from another province", NewInternationalAddress("24 Sussex Dr", "Ottawa", "BC", "K1A 0B1", "CA"), []string{"zip_code"}, "not in province BC"},
+		{"UK without a region", NewInternationalAddress("10 Downing St", "London", "", "sw1a2aa", "UK"), nil, ""},
+		{"Australia needs a state", NewInternationalAddress("1 George St", "Sydney", "", "2000", "Australia"), []string{"state"}, ""},
+		{"Dutch postal code", NewInternationalAddress("Dam 1", "Amsterdam", "", "1012JS", "Netherlands"), nil, ""},
+		{"unsupported country", NewInternationalAddress("1 Castle Rd", "Cair Paravel", "", "1", "Narnia"), []string{"country"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.address.Validate()
+			if tt.fields == nil {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			var addressErr *AddressError
+			if !errors.As(err, &addressErr) || !errors.Is(err, ErrInvalidAddress) {
+				t.Fatalf("Validate() = %v, want an *AddressError", err)
+			}
+			var fields []string
+			for _, issue := range addressErr.Issues {
+				fields = append(fields, issue.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+				t.Errorf("issues with %v, want %v: %v", fields, tt.fields, err)
+			}
+			if !strings.Contains(err.Error(), tt.problem) {
+				t.Errorf("Validate() = %v, want it to mention %q", err, tt.problem)
+			}
+		})
+	}
+}
+
+func TestLabelFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		address *Address
+		want    string
+	}{
+		{"US", NewAddress("123 Main Street, Apt 4", "Anytown", "California", "95401"), "123 MAIN ST APT 4\nANYTOWN CA 95401"},
+		{"Canada", NewInternationalAddress("24 Sussex Drive", "Ottawa", "Ontario", "k1a0b1", "Canada"), "24 SUSSEX DR\nOTTAWA ON K1A 0B1\nCANADA"},
+		{"postal code before city", NewInternationalAddress("Unter den Linden 77", "Berlin", "", "10117", "Germany"), "UNTER DEN LINDEN 77\n10117 BERLIN\nGERMANY"},
+		{"postal code on its own line", NewInternationalAddress("10 Downing St.", "London", "", "sw1a2aa", "UK"), "10 DOWNING ST\nLONDON\nSW1A 2AA\nUNITED KINGDOM"},
+	}
+	for _, tt := range tests {
+		if got := tt.address.ToLabelFormat(); got != tt.want {
+			t.Errorf("%s label =\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
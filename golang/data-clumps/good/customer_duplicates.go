@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var ErrDuplicateCustomer = errors.New("customer already exists")
+
+// DuplicateKeys are the coarse keys candidates are looked up by. They are
+// cheap to index; the real comparison happens in scoreDuplicate.
+type DuplicateKeys struct {
+	Email   string
+	Phone   string
+	Name    string
+	Address string
+}
+
+func duplicateKeys(person *Person, address *Address) DuplicateKeys {
+	keys := DuplicateKeys{
+		Email: emailKey(person.GetEmail()),
+		Phone: phoneKey(person.GetPhone()),
+		Name:  nameKey(person.GetFirstName(), person.GetLastName()),
+	}
+	if address != nil {
+		keys.Address = addressKey(*address)
+	}
+	return keys
+}
+
+type DuplicateMatch struct {
+	CustomerId int64
+	Score      float64
+	Reasons    []string
+}
+
+// DuplicateCustomerError lists the existing customers a new one looks
+// like, best match first.
+type DuplicateCustomerError struct {
+	Matches []DuplicateMatch
+}
+
+func (e *DuplicateCustomerError) Error() string {
+	parts := make([]string, len(e.Matches))
+	for i, match := range e.Matches {
+		parts[i] = fmt.Sprintf("customer %d (%s)", match.CustomerId, strings.Join(match.Reasons, ", "))
+	}
+	return "customer already exists: " + strings.Join(parts, "; ")
+}
+
+func (e *DuplicateCustomerError) Unwrap() error {
+	return ErrDuplicateCustomer
+}
+
+// A matching email or phone is enough on its own. Name and address only
+// rank matches: families share addresses and common names repeat, so they
+// never make a duplicate without a shared way of contacting the person.
+const (
+	duplicateThreshold  = 0.6
+	emailWeight         = 0.7
+	phoneWeight         = 0.7
+	nameWeight          = 0.3
+	addressWeight       = 0.3
+	nameSimilarityFloor = 0.85
+)
+
+// scoreDuplicate also reports whether the candidate shares an email or
+// phone with the person.
+func scoreDuplicate(person *Person, keys DuplicateKeys, candidate *Customer) (DuplicateMatch, bool) {
+	match := DuplicateMatch{CustomerId: candidate.Id}
+	contact := false
+	if keys.Email != "" && keys.Email == emailKey(candidate.Email) {
+		match.Score += emailWeight
+		match.Reasons = append(match.Reasons, "same email")
+		contact = true
+	}
+	if keys.Phone != "" && keys.Phone == phoneKey(candidate.Phone) {
+		match.Score += phoneWeight
+		match.Reasons = append(match.Reasons, "same phone")
+		contact = true
+	}
+
+	similarity := jaroWinkler(
+		strings.ToLower(collapseSpaces(person.GetFullName())),
+		strings.ToLower(collapseSpaces(candidate.FullName())))
+	if similarity >= nameSimilarityFloor {
+		match.Score += nameWeight
+		match.Reasons = append(match.Reasons, fmt.Sprintf("similar name %q", candidate.FullName()))
+	}
+
+	if keys.Address != "" {
+		for _, address := range candidate.Addresses {
+			if addressKey(address.Address) == keys.Address {
+				match.Score += addressWeight
+				match.Reasons = append(match.Reasons, "same address")
+				break
+			}
+		}
+	}
+	return match, contact
+}
+
+func findDuplicates(person *Person, keys DuplicateKeys, candidates []*Customer) []DuplicateMatch {
+	var matches []DuplicateMatch
+	for _, candidate := range candidates {
+		if match, contact := scoreDuplicate(person, keys, candidate); contact && match.Score >= duplicateThreshold {
+			matches = append(matches, match)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	return matches
+}
+
+// emailKey folds the spellings one mailbox can have: case, "+tag"
+// sub-addresses and, for Gmail, dots in the local part.
+func emailKey(email string) string {
+	local, domain, found := strings.Cut(strings.ToLower(strings.TrimSpace(email)), "@")
+	if !found {
+		return local
+	}
+	local, _, _ = strings.Cut(local, "+")
+	if domain == "googlemail.com" {
+		domain = "gmail.com"
+	}
+	if domain == "gmail.com" {
+		local = strings.ReplaceAll(local, ".", "")
+	}
+	return local + "@" + domain
+}
+
+// phoneKey keeps only the digits of a phone number, dropping the North
+// American country code, so "(555) 123-4567" and "+1 555.123.4567" agree.
+// Numbers too short to identify anyone give no key.
+func phoneKey(phone *string) string {
+	if phone == nil {
+		return ""
+	}
+	var digits strings.Builder
+	for _, r := range *phone {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+	key := digits.String()
+	if len(key) == 11 && key[0] == '1' {
+		key = key[1:]
+	}
+	if len(key) < 7 {
+		return ""
+	}
+	return key
+}
+
+// nameKey is the Soundex code of the last name plus the first initial, so
+// "Jon Smyth" and "John Smith" land in the same bucket.
+func nameKey(firstName, lastName string) string {
+	code := soundex(lastName)
+	first := strings.ToUpper(strings.TrimSpace(firstName))
+	if code == "" || first == "" {
+		return code
+	}
+	return code + first[:1]
+}
+
+// addressKey identifies a delivery point regardless of how it was typed:
+// normalized street, five-digit ZIP (or the postal code) and country.
+func addressKey(address Address) string {
+	n := address.Normalize()
+	postal := strings.ReplaceAll(n.zipCode, " ", "")
+	if n.country == "US" && len(postal) > 5 {
+		postal = postal[:5]
+	}
+	street := labelText(n.street)
+	if street == "" || postal == "" {
+		return ""
+	}
+	return n.country + "|" + postal + "|" + street
+}
+
+var soundexCodes = map[rune]byte{
+	'B': '1', 'F': '1', 'P': '1', 'V': '1',
+	'C': '2', 'G': '2', 'J': '2', 'K': '2', 'Q': '2', 'S': '2', 'X': '2', 'Z': '2',
+	'D': '3', 'T': '3',
+	'L': '4',
+	'M': '5', 'N': '5',
+	'R': '6',
+}
+
+// soundex is American Soundex: H and W do not separate letters with the
+// same code, vowels do.
+func soundex(name string) string {
+	var letters []rune
+	for _, r := range strings.ToUpper(name) {
+		if r >= 'A' && r <= 'Z' {
+			letters = append(letters, r)
+		}
+	}
+	if len(letters) == 0 {
+		return ""
+	}
+
+	code := []byte{byte(letters[0])}
+	last := soundexCodes[letters[0]]
+	for _, r := range letters[1:] {
+		if len(code) == 4 {
+			break
+		}
+		digit, ok := soundexCodes[r]
+		switch {
+		case ok && digit != last:
+			code = append(code, digit)
+			last = digit
+		case !ok && r != 'H' && r != 'W':
+			last = 0
+		}
+	}
+	for len(code) < 4 {
+		code = append(code, '0')
+	}
+	return string(code)
+}
+
+// jaroWinkler returns a similarity between 0 and 1 that favours strings
+// sharing a prefix, which suits names with typos near the end.
+func jaroWinkler(a, b string) float64 {
+	s, t := []rune(a), []rune(b)
+	if len(s) == 0 || len(t) == 0 {
+		if len(s) == len(t) {
+			return 1
+		}
+		return 0
+	}
+
+	window := max(len(s), len(t))/2 - 1
+	window = max(window, 0)
+	sMatched := make([]bool, len(s))
+	tMatched := make([]bool, len(t))
+	matches := 0
+	for i := range s {
+		for j := max(0, i-window); j < min(len(t), i+window+1); j++ {
+			if !tMatched[j] && s[i] == t[j] {
+				sMatched[i], tMatched[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	transpositions := 0
+	j := 0
+	for i := range s {
+		if !sMatched[i] {
+			continue
+		}
+		for !tMatched[j] {
+			j++
+		}
+		if s[i] != t[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(s)) + m/float64(len(t)) + (m-float64(transpositions/2))/m) / 3
+
+	prefix := 0
+	for prefix < min(4, len(s), len(t)) && s[prefix] == t[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
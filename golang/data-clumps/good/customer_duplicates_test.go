@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestCreateCustomerRejectsOnlyContactMatches(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCustomerService(NewMemoryCustomerRepository())
+	home := NewAddress("123 North Main Street", "Los Angeles", "CA", "90012")
+	if _, err := cs.CreateCustomer(ctx, NewPerson("John", "Doe", "john.doe@gmail.com", stringPtr("555-123-4567"), nil), home); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		person    *Person
+		address   *Address
+		duplicate bool
+		reasons   []string
+	}{
+		{"family member at the same address", NewPerson("Joan", "Doe", "joan@example.com", nil, nil), NewAddress("123 N Main St", "Los Angeles", "CA", "90012"), false, nil},
+		{"same name and address, own email", NewPerson("John", "Doe", "jd@example.net", nil, nil), NewAddress("123 N Main St", "Los Angeles", "CA", "90012"), false, nil},
+		{"same mailbox spelled differently", NewPerson("Johnny", "D", "JohnDoe+shop@googlemail.com", nil, nil), NewAddress("1 Broadway", "New York", "NY", "10004"), true, []string{"same email", `similar name "John Doe"`}},
+		{"same phone formatted differently", NewPerson("Jon", "Doe", "jon@example.org", stringPtr("+1 (555) 123.4567"), nil), home, true, []string{"same phone", `similar name "John Doe"`, "same address"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := cs.CreateCustomer(ctx, tt.person, tt.address)
+			if !tt.duplicate {
+				if err != nil {
+					t.Fatalf("CreateCustomer() = %v, want a new customer", err)
+				}
+				return
+			}
+			var duplicateErr *DuplicateCustomerError
+			if !errors.As(err, &duplicateErr) || !errors.Is(err, ErrDuplicateCustomer) {
+				t.Fatalf("CreateCustomer() = %v, want a *DuplicateCustomerError", err)
+			}
+			match := duplicateErr.Matches[0]
+			if match.CustomerId != 1 || fmt.Sprint(match.Reasons) != fmt.Sprint(tt.reasons) {
+				t.Errorf("match = customer %d %v, want customer 1 %v", match.CustomerId, match.Reasons, tt.reasons)
+			}
+		})
+	}
+}
+
+func TestConcurrentSignUpsCreateOneCustomer(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCustomerService(NewMemoryCustomerRepository())
+
+	const attempts = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, attempts)
+	for range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cs.CreateCustomer(ctx, NewPerson("John", "Doe", "john@example.com", nil, nil), NewAddress("1 Main St", "Albany", "NY", "12207"))
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrDuplicateCustomer):
+			t.Fatalf("CreateCustomer() = %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d of %d concurrent sign-ups were created, want 1", created, attempts)
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	for _, tt := range []struct {
+		fn   func(string) string
+		in   string
+		want string
+	}{
+		{emailKey, " John.Doe+news@GoogleMail.com", "johndoe@gmail.com"},
+		{emailKey, "john.doe@example.com", "john.doe@example.com"},
+		{func(s string) string { return phoneKey(&s) }, "+1 (555) 123-4567", "5551234567"},
+		{func(s string) string { return phoneKey(&s) }, "123", ""},
+		{soundex, "Robert", "R163"},
+		{soundex, "Ashcraft", "A261"},
+		{soundex, "Tymczak", "T522"},
+	} {
+		if got := tt.fn(tt.in); got != tt.want {
+			t.Errorf("key(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if nameKey("Jon", "Smyth") != nameKey("John", "Smith") {
+		t.Errorf("Jon Smyth and John Smith got different name keys")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("customer not found")
+
+type AddressKind string
+
+const (
+	ShippingAddress AddressKind = "shipping"
+	BillingAddress  AddressKind = "billing"
+)
+
+// CustomerAddress is one address a customer has used. Replacing an address
+// retires the old one rather than deleting it, so past addresses stay on
+// record as history.
+type CustomerAddress struct {
+	Id        int64
+	Kind      AddressKind
+	Address   Address
+	AddedAt   time.Time
+	RetiredAt *time.Time
+}
+
+func (ca CustomerAddress) Current() bool {
+	return ca.RetiredAt == nil
+}
+
+type Customer struct {
+	Id          int64
+	FirstName   string
+	LastName    string
+	Email       string
+	Phone       *string
+	DateOfBirth *string
+	Addresses   []CustomerAddress
+	CreatedAt   time.Time
+}
+
+func (c Customer) FullName() string {
+	return c.FirstName + " " + c.LastName
+}
+
+func (c Customer) CurrentAddress(kind AddressKind) (Address, bool) {
+	for _, address := range c.Addresses {
+		if address.Kind == kind && address.Current() {
+			return address.Address, true
+		}
+	}
+	return Address{}, false
+}
+
+type CustomerRepository interface {
+	Create(ctx context.Context, customer *Customer) error
+	// CreateUnlessDuplicate looks up the candidates for keys and stores
+	// customer only if duplicates finds none among them. Both happen as one
+	// step, so two sign-ups of the same person cannot both get in.
+	CreateUnlessDuplicate(ctx context.Context, customer *Customer, keys DuplicateKeys, duplicates func(candidates []*Customer) []DuplicateMatch) ([]DuplicateMatch, error)
+	Get(ctx context.Context, id int64) (*Customer, error)
+	// SetAddress makes address the customer's current address of its kind
+	// and moves the previous one into history.
+	SetAddress(ctx context.Context, customerId int64, kind AddressKind, address Address) error
+	// Candidates returns customers sharing any of the blocking keys used
+	// by duplicate detection; scoring happens in the caller.
+	Candidates(ctx context.Context, keys DuplicateKeys) ([]*Customer, error)
+}
+
+type memoryCustomerRepository struct {
+	mu            sync.Mutex
+	nextId        int64
+	nextAddressId int64
+	customers     map[int64]*Customer
+	now           func() time.Time
+}
+
+// NewMemoryCustomerRepository keeps customers in process memory, for demos
+// and tests. Every read and write goes through a copy, so callers never
+// share a customer with the store.
+func NewMemoryCustomerRepository() CustomerRepository {
+	return &memoryCustomerRepository{
+		nextId:        1,
+		nextAddressId: 1,
+		customers:     map[int64]*Customer{},
+		now:           time.Now,
+	}
+}
+
+func (r *memoryCustomerRepository) Create(ctx context.Context, customer *Customer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.insert(customer)
+	return nil
+}
+
+func (r *memoryCustomerRepository) CreateUnlessDuplicate(ctx context.Context, customer *Customer, keys DuplicateKeys, duplicates func(candidates []*Customer) []DuplicateMatch) ([]DuplicateMatch, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if matches := duplicates(r.candidates(keys)); len(matches) > 0 {
+		return matches, nil
+	}
+	r.insert(customer)
+	return nil, nil
+}
+
+func (r *memoryCustomerRepository) insert(customer *Customer) {
+	now := r.now()
+	customer.Id = r.nextId
+	r.nextId++
+	customer.CreatedAt = now
+	for i := range customer.Addresses {
+		customer.Addresses[i].Id = r.nextAddressId
+		r.nextAddressId++
+		customer.Addresses[i].AddedAt = now
+		customer.Addresses[i].RetiredAt = nil
+	}
+	r.customers[customer.Id] = customer.clone()
+}
+
+func (r *memoryCustomerRepository) SetAddress(ctx context.Context, customerId int64, kind AddressKind, address Address) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	customer, ok := r.customers[customerId]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrNotFound, customerId)
+	}
+	now := r.now()
+	for i := range customer.Addresses {
+		if current := &customer.Addresses[i]; current.Kind == kind && current.Current() {
+			retiredAt := now
+			current.RetiredAt = &retiredAt
+		}
+	}
+	customer.Addresses = append(customer.Addresses, CustomerAddress{Id: r.nextAddressId, Kind: kind, Address: address, AddedAt: now})
+	r.nextAddressId++
+	return nil
+}
+
+func (r *memoryCustomerRepository) Get(ctx context.Context, id int64) (*Customer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	customer, ok := r.customers[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrNotFound, id)
+	}
+	return customer.clone(), nil
+}
+
+func (r *memoryCustomerRepository) Candidates(ctx context.Context, keys DuplicateKeys) ([]*Customer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.candidates(keys), nil
+}
+
+func (r *memoryCustomerRepository) candidates(keys DuplicateKeys) []*Customer {
+	var candidates []*Customer
+	for _, customer := range r.customers {
+		if customer.sharesKey(keys) {
+			candidates = append(candidates, customer.clone())
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Id < candidates[j].Id })
+	return candidates
+}
+
+// sharesKey reports whether any blocking key matches, counting past
+// addresses as well as current ones.
+func (c *Customer) sharesKey(keys DuplicateKeys) bool {
+	if keys.Email != "" && emailKey(c.Email) == keys.Email {
+		return true
+	}
+	if keys.Phone != "" && phoneKey(c.Phone) == keys.Phone {
+		return true
+	}
+	if keys.Name != "" && nameKey(c.FirstName, c.LastName) == keys.Name {
+		return true
+	}
+	for _, address := range c.Addresses {
+		if keys.Address != "" && addressKey(address.Address) == keys.Address {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Customer) clone() *Customer {
+	copied := *c
+	copied.Phone = cloneString(c.Phone)
+	copied.DateOfBirth = cloneString(c.DateOfBirth)
+	copied.Addresses = make([]CustomerAddress, len(c.Addresses))
+	for i, address := range c.Addresses {
+		if address.RetiredAt != nil {
+			retiredAt := *address.RetiredAt
+			address.RetiredAt = &retiredAt
+		}
+		copied.Addresses[i] = address
+	}
+	return &copied
+}
+
+func cloneString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	copied := *s
+	return &copied
+}
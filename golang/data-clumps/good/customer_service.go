@@ -1,64 +1,14 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"regexp"
-	"strconv"
+	"strings"
+	"time"
 )
 
-type Address struct {
-	street  string
-	city    string
-	state   string
-	zipCode string
-}
-
-func NewAddress(street, city, state, zipCode string) *Address {
-	return &Address{
-		street:  street,
-		city:    city,
-		state:   state,
-		zipCode: zipCode,
-	}
-}
-
-func (a Address) GetStreet() string {
-	return a.street
-}
-
-func (a Address) GetCity() string {
-	return a.city
-}
-
-func (a Address) GetState() string {
-	return a.state
-}
-
-func (a Address) GetZipCode() string {
-	return a.zipCode
-}
-
-func (a Address) IsValid() bool {
-	if a.street == "" || a.city == "" || a.state == "" || a.zipCode == "" {
-		return false
-	}
-
-	if len(a.zipCode) != 5 {
-		return false
-	}
-
-	return true
-}
-
-func (a Address) ToString() string {
-	return fmt.Sprintf("%s, %s, %s %s", a.street, a.city, a.state, a.zipCode)
-}
-
-func (a Address) ToLabelFormat() string {
-	return fmt.Sprintf("%s\n%s, %s %s", a.street, a.city, a.state, a.zipCode)
-}
-
 type Person struct {
 	firstName   string
 	lastName    string
@@ -111,53 +61,87 @@ func (p Person) IsValid() bool {
 		return false
 	}
 
+	if dateOfBirth := optional(p.dateOfBirth); dateOfBirth != nil {
+		if _, err := time.Parse("2006-01-02", *dateOfBirth); err != nil {
+			return false
+		}
+	}
+
 	return true
 }
 
-type CustomerService struct{}
+var ErrInvalidPerson = errors.New("invalid person data")
 
-func (cs CustomerService) CreateCustomer(person *Person, address *Address) (map[string]string, error) {
+type CustomerService struct {
+	customers CustomerRepository
+}
+
+func NewCustomerService(customers CustomerRepository) CustomerService {
+	return CustomerService{customers: customers}
+}
+
+// CreateCustomer stores the person with address as both their shipping and
+// billing address. It refuses with a *DuplicateCustomerError when the
+// person looks like a customer who already exists; the check and the insert
+// are one step in the repository.
+func (cs CustomerService) CreateCustomer(ctx context.Context, person *Person, address *Address) (*Customer, error) {
 	if !person.IsValid() {
-		return nil, errors.New("invalid person data")
+		return nil, ErrInvalidPerson
 	}
 
-	if !address.IsValid() {
-		return nil, errors.New("invalid address data")
+	if err := address.Validate(); err != nil {
+		return nil, err
 	}
-
-	// Create customer record
-	customerData := map[string]string{
-		"first_name":    person.GetFirstName(),
-		"last_name":     person.GetLastName(),
-		"email":         person.GetEmail(),
-		"phone":         *person.GetPhone(),
-		"date_of_birth": *person.GetDateOfBirth(),
-		"street":        address.GetStreet(),
-		"city":          address.GetCity(),
-		"state":         address.GetState(),
-		"zip_code":      address.GetZipCode(),
+	address = address.Normalize()
+
+	customer := &Customer{
+		FirstName:   person.GetFirstName(),
+		LastName:    person.GetLastName(),
+		Email:       strings.TrimSpace(person.GetEmail()),
+		Phone:       optional(person.GetPhone()),
+		DateOfBirth: optional(person.GetDateOfBirth()),
+		Addresses: []CustomerAddress{
+			{Kind: ShippingAddress, Address: *address},
+			{Kind: BillingAddress, Address: *address},
+		},
 	}
-
-	// Save to database (simulated)
-	return customerData, nil
+	keys := duplicateKeys(person, address)
+	matches, err := cs.customers.CreateUnlessDuplicate(ctx, customer, keys, func(candidates []*Customer) []DuplicateMatch {
+		return findDuplicates(person, keys, candidates)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) > 0 {
+		return nil, &DuplicateCustomerError{Matches: matches}
+	}
+	return customer, nil
 }
 
-func (cs CustomerService) UpdateCustomerAddress(customerId int, address *Address) (map[string]string, error) {
-	if !address.IsValid() {
-		return nil, errors.New("invalid address data")
+// FindDuplicates returns existing customers that probably are the same
+// person, best match first.
+func (cs CustomerService) FindDuplicates(ctx context.Context, person *Person, address *Address) ([]DuplicateMatch, error) {
+	keys := duplicateKeys(person, address)
+	candidates, err := cs.customers.Candidates(ctx, keys)
+	if err != nil {
+		return nil, err
 	}
+	return findDuplicates(person, keys, candidates), nil
+}
 
-	// Update address
-	addressData := map[string]string{
-		"customer_id": strconv.Itoa(customerId),
-		"street":      address.GetStreet(),
-		"city":        address.GetCity(),
-		"state":       address.GetState(),
-		"zip_code":    address.GetZipCode(),
+func (cs CustomerService) UpdateCustomerAddress(ctx context.Context, customerId int64, kind AddressKind, address *Address) (*Customer, error) {
+	if kind != ShippingAddress && kind != BillingAddress {
+		return nil, fmt.Errorf("unknown address kind %q", kind)
 	}
+	if err := address.Validate(); err != nil {
+		return nil, err
+	}
+	address = address.Normalize()
 
-	// Save to database (simulated)
-	return addressData, nil
+	if err := cs.customers.SetAddress(ctx, customerId, kind, *address); err != nil {
+		return nil, err
+	}
+	return cs.customers.Get(ctx, customerId)
 }
 
 func (cs CustomerService) SendWelcomeEmail(person *Person, address *Address) map[string]string {
@@ -179,23 +163,76 @@ func (cs CustomerService) FormatAddressLabel(person *Person, address *Address) s
 	return person.GetFullName() + "\n" + address.ToLabelFormat()
 }
 
+// optional treats a blank optional field the same as a missing one.
+func optional(value *string) *string {
+	if value == nil || strings.TrimSpace(*value) == "" {
+		return nil
+	}
+	trimmed := strings.TrimSpace(*value)
+	return &trimmed
+}
+
 func main() {
-	cs := CustomerService{}
+	ctx := context.Background()
+	cs := NewCustomerService(NewMemoryCustomerRepository())
 
 	// Create person and address objects
 	phone := "555-1234"
 	dob := "1990-01-01"
 	person := NewPerson("John", "Doe", "john@example.com", &phone, &dob)
-	address := NewAddress("123 Main St", "Anytown", "CA", "12345")
+	address := NewAddress("123 north main street apt. 4", "Los Angeles", "California", "900121234")
 
 	// Example usage
-	customer, err := cs.CreateCustomer(person, address)
+	customer, err := cs.CreateCustomer(ctx, person, address)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+
+	fmt.Printf("Created customer %d: %s <%s> phone %s\n", customer.Id, customer.FullName(), customer.Email, *customer.Phone)
+
+	// Phone and date of birth are optional
+	jane, err := cs.CreateCustomer(ctx, NewPerson("Jane", "Roe", "jane@example.com", nil, nil), NewAddress("9 Elm St", "Austin", "TX", "78701"))
 	if err != nil {
 		fmt.Printf("Error: %s\n", err.Error())
 		return
 	}
+	fmt.Printf("Created customer %d: %s, phone on file: %t\n", jane.Id, jane.FullName(), jane.Phone != nil)
+
+	otherPhone := "(555) 1234"
+	for _, lookalike := range []struct {
+		person  *Person
+		address *Address
+	}{
+		{NewPerson("Johnny", "Doe", "John+shop@Example.com", nil, nil), NewAddress("1 Broadway", "New York", "NY", "10004")},
+		{NewPerson("Jon", "Doe", "jd1990@example.net", &otherPhone, nil), NewAddress("123 N. Main St., Apartment 4", "Los Angeles", "CA", "90012")},
+		{NewPerson("Jim", "Roe", "jim@example.com", nil, nil), NewAddress("9 Elm Street", "Austin", "Texas", "78701")},
+	} {
+		if _, err := cs.CreateCustomer(ctx, lookalike.person, lookalike.address); errors.Is(err, ErrDuplicateCustomer) {
+			fmt.Printf("Rejected %s: %s\n", lookalike.person.GetFullName(), err)
+		} else if err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return
+		} else {
+			fmt.Printf("Created %s\n", lookalike.person.GetFullName())
+		}
+	}
 
-	fmt.Printf("Created customer: %+v\n", customer)
+	moved, err := cs.UpdateCustomerAddress(ctx, customer.Id, ShippingAddress, NewAddress("500 west 5th street", "Austin", "TX", "78701"))
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+	for _, address := range moved.Addresses {
+		state := "current"
+		if !address.Current() {
+			state = "retired"
+		}
+		fmt.Printf("  %-8s %-7s %s\n", address.Kind, state, address.Address.ToString())
+	}
+	if _, err := cs.UpdateCustomerAddress(ctx, 99, BillingAddress, address); errors.Is(err, ErrNotFound) {
+		fmt.Printf("Rejected: %s\n", err)
+	}
 
 	isValid := cs.ValidateShippingAddress(address)
 	fmt.Printf("Address valid: %t\n", isValid)
@@ -205,4 +242,21 @@ func main() {
 
 	label := cs.FormatAddressLabel(person, address)
 	fmt.Printf("Address label:\n%s\n", label)
+
+	mismatched := NewAddress("1 Broadway", "New York", "NJ", "10004")
+	if err := mismatched.Validate(); err != nil {
+		fmt.Printf("Rejected: %s\n", err)
+	}
+
+	for _, abroad := range []*Address{
+		NewInternationalAddress("24 Sussex Drive", "Ottawa", "Ontario", "k1m1m4", "Canada"),
+		NewInternationalAddress("10 Downing Street", "London", "", "sw1a2aa", "UK"),
+		NewInternationalAddress("Unter den Linden 77", "Berlin", "", "10117", "Germany"),
+	} {
+		if err := abroad.Validate(); err != nil {
+			fmt.Printf("Rejected: %s\n", err)
+			continue
+		}
+		fmt.Printf("Label:\n%s\n", cs.FormatAddressLabel(person, abroad))
+	}
 }
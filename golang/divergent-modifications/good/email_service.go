@@ -1,23 +1,50 @@
 package main
 
-import "fmt"
+import (
+	"bytes"
+	"context"
+	htmltemplate "html/template"
+)
 
-type emailService struct{}
+var monthlyStatementTemplate = MustMailTemplate("monthly_statement",
+	"Monthly Financial Statement",
+	`Your current balance: {{.Balance}}
 
+{{.ReportText}}`,
+	`<p>Your current balance: <strong>{{.Balance}}</strong></p>
+{{.ReportHTML}}`)
+
+type emailService struct {
+	transport MailTransport
+	from      string
+}
+
+// NewEmailService prints statements to stdout; use
+// NewEmailServiceWithTransport to deliver them.
 func NewEmailService() EmailService {
-	return &emailService{}
+	return &emailService{transport: stdoutTransport{}, from: "statements@example.com"}
 }
 
-func (es emailService) SendMonthlyStatement(email string, balance float64, monthlyReport []map[string]interface{}) {
-	subject := "Monthly Financial Statement"
-	message := fmt.Sprintf("Your current balance: $%.2f\n\n", balance)
-	message += "Transactions this month:\n"
+func NewEmailServiceWithTransport(transport MailTransport, from string) EmailService {
+	return &emailService{transport: transport, from: from}
+}
 
-	for _, row := range monthlyReport {
-		message += fmt.Sprintf("- %s: %d transactions, total: $%.2f\n",
-			row["type"], row["count"], row["total"])
+func (es emailService) SendMonthlyStatement(ctx context.Context, email string, balance Money, monthlyReport MonthlyReport) error {
+	var text, html bytes.Buffer
+	if err := RenderReport(&text, monthlyReport, FormatText); err != nil {
+		return err
+	}
+	if err := RenderReport(&html, monthlyReport, FormatHTML); err != nil {
+		return err
 	}
 
-	fmt.Printf("Sending email to %s:\nSubject: %s\n%s\n", email, subject, message)
-	// In real implementation, would send actual email
+	message, err := monthlyStatementTemplate.Render(es.from, []string{email}, map[string]interface{}{
+		"Balance":    balance.Format(),
+		"ReportText": text.String(),
+		"ReportHTML": htmltemplate.HTML(html.String()),
+	})
+	if err != nil {
+		return err
+	}
+	return es.transport.Send(ctx, message)
 }
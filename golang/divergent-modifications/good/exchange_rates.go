@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrNoExchangeRate = errors.New("no exchange rate")
+
+// ExchangeRateProvider quotes how many units of to one unit of from buys on
+// a given day.
+type ExchangeRateProvider interface {
+	Rate(ctx context.Context, from, to string, on time.Time) (float64, error)
+}
+
+// ConvertMoney values amount in currency using the provider's rate for the
+// given day.
+func ConvertMoney(ctx context.Context, rates ExchangeRateProvider, amount Money, currency string, on time.Time) (Money, error) {
+	currency = strings.ToUpper(currency)
+	if amount.Currency() == currency {
+		return amount, nil
+	}
+	if rates == nil {
+		return Money{}, fmt.Errorf("%w: %s to %s (no rate provider configured)", ErrNoExchangeRate, amount.Currency(), currency)
+	}
+	rate, err := rates.Rate(ctx, amount.Currency(), currency, on)
+	if err != nil {
+		return Money{}, err
+	}
+	return amount.Convert(rate, currency, RoundHalfEven)
+}
+
+// crossRate derives from/to from two quotes against a common base.
+func crossRate(base, from, to string, quote func(currency string) (float64, bool)) (float64, error) {
+	inBase := func(currency string) (float64, bool) {
+		if currency == base {
+			return 1, true
+		}
+		return quote(currency)
+	}
+	fromRate, ok := inBase(from)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s to %s", ErrNoExchangeRate, from, to)
+	}
+	toRate, ok := inBase(to)
+	if !ok || toRate == 0 {
+		return 0, fmt.Errorf("%w: %s to %s", ErrNoExchangeRate, from, to)
+	}
+	return fromRate / toRate, nil
+}
+
+type staticRates struct {
+	base   string
+	quotes map[string]float64
+}
+
+// NewStaticRates uses one fixed rate per currency, quoted as the price of
+// one unit in base (EUR 1.08 means 1 EUR = 1.08 base). Any pair of quoted
+// currencies can be converted through the base.
+func NewStaticRates(base string, quotes map[string]float64) ExchangeRateProvider {
+	normalized := make(map[string]float64, len(quotes))
+	for currency, rate := range quotes {
+		normalized[strings.ToUpper(currency)] = rate
+	}
+	return &staticRates{base: strings.ToUpper(base), quotes: normalized}
+}
+
+func (sr staticRates) Rate(ctx context.Context, from, to string, on time.Time) (float64, error) {
+	return crossRate(sr.base, strings.ToUpper(from), strings.ToUpper(to), func(currency string) (float64, bool) {
+		rate, ok := sr.quotes[currency]
+		return rate, ok
+	})
+}
+
+type datedRate struct {
+	on   time.Time
+	rate float64
+}
+
+// HistoricalRates keeps a dated series of quotes against base per currency
+// and answers with the latest quote on or before the requested day.
+type HistoricalRates struct {
+	base   string
+	series map[string][]datedRate
+}
+
+func NewHistoricalRates(base string) *HistoricalRates {
+	return &HistoricalRates{base: strings.ToUpper(base), series: map[string][]datedRate{}}
+}
+
+func (hr *HistoricalRates) Add(currency string, on time.Time, rate float64) {
+	currency = strings.ToUpper(currency)
+	series := append(hr.series[currency], datedRate{on: truncateDay(on), rate: rate})
+	sort.Slice(series, func(i, j int) bool { return series[i].on.Before(series[j].on) })
+	hr.series[currency] = series
+}
+
+// LoadFile reads a CSV file with a date,currency,rate header, where rate is
+// the price of one unit of currency in the base currency.
+func (hr *HistoricalRates) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := hr.Load(file); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (hr *HistoricalRates) Load(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	if strings.Join(header, ",") != "date,currency,rate" {
+		return fmt.Errorf("unexpected header %q, want date,currency,rate", strings.Join(header, ","))
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		on, err := time.Parse("2006-01-02", record[0])
+		if err != nil {
+			return err
+		}
+		rate, err := strconv.ParseFloat(record[2], 64)
+		if err != nil {
+			return err
+		}
+		if rate <= 0 {
+			return fmt.Errorf("rate for %s on %s must be positive", record[1], record[0])
+		}
+		hr.Add(record[1], on, rate)
+	}
+}
+
+func (hr *HistoricalRates) Rate(ctx context.Context, from, to string, on time.Time) (float64, error) {
+	day := truncateDay(on)
+	return crossRate(hr.base, strings.ToUpper(from), strings.ToUpper(to), func(currency string) (float64, bool) {
+		series := hr.series[currency]
+		i := sort.Search(len(series), func(i int) bool { return series[i].on.After(day) })
+		if i == 0 {
+			return 0, false
+		}
+		return series[i-1].rate, true
+	})
+}
+
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -1,22 +1,75 @@
 package main
 
-type financialCalculator struct{}
+import (
+	"fmt"
+	"time"
+)
+
+type financialCalculator struct {
+	taxEngine     *TaxEngine
+	jurisdiction  string
+	filingStatus  FilingStatus
+	interestModel InterestModel
+}
 
 func NewFinancialCalculator() FinancialCalculator {
-	return &financialCalculator{}
+	return &financialCalculator{
+		taxEngine:     NewDefaultTaxEngine(),
+		jurisdiction:  defaultTaxJurisdiction,
+		filingStatus:  FilingSingle,
+		interestModel: SimpleInterest{},
+	}
+}
+
+func NewFinancialCalculatorWithTaxEngine(engine *TaxEngine, jurisdiction string, status FilingStatus) (FinancialCalculator, error) {
+	table, err := engine.table(jurisdiction, anyTaxYear)
+	if err != nil {
+		return nil, fmt.Errorf("financial calculator: %w", err)
+	}
+	status = defaultFilingStatus(status)
+	if _, ok := table.Schedules[status]; !ok {
+		return nil, fmt.Errorf("financial calculator: %s has no schedule for filing status %q", table.Jurisdiction, status)
+	}
+	return &financialCalculator{
+		taxEngine:     engine,
+		jurisdiction:  jurisdiction,
+		filingStatus:  status,
+		interestModel: SimpleInterest{},
+	}, nil
 }
 
-func (fc financialCalculator) CalculateInterest(principal, rate float64, time int) float64 {
-	return principal * rate * float64(time)
+// CalculateInterest keeps the original whole-years simple interest API.
+func (fc financialCalculator) CalculateInterest(principal Money, rate float64, years int) (Money, error) {
+	return fc.interestModel.Interest(principal, rate, float64(years))
 }
 
-func (fc financialCalculator) CalculateTax(income, deductions float64) float64 {
-	taxableIncome := income - deductions
-	if taxableIncome <= 50000 {
-		return taxableIncome * 0.1
-	} else if taxableIncome <= 100000 {
-		return 5000 + (taxableIncome-50000)*0.2
-	} else {
-		return 15000 + (taxableIncome-100000)*0.3
+func (fc financialCalculator) CalculateInterestWithModel(principal Money, rate, years float64, model InterestModel) (Money, error) {
+	return model.Interest(principal, rate, years)
+}
+
+func (fc financialCalculator) AccrueInterest(principal Money, rate float64, start, end time.Time, model InterestModel, convention DayCountConvention) (Money, error) {
+	return AccrueInterest(principal, rate, start, end, model, convention)
+}
+
+func (fc financialCalculator) AmortizationSchedule(loan Loan) ([]AmortizationPayment, error) {
+	return AmortizationSchedule(loan)
+}
+
+// CalculateTax uses the most recent table for the calculator's jurisdiction.
+func (fc financialCalculator) CalculateTax(income, deductions Money) (Money, error) {
+	computation, err := fc.CalculateTaxBreakdown(income, deductions, anyTaxYear)
+	if err != nil {
+		return Money{}, err
 	}
+	return computation.TaxOwed, nil
+}
+
+func (fc financialCalculator) CalculateTaxBreakdown(income, deductions Money, year int) (TaxComputation, error) {
+	return fc.taxEngine.Calculate(TaxRequest{
+		Jurisdiction: fc.jurisdiction,
+		Year:         year,
+		FilingStatus: fc.filingStatus,
+		Income:       income,
+		Deductions:   deductions,
+	})
 }
@@ -1,43 +1,59 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"time"
 )
 
 type FinancialCalculator interface {
-	CalculateInterest(principal, rate float64, time int) float64
-	CalculateTax(income, deductions float64) float64
+	CalculateInterest(principal Money, rate float64, time int) (Money, error)
+	CalculateInterestWithModel(principal Money, rate, years float64, model InterestModel) (Money, error)
+	AccrueInterest(principal Money, rate float64, start, end time.Time, model InterestModel, convention DayCountConvention) (Money, error)
+	AmortizationSchedule(loan Loan) ([]AmortizationPayment, error)
+	CalculateTax(income, deductions Money) (Money, error)
+	CalculateTaxBreakdown(income, deductions Money, year int) (TaxComputation, error)
 }
 
 type TransactionRepository interface {
-	SaveTransaction(userId int, amount float64, transactionType string) (int64, error)
-	GetUserBalance(userId int) float64
-	GetMonthlyTransactions(userId, month, year int) []map[string]interface{}
-	GetYearlyIncome(userId, year int) float64
-	GetYearlyDeductions(userId, year int) float64
+	SaveTransaction(ctx context.Context, userId int, amount Money, transactionType string) (int64, error)
+	ReverseTransaction(ctx context.Context, transactionId int64, reason string) (int64, error)
+	TrialBalance(ctx context.Context) (TrialBalance, error)
+	ClosePeriod(ctx context.Context, period StatementPeriod) error
+	VerifyLedger(ctx context.Context) ([]LedgerMismatch, error)
+	GetUserBalance(ctx context.Context, userId int) (Money, error)
+	GetUserBalanceIn(ctx context.Context, userId int, currency string) (Money, error)
+	GetUserBalanceAsOf(ctx context.Context, userId int, period StatementPeriod) (Money, error)
+	GetUserHoldings(ctx context.Context, userId int) ([]Money, error)
+	GetCurrencyMovements(ctx context.Context, userId int, before time.Time) ([]CurrencyMovement, error)
+	GetMonthlyTransactions(ctx context.Context, userId, month, year int) ([]TransactionSummary, error)
+	GetYearlyIncome(ctx context.Context, userId, year int) (Money, error)
+	GetYearlyDeductions(ctx context.Context, userId, year int) (Money, error)
 }
 
 type UserRepository interface {
-	UpdateUserProfile(userId int, name, email string) error
-	GetUserEmail(userId int) (string, error)
+	UpdateUserProfile(ctx context.Context, userId int, name, email string) error
+	GetUserEmail(ctx context.Context, userId int) (string, error)
+	ListUserIds(ctx context.Context) ([]int, error)
 }
 
 type ReportGenerator interface {
-	GenerateMonthlyReport(userId, month, year int) []map[string]interface{}
-	GenerateTaxReport(userId, year int) map[string]interface{}
+	GenerateMonthlyReport(ctx context.Context, userId, month, year int) (MonthlyReport, error)
+	GenerateTaxReport(ctx context.Context, userId, year int) (TaxReport, error)
 }
 
 type EmailService interface {
-	SendMonthlyStatement(email string, balance float64, monthlyReport []map[string]interface{})
+	SendMonthlyStatement(ctx context.Context, email string, balance Money, monthlyReport MonthlyReport) error
 }
 
 type FinancialService struct {
-	calculator           FinancialCalculator
+	calculator            FinancialCalculator
 	transactionRepository TransactionRepository
-	userRepository       UserRepository
-	reportGenerator      ReportGenerator
-	emailService         EmailService
+	userRepository        UserRepository
+	reportGenerator       ReportGenerator
+	emailService          EmailService
+	unitOfWork            *UnitOfWork
 }
 
 func NewFinancialService(
@@ -46,58 +62,151 @@ func NewFinancialService(
 	userRepo UserRepository,
 	reportGen ReportGenerator,
 	emailSvc EmailService,
+	unitOfWork *UnitOfWork,
 ) *FinancialService {
 	return &FinancialService{
 		calculator:            calculator,
 		transactionRepository: transactionRepo,
-		userRepository:       userRepo,
-		reportGenerator:      reportGen,
-		emailService:         emailSvc,
+		userRepository:        userRepo,
+		reportGenerator:       reportGen,
+		emailService:          emailSvc,
+		unitOfWork:            unitOfWork,
 	}
 }
 
 // Financial calculations - delegated to FinancialCalculator
-func (fs FinancialService) CalculateInterest(principal, rate float64, time int) float64 {
+func (fs FinancialService) CalculateInterest(principal Money, rate float64, time int) (Money, error) {
 	return fs.calculator.CalculateInterest(principal, rate, time)
 }
 
-func (fs FinancialService) CalculateTax(income, deductions float64) float64 {
+func (fs FinancialService) CalculateInterestWithModel(principal Money, rate, years float64, model InterestModel) (Money, error) {
+	return fs.calculator.CalculateInterestWithModel(principal, rate, years, model)
+}
+
+func (fs FinancialService) AccrueInterest(principal Money, rate float64, start, end time.Time, model InterestModel, convention DayCountConvention) (Money, error) {
+	return fs.calculator.AccrueInterest(principal, rate, start, end, model, convention)
+}
+
+func (fs FinancialService) AmortizationSchedule(loan Loan) ([]AmortizationPayment, error) {
+	return fs.calculator.AmortizationSchedule(loan)
+}
+
+func (fs FinancialService) CalculateTax(income, deductions Money) (Money, error) {
 	return fs.calculator.CalculateTax(income, deductions)
 }
 
+func (fs FinancialService) CalculateTaxBreakdown(income, deductions Money, year int) (TaxComputation, error) {
+	return fs.calculator.CalculateTaxBreakdown(income, deductions, year)
+}
+
 // Database operations - delegated to repositories
-func (fs FinancialService) SaveTransaction(userId int, amount float64, transactionType string) (int64, error) {
-	return fs.transactionRepository.SaveTransaction(userId, amount, transactionType)
+func (fs FinancialService) SaveTransaction(ctx context.Context, userId int, amount Money, transactionType string) (int64, error) {
+	return fs.transactionRepository.SaveTransaction(ctx, userId, amount, transactionType)
+}
+
+func (fs FinancialService) ReverseTransaction(ctx context.Context, transactionId int64, reason string) (int64, error) {
+	return fs.transactionRepository.ReverseTransaction(ctx, transactionId, reason)
+}
+
+func (fs FinancialService) TrialBalance(ctx context.Context) (TrialBalance, error) {
+	return fs.transactionRepository.TrialBalance(ctx)
+}
+
+func (fs FinancialService) ClosePeriod(ctx context.Context, period StatementPeriod) error {
+	return fs.transactionRepository.ClosePeriod(ctx, period)
+}
+
+func (fs FinancialService) VerifyLedger(ctx context.Context) ([]LedgerMismatch, error) {
+	return fs.transactionRepository.VerifyLedger(ctx)
+}
+
+func (fs FinancialService) GetUserBalance(ctx context.Context, userId int) (Money, error) {
+	return fs.transactionRepository.GetUserBalance(ctx, userId)
+}
+
+// GetUserBalanceIn values all of the user's holdings in one currency at
+// today's rates.
+func (fs FinancialService) GetUserBalanceIn(ctx context.Context, userId int, currency string) (Money, error) {
+	return fs.transactionRepository.GetUserBalanceIn(ctx, userId, currency)
+}
+
+func (fs FinancialService) GetUserHoldings(ctx context.Context, userId int) ([]Money, error) {
+	return fs.transactionRepository.GetUserHoldings(ctx, userId)
+}
+
+func (fs FinancialService) UpdateUserProfile(ctx context.Context, userId int, name, email string) error {
+	return fs.userRepository.UpdateUserProfile(ctx, userId, name, email)
 }
 
-func (fs FinancialService) GetUserBalance(userId int) float64 {
-	return fs.transactionRepository.GetUserBalance(userId)
+// Multi-step operations - run atomically through the UnitOfWork
+func (fs FinancialService) WithTx(ctx context.Context, fn func(repos Repositories) error) error {
+	return fs.unitOfWork.WithTx(ctx, fn)
 }
 
-func (fs FinancialService) UpdateUserProfile(userId int, name, email string) error {
-	return fs.userRepository.UpdateUserProfile(userId, name, email)
+func (fs FinancialService) TransferFunds(ctx context.Context, fromUserId, toUserId int, amount Money) error {
+	if amount.IsNegative() || amount.IsZero() {
+		return fmt.Errorf("transfer amount must be positive, got %s", amount)
+	}
+	return fs.WithTx(ctx, func(repos Repositories) error {
+		for _, userId := range []int{fromUserId, toUserId} {
+			if _, err := repos.Users.GetUserEmail(ctx, userId); err != nil {
+				return fmt.Errorf("transfer user %d: %w", userId, err)
+			}
+		}
+		if _, err := repos.Transactions.SaveTransaction(ctx, fromUserId, amount.Negate(), "transfer_out"); err != nil {
+			return err
+		}
+		_, err := repos.Transactions.SaveTransaction(ctx, toUserId, amount, "transfer_in")
+		return err
+	})
 }
 
 // Reporting - delegated to ReportGenerator
-func (fs FinancialService) GenerateMonthlyReport(userId, month, year int) []map[string]interface{} {
-	return fs.reportGenerator.GenerateMonthlyReport(userId, month, year)
+func (fs FinancialService) GenerateMonthlyReport(ctx context.Context, userId, month, year int) (MonthlyReport, error) {
+	return fs.reportGenerator.GenerateMonthlyReport(ctx, userId, month, year)
 }
 
-func (fs FinancialService) GenerateTaxReport(userId, year int) map[string]interface{} {
-	return fs.reportGenerator.GenerateTaxReport(userId, year)
+func (fs FinancialService) GenerateTaxReport(ctx context.Context, userId, year int) (TaxReport, error) {
+	return fs.reportGenerator.GenerateTaxReport(ctx, userId, year)
 }
 
-// Email notifications - delegated to EmailService
-func (fs FinancialService) SendMonthlyStatement(userId int) error {
-	email, err := fs.userRepository.GetUserEmail(userId)
+// Exports render the typed reports in whichever format the caller needs
+func (fs FinancialService) ExportMonthlyReport(ctx context.Context, w io.Writer, userId, month, year int, format ReportFormat) error {
+	report, err := fs.GenerateMonthlyReport(ctx, userId, month, year)
+	if err != nil {
+		return err
+	}
+	return RenderReport(w, report, format)
+}
+
+func (fs FinancialService) ExportTaxReport(ctx context.Context, w io.Writer, userId, year int, format ReportFormat) error {
+	report, err := fs.GenerateTaxReport(ctx, userId, year)
 	if err != nil {
 		return err
 	}
+	return RenderReport(w, report, format)
+}
 
-	balance := fs.GetUserBalance(userId)
+// Email notifications - delegated to EmailService
+func (fs FinancialService) SendMonthlyStatement(ctx context.Context, userId int) error {
 	currentTime := time.Now()
-	report := fs.GenerateMonthlyReport(userId, int(currentTime.Month()), currentTime.Year())
+	return fs.SendStatementForPeriod(ctx, userId, int(currentTime.Month()), currentTime.Year())
+}
+
+func (fs FinancialService) SendStatementForPeriod(ctx context.Context, userId, month, year int) error {
+	email, err := fs.userRepository.GetUserEmail(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	balance, err := fs.transactionRepository.GetUserBalanceAsOf(ctx, userId, StatementPeriod{Month: month, Year: year})
+	if err != nil {
+		return err
+	}
+	report, err := fs.GenerateMonthlyReport(ctx, userId, month, year)
+	if err != nil {
+		return err
+	}
 
-	fs.emailService.SendMonthlyStatement(email, balance, report)
-	return nil
+	return fs.emailService.SendMonthlyStatement(ctx, email, balance, report)
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// CurrencyMovement is one foreign-currency posting on a user's account
+// together with its base-currency value on the day it was booked.
+type CurrencyMovement struct {
+	Amount     Money
+	BaseAmount Money
+	At         time.Time
+}
+
+// FxGainLine is the exchange result for one foreign currency. Realized
+// gains come from amounts spent during the period, measured against their
+// average cost; unrealized gains are what the remaining holding would make
+// if sold at the closing rate.
+type FxGainLine struct {
+	Currency    string `json:"currency"`
+	Holding     Money  `json:"holding"`
+	CostBasis   Money  `json:"cost_basis"`
+	MarketValue Money  `json:"market_value"`
+	Realized    Money  `json:"realized"`
+	Unrealized  Money  `json:"unrealized"`
+}
+
+type currencyPosition struct {
+	units    Money
+	cost     Money
+	realized Money
+}
+
+// CalculateFxGains replays movements (oldest first) using average cost and
+// values what is left at asOf. Only disposals on or after from count as
+// realized in this period. Short positions carry no cost basis.
+func CalculateFxGains(ctx context.Context, rates ExchangeRateProvider, baseCurrency string, movements []CurrencyMovement, from, asOf time.Time) ([]FxGainLine, error) {
+	positions := map[string]*currencyPosition{}
+	for _, movement := range movements {
+		if !movement.At.Before(asOf) {
+			continue
+		}
+		currency := movement.Amount.Currency()
+		position, ok := positions[currency]
+		if !ok {
+			position = &currencyPosition{
+				units:    NewMoney(0, currency),
+				cost:     NewMoney(0, baseCurrency),
+				realized: NewMoney(0, baseCurrency),
+			}
+			positions[currency] = position
+		}
+
+		if err := position.apply(movement, from, baseCurrency); err != nil {
+			return nil, err
+		}
+	}
+
+	lines := make([]FxGainLine, 0, len(positions))
+	for currency, position := range positions {
+		marketValue, err := ConvertMoney(ctx, rates, position.units, baseCurrency, asOf)
+		if err != nil {
+			return nil, err
+		}
+		unrealized, err := marketValue.Subtract(position.cost)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, FxGainLine{
+			Currency:    currency,
+			Holding:     position.units,
+			CostBasis:   position.cost,
+			MarketValue: marketValue,
+			Realized:    position.realized,
+			Unrealized:  unrealized,
+		})
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].Currency < lines[j].Currency })
+	return lines, nil
+}
+
+func (p *currencyPosition) apply(movement CurrencyMovement, from time.Time, baseCurrency string) error {
+	var err error
+	if !movement.Amount.IsNegative() {
+		if p.units, err = p.units.Add(movement.Amount); err != nil {
+			return err
+		}
+		p.cost, err = p.cost.Add(movement.BaseAmount)
+		return err
+	}
+
+	spent := movement.Amount.Negate()
+	proceeds := movement.BaseAmount.Negate()
+	// Only units actually held realize anything. Spending beyond the
+	// holding opens a short position, so that share of the proceeds is
+	// left out of the gain.
+	held := p.units.MinorUnits()
+	disposedCost, heldProceeds := NewMoney(0, baseCurrency), NewMoney(0, baseCurrency)
+	switch {
+	case held <= 0:
+	case spent.MinorUnits() > held:
+		disposedCost = p.cost
+		share := float64(held) / float64(spent.MinorUnits())
+		if heldProceeds, err = proceeds.Multiply(share, RoundHalfEven); err != nil {
+			return err
+		}
+	case spent.MinorUnits() == held:
+		disposedCost, heldProceeds = p.cost, proceeds
+	default:
+		share := float64(spent.MinorUnits()) / float64(held)
+		if disposedCost, err = p.cost.Multiply(share, RoundHalfEven); err != nil {
+			return err
+		}
+		heldProceeds = proceeds
+	}
+	if !movement.At.Before(from) {
+		gain, err := heldProceeds.Subtract(disposedCost)
+		if err != nil {
+			return err
+		}
+		if p.realized, err = p.realized.Add(gain); err != nil {
+			return err
+		}
+	}
+	if p.units, err = p.units.Subtract(spent); err != nil {
+		return err
+	}
+	if p.cost, err = p.cost.Subtract(disposedCost); err != nil {
+		return err
+	}
+	if p.units.MinorUnits() <= 0 {
+		p.cost = NewMoney(0, baseCurrency)
+	}
+	return nil
+}
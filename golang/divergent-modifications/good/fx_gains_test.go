@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCalculateFxGains(t *testing.T) {
+	rates := NewStaticRates("USD", map[string]float64{"EUR": 1.20})
+	day := func(month time.Month) time.Time { return time.Date(2024, month, 1, 0, 0, 0, 0, time.UTC) }
+	movement := func(month time.Month, eur, usd int64) CurrencyMovement {
+		return CurrencyMovement{Amount: NewMoney(eur*100, "EUR"), BaseAmount: NewMoney(usd*100, "USD"), At: day(month)}
+	}
+
+	tests := []struct {
+		name       string
+		movements  []CurrencyMovement
+		holding    Money
+		costBasis  Money
+		realized   Money
+		unrealized Money
+	}{
+		{
+			// Average cost is 220/200 = 1.10, so 50 EUR cost 55 and sold for 65.
+			name:       "partial disposal",
+			movements:  []CurrencyMovement{movement(time.January, 100, 100), movement(time.February, 100, 120), movement(time.March, -50, -65)},
+			holding:    NewMoney(15000, "EUR"),
+			costBasis:  dollars(165),
+			realized:   dollars(10),
+			unrealized: dollars(15),
+		},
+		{
+			name:       "full disposal",
+			movements:  []CurrencyMovement{movement(time.January, 100, 110), movement(time.March, -100, -125)},
+			holding:    NewMoney(0, "EUR"),
+			costBasis:  dollars(0),
+			realized:   dollars(15),
+			unrealized: dollars(0),
+		},
+		{
+			// Only the 100 EUR held count: two thirds of 180 is 120 against
+			// a cost of 110. The other 50 EUR open a short position.
+			name:       "over-disposal",
+			movements:  []CurrencyMovement{movement(time.January, 100, 110), movement(time.March, -150, -180)},
+			holding:    NewMoney(-5000, "EUR"),
+			costBasis:  dollars(0),
+			realized:   dollars(10),
+			unrealized: dollars(-60),
+		},
+		{
+			name:       "disposal before the period is not realized in it",
+			movements:  []CurrencyMovement{movement(time.January, 100, 100), movement(time.January, -50, -60)},
+			holding:    NewMoney(5000, "EUR"),
+			costBasis:  dollars(50),
+			realized:   dollars(0),
+			unrealized: dollars(10),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := CalculateFxGains(context.Background(), rates, "USD", tt.movements, day(time.February), day(time.April))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lines) != 1 || lines[0].Currency != "EUR" {
+				t.Fatalf("lines = %+v, want one EUR line", lines)
+			}
+			line := lines[0]
+			if line.Holding != tt.holding || line.CostBasis != tt.costBasis {
+				t.Errorf("holding %s at cost %s, want %s at %s", line.Holding, line.CostBasis, tt.holding, tt.costBasis)
+			}
+			if line.Realized != tt.realized {
+				t.Errorf("realized = %s, want %s", line.Realized, tt.realized)
+			}
+			if line.Unrealized != tt.unrealized {
+				t.Errorf("unrealized = %s, want %s", line.Unrealized, tt.unrealized)
+			}
+		})
+	}
+}
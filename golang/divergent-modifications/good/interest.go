@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// InterestModel turns a principal, an annual rate and a term in years into
+// the interest earned over that term.
+type InterestModel interface {
+	Interest(principal Money, annualRate, years float64) (Money, error)
+}
+
+type SimpleInterest struct{}
+
+func (SimpleInterest) Interest(principal Money, annualRate, years float64) (Money, error) {
+	return principal.Multiply(annualRate*years, RoundHalfEven)
+}
+
+// CompoundInterest compounds PeriodsPerYear times a year (12 for monthly,
+// 365 for daily).
+type CompoundInterest struct {
+	PeriodsPerYear int
+}
+
+func (ci CompoundInterest) Interest(principal Money, annualRate, years float64) (Money, error) {
+	periods := float64(ci.PeriodsPerYear)
+	if periods <= 0 {
+		periods = 1
+	}
+	growth := math.Pow(1+annualRate/periods, periods*years)
+	return principal.Multiply(growth-1, RoundHalfEven)
+}
+
+type ContinuousInterest struct{}
+
+func (ContinuousInterest) Interest(principal Money, annualRate, years float64) (Money, error) {
+	return principal.Multiply(math.Expm1(annualRate*years), RoundHalfEven)
+}
+
+// DayCountConvention decides how much of a year lies between two dates.
+type DayCountConvention interface {
+	Name() string
+	YearFraction(start, end time.Time) float64
+}
+
+// Thirty360 is the US (bond basis) 30/360 convention.
+type Thirty360 struct{}
+
+func (Thirty360) Name() string { return "30/360" }
+
+func (Thirty360) YearFraction(start, end time.Time) float64 {
+	y1, m1, d1 := start.Date()
+	y2, m2, d2 := end.Date()
+	if d1 == 31 {
+		d1 = 30
+	}
+	if d2 == 31 && d1 == 30 {
+		d2 = 30
+	}
+	days := 360*(y2-y1) + 30*(int(m2)-int(m1)) + (d2 - d1)
+	return float64(days) / 360
+}
+
+type Actual365Fixed struct{}
+
+func (Actual365Fixed) Name() string { return "ACT/365" }
+
+func (Actual365Fixed) YearFraction(start, end time.Time) float64 {
+	return float64(daysBetween(start, end)) / 365
+}
+
+// ActualActual is the ISDA variant: days falling in leap years count
+// against 366, all others against 365.
+type ActualActual struct{}
+
+func (ActualActual) Name() string { return "ACT/ACT" }
+
+func (ActualActual) YearFraction(start, end time.Time) float64 {
+	if end.Before(start) {
+		return -ActualActual{}.YearFraction(end, start)
+	}
+	fraction := 0.0
+	for cursor := civilDate(start); cursor.Before(civilDate(end)); {
+		nextYear := time.Date(cursor.Year()+1, time.January, 1, 0, 0, 0, 0, time.UTC)
+		periodEnd := civilDate(end)
+		if nextYear.Before(periodEnd) {
+			periodEnd = nextYear
+		}
+		fraction += float64(daysBetween(cursor, periodEnd)) / float64(daysInYear(cursor.Year()))
+		cursor = periodEnd
+	}
+	return fraction
+}
+
+func civilDate(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func daysBetween(start, end time.Time) int {
+	return int(civilDate(end).Sub(civilDate(start)).Hours() / 24)
+}
+
+func daysInYear(year int) int {
+	if time.Date(year, time.December, 31, 0, 0, 0, 0, time.UTC).YearDay() == 366 {
+		return 366
+	}
+	return 365
+}
+
+// AccrueInterest applies a model over the period between two dates as
+// measured by a day-count convention.
+func AccrueInterest(principal Money, annualRate float64, start, end time.Time, model InterestModel, convention DayCountConvention) (Money, error) {
+	return model.Interest(principal, annualRate, convention.YearFraction(start, end))
+}
+
+type Loan struct {
+	Principal      Money
+	AnnualRate     float64
+	Periods        int
+	PeriodsPerYear int
+	StartDate      time.Time
+}
+
+type AmortizationPayment struct {
+	Period           int
+	DueDate          time.Time
+	Payment          Money
+	Principal        Money
+	Interest         Money
+	RemainingBalance Money
+}
+
+// AmortizationSchedule splits a fixed-payment loan into per-period
+// principal and interest. The final payment absorbs rounding so the
+// balance ends at exactly zero.
+func AmortizationSchedule(loan Loan) ([]AmortizationPayment, error) {
+	if loan.Periods <= 0 || loan.PeriodsPerYear <= 0 {
+		return nil, fmt.Errorf("loan needs a positive number of periods")
+	}
+	if loan.Principal.IsNegative() || loan.AnnualRate < 0 {
+		return nil, fmt.Errorf("loan principal and rate must not be negative")
+	}
+
+	periodRate := loan.AnnualRate / float64(loan.PeriodsPerYear)
+	factor, mode := 1/float64(loan.Periods), RoundUp
+	if periodRate != 0 {
+		factor = periodRate / (1 - math.Pow(1+periodRate, -float64(loan.Periods)))
+		mode = RoundHalfUp
+	}
+	payment, err := loan.Principal.Multiply(factor, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := make([]AmortizationPayment, 0, loan.Periods)
+	balance := loan.Principal
+	for period := 1; period <= loan.Periods; period++ {
+		interest, err := balance.Multiply(periodRate, RoundHalfEven)
+		if err != nil {
+			return nil, err
+		}
+		principal := NewMoney(payment.MinorUnits()-interest.MinorUnits(), balance.Currency())
+		if period == loan.Periods || principal.MinorUnits() > balance.MinorUnits() {
+			principal = balance
+		}
+		balance = NewMoney(balance.MinorUnits()-principal.MinorUnits(), balance.Currency())
+
+		schedule = append(schedule, AmortizationPayment{
+			Period:           period,
+			DueDate:          paymentDueDate(loan, period),
+			Payment:          NewMoney(principal.MinorUnits()+interest.MinorUnits(), balance.Currency()),
+			Principal:        principal,
+			Interest:         interest,
+			RemainingBalance: balance,
+		})
+		if balance.IsZero() {
+			break
+		}
+	}
+	return schedule, nil
+}
+
+func paymentDueDate(loan Loan, period int) time.Time {
+	if loan.StartDate.IsZero() {
+		return time.Time{}
+	}
+	if 12%loan.PeriodsPerYear == 0 {
+		return loan.StartDate.AddDate(0, period*12/loan.PeriodsPerYear, 0)
+	}
+	days := int(math.Round(365 / float64(loan.PeriodsPerYear)))
+	return loan.StartDate.AddDate(0, 0, period*days)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestYearFraction(t *testing.T) {
+	tests := []struct {
+		name       string
+		convention DayCountConvention
+		start, end time.Time
+		want       float64
+	}{
+		{"30/360 half year", Thirty360{}, date(2023, time.January, 15), date(2023, time.July, 15), 0.5},
+		{"30/360 from the 31st into leap February", Thirty360{}, date(2024, time.January, 31), date(2024, time.February, 29), 29.0 / 360},
+		{"30/360 from the 30th to the 31st", Thirty360{}, date(2023, time.January, 30), date(2023, time.March, 31), 60.0 / 360},
+		{"30/360 from February's end to the 31st", Thirty360{}, date(2023, time.February, 28), date(2023, time.March, 31), 33.0 / 360},
+		{"ACT/365 over a leap year", Actual365Fixed{}, date(2024, time.January, 1), date(2025, time.January, 1), 366.0 / 365},
+		{"ACT/365 month end to month end", Actual365Fixed{}, date(2023, time.January, 31), date(2023, time.February, 28), 28.0 / 365},
+		{"ACT/ACT whole leap year", ActualActual{}, date(2024, time.January, 1), date(2025, time.January, 1), 1},
+		{"ACT/ACT across into a leap year", ActualActual{}, date(2023, time.July, 1), date(2024, time.July, 1), 184.0/365 + 182.0/366},
+		{"ACT/ACT to December 31st", ActualActual{}, date(2023, time.January, 1), date(2023, time.December, 31), 364.0 / 365},
+		{"ACT/ACT backwards", ActualActual{}, date(2025, time.January, 1), date(2024, time.January, 1), -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.convention.YearFraction(tt.start, tt.end); math.Abs(got-tt.want) > 1e-12 {
+				t.Errorf("%s YearFraction(%s, %s) = %v, want %v",
+					tt.convention.Name(), tt.start.Format("2006-01-02"), tt.end.Format("2006-01-02"), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAmortizationScheduleRepaysPrincipal(t *testing.T) {
+	tests := []struct {
+		name string
+		loan Loan
+	}{
+		{"monthly at 6%", Loan{Principal: NewMoney(1000000, "USD"), AnnualRate: 0.06, Periods: 12, PeriodsPerYear: 12}},
+		{"quarterly at 7.25%", Loan{Principal: NewMoney(2500001, "USD"), AnnualRate: 0.0725, Periods: 20, PeriodsPerYear: 4}},
+		{"zero rate", Loan{Principal: NewMoney(100000, "USD"), AnnualRate: 0, Periods: 3, PeriodsPerYear: 12}},
+		{"zero rate with more periods than cents", Loan{Principal: NewMoney(5, "USD"), AnnualRate: 0, Periods: 12, PeriodsPerYear: 12}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := AmortizationSchedule(tt.loan)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(schedule) == 0 || len(schedule) > tt.loan.Periods {
+				t.Fatalf("%d payments for a %d-period loan", len(schedule), tt.loan.Periods)
+			}
+			var repaid int64
+			for i, payment := range schedule {
+				if payment.Principal.MinorUnits()+payment.Interest.MinorUnits() != payment.Payment.MinorUnits() {
+					t.Errorf("payment %d: %s principal + %s interest != %s", payment.Period, payment.Principal, payment.Interest, payment.Payment)
+				}
+				if tt.loan.AnnualRate == 0 && !payment.Interest.IsZero() {
+					t.Errorf("payment %d charges %s interest at a zero rate", payment.Period, payment.Interest)
+				}
+				// Every payment but the last is the same fixed amount.
+				if i > 0 && i < len(schedule)-1 && payment.Payment != schedule[0].Payment {
+					t.Errorf("payment %d = %s, want %s", payment.Period, payment.Payment, schedule[0].Payment)
+				}
+				repaid += payment.Principal.MinorUnits()
+			}
+			if repaid != tt.loan.Principal.MinorUnits() {
+				t.Errorf("principal repaid = %d, want %d", repaid, tt.loan.Principal.MinorUnits())
+			}
+			if last := schedule[len(schedule)-1]; !last.RemainingBalance.IsZero() {
+				t.Errorf("balance after the last payment = %s, want zero", last.RemainingBalance)
+			}
+		})
+	}
+}
+
+func TestZeroRateScheduleSplitsEvenly(t *testing.T) {
+	schedule, err := AmortizationSchedule(Loan{Principal: NewMoney(100000, "USD"), Periods: 3, PeriodsPerYear: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{33334, 33334, 33332}
+	for i, payment := range schedule {
+		if payment.Payment.MinorUnits() != want[i] {
+			t.Errorf("payment %d = %d, want %d", payment.Period, payment.Payment.MinorUnits(), want[i])
+		}
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type AccountKind string
+
+const (
+	AccountAsset     AccountKind = "asset"
+	AccountLiability AccountKind = "liability"
+	AccountEquity    AccountKind = "equity"
+	AccountRevenue   AccountKind = "revenue"
+	AccountExpense   AccountKind = "expense"
+)
+
+var (
+	ErrUnbalancedEntry = errors.New("journal entry does not balance")
+	ErrAlreadyReversed = errors.New("journal entry already reversed")
+)
+
+type Account struct {
+	Code string
+	Name string
+	Kind AccountKind
+}
+
+// UserAccount is the account holding a user's funds.
+func UserAccount(userId int) Account {
+	return Account{Code: fmt.Sprintf("user:%d", userId), Name: fmt.Sprintf("User %d funds", userId), Kind: AccountAsset}
+}
+
+// counterpartAccount is where the other side of a single-user transaction
+// type is booked. Both transfer legs go through one clearing account, which
+// nets to zero once a transfer is complete.
+func counterpartAccount(transactionType string) Account {
+	switch transactionType {
+	case "income":
+		return Account{Code: "revenue:income", Name: "Income", Kind: AccountRevenue}
+	case "deduction":
+		return Account{Code: "expense:deductions", Name: "Deductions", Kind: AccountExpense}
+	case "transfer_in", "transfer_out":
+		return Account{Code: "clearing:transfers", Name: "Transfers in flight", Kind: AccountAsset}
+	}
+	return Account{Code: "equity:" + transactionType, Name: "Other: " + transactionType, Kind: AccountEquity}
+}
+
+// Posting is one line of a journal entry. Debits are positive amounts and
+// credits negative, so a balanced entry's postings sum to zero in every
+// currency. BaseAmount is Amount valued in the ledger's base currency on
+// the entry date; it is filled in for base-currency postings. A zero base
+// amount is valid, since a tiny foreign amount can round to it, so an unset
+// one is told apart by being the zero Money, which has no currency.
+type Posting struct {
+	Account    Account
+	Amount     Money
+	BaseAmount Money
+}
+
+type JournalEntry struct {
+	Id              int64
+	UserId          int
+	Type            string
+	Memo            string
+	CreatedAt       time.Time
+	ReversesEntryId int64
+	Postings        []Posting
+}
+
+type AccountBalance struct {
+	Account Account
+	Balance Money
+}
+
+type TrialBalance struct {
+	Accounts     []AccountBalance
+	TotalDebits  Money
+	TotalCredits Money
+}
+
+func (tb TrialBalance) Balanced() bool {
+	cmp, err := tb.TotalDebits.Compare(tb.TotalCredits)
+	return err == nil && cmp == 0
+}
+
+// Ledger is append-only: entries are never edited or deleted, mistakes are
+// undone by posting a reversal.
+type Ledger interface {
+	Post(ctx context.Context, entry JournalEntry) (int64, error)
+	Reverse(ctx context.Context, entryId int64, memo string) (int64, error)
+	Entry(ctx context.Context, entryId int64) (JournalEntry, error)
+	AccountBalance(ctx context.Context, account Account, currency string) (Money, error)
+	AccountBalances(ctx context.Context, account Account) ([]Money, error)
+	AccountBalancesThrough(ctx context.Context, account Account, period StatementPeriod) ([]Money, error)
+	TrialBalance(ctx context.Context) (TrialBalance, error)
+	ClosePeriod(ctx context.Context, period StatementPeriod) error
+	Verify(ctx context.Context) ([]LedgerMismatch, error)
+}
+
+// The ledger holds postings in any currency. Its currency is the base
+// that BaseAmount and the trial balance are expressed in.
+type ledger struct {
+	db       Database
+	dialect  Dialect
+	currency string
+	now      func() time.Time
+}
+
+func NewLedger(db Database, dialect Dialect, baseCurrency string) Ledger {
+	return &ledger{db: db, dialect: dialect, currency: strings.ToUpper(baseCurrency), now: time.Now}
+}
+
+func (l ledger) toMoney(amount *float64, currency string) (Money, error) {
+	if amount == nil {
+		return NewMoney(0, currency), nil
+	}
+	return MoneyFromFloat(*amount, currency, RoundHalfEven)
+}
+
+// moneyFromMinor reads an integer column of minor units; NULL, as from a
+// SUM over no rows, is zero.
+func moneyFromMinor(minor *int64, currency string) Money {
+	if minor == nil {
+		return NewMoney(0, currency)
+	}
+	return NewMoney(*minor, currency)
+}
+
+func (l ledger) validate(entry JournalEntry) error {
+	if len(entry.Postings) < 2 {
+		return fmt.Errorf("%w: need at least two postings, got %d", ErrUnbalancedEntry, len(entry.Postings))
+	}
+	sums := map[string]Money{}
+	base := NewMoney(0, l.currency)
+	for _, posting := range entry.Postings {
+		if posting.BaseAmount == (Money{}) || posting.BaseAmount.Currency() != l.currency {
+			return fmt.Errorf("%s posting to %s needs a %s base amount", posting.Amount.Currency(), posting.Account.Code, l.currency)
+		}
+		sum, ok := sums[posting.Amount.Currency()]
+		if !ok {
+			sum = NewMoney(0, posting.Amount.Currency())
+		}
+		sum, err := sum.Add(posting.Amount)
+		if err != nil {
+			return err
+		}
+		sums[posting.Amount.Currency()] = sum
+		if base, err = base.Add(posting.BaseAmount); err != nil {
+			return err
+		}
+	}
+	for _, sum := range sums {
+		if !sum.IsZero() {
+			return fmt.Errorf("%w: off by %s", ErrUnbalancedEntry, sum)
+		}
+	}
+	if !base.IsZero() {
+		return fmt.Errorf("%w: base amounts off by %s", ErrUnbalancedEntry, base)
+	}
+	return nil
+}
+
+// ledgerIndexes are the constraints the ledger relies on. A reversal is
+// unique per original entry, so two concurrent reversals cannot both land,
+// each aggregate key has one row for posts to add to, and a period can only
+// be closed once.
+var ledgerIndexes = []string{
+	"CREATE UNIQUE INDEX IF NOT EXISTS journal_entries_reverses ON journal_entries (reverses_entry_id)",
+	"CREATE UNIQUE INDEX IF NOT EXISTS posting_aggregates_key ON posting_aggregates (account, period, type, currency)",
+	"CREATE UNIQUE INDEX IF NOT EXISTS period_closes_period ON period_closes (period)",
+}
+
+// CreateLedgerIndexes adds the ledger's constraints; run it once when the
+// database is provisioned.
+func CreateLedgerIndexes(ctx context.Context, db Database) error {
+	for _, statement := range ledgerIndexes {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return repositoryError("create ledger indexes", ErrConnection, err)
+		}
+	}
+	return nil
+}
+
+// Post records a balanced entry and all of its postings atomically. On a
+// plain *sql.DB it opens its own transaction; inside a UnitOfWork it joins
+// the caller's.
+func (l ledger) Post(ctx context.Context, entry JournalEntry) (int64, error) {
+	entry, err := l.prepare(entry)
+	if err != nil {
+		return 0, err
+	}
+	var entryId int64
+	err = l.withTx(ctx, "post journal entry", func(db Database) error {
+		entryId, err = l.insert(ctx, db, entry)
+		return err
+	})
+	return entryId, err
+}
+
+// prepare fills in what Post derives and validates the result.
+func (l ledger) prepare(entry JournalEntry) (JournalEntry, error) {
+	postings := make([]Posting, len(entry.Postings))
+	for i, posting := range entry.Postings {
+		if posting.Amount.Currency() == l.currency {
+			posting.BaseAmount = posting.Amount
+		}
+		postings[i] = posting
+	}
+	entry.Postings = postings
+	if err := l.validate(entry); err != nil {
+		return entry, err
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = l.now()
+	}
+	return entry, nil
+}
+
+// withTx runs fn in its own transaction, or directly on l.db when that is
+// already a transaction.
+func (l ledger) withTx(ctx context.Context, op string, fn func(db Database) error) error {
+	txDb, ok := l.db.(TxDatabase)
+	if !ok {
+		return fn(l.db)
+	}
+	tx, err := txDb.BeginTx(ctx, nil)
+	if err != nil {
+		return repositoryError(op, ErrConnection, err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return repositoryError(op, ErrConnection, err)
+	}
+	return nil
+}
+
+func (l ledger) insert(ctx context.Context, db Database, entry JournalEntry) (int64, error) {
+	const op = "post journal entry"
+	createdAt := entry.CreatedAt.UTC()
+	closed, err := l.closedThrough(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	if periodKey(createdAt) <= closed {
+		return 0, fmt.Errorf("post entry dated %s: %w", createdAt.Format("2006-01-02"), ErrPeriodClosed)
+	}
+
+	// Only reversals carry reverses_entry_id; NULL keeps the rest out of
+	// its unique index.
+	reverses := sql.NullInt64{Int64: entry.ReversesEntryId, Valid: entry.ReversesEntryId != 0}
+	entryId, err := insertReturningId(ctx, db, l.dialect,
+		"INSERT INTO journal_entries (user_id, type, memo, reverses_entry_id, created_at) VALUES (?, ?, ?, ?, ?)",
+		entry.UserId, entry.Type, entry.Memo, reverses, createdAt,
+	)
+	if l.dialect.IsUniqueViolation(err) && reverses.Valid {
+		return 0, fmt.Errorf("entry %d: %w", entry.ReversesEntryId, ErrAlreadyReversed)
+	}
+	if err != nil {
+		return 0, repositoryError(op, ErrConnection, err)
+	}
+
+	for _, posting := range entry.Postings {
+		if err := l.ensureAccount(ctx, db, posting.Account); err != nil {
+			return 0, err
+		}
+		_, err := db.ExecContext(ctx, rebind(l.dialect, `
+			INSERT INTO postings (entry_id, account, user_id, type, currency, amount, base_amount, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`), entryId, posting.Account.Code, entry.UserId, entry.Type, posting.Amount.Currency(),
+			posting.Amount.Float64(), posting.BaseAmount.Float64(), createdAt,
+		)
+		if err != nil {
+			return 0, repositoryError(op, ErrConnection, err)
+		}
+		if err := l.addToAggregate(ctx, db, posting.Account.Code, periodKey(createdAt), entry.Type, posting); err != nil {
+			return 0, err
+		}
+	}
+	return entryId, nil
+}
+
+func (l ledger) ensureAccount(ctx context.Context, db Database, account Account) error {
+	const op = "open account"
+	rows, err := db.QueryContext(ctx, rebind(l.dialect, "SELECT code FROM accounts WHERE code = ?"), account.Code)
+	if err != nil {
+		return repositoryError(op, ErrConnection, err)
+	}
+	exists := rows.Next()
+	rows.Close()
+	if exists {
+		return nil
+	}
+	_, err = db.ExecContext(ctx, rebind(l.dialect, "INSERT INTO accounts (code, name, kind) VALUES (?, ?, ?)"),
+		account.Code, account.Name, string(account.Kind))
+	if err != nil {
+		return repositoryError(op, ErrConnection, err)
+	}
+	return nil
+}
+
+// Reverse posts a mirror image of an entry so its effect nets to zero while
+// both entries stay on the books. The check for an earlier reversal and the
+// post share one transaction, and the unique index on reverses_entry_id
+// stops a concurrent reversal that slips past the check.
+func (l ledger) Reverse(ctx context.Context, entryId int64, memo string) (int64, error) {
+	const op = "reverse journal entry"
+	original, err := l.Entry(ctx, entryId)
+	if err != nil {
+		return 0, err
+	}
+	if original.ReversesEntryId != 0 {
+		return 0, fmt.Errorf("entry %d is itself a reversal of %d", entryId, original.ReversesEntryId)
+	}
+
+	reversal := JournalEntry{
+		UserId:          original.UserId,
+		Type:            original.Type,
+		Memo:            memo,
+		ReversesEntryId: entryId,
+	}
+	for _, posting := range original.Postings {
+		reversal.Postings = append(reversal.Postings, Posting{
+			Account:    posting.Account,
+			Amount:     posting.Amount.Negate(),
+			BaseAmount: posting.BaseAmount.Negate(),
+		})
+	}
+	if reversal, err = l.prepare(reversal); err != nil {
+		return 0, err
+	}
+
+	var reversalId int64
+	err = l.withTx(ctx, op, func(db Database) error {
+		rows, err := db.QueryContext(ctx, rebind(l.dialect, "SELECT id FROM journal_entries WHERE reverses_entry_id = ?"), entryId)
+		if err != nil {
+			return repositoryError(op, ErrConnection, err)
+		}
+		reversed := rows.Next()
+		rows.Close()
+		if reversed {
+			return fmt.Errorf("entry %d: %w", entryId, ErrAlreadyReversed)
+		}
+		reversalId, err = l.insert(ctx, db, reversal)
+		return err
+	})
+	return reversalId, err
+}
+
+func (l ledger) Entry(ctx context.Context, entryId int64) (JournalEntry, error) {
+	const op = "read journal entry"
+	rows, err := l.db.QueryContext(ctx, rebind(l.dialect,
+		"SELECT id, user_id, type, memo, reverses_entry_id, created_at FROM journal_entries WHERE id = ?"), entryId)
+	if err != nil {
+		return JournalEntry{}, repositoryError(op, ErrConnection, err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return JournalEntry{}, repositoryError(op, ErrConnection, err)
+		}
+		return JournalEntry{}, repositoryError(op, ErrNotFound, fmt.Errorf("entry %d", entryId))
+	}
+	var entry JournalEntry
+	var reverses sql.NullInt64
+	if err := rows.Scan(&entry.Id, &entry.UserId, &entry.Type, &entry.Memo, &reverses, &entry.CreatedAt); err != nil {
+		return JournalEntry{}, repositoryError(op, ErrScan, err)
+	}
+	entry.ReversesEntryId = reverses.Int64
+	rows.Close()
+
+	accounts, err := l.accounts(ctx)
+	if err != nil {
+		return JournalEntry{}, err
+	}
+	postings, err := l.db.QueryContext(ctx, rebind(l.dialect,
+		"SELECT account, currency, amount, base_amount FROM postings WHERE entry_id = ? ORDER BY id"), entryId)
+	if err != nil {
+		return JournalEntry{}, repositoryError(op, ErrConnection, err)
+	}
+	defer postings.Close()
+	for postings.Next() {
+		var code, currency string
+		var amount, baseAmount float64
+		if err := postings.Scan(&code, &currency, &amount, &baseAmount); err != nil {
+			return JournalEntry{}, repositoryError(op, ErrScan, err)
+		}
+		posting := Posting{Account: accounts.lookup(code)}
+		if posting.Amount, err = l.toMoney(&amount, currency); err != nil {
+			return JournalEntry{}, repositoryError(op, ErrScan, err)
+		}
+		if posting.BaseAmount, err = l.toMoney(&baseAmount, l.currency); err != nil {
+			return JournalEntry{}, repositoryError(op, ErrScan, err)
+		}
+		entry.Postings = append(entry.Postings, posting)
+	}
+	if err := postings.Err(); err != nil {
+		return JournalEntry{}, repositoryError(op, ErrConnection, err)
+	}
+	return entry, nil
+}
+
+func (l ledger) AccountBalance(ctx context.Context, account Account, currency string) (Money, error) {
+	return l.balanceThrough(ctx, l.db, account.Code, strings.ToUpper(currency), 0)
+}
+
+// AccountBalances returns the account's balance in each currency it has
+// ever held, including currencies that are back to zero.
+func (l ledger) AccountBalances(ctx context.Context, account Account) ([]Money, error) {
+	return l.balancesThrough(ctx, account, 0)
+}
+
+// AccountBalancesThrough is AccountBalances as it stood at the end of the
+// given month.
+func (l ledger) AccountBalancesThrough(ctx context.Context, account Account, period StatementPeriod) ([]Money, error) {
+	return l.balancesThrough(ctx, account, period.key())
+}
+
+func (l ledger) balancesThrough(ctx context.Context, account Account, period int) ([]Money, error) {
+	currencies, err := l.aggregateCurrencies(ctx, l.db, account.Code)
+	if err != nil {
+		return nil, err
+	}
+	balances := make([]Money, 0, len(currencies))
+	for _, currency := range currencies {
+		balance, err := l.balanceThrough(ctx, l.db, account.Code, currency, period)
+		if err != nil {
+			return nil, err
+		}
+		balances = append(balances, balance)
+	}
+	return balances, nil
+}
+
+// TrialBalance lists every account's balance in the base currency, valuing
+// foreign postings at their historical base amounts. Debit balances add up
+// to TotalDebits and credit balances to TotalCredits; any difference means
+// the books are corrupt.
+func (l ledger) TrialBalance(ctx context.Context) (TrialBalance, error) {
+	const op = "trial balance"
+	accounts, err := l.accounts(ctx)
+	if err != nil {
+		return TrialBalance{}, err
+	}
+
+	rows, err := l.db.QueryContext(ctx, "SELECT account, SUM(base_amount) as balance FROM postings GROUP BY account")
+	if err != nil {
+		return TrialBalance{}, repositoryError(op, ErrConnection, err)
+	}
+	defer rows.Close()
+
+	trial := TrialBalance{TotalDebits: NewMoney(0, l.currency), TotalCredits: NewMoney(0, l.currency)}
+	for rows.Next() {
+		var code string
+		var amount float64
+		if err := rows.Scan(&code, &amount); err != nil {
+			return TrialBalance{}, repositoryError(op, ErrScan, err)
+		}
+		account := accounts.lookup(code)
+		balance, err := l.toMoney(&amount, l.currency)
+		if err != nil {
+			return TrialBalance{}, repositoryError(op, ErrScan, err)
+		}
+		if balance.IsNegative() {
+			trial.TotalCredits, err = trial.TotalCredits.Add(balance.Negate())
+		} else {
+			trial.TotalDebits, err = trial.TotalDebits.Add(balance)
+		}
+		if err != nil {
+			return TrialBalance{}, err
+		}
+		trial.Accounts = append(trial.Accounts, AccountBalance{Account: account, Balance: balance})
+	}
+	if err := rows.Err(); err != nil {
+		return TrialBalance{}, repositoryError(op, ErrConnection, err)
+	}
+
+	sort.Slice(trial.Accounts, func(i, j int) bool {
+		return trial.Accounts[i].Account.Code < trial.Accounts[j].Account.Code
+	})
+	return trial, nil
+}
+
+type accountIndex map[string]Account
+
+func (ai accountIndex) lookup(code string) Account {
+	if account, ok := ai[code]; ok {
+		return account
+	}
+	return Account{Code: code}
+}
+
+func (l ledger) accounts(ctx context.Context) (accountIndex, error) {
+	const op = "read accounts"
+	rows, err := l.db.QueryContext(ctx, "SELECT code, name, kind FROM accounts")
+	if err != nil {
+		return nil, repositoryError(op, ErrConnection, err)
+	}
+	defer rows.Close()
+
+	accounts := accountIndex{}
+	for rows.Next() {
+		var account Account
+		var kind string
+		if err := rows.Scan(&account.Code, &account.Name, &kind); err != nil {
+			return nil, repositoryError(op, ErrScan, err)
+		}
+		account.Kind = AccountKind(kind)
+		accounts[account.Code] = account
+	}
+	if err := rows.Err(); err != nil {
+		return nil, repositoryError(op, ErrConnection, err)
+	}
+	return accounts, nil
+}
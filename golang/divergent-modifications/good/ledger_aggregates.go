@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reads avoid scanning raw postings. Every posting also updates a running
+// total per account, calendar month, transaction type and currency, and
+// closing a month snapshots each account's balances so GetUserBalance only
+// has to add the months since the last close. Totals and snapshots are
+// stored as integer minor units, so sums never pick up float error.
+
+var ErrPeriodClosed = errors.New("accounting period is closed")
+
+// periodKey encodes a calendar month as yyyymm so periods compare as
+// integers in any SQL dialect.
+func periodKey(t time.Time) int {
+	t = t.UTC()
+	return t.Year()*100 + int(t.Month())
+}
+
+func (p StatementPeriod) key() int {
+	return p.Year*100 + p.Month
+}
+
+// addToAggregate bumps the running totals in the database itself rather
+// than reading and rewriting them, so concurrent posts cannot overwrite each
+// other. The first posting for a key inserts the row; if another post
+// inserted it first, the unique index rejects ours and the update is
+// retried.
+func (l ledger) addToAggregate(ctx context.Context, db Database, account string, period int, transactionType string, posting Posting) error {
+	const op = "update posting aggregate"
+	currency := posting.Amount.Currency()
+	increment := func() (bool, error) {
+		result, err := db.ExecContext(ctx, rebind(l.dialect, `
+			UPDATE posting_aggregates
+			SET total = total + ?, base_total = base_total + ?, postings_count = postings_count + 1
+			WHERE account = ? AND period = ? AND type = ? AND currency = ?
+		`), posting.Amount.MinorUnits(), posting.BaseAmount.MinorUnits(), account, period, transactionType, currency)
+		if err != nil {
+			return false, err
+		}
+		updated, err := result.RowsAffected()
+		return updated > 0, err
+	}
+
+	updated, err := increment()
+	if err != nil {
+		return repositoryError(op, ErrConnection, err)
+	}
+	if updated {
+		return nil
+	}
+	_, err = db.ExecContext(ctx, rebind(l.dialect, `
+		INSERT INTO posting_aggregates (account, period, type, currency, total, base_total, postings_count)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`), account, period, transactionType, currency, posting.Amount.MinorUnits(), posting.BaseAmount.MinorUnits(), 1)
+	if l.dialect.IsUniqueViolation(err) {
+		updated, err = increment()
+		if err == nil && !updated {
+			err = fmt.Errorf("aggregate row for %s %d vanished", account, period)
+		}
+	}
+	if err != nil {
+		return repositoryError(op, ErrConnection, err)
+	}
+	return nil
+}
+
+// aggregateCurrencies lists the currencies an account has postings in.
+func (l ledger) aggregateCurrencies(ctx context.Context, db Database, account string) ([]string, error) {
+	const op = "read account currencies"
+	rows, err := db.QueryContext(ctx, rebind(l.dialect, "SELECT currency FROM posting_aggregates WHERE account = ?"), account)
+	if err != nil {
+		return nil, repositoryError(op, ErrConnection, err)
+	}
+	defer rows.Close()
+
+	seen := map[string]bool{}
+	var currencies []string
+	for rows.Next() {
+		var currency string
+		if err := rows.Scan(&currency); err != nil {
+			return nil, repositoryError(op, ErrScan, err)
+		}
+		if !seen[currency] {
+			seen[currency] = true
+			currencies = append(currencies, currency)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, repositoryError(op, ErrConnection, err)
+	}
+	sort.Strings(currencies)
+	return currencies, nil
+}
+
+// closedThrough is the latest closed period, or 0. Closes are recorded in
+// period_closes rather than inferred from snapshots, because a month with
+// no postings at all leaves no snapshot behind.
+func (l ledger) closedThrough(ctx context.Context, db Database) (int, error) {
+	const op = "read closed period"
+	rows, err := db.QueryContext(ctx, "SELECT MAX(period) as closed FROM period_closes")
+	if err != nil {
+		return 0, repositoryError(op, ErrConnection, err)
+	}
+	defer rows.Close()
+
+	var closed *int64
+	if rows.Next() {
+		if err := rows.Scan(&closed); err != nil {
+			return 0, repositoryError(op, ErrScan, err)
+		}
+	}
+	if closed == nil {
+		return 0, nil
+	}
+	return int(*closed), nil
+}
+
+// latestSnapshot returns the account's most recent snapshot in currency
+// taken no later than through (0 means any), or period 0 and a zero
+// balance when there is none.
+func (l ledger) latestSnapshot(ctx context.Context, db Database, account, currency string, through int) (int, Money, error) {
+	const op = "read balance snapshot"
+	query := "SELECT period, balance FROM balance_snapshots WHERE account = ? AND currency = ?"
+	args := []interface{}{account, currency}
+	if through > 0 {
+		query += " AND period <= ?"
+		args = append(args, through)
+	}
+	rows, err := db.QueryContext(ctx, rebind(l.dialect, query+" ORDER BY period DESC LIMIT 1"), args...)
+	if err != nil {
+		return 0, Money{}, repositoryError(op, ErrConnection, err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, Money{}, repositoryError(op, ErrConnection, err)
+		}
+		return 0, NewMoney(0, currency), nil
+	}
+	var period int
+	var balance int64
+	if err := rows.Scan(&period, &balance); err != nil {
+		return 0, Money{}, repositoryError(op, ErrScan, err)
+	}
+	return period, NewMoney(balance, currency), nil
+}
+
+// balanceThrough adds the aggregates after the latest snapshot, up to and
+// including period (0 means no upper bound).
+func (l ledger) balanceThrough(ctx context.Context, db Database, account, currency string, period int) (Money, error) {
+	const op = "read account balance"
+	snapshotPeriod, balance, err := l.latestSnapshot(ctx, db, account, currency, period)
+	if err != nil {
+		return Money{}, err
+	}
+
+	query := "SELECT SUM(total) as balance FROM posting_aggregates WHERE account = ? AND currency = ? AND period > ?"
+	args := []interface{}{account, currency, snapshotPeriod}
+	if period > 0 {
+		query += " AND period <= ?"
+		args = append(args, period)
+	}
+	rows, err := db.QueryContext(ctx, rebind(l.dialect, query), args...)
+	if err != nil {
+		return Money{}, repositoryError(op, ErrConnection, err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return Money{}, repositoryError(op, ErrConnection, err)
+		}
+		return Money{}, repositoryError(op, ErrNotFound, nil)
+	}
+	var sum *int64
+	if err := rows.Scan(&sum); err != nil {
+		return Money{}, repositoryError(op, ErrScan, err)
+	}
+	return balance.Add(moneyFromMinor(sum, currency))
+}
+
+// ClosePeriod snapshots every account's balances through the given month.
+// Afterwards postings dated in or before that month are rejected, so the
+// snapshots stay true.
+func (l ledger) ClosePeriod(ctx context.Context, period StatementPeriod) error {
+	return l.withTx(ctx, "close period", func(db Database) error {
+		return l.closePeriod(ctx, db, period)
+	})
+}
+
+func (l ledger) closePeriod(ctx context.Context, db Database, period StatementPeriod) error {
+	const op = "close period"
+	closed, err := l.closedThrough(ctx, db)
+	if err != nil {
+		return err
+	}
+	if period.key() <= closed {
+		return fmt.Errorf("close %s: %w", period, ErrPeriodClosed)
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT code FROM accounts")
+	if err != nil {
+		return repositoryError(op, ErrConnection, err)
+	}
+	var accounts []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			rows.Close()
+			return repositoryError(op, ErrScan, err)
+		}
+		accounts = append(accounts, code)
+	}
+	rows.Close()
+
+	for _, account := range accounts {
+		currencies, err := l.aggregateCurrencies(ctx, db, account)
+		if err != nil {
+			return err
+		}
+		for _, currency := range currencies {
+			balance, err := l.balanceThrough(ctx, db, account, currency, period.key())
+			if err != nil {
+				return err
+			}
+			_, err = db.ExecContext(ctx, rebind(l.dialect, `
+				INSERT INTO balance_snapshots (account, currency, period, balance, created_at)
+				VALUES (?, ?, ?, ?, ?)
+			`), account, currency, period.key(), balance.MinorUnits(), l.now().UTC())
+			if err != nil {
+				return repositoryError(op, ErrConnection, err)
+			}
+		}
+	}
+
+	_, err = db.ExecContext(ctx, rebind(l.dialect, `
+		INSERT INTO period_closes (period, closed_at) VALUES (?, ?)
+	`), period.key(), l.now().UTC())
+	if l.dialect.IsUniqueViolation(err) {
+		return fmt.Errorf("close %s: %w", period, ErrPeriodClosed)
+	}
+	if err != nil {
+		return repositoryError(op, ErrConnection, err)
+	}
+	return nil
+}
+
+// LedgerMismatch is a stored figure that disagrees with the raw postings.
+// Kind is "aggregate" for a monthly total and "balance" for an account
+// balance; Period and Type are only set for aggregates.
+type LedgerMismatch struct {
+	Kind            string
+	Account         string
+	Period          int
+	Type            string
+	Stored          Money
+	Recomputed      Money
+	StoredCount     int
+	RecomputedCount int
+}
+
+func (m LedgerMismatch) String() string {
+	if m.Kind == "balance" {
+		return fmt.Sprintf("balance %s: stored %s, postings say %s", m.Account, m.Stored, m.Recomputed)
+	}
+	return fmt.Sprintf("aggregate %s/%d/%s: stored %s (%d), postings say %s (%d)",
+		m.Account, m.Period, m.Type, m.Stored, m.StoredCount, m.Recomputed, m.RecomputedCount)
+}
+
+type aggregateKey struct {
+	account  string
+	period   int
+	kind     string
+	currency string
+}
+
+type aggregateValue struct {
+	total     Money
+	baseTotal Money
+	count     int
+}
+
+type balanceKey struct {
+	account  string
+	currency string
+}
+
+// Verify recomputes every aggregate and account balance from the raw
+// postings and reports each one the stored figures get wrong.
+func (l ledger) Verify(ctx context.Context) ([]LedgerMismatch, error) {
+	const op = "verify ledger"
+	recomputed := map[aggregateKey]aggregateValue{}
+	balances := map[balanceKey]Money{}
+
+	rows, err := l.db.QueryContext(ctx, "SELECT account, type, currency, amount, base_amount, created_at FROM postings")
+	if err != nil {
+		return nil, repositoryError(op, ErrConnection, err)
+	}
+	for rows.Next() {
+		var account, transactionType, currency string
+		var amount, baseAmount float64
+		var createdAt time.Time
+		if err := rows.Scan(&account, &transactionType, &currency, &amount, &baseAmount, &createdAt); err != nil {
+			rows.Close()
+			return nil, repositoryError(op, ErrScan, err)
+		}
+		key := aggregateKey{account: account, period: periodKey(createdAt), kind: transactionType, currency: currency}
+		value, ok := recomputed[key]
+		if !ok {
+			value = l.zeroAggregate(currency)
+		}
+		posted, err := l.toMoney(&amount, currency)
+		if err != nil {
+			rows.Close()
+			return nil, repositoryError(op, ErrScan, err)
+		}
+		postedBase, err := l.toMoney(&baseAmount, l.currency)
+		if err != nil {
+			rows.Close()
+			return nil, repositoryError(op, ErrScan, err)
+		}
+		balance, ok := balances[balanceKey{account, currency}]
+		if !ok {
+			balance = NewMoney(0, currency)
+		}
+		value.total, err = value.total.Add(posted)
+		if err == nil {
+			value.baseTotal, err = value.baseTotal.Add(postedBase)
+		}
+		if err == nil {
+			balance, err = balance.Add(posted)
+		}
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		value.count++
+		recomputed[key] = value
+		balances[balanceKey{account, currency}] = balance
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, repositoryError(op, ErrConnection, err)
+	}
+
+	stored := map[aggregateKey]aggregateValue{}
+	rows, err = l.db.QueryContext(ctx,
+		"SELECT account, period, type, currency, total, base_total, postings_count FROM posting_aggregates")
+	if err != nil {
+		return nil, repositoryError(op, ErrConnection, err)
+	}
+	for rows.Next() {
+		var key aggregateKey
+		var total, baseTotal int64
+		var value aggregateValue
+		if err := rows.Scan(&key.account, &key.period, &key.kind, &key.currency, &total, &baseTotal, &value.count); err != nil {
+			rows.Close()
+			return nil, repositoryError(op, ErrScan, err)
+		}
+		value.total = NewMoney(total, key.currency)
+		value.baseTotal = NewMoney(baseTotal, l.currency)
+		stored[key] = value
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, repositoryError(op, ErrConnection, err)
+	}
+
+	var mismatches []LedgerMismatch
+	for key := range mergeAggregateKeys(recomputed, stored) {
+		want, ok := recomputed[key]
+		if !ok {
+			want = l.zeroAggregate(key.currency)
+		}
+		got, ok := stored[key]
+		if !ok {
+			got = l.zeroAggregate(key.currency)
+		}
+		totalCmp, err := want.total.Compare(got.total)
+		if err != nil {
+			return nil, err
+		}
+		baseCmp, err := want.baseTotal.Compare(got.baseTotal)
+		if err != nil {
+			return nil, err
+		}
+		if want.count != got.count || totalCmp != 0 {
+			mismatches = append(mismatches, LedgerMismatch{
+				Kind: "aggregate", Account: key.account, Period: key.period, Type: key.kind,
+				Stored: got.total, Recomputed: want.total, StoredCount: got.count, RecomputedCount: want.count,
+			})
+		}
+		if baseCmp != 0 {
+			mismatches = append(mismatches, LedgerMismatch{
+				Kind: "aggregate", Account: key.account, Period: key.period, Type: key.kind,
+				Stored: got.baseTotal, Recomputed: want.baseTotal, StoredCount: got.count, RecomputedCount: want.count,
+			})
+		}
+	}
+
+	for key, want := range balances {
+		got, err := l.balanceThrough(ctx, l.db, key.account, key.currency, 0)
+		if err != nil {
+			return nil, err
+		}
+		if cmp, err := want.Compare(got); err != nil {
+			return nil, err
+		} else if cmp != 0 {
+			mismatches = append(mismatches, LedgerMismatch{Kind: "balance", Account: key.account, Stored: got, Recomputed: want})
+		}
+	}
+	sort.Slice(mismatches, func(i, j int) bool {
+		return mismatches[i].String() < mismatches[j].String()
+	})
+	return mismatches, nil
+}
+
+func (l ledger) zeroAggregate(currency string) aggregateValue {
+	return aggregateValue{total: NewMoney(0, currency), baseTotal: NewMoney(0, l.currency)}
+}
+
+func mergeAggregateKeys(sets ...map[aggregateKey]aggregateValue) map[aggregateKey]struct{} {
+	keys := map[aggregateKey]struct{}{}
+	for _, set := range sets {
+		for key := range set {
+			keys[key] = struct{}{}
+		}
+	}
+	return keys
+}
+
+// rawAccountBalance sums every posting on the account in one currency,
+// ignoring snapshots and aggregates. It is the baseline Verify and the
+// benchmarks compare to.
+func (l ledger) rawAccountBalance(ctx context.Context, account Account, currency string) (Money, error) {
+	const op = "read raw account balance"
+	currency = strings.ToUpper(currency)
+	rows, err := l.db.QueryContext(ctx, rebind(l.dialect,
+		"SELECT SUM(amount) as balance FROM postings WHERE account = ? AND currency = ?"), account.Code, currency)
+	if err != nil {
+		return Money{}, repositoryError(op, ErrConnection, err)
+	}
+	defer rows.Close()
+
+	var balance *float64
+	if rows.Next() {
+		if err := rows.Scan(&balance); err != nil {
+			return Money{}, repositoryError(op, ErrScan, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return Money{}, repositoryError(op, ErrConnection, err)
+	}
+	total, err := l.toMoney(balance, currency)
+	if err != nil {
+		return Money{}, repositoryError(op, ErrScan, err)
+	}
+	return total, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// seedLedger posts postingsPerMonth deposits to user 1 in each of months
+// months from January 2020, then closes all but the last month.
+func seedLedger(tb testing.TB, months, postingsPerMonth int) *ledger {
+	tb.Helper()
+	ctx := context.Background()
+	db, err := OpenMemoryDatabase(fmt.Sprintf("%s-%d", tb.Name(), testDatabases.Add(1)))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	dialect, err := DialectFor(memoryDriverName)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := CreateLedgerIndexes(ctx, db); err != nil {
+		tb.Fatal(err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	seed := NewLedger(tx, dialect, defaultCurrency)
+	start := time.Date(2020, time.January, 15, 0, 0, 0, 0, time.UTC)
+	for month := 0; month < months; month++ {
+		for i := 0; i < postingsPerMonth; i++ {
+			if _, err := seed.Post(ctx, deposit(start.AddDate(0, month, 0), 1000)); err != nil {
+				tx.Rollback()
+				tb.Fatal(err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tb.Fatal(err)
+	}
+
+	l := &ledger{db: db, dialect: dialect, currency: defaultCurrency, now: time.Now}
+	if err := l.ClosePeriod(ctx, PreviousPeriod(start.AddDate(0, months-1, 0))); err != nil {
+		tb.Fatal(err)
+	}
+	return l
+}
+
+func deposit(at time.Time, minor int64) JournalEntry {
+	return JournalEntry{
+		UserId:    1,
+		Type:      "income",
+		CreatedAt: at,
+		Postings: []Posting{
+			{Account: UserAccount(1), Amount: NewMoney(minor, defaultCurrency)},
+			{Account: counterpartAccount("income"), Amount: NewMoney(-minor, defaultCurrency)},
+		},
+	}
+}
+
+func TestConcurrentAggregateUpdatesAreNotLost(t *testing.T) {
+	ctx := context.Background()
+	l := seedLedger(t, 1, 1)
+	posting := Posting{Account: UserAccount(2), Amount: NewMoney(100, defaultCurrency), BaseAmount: NewMoney(100, defaultCurrency)}
+
+	// Outside a transaction every statement lands on the shared store at
+	// once, so a read-then-write update would drop some of these, and the
+	// first few race to insert the row.
+	const posts = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, posts)
+	for range posts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- l.addToAggregate(ctx, l.db, UserAccount(2).Code, 202403, "income", posting)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := l.db.QueryContext(ctx, "SELECT total, postings_count FROM posting_aggregates WHERE account = ?", UserAccount(2).Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var total int64
+	var count, found int
+	for rows.Next() {
+		found++
+		if err := rows.Scan(&total, &count); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if found != 1 || count != posts || total != posts*100 {
+		t.Errorf("%d aggregate rows, last with total %d over %d postings; want 1 row, %d over %d", found, total, count, posts*100, posts)
+	}
+}
+
+func TestAggregatedBalanceMatchesRaw(t *testing.T) {
+	ctx := context.Background()
+	l := seedLedger(t, 6, 20)
+	raw, err := l.rawAccountBalance(ctx, UserAccount(1), defaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast, err := l.AccountBalance(ctx, UserAccount(1), defaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.MinorUnits() != fast.MinorUnits() {
+		t.Errorf("raw balance %s, aggregated %s", raw, fast)
+	}
+}
+
+func benchmarkBalance(b *testing.B, read func(*ledger) func(context.Context, Account, string) (Money, error)) {
+	l := seedLedger(b, 60, 200)
+	ctx := context.Background()
+	balance := read(l)
+	b.ResetTimer()
+	for b.Loop() {
+		if _, err := balance(ctx, UserAccount(1), defaultCurrency); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRawAccountBalance(b *testing.B) {
+	benchmarkBalance(b, func(l *ledger) func(context.Context, Account, string) (Money, error) {
+		return l.rawAccountBalance
+	})
+}
+
+func BenchmarkAggregatedAccountBalance(b *testing.B) {
+	benchmarkBalance(b, func(l *ledger) func(context.Context, Account, string) (Money, error) {
+		return l.AccountBalance
+	})
+}
+
+func TestClosingAPeriodWithoutPostingsRejectsLaterPosts(t *testing.T) {
+	ctx := context.Background()
+	db, dialect := newTestDatabase(t)
+	l := NewLedger(db, dialect, defaultCurrency)
+	march := StatementPeriod{Month: 3, Year: 2024}
+
+	if err := l.ClosePeriod(ctx, march); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Post(ctx, deposit(time.Date(2024, time.March, 20, 0, 0, 0, 0, time.UTC), 1000)); !errors.Is(err, ErrPeriodClosed) {
+		t.Errorf("post into the closed month = %v, want ErrPeriodClosed", err)
+	}
+	if err := l.ClosePeriod(ctx, march); !errors.Is(err, ErrPeriodClosed) {
+		t.Errorf("closing the month again = %v, want ErrPeriodClosed", err)
+	}
+	if _, err := l.Post(ctx, deposit(time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC), 1000)); err != nil {
+		t.Errorf("post into the next month: %v", err)
+	}
+}
+
+func TestAggregatesStoreWholeMinorUnits(t *testing.T) {
+	ctx := context.Background()
+	l := seedLedger(t, 1, 0)
+	at := time.Date(2024, time.May, 10, 0, 0, 0, 0, time.UTC)
+	// 0.1 + 0.2 does not add up to 0.3 in floating point.
+	for _, minor := range []int64{10, 20} {
+		if _, err := l.Post(ctx, deposit(at, minor)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := l.db.QueryContext(ctx, "SELECT total FROM posting_aggregates WHERE account = ?", UserAccount(1).Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var stored interface{}
+	for rows.Next() {
+		if err := rows.Scan(&stored); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if total, ok := stored.(int64); !ok || total != 30 {
+		t.Errorf("stored total = %#v, want int64 30", stored)
+	}
+}
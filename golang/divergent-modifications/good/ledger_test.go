@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestReverseConcurrentlyPostsOneReversal(t *testing.T) {
+	ctx := context.Background()
+	db, dialect := newTestDatabase(t)
+	repo := NewTransactionRepository(db, dialect, nil)
+	entryId, err := repo.SaveTransaction(ctx, 1, NewMoney(10000, defaultCurrency), "income")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const attempts = 8
+	var wg sync.WaitGroup
+	errs := make([]error, attempts)
+	for i := range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = repo.ReverseTransaction(ctx, entryId, "duplicate deposit")
+		}()
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("%d of %d concurrent reversals succeeded: %v", succeeded, attempts, errs)
+	}
+	assertBalance(t, repo, 1, 0)
+
+	if _, err := repo.ReverseTransaction(ctx, entryId, "again"); !errors.Is(err, ErrAlreadyReversed) {
+		t.Errorf("reversing again = %v, want ErrAlreadyReversed", err)
+	}
+}
+
+func TestUniqueIndexRejectsSecondReversal(t *testing.T) {
+	ctx := context.Background()
+	db, dialect := newTestDatabase(t)
+	l := NewLedger(db, dialect, defaultCurrency).(*ledger)
+	repo := NewTransactionRepository(db, dialect, nil)
+	entryId, err := repo.SaveTransaction(ctx, 1, NewMoney(10000, defaultCurrency), "income")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Reverse(ctx, entryId, "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Going straight to insert skips Reverse's own check, leaving only the
+	// index to catch it.
+	original, err := l.Entry(ctx, entryId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reversal := JournalEntry{UserId: original.UserId, Type: original.Type, ReversesEntryId: entryId}
+	for _, posting := range original.Postings {
+		reversal.Postings = append(reversal.Postings, Posting{Account: posting.Account, Amount: posting.Amount.Negate()})
+	}
+	if reversal, err = l.prepare(reversal); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.insert(ctx, db, reversal); !errors.Is(err, ErrAlreadyReversed) {
+		t.Fatalf("second reversal insert = %v, want ErrAlreadyReversed", err)
+	}
+}
+
+func TestPostAcceptsForeignPostingWorthZeroBase(t *testing.T) {
+	ctx := context.Background()
+	db, dialect := newTestDatabase(t)
+	l := NewLedger(db, dialect, defaultCurrency)
+
+	// One yen is worth less than half a cent, so its base value rounds to
+	// zero; that is still a base amount.
+	_, err := l.Post(ctx, JournalEntry{
+		UserId: 1,
+		Type:   "income",
+		Postings: []Posting{
+			{Account: UserAccount(1), Amount: NewMoney(1, "JPY"), BaseAmount: NewMoney(0, defaultCurrency)},
+			{Account: counterpartAccount("income"), Amount: NewMoney(-1, "JPY"), BaseAmount: NewMoney(0, defaultCurrency)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Post with a zero base amount: %v", err)
+	}
+
+	_, err = l.Post(ctx, JournalEntry{
+		UserId: 1,
+		Type:   "income",
+		Postings: []Posting{
+			{Account: UserAccount(1), Amount: NewMoney(1, "JPY")},
+			{Account: counterpartAccount("income"), Amount: NewMoney(-1, "JPY")},
+		},
+	})
+	if err == nil {
+		t.Fatal("Post without base amounts succeeded")
+	}
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+type MailMessage struct {
+	From     string    `json:"from"`
+	To       []string  `json:"to"`
+	Subject  string    `json:"subject"`
+	TextBody string    `json:"text_body,omitempty"`
+	HTMLBody string    `json:"html_body,omitempty"`
+	Date     time.Time `json:"date"`
+}
+
+// Bytes renders the message as RFC 5322 with a multipart/alternative body
+// when both a text and an HTML part are present.
+func (m MailMessage) Bytes() ([]byte, error) {
+	if m.From == "" || len(m.To) == 0 {
+		return nil, errors.New("mail message needs a sender and at least one recipient")
+	}
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	var buf bytes.Buffer
+	writeHeader := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	writeHeader("From", m.From)
+	writeHeader("To", strings.Join(m.To, ", "))
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	writeHeader("Date", date.Format(time.RFC1123Z))
+	writeHeader("Message-ID", "<"+randomMailId()+"@"+mailDomain(m.From)+">")
+	writeHeader("MIME-Version", "1.0")
+
+	if m.TextBody != "" && m.HTMLBody != "" {
+		writer := multipart.NewWriter(&buf)
+		writeHeader("Content-Type", "multipart/alternative; boundary="+writer.Boundary())
+		buf.WriteString("\r\n")
+		for _, part := range []struct{ contentType, body string }{
+			{"text/plain; charset=utf-8", m.TextBody},
+			{"text/html; charset=utf-8", m.HTMLBody},
+		} {
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Type", part.contentType)
+			header.Set("Content-Transfer-Encoding", "quoted-printable")
+			partWriter, err := writer.CreatePart(header)
+			if err != nil {
+				return nil, err
+			}
+			if err := writeQuotedPrintable(partWriter, part.body); err != nil {
+				return nil, err
+			}
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	contentType, body := "text/plain; charset=utf-8", m.TextBody
+	if m.HTMLBody != "" {
+		contentType, body = "text/html; charset=utf-8", m.HTMLBody
+	}
+	writeHeader("Content-Type", contentType)
+	writeHeader("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+	if err := writeQuotedPrintable(&buf, body); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, body string) error {
+	encoder := quotedprintable.NewWriter(w)
+	if _, err := encoder.Write([]byte(body)); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func randomMailId() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func mailDomain(address string) string {
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		return strings.TrimSuffix(address[at+1:], ">")
+	}
+	return "localhost"
+}
+
+// MailTransport delivers a fully built message.
+type MailTransport interface {
+	Send(ctx context.Context, message MailMessage) error
+}
+
+// SMTPTransport speaks to any SMTP server, upgrading to STARTTLS when the
+// server offers it. Credentials are optional so it also works against a
+// local development or fake server. The context bounds the whole session.
+type SMTPTransport struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+func NewSMTPTransport(host string, port int, username, password string) *SMTPTransport {
+	return &SMTPTransport{Host: host, Port: port, Username: username, Password: password}
+}
+
+func (st *SMTPTransport) Send(ctx context.Context, message MailMessage) error {
+	data, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(st.Host, strconv.Itoa(st.Port)))
+	if err != nil {
+		return err
+	}
+	// Closing the connection when ctx ends, rather than setting a deadline,
+	// means any failure after that point is reported as ctx.Err().
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := st.deliver(conn, message, data); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+func (st *SMTPTransport) deliver(conn net.Conn, message MailMessage, data []byte) error {
+	client, err := smtp.NewClient(conn, st.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: st.Host}); err != nil {
+			return err
+		}
+	}
+	if st.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", st.Username, st.Password, st.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(message.From); err != nil {
+		return err
+	}
+	for _, recipient := range message.To {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// SpoolTransport writes each message into a maildir (tmp/, new/, cur/) so
+// development mail can be opened with any mail client instead of sent.
+type SpoolTransport struct {
+	dir string
+}
+
+func NewSpoolTransport(dir string) (*SpoolTransport, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &SpoolTransport{dir: dir}, nil
+}
+
+func (st *SpoolTransport) Send(ctx context.Context, message MailMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%d_%s.%s", time.Now().UnixNano(), os.Getpid(), randomMailId(), hostname)
+
+	// Maildir delivery: write into tmp/ then rename into new/ atomically.
+	tmpPath := filepath.Join(st.dir, "tmp", name)
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(st.dir, "new", name))
+}
+
+// stdoutTransport prints messages instead of sending them.
+type stdoutTransport struct{}
+
+func (stdoutTransport) Send(ctx context.Context, message MailMessage) error {
+	body := message.TextBody
+	if body == "" {
+		body = message.HTMLBody
+	}
+	fmt.Printf("Sending email to %s:\nSubject: %s\n%s\n", strings.Join(message.To, ", "), message.Subject, body)
+	return nil
+}
+
+// MailTemplate renders the subject and text/HTML bodies of one kind of
+// message. The HTML part is escaped by html/template.
+type MailTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+func NewMailTemplate(name, subject, text, html string) (*MailTemplate, error) {
+	t := &MailTemplate{}
+	var err error
+	if t.subject, err = texttemplate.New(name + ".subject").Parse(subject); err != nil {
+		return nil, err
+	}
+	if text != "" {
+		if t.text, err = texttemplate.New(name + ".text").Parse(text); err != nil {
+			return nil, err
+		}
+	}
+	if html != "" {
+		if t.html, err = htmltemplate.New(name + ".html").Parse(html); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func MustMailTemplate(name, subject, text, html string) *MailTemplate {
+	t, err := NewMailTemplate(name, subject, text, html)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func (mt *MailTemplate) Render(from string, to []string, data interface{}) (MailMessage, error) {
+	message := MailMessage{From: from, To: to, Date: time.Now()}
+	var buf bytes.Buffer
+	if err := mt.subject.Execute(&buf, data); err != nil {
+		return message, err
+	}
+	message.Subject = strings.TrimSpace(buf.String())
+
+	if mt.text != nil {
+		buf.Reset()
+		if err := mt.text.Execute(&buf, data); err != nil {
+			return message, err
+		}
+		message.TextBody = buf.String()
+	}
+	if mt.html != nil {
+		buf.Reset()
+		if err := mt.html.Execute(&buf, data); err != nil {
+			return message, err
+		}
+		message.HTMLBody = buf.String()
+	}
+	return message, nil
+}
+
+type OutboxEntry struct {
+	Id            string      `json:"id"`
+	Message       MailMessage `json:"message"`
+	Attempts      int         `json:"attempts"`
+	NextAttemptAt time.Time   `json:"next_attempt_at"`
+	LastError     string      `json:"last_error,omitempty"`
+}
+
+// Outbox is a MailTransport that persists every message to disk before
+// handing it to the real transport. Failed deliveries stay in pending/
+// and are retried by Flush with exponential backoff until maxAttempts,
+// after which they are parked in failed/ for inspection.
+type Outbox struct {
+	dir         string
+	transport   MailTransport
+	maxAttempts int
+	baseDelay   time.Duration
+	now         func() time.Time
+}
+
+func NewOutbox(dir string, transport MailTransport, maxAttempts int, baseDelay time.Duration) (*Outbox, error) {
+	for _, sub := range []string{"pending", "failed"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+	return &Outbox{dir: dir, transport: transport, maxAttempts: maxAttempts, baseDelay: baseDelay, now: time.Now}, nil
+}
+
+// Send returns an error only when the message could not be persisted; a
+// delivery failure leaves it queued for the next Flush.
+func (o *Outbox) Send(ctx context.Context, message MailMessage) error {
+	entry := OutboxEntry{
+		Id:            fmt.Sprintf("%d-%s", o.now().UnixNano(), randomMailId()),
+		Message:       message,
+		NextAttemptAt: o.now(),
+	}
+	if err := o.save(entry); err != nil {
+		return err
+	}
+	_, err := o.attempt(ctx, entry)
+	return err
+}
+
+// Flush retries every pending message that is due and reports how many
+// were delivered.
+func (o *Outbox) Flush(ctx context.Context) (int, error) {
+	entries, err := o.Pending()
+	if err != nil {
+		return 0, err
+	}
+	delivered := 0
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return delivered, err
+		}
+		if entry.NextAttemptAt.After(o.now()) {
+			continue
+		}
+		sent, err := o.attempt(ctx, entry)
+		if err != nil {
+			return delivered, err
+		}
+		if sent {
+			delivered++
+		}
+	}
+	return delivered, nil
+}
+
+func (o *Outbox) Pending() ([]OutboxEntry, error) {
+	files, err := filepath.Glob(filepath.Join(o.dir, "pending", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var entries []OutboxEntry
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var entry OutboxEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// attempt tries one delivery and reports whether the transport accepted
+// the message. A message that fails for the last time is parked in failed/.
+func (o *Outbox) attempt(ctx context.Context, entry OutboxEntry) (bool, error) {
+	sendErr := o.transport.Send(ctx, entry.Message)
+	if sendErr == nil {
+		return true, os.Remove(o.path("pending", entry.Id))
+	}
+	// A cancelled caller is not the message's fault; leave it as it was.
+	if ctx.Err() != nil {
+		return false, nil
+	}
+
+	entry.Attempts++
+	entry.LastError = sendErr.Error()
+	if entry.Attempts >= o.maxAttempts {
+		if err := o.save(entry); err != nil {
+			return false, err
+		}
+		return false, os.Rename(o.path("pending", entry.Id), o.path("failed", entry.Id))
+	}
+	entry.NextAttemptAt = o.now().Add(o.baseDelay * time.Duration(1<<(entry.Attempts-1)))
+	return false, o.save(entry)
+}
+
+func (o *Outbox) path(state, id string) string {
+	return filepath.Join(o.dir, state, id+".json")
+}
+
+func (o *Outbox) save(entry OutboxEntry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := o.path("pending", entry.Id) + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, o.path("pending", entry.Id))
+}
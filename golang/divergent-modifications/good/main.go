@@ -1,41 +1,243 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
+	"os"
+	"time"
 )
 
-// Mock database interface for demonstration
+// Database is satisfied by *sql.DB and *sql.Tx for any driver, including
+// the in-memory one used here for demonstration.
 type Database interface {
-	Query(query string, args ...interface{}) (*sql.Rows, error)
-	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
 }
 
 func main() {
-	// This would normally use a real database connection
-	var db Database // mock database
+	// A real deployment would open mysql/postgres/sqlite here instead
+	db, err := OpenMemoryDatabase("demo")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	dialect, err := DialectFor(memoryDriverName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := CreateLedgerIndexes(context.Background(), db); err != nil {
+		log.Fatal(err)
+	}
+
+	if _, err := db.Exec("INSERT INTO users (id, name, email) VALUES (?, ?, ?)", 1, "John Doe", "john@example.com"); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO users (id, name, email) VALUES (?, ?, ?)", 2, "Jane Roe", "jane@example.com"); err != nil {
+		log.Fatal(err)
+	}
 
 	// Create all the separate services
 	calculator := NewFinancialCalculator()
-	transactionRepo := NewTransactionRepository(db)
-	userRepo := NewUserRepository(db)
-	reportGen := NewReportGenerator(transactionRepo, calculator)
+	// Historical rates value foreign amounts on the day they are booked
+	rates := NewHistoricalRates("USD")
+	if err := rates.LoadFile("fx_rates.csv"); err != nil {
+		log.Fatal(err)
+	}
+
+	transactionRepo := NewTransactionRepository(db, dialect, rates)
+	userRepo := NewUserRepository(db, dialect)
+	reportGen := NewReportGenerator(transactionRepo, calculator, rates)
 	emailSvc := NewEmailService()
 
 	// Create the main financial service with all dependencies
-	fs := NewFinancialService(calculator, transactionRepo, userRepo, reportGen, emailSvc)
+	unitOfWork := NewUnitOfWork(db, dialect, rates)
+	fs := NewFinancialService(calculator, transactionRepo, userRepo, reportGen, emailSvc, unitOfWork)
 
 	// Example usage
-	interest := fs.CalculateInterest(1000, 0.05, 2)
-	fmt.Printf("Interest: $%.2f\n", interest)
+	interest, err := fs.CalculateInterest(NewMoney(100000, "USD"), 0.05, 2)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Interest: %s\n", interest.Format())
+
+	compound, err := fs.CalculateInterestWithModel(NewMoney(100000, "USD"), 0.05, 2, CompoundInterest{PeriodsPerYear: 12})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Compound interest (monthly): %s\n", compound.Format())
+
+	schedule, err := fs.AmortizationSchedule(Loan{
+		Principal:      NewMoney(1000000, "USD"),
+		AnnualRate:     0.06,
+		Periods:        12,
+		PeriodsPerYear: 12,
+		StartDate:      time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	first, last := schedule[0], schedule[len(schedule)-1]
+	fmt.Printf("Loan payment %s (first month: %s interest, last month: %s interest)\n",
+		first.Payment.Format(), first.Interest.Format(), last.Interest.Format())
+
+	tax, err := fs.CalculateTax(NewMoney(7500000, "USD"), NewMoney(1000000, "USD"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Tax: %s\n", tax.Format())
+
+	ctx := context.Background()
+	fs.SaveTransaction(ctx, 1, NewMoney(500000, "USD"), "income")
+	fs.SaveTransaction(ctx, 1, NewMoney(-120000, "USD"), "deduction")
+	balance, err := fs.GetUserBalance(ctx, 1)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Balance: %s\n", balance.Format())
+
+	// Transfers are atomic: the second one names a missing user and leaves no trace
+	if err := fs.TransferFunds(ctx, 1, 2, NewMoney(25000, "USD")); err != nil {
+		log.Fatal(err)
+	}
+	if err := fs.TransferFunds(ctx, 1, 99, NewMoney(10000, "USD")); err != nil {
+		fmt.Printf("Transfer rejected: %v\n", err)
+	}
+	for _, userId := range []int{1, 2} {
+		balance, err := fs.GetUserBalance(ctx, userId)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Balance after transfers for user %d: %s\n", userId, balance.Format())
+	}
+
+	if err := fs.SendMonthlyStatement(ctx, 1); err != nil {
+		log.Fatal(err)
+	}
+
+	// Month-end statements go out in a batch; a rerun only retries failures
+	monthEnd := func() time.Time { return time.Date(2026, time.November, 1, 6, 0, 0, 0, time.UTC) }
+	batch := NewStatementBatch(fs, userRepo, NewStatementRunLog(db, dialect), 4, monthEnd)
+	for run := 1; run <= 2; run++ {
+		summary, err := batch.RunMonthEnd(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Statement run %d for %s: %d sent, %d skipped, %d failed\n",
+			run, summary.Period, summary.Sent, summary.Skipped, len(summary.Failures))
+	}
+
+	// Foreign amounts keep their currency; balances can be shown in any of them
+	if _, err := fs.SaveTransaction(ctx, 2, NewMoney(80000, "EUR"), "income"); err != nil {
+		log.Fatal(err)
+	}
+	holdings, err := fs.GetUserHoldings(ctx, 2)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, holding := range holdings {
+		fmt.Printf("User 2 holds %s\n", holding.Format())
+	}
+	for _, currency := range []string{"USD", "EUR"} {
+		balance, err := fs.GetUserBalanceIn(ctx, 2, currency)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("User 2 balance in %s: %s\n", currency, balance.Format())
+	}
+
+	// Exchange gains use average cost: bought at 1.094, half spent at 1.074,
+	// the rest valued at the year-end 1.0389
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 12, 0, 0, 0, time.UTC)
+	}
+	fxGains, err := CalculateFxGains(ctx, rates, "USD", []CurrencyMovement{
+		{Amount: NewMoney(100000, "EUR"), BaseAmount: NewMoney(109400, "USD"), At: day(2024, time.January, 2)},
+		{Amount: NewMoney(-50000, "EUR"), BaseAmount: NewMoney(-53700, "USD"), At: day(2024, time.July, 1)},
+	}, day(2024, time.January, 1), day(2024, time.December, 31))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, line := range fxGains {
+		fmt.Printf("FX %s 2024: realized %s, unrealized %s on %s held\n",
+			line.Currency, line.Realized.Format(), line.Unrealized.Format(), line.Holding.Format())
+	}
+
+	// Mistakes are reversed rather than edited, and the books must still balance
+	mistakeId, err := fs.SaveTransaction(ctx, 2, NewMoney(99900, "USD"), "income")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := fs.ReverseTransaction(ctx, mistakeId, "duplicate deposit"); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := fs.ReverseTransaction(ctx, mistakeId, "duplicate deposit"); errors.Is(err, ErrAlreadyReversed) {
+		fmt.Printf("Second reversal rejected: %v\n", err)
+	}
+	trial, err := fs.TrialBalance(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, account := range trial.Accounts {
+		fmt.Printf("  %-20s %12s\n", account.Account.Code, account.Balance.Format())
+	}
+	fmt.Printf("Trial balance: debits %s, credits %s, balanced=%t\n",
+		trial.TotalDebits.Format(), trial.TotalCredits.Format(), trial.Balanced())
+
+	// Closing last month snapshots balances; aggregates must match raw postings
+	if err := fs.ClosePeriod(ctx, PreviousPeriod(time.Now())); err != nil {
+		log.Fatal(err)
+	}
+	mismatches, err := fs.VerifyLedger(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Ledger verification: %d mismatches\n", len(mismatches))
+
+	// The same reports can be exported in any supported format
+	now := time.Now()
+	for _, format := range []ReportFormat{FormatCSV, FormatJSON} {
+		if err := fs.ExportMonthlyReport(ctx, os.Stdout, 1, int(now.Month()), now.Year(), format); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// A cancelled context stops report generation before anything is rendered
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := fs.ExportTaxReport(cancelled, os.Stdout, 1, now.Year(), FormatText); errors.Is(err, ErrConnection) {
+		fmt.Printf("Tax report export stopped: %v\n", err)
+	}
+
+	// Missing users are reported as such, not as an empty statement
+	if err := fs.SendMonthlyStatement(ctx, 99); errors.Is(err, ErrNotFound) {
+		fmt.Printf("Statement skipped: %v\n", err)
+	}
 
-	tax := fs.CalculateTax(75000, 10000)
-	fmt.Printf("Tax: $%.2f\n", tax)
+	// Bracket tables can also be loaded per jurisdiction and year
+	taxEngine := NewTaxEngine()
+	if err := taxEngine.LoadFile("tax_tables.json"); err != nil {
+		log.Printf("Skipping tax table demo: %v", err)
+	} else if federal, err := NewFinancialCalculatorWithTaxEngine(taxEngine, "US-FED", FilingSingle); err == nil {
+		breakdown, err := federal.CalculateTaxBreakdown(NewMoney(7500000, "USD"), NewMoney(1000000, "USD"), 2024)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("US-FED 2024 tax on %s taxable (%s deduction):\n", breakdown.TaxableIncome.Format(), breakdown.DeductionKind)
+		for _, bracket := range breakdown.Brackets {
+			fmt.Printf("  %4.0f%% on %s = %s\n", bracket.Rate*100, bracket.Taxable.Format(), bracket.Tax.Format())
+		}
+		fmt.Printf("  Total: %s\n", breakdown.TaxOwed.Format())
+	}
 
 	fmt.Println("Each responsibility is now handled by a separate, focused component:")
 	fmt.Println("- FinancialCalculator: handles business calculations")
-	fmt.Println("- TransactionRepository: handles transaction data operations")
+	fmt.Println("- TransactionRepository: handles transaction data operations on a double-entry Ledger")
 	fmt.Println("- UserRepository: handles user data operations")
 	fmt.Println("- ReportGenerator: handles report generation")
 	fmt.Println("- EmailService: handles email communications")
+	fmt.Println("- StatementBatch: sends month-end statements and resumes failed runs")
 }
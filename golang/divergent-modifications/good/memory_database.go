@@ -0,0 +1,1314 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// The memory driver is an in-process stand-in for a SQL server. It stores
+// rows in maps and understands the subset of SQL the repositories emit:
+// CREATE TABLE, CREATE UNIQUE INDEX, INSERT (with optional RETURNING id), SELECT with
+// SUM/COUNT/MIN/MAX, WHERE ... AND ..., GROUP BY, ORDER BY and LIMIT,
+// UPDATE (including "SET n = n + ?") and DELETE. Both "?" and "$n" placeholders are accepted, so it can
+// stand in for any Dialect.
+//
+// Transactions run against a private snapshot that replaces the shared
+// tables on commit. If another connection wrote in the meantime the commit
+// fails instead of losing that write, much like SQLite's SQLITE_BUSY.
+const memoryDriverName = "memdb"
+
+func init() {
+	sql.Register(memoryDriverName, &memoryDriver{stores: map[string]*memoryStore{}})
+}
+
+// OpenMemoryDatabase returns a database backed by the named in-memory
+// store. Connections opened with the same name share their rows.
+func OpenMemoryDatabase(name string) (*sql.DB, error) {
+	return sql.Open(memoryDriverName, name)
+}
+
+type memoryDriver struct {
+	mu     sync.Mutex
+	stores map[string]*memoryStore
+}
+
+func (d *memoryDriver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	store, ok := d.stores[name]
+	if !ok {
+		store = &memoryStore{tables: map[string]*memoryTable{}}
+		d.stores[name] = store
+	}
+	return &memoryConn{store: store}, nil
+}
+
+type memoryStore struct {
+	mu      sync.Mutex
+	tables  map[string]*memoryTable
+	version int64
+}
+
+type memoryTable struct {
+	columns []string
+	rows    []map[string]driver.Value
+	nextId  int64
+	unique  [][]string
+}
+
+func (s *memoryStore) table(name string, create bool) *memoryTable {
+	t, ok := s.tables[name]
+	if !ok && create {
+		t = &memoryTable{nextId: 1}
+		s.tables[name] = t
+	}
+	return t
+}
+
+// snapshot copies the table structure; rows themselves are never mutated
+// in place, so they can be shared between the copy and the original.
+func (s *memoryStore) snapshot() *memoryStore {
+	copied := &memoryStore{tables: make(map[string]*memoryTable, len(s.tables)), version: s.version}
+	for name, t := range s.tables {
+		copied.tables[name] = &memoryTable{
+			columns: append([]string(nil), t.columns...),
+			rows:    append([]map[string]driver.Value(nil), t.rows...),
+			nextId:  t.nextId,
+			unique:  t.unique,
+		}
+	}
+	return copied
+}
+
+func (t *memoryTable) addColumn(name string) {
+	for _, column := range t.columns {
+		if column == name {
+			return
+		}
+	}
+	t.columns = append(t.columns, name)
+}
+
+// memoryUniqueError is returned when a write would break a unique index,
+// so callers can match it by type rather than by message.
+type memoryUniqueError struct {
+	columns []string
+}
+
+func (e *memoryUniqueError) Error() string {
+	return "memdb: UNIQUE constraint failed: " + strings.Join(e.columns, ", ")
+}
+
+// checkUnique fails when row would share every column of a unique index
+// with another row. As in SQL, rows with a NULL in the index never clash.
+func (t *memoryTable) checkUnique(row map[string]driver.Value, skip int) error {
+	return t.checkUniqueIn(t.rows, row, skip)
+}
+
+func (t *memoryTable) checkUniqueIn(rows []map[string]driver.Value, row map[string]driver.Value, skip int) error {
+	for _, columns := range t.unique {
+		if hasNullColumn(row, columns) {
+			continue
+		}
+		for i, other := range rows {
+			if i == skip || hasNullColumn(other, columns) {
+				continue
+			}
+			clash := true
+			for _, column := range columns {
+				if c, ok := compareMemoryValues(row[column], other[column]); !ok || c != 0 {
+					clash = false
+					break
+				}
+			}
+			if clash {
+				return &memoryUniqueError{columns: columns}
+			}
+		}
+	}
+	return nil
+}
+
+func (t *memoryTable) hasUnique(columns []string) bool {
+	for _, existing := range t.unique {
+		if strings.Join(existing, ",") == strings.Join(columns, ",") {
+			return true
+		}
+	}
+	return false
+}
+
+func hasNullColumn(row map[string]driver.Value, columns []string) bool {
+	for _, column := range columns {
+		if row[column] == nil {
+			return true
+		}
+	}
+	return false
+}
+
+type memoryConn struct {
+	store *memoryStore
+	tx    *memoryTx
+}
+
+func (c *memoryConn) Prepare(query string) (driver.Stmt, error) {
+	statement, err := parseMemoryStatement(query)
+	if err != nil {
+		return nil, err
+	}
+	return &memoryStmt{conn: c, statement: statement}, nil
+}
+
+func (c *memoryConn) Close() error {
+	return nil
+}
+
+func (c *memoryConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Begin()
+}
+
+func (c *memoryConn) Begin() (driver.Tx, error) {
+	if c.tx != nil {
+		return nil, errors.New("memdb: transaction already in progress")
+	}
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	c.tx = &memoryTx{conn: c, snapshot: c.store.snapshot(), baseVersion: c.store.version}
+	return c.tx, nil
+}
+
+type memoryTx struct {
+	conn        *memoryConn
+	snapshot    *memoryStore
+	baseVersion int64
+	wrote       bool
+}
+
+func (tx *memoryTx) Commit() error {
+	defer func() { tx.conn.tx = nil }()
+	if !tx.wrote {
+		return nil
+	}
+
+	store := tx.conn.store
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.version != tx.baseVersion {
+		return errors.New("memdb: transaction conflicts with a concurrent write")
+	}
+	store.tables = tx.snapshot.tables
+	store.version++
+	return nil
+}
+
+func (tx *memoryTx) Rollback() error {
+	tx.conn.tx = nil
+	return nil
+}
+
+type memoryStmt struct {
+	conn      *memoryConn
+	statement *memoryStatement
+}
+
+func (s *memoryStmt) Close() error  { return nil }
+func (s *memoryStmt) NumInput() int { return -1 }
+
+func (s *memoryStmt) Exec(args []driver.Value) (driver.Result, error) {
+	result, _, err := s.run(args)
+	return result, err
+}
+
+func (s *memoryStmt) Query(args []driver.Value) (driver.Rows, error) {
+	_, rows, err := s.run(args)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		rows = &memoryRows{}
+	}
+	return rows, nil
+}
+
+// The context-aware variants let a cancelled context stop a statement
+// before it touches the store.
+func (s *memoryStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Exec(namedValues(args))
+}
+
+func (s *memoryStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Query(namedValues(args))
+}
+
+func namedValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
+
+// run executes inside the connection's transaction snapshot when there is
+// one, otherwise directly against the shared store.
+func (s *memoryStmt) run(args []driver.Value) (driver.Result, *memoryRows, error) {
+	writes := s.statement.kind != "SELECT"
+	if tx := s.conn.tx; tx != nil {
+		if writes {
+			tx.wrote = true
+		}
+		return s.statement.execute(tx.snapshot, args)
+	}
+
+	store := s.conn.store
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if writes {
+		store.version++
+	}
+	return s.statement.execute(store, args)
+}
+
+type memoryResult struct {
+	lastInsertId int64
+	rowsAffected int64
+}
+
+func (r memoryResult) LastInsertId() (int64, error) { return r.lastInsertId, nil }
+func (r memoryResult) RowsAffected() (int64, error) { return r.rowsAffected, nil }
+
+type memoryRows struct {
+	columns []string
+	values  [][]driver.Value
+	next    int
+}
+
+func (r *memoryRows) Columns() []string { return r.columns }
+func (r *memoryRows) Close() error      { return nil }
+
+func (r *memoryRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.next])
+	r.next++
+	return nil
+}
+
+// Statement model
+
+type memoryOperand struct {
+	column      string
+	placeholder int // 1-based; 0 when the operand is not a placeholder
+	literal     driver.Value
+	isLiteral   bool
+}
+
+func (o memoryOperand) value(row map[string]driver.Value, args []driver.Value) (driver.Value, error) {
+	switch {
+	case o.placeholder > 0:
+		if o.placeholder > len(args) {
+			return nil, fmt.Errorf("memdb: missing argument $%d", o.placeholder)
+		}
+		return args[o.placeholder-1], nil
+	case o.isLiteral:
+		return o.literal, nil
+	default:
+		return row[o.column], nil
+	}
+}
+
+type memoryCondition struct {
+	column   string
+	operator string
+	operand  memoryOperand
+}
+
+// memoryAssignment sets column to operand, plus addend when there is one.
+type memoryAssignment struct {
+	column  string
+	operand memoryOperand
+	addend  *memoryOperand
+}
+
+func (a memoryAssignment) value(row map[string]driver.Value, args []driver.Value) (driver.Value, error) {
+	value, err := a.operand.value(row, args)
+	if err != nil || a.addend == nil {
+		return value, err
+	}
+	addend, err := a.addend.value(row, args)
+	if err != nil || value == nil || addend == nil {
+		return nil, err
+	}
+	x, xInt := value.(int64)
+	y, yInt := addend.(int64)
+	if xInt && yInt {
+		return x + y, nil
+	}
+	left, leftOk := memoryNumber(value)
+	right, rightOk := memoryNumber(addend)
+	if !leftOk || !rightOk {
+		return nil, fmt.Errorf("memdb: cannot add %v and %v", value, addend)
+	}
+	return left + right, nil
+}
+
+type memorySelectItem struct {
+	function string // SUM, COUNT, MIN, MAX or empty for a plain column
+	column   string // "*" for SELECT * and COUNT(*)
+	alias    string
+}
+
+func (item memorySelectItem) name() string {
+	if item.alias != "" {
+		return item.alias
+	}
+	if item.function != "" {
+		return strings.ToLower(item.function) + "(" + item.column + ")"
+	}
+	return item.column
+}
+
+type memoryOrder struct {
+	column     string
+	descending bool
+}
+
+type memoryStatement struct {
+	kind        string
+	table       string
+	columns     []string
+	values      []memoryOperand
+	returning   string
+	items       []memorySelectItem
+	where       []memoryCondition
+	groupBy     []string
+	orderBy     []memoryOrder
+	limit       int
+	assignments []memoryAssignment
+}
+
+func (st *memoryStatement) execute(store *memoryStore, args []driver.Value) (driver.Result, *memoryRows, error) {
+	switch st.kind {
+	case "CREATE":
+		table := store.table(st.table, true)
+		if st.columns != nil && !table.hasUnique(st.columns) {
+			// Snapshots share the index list, so extend a copy.
+			existing := table.unique
+			table.unique = append(append([][]string(nil), existing...), st.columns)
+			for i, row := range table.rows {
+				if err := table.checkUnique(row, i); err != nil {
+					table.unique = existing
+					return nil, nil, err
+				}
+			}
+		}
+		return memoryResult{}, nil, nil
+	case "INSERT":
+		return st.executeInsert(store, args)
+	case "SELECT":
+		rows, err := st.executeSelect(store, args)
+		return memoryResult{}, rows, err
+	case "UPDATE":
+		return st.executeUpdate(store, args)
+	case "DELETE":
+		return st.executeDelete(store, args)
+	}
+	return nil, nil, fmt.Errorf("memdb: unsupported statement %s", st.kind)
+}
+
+func (st *memoryStatement) executeInsert(store *memoryStore, args []driver.Value) (driver.Result, *memoryRows, error) {
+	table := store.table(st.table, true)
+	row := map[string]driver.Value{}
+	for i, column := range st.columns {
+		value, err := st.values[i].value(nil, args)
+		if err != nil {
+			return nil, nil, err
+		}
+		row[column] = value
+		table.addColumn(column)
+	}
+
+	id, ok := row["id"].(int64)
+	if !ok {
+		id = table.nextId
+		row["id"] = id
+		table.addColumn("id")
+	}
+	if err := table.checkUnique(row, -1); err != nil {
+		return nil, nil, err
+	}
+	if id >= table.nextId {
+		table.nextId = id + 1
+	}
+	table.rows = append(table.rows, row)
+
+	var rows *memoryRows
+	if st.returning != "" {
+		rows = &memoryRows{
+			columns: []string{st.returning},
+			values:  [][]driver.Value{{row[st.returning]}},
+		}
+	}
+	return memoryResult{lastInsertId: id, rowsAffected: 1}, rows, nil
+}
+
+func (st *memoryStatement) matching(table *memoryTable, args []driver.Value) ([]int, error) {
+	var indexes []int
+	if table == nil {
+		return indexes, nil
+	}
+	for i, row := range table.rows {
+		matched := true
+		for _, condition := range st.where {
+			ok, err := condition.matches(row, args)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes, nil
+}
+
+func (c memoryCondition) matches(row map[string]driver.Value, args []driver.Value) (bool, error) {
+	right, err := c.operand.value(row, args)
+	if err != nil {
+		return false, err
+	}
+	cmp, ok := compareMemoryValues(row[c.column], right)
+	if !ok {
+		return false, nil
+	}
+	switch c.operator {
+	case "=":
+		return cmp == 0, nil
+	case "!=", "<>":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+	return false, fmt.Errorf("memdb: unsupported operator %s", c.operator)
+}
+
+func (st *memoryStatement) executeSelect(store *memoryStore, args []driver.Value) (*memoryRows, error) {
+	table := store.table(st.table, false)
+	indexes, err := st.matching(table, args)
+	if err != nil {
+		return nil, err
+	}
+
+	items := st.items
+	if len(items) == 1 && items[0].function == "" && items[0].column == "*" {
+		items = nil
+		if table != nil {
+			for _, column := range table.columns {
+				items = append(items, memorySelectItem{column: column})
+			}
+		}
+	}
+
+	aggregated := len(st.groupBy) > 0
+	for _, item := range items {
+		if item.function != "" {
+			aggregated = true
+		}
+	}
+
+	var groups [][]map[string]driver.Value
+	if aggregated {
+		keys := map[string]int{}
+		if len(st.groupBy) == 0 {
+			groups = append(groups, nil)
+		}
+		for _, i := range indexes {
+			row := table.rows[i]
+			if len(st.groupBy) == 0 {
+				groups[0] = append(groups[0], row)
+				continue
+			}
+			var parts []string
+			for _, column := range st.groupBy {
+				parts = append(parts, fmt.Sprintf("%v", row[column]))
+			}
+			key := strings.Join(parts, "\x00")
+			position, ok := keys[key]
+			if !ok {
+				position = len(groups)
+				keys[key] = position
+				groups = append(groups, nil)
+			}
+			groups[position] = append(groups[position], row)
+		}
+	} else {
+		for _, i := range indexes {
+			groups = append(groups, []map[string]driver.Value{table.rows[i]})
+		}
+	}
+
+	result := &memoryRows{}
+	for _, item := range items {
+		result.columns = append(result.columns, item.name())
+	}
+	for _, group := range groups {
+		values := make([]driver.Value, len(items))
+		for i, item := range items {
+			values[i] = aggregateMemoryValues(item, group)
+		}
+		result.values = append(result.values, values)
+	}
+
+	if len(st.orderBy) > 0 {
+		positions := map[string]int{}
+		for i, column := range result.columns {
+			positions[column] = i
+		}
+		sort.SliceStable(result.values, func(a, b int) bool {
+			for _, order := range st.orderBy {
+				position, ok := positions[order.column]
+				if !ok {
+					continue
+				}
+				cmp, _ := compareMemoryValues(result.values[a][position], result.values[b][position])
+				if cmp == 0 {
+					continue
+				}
+				if order.descending {
+					return cmp > 0
+				}
+				return cmp < 0
+			}
+			return false
+		})
+	}
+	if st.limit > 0 && len(result.values) > st.limit {
+		result.values = result.values[:st.limit]
+	}
+	return result, nil
+}
+
+func aggregateMemoryValues(item memorySelectItem, group []map[string]driver.Value) driver.Value {
+	switch item.function {
+	case "":
+		if len(group) == 0 {
+			return nil
+		}
+		return group[0][item.column]
+	case "COUNT":
+		var count int64
+		for _, row := range group {
+			if item.column == "*" || row[item.column] != nil {
+				count++
+			}
+		}
+		return count
+	case "SUM":
+		var intSum int64
+		var floatSum float64
+		isFloat, seen := false, false
+		for _, row := range group {
+			switch v := row[item.column].(type) {
+			case int64:
+				intSum += v
+				floatSum += float64(v)
+				seen = true
+			case float64:
+				floatSum += v
+				isFloat, seen = true, true
+			}
+		}
+		if !seen {
+			return nil
+		}
+		if isFloat {
+			return floatSum
+		}
+		return intSum
+	case "MIN", "MAX":
+		var best driver.Value
+		for _, row := range group {
+			value := row[item.column]
+			if value == nil {
+				continue
+			}
+			if best == nil {
+				best = value
+				continue
+			}
+			cmp, ok := compareMemoryValues(value, best)
+			if ok && ((item.function == "MIN" && cmp < 0) || (item.function == "MAX" && cmp > 0)) {
+				best = value
+			}
+		}
+		return best
+	}
+	return nil
+}
+
+func (st *memoryStatement) executeUpdate(store *memoryStore, args []driver.Value) (driver.Result, *memoryRows, error) {
+	table := store.table(st.table, false)
+	indexes, err := st.matching(table, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(indexes) == 0 {
+		return memoryResult{}, nil, nil
+	}
+	// Build every updated row before touching the table, so a failed
+	// assignment or unique check leaves no row half-updated.
+	next := append([]map[string]driver.Value(nil), table.rows...)
+	for _, i := range indexes {
+		row := table.rows[i]
+		updated := make(map[string]driver.Value, len(row))
+		for column, value := range row {
+			updated[column] = value
+		}
+		for _, assignment := range st.assignments {
+			value, err := assignment.value(row, args)
+			if err != nil {
+				return nil, nil, err
+			}
+			updated[assignment.column] = value
+		}
+		next[i] = updated
+	}
+	for _, i := range indexes {
+		if err := table.checkUniqueIn(next, next[i], i); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, assignment := range st.assignments {
+		table.addColumn(assignment.column)
+	}
+	table.rows = next
+	return memoryResult{rowsAffected: int64(len(indexes))}, nil, nil
+}
+
+func (st *memoryStatement) executeDelete(store *memoryStore, args []driver.Value) (driver.Result, *memoryRows, error) {
+	table := store.table(st.table, false)
+	indexes, err := st.matching(table, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(indexes) == 0 {
+		return memoryResult{}, nil, nil
+	}
+
+	deleted := map[int]bool{}
+	for _, i := range indexes {
+		deleted[i] = true
+	}
+	kept := make([]map[string]driver.Value, 0, len(table.rows)-len(indexes))
+	for i, row := range table.rows {
+		if !deleted[i] {
+			kept = append(kept, row)
+		}
+	}
+	table.rows = kept
+	return memoryResult{rowsAffected: int64(len(indexes))}, nil, nil
+}
+
+// compareMemoryValues orders two stored values. The second result is false
+// when the values cannot be compared, which SQL treats as "not matched".
+func compareMemoryValues(a, b driver.Value) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+	if x, ok := memoryNumber(a); ok {
+		if y, ok := memoryNumber(b); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	if x, ok := memoryTime(a); ok {
+		if y, ok := memoryTime(b); ok {
+			return x.Compare(y), true
+		}
+	}
+	return strings.Compare(memoryString(a), memoryString(b)), true
+}
+
+func memoryNumber(v driver.Value) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case bool:
+		if n {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func memoryTime(v driver.Value) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			parsed, err = time.Parse("2006-01-02 15:04:05", t)
+		}
+		return parsed, err == nil
+	}
+	return time.Time{}, false
+}
+
+func memoryString(v driver.Value) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// Parsing
+
+type memoryParser struct {
+	tokens       []string
+	position     int
+	placeholders int
+}
+
+func parseMemoryStatement(query string) (*memoryStatement, error) {
+	tokens, err := tokenizeMemoryQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &memoryParser{tokens: tokens}
+
+	var statement *memoryStatement
+	switch keyword := strings.ToUpper(p.next()); keyword {
+	case "CREATE":
+		statement, err = p.parseCreate()
+	case "INSERT":
+		statement, err = p.parseInsert()
+	case "SELECT":
+		statement, err = p.parseSelect()
+	case "UPDATE":
+		statement, err = p.parseUpdate()
+	case "DELETE":
+		statement, err = p.parseDelete()
+	default:
+		return nil, fmt.Errorf("memdb: unsupported statement %q", keyword)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.accept(";")
+	if !p.done() {
+		return nil, fmt.Errorf("memdb: unexpected %q in %q", p.peek(), query)
+	}
+	return statement, nil
+}
+
+func (p *memoryParser) done() bool {
+	return p.position >= len(p.tokens)
+}
+
+func (p *memoryParser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.position]
+}
+
+func (p *memoryParser) next() string {
+	token := p.peek()
+	p.position++
+	return token
+}
+
+func (p *memoryParser) accept(keyword string) bool {
+	if strings.EqualFold(p.peek(), keyword) {
+		p.position++
+		return true
+	}
+	return false
+}
+
+func (p *memoryParser) expect(keyword string) error {
+	if !p.accept(keyword) {
+		return fmt.Errorf("memdb: expected %s, found %q", keyword, p.peek())
+	}
+	return nil
+}
+
+func (p *memoryParser) identifier() (string, error) {
+	token := p.next()
+	if token == "" || !isMemoryIdentifierStart(rune(token[0])) {
+		return "", fmt.Errorf("memdb: expected identifier, found %q", token)
+	}
+	return strings.ToLower(token), nil
+}
+
+func (p *memoryParser) operand() (memoryOperand, error) {
+	token := p.next()
+	switch {
+	case token == "?":
+		p.placeholders++
+		return memoryOperand{placeholder: p.placeholders}, nil
+	case strings.HasPrefix(token, "$"):
+		position, err := strconv.Atoi(token[1:])
+		if err != nil {
+			return memoryOperand{}, fmt.Errorf("memdb: bad placeholder %q", token)
+		}
+		return memoryOperand{placeholder: position}, nil
+	case strings.HasPrefix(token, "'"):
+		return memoryOperand{literal: token[1:], isLiteral: true}, nil
+	case token != "" && (unicode.IsDigit(rune(token[0])) || token[0] == '-'):
+		if i, err := strconv.ParseInt(token, 10, 64); err == nil {
+			return memoryOperand{literal: i, isLiteral: true}, nil
+		}
+		f, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return memoryOperand{}, fmt.Errorf("memdb: bad number %q", token)
+		}
+		return memoryOperand{literal: f, isLiteral: true}, nil
+	case strings.EqualFold(token, "NULL"):
+		return memoryOperand{isLiteral: true}, nil
+	case strings.EqualFold(token, "TRUE"), strings.EqualFold(token, "FALSE"):
+		return memoryOperand{literal: strings.EqualFold(token, "TRUE"), isLiteral: true}, nil
+	case token != "" && isMemoryIdentifierStart(rune(token[0])):
+		return memoryOperand{column: strings.ToLower(token)}, nil
+	}
+	return memoryOperand{}, fmt.Errorf("memdb: unexpected %q", token)
+}
+
+func (p *memoryParser) parseCreate() (*memoryStatement, error) {
+	if p.accept("UNIQUE") {
+		return p.parseCreateUniqueIndex()
+	}
+	if err := p.expect("TABLE"); err != nil {
+		return nil, err
+	}
+	if p.accept("IF") {
+		if err := p.expect("NOT"); err != nil {
+			return nil, err
+		}
+		if err := p.expect("EXISTS"); err != nil {
+			return nil, err
+		}
+	}
+	table, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	// Column definitions are informational only; tables are schemaless.
+	depth := 0
+	for !p.done() && p.peek() != ";" {
+		switch p.next() {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+	}
+	if depth != 0 {
+		return nil, errors.New("memdb: unbalanced parentheses in CREATE TABLE")
+	}
+	return &memoryStatement{kind: "CREATE", table: table}, nil
+}
+
+// parseCreateUniqueIndex reads "INDEX [IF NOT EXISTS] name ON table (a, b)".
+// Index names are not tracked; an index is identified by its columns.
+func (p *memoryParser) parseCreateUniqueIndex() (*memoryStatement, error) {
+	if err := p.expect("INDEX"); err != nil {
+		return nil, err
+	}
+	if p.accept("IF") {
+		if err := p.expect("NOT"); err != nil {
+			return nil, err
+		}
+		if err := p.expect("EXISTS"); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.identifier(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("ON"); err != nil {
+		return nil, err
+	}
+	table, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	statement := &memoryStatement{kind: "CREATE", table: table, columns: []string{}}
+	for {
+		column, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		statement.columns = append(statement.columns, column)
+		if !p.accept(",") {
+			break
+		}
+	}
+	return statement, p.expect(")")
+}
+
+func (p *memoryParser) parseInsert() (*memoryStatement, error) {
+	if err := p.expect("INTO"); err != nil {
+		return nil, err
+	}
+	table, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	statement := &memoryStatement{kind: "INSERT", table: table}
+
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for {
+		column, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		statement.columns = append(statement.columns, column)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	if err := p.expect("VALUES"); err != nil {
+		return nil, err
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for {
+		value, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		statement.values = append(statement.values, value)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if len(statement.values) != len(statement.columns) {
+		return nil, errors.New("memdb: INSERT column and value counts differ")
+	}
+
+	if p.accept("RETURNING") {
+		if statement.returning, err = p.identifier(); err != nil {
+			return nil, err
+		}
+	}
+	return statement, nil
+}
+
+func (p *memoryParser) parseSelect() (*memoryStatement, error) {
+	statement := &memoryStatement{kind: "SELECT"}
+	for {
+		item, err := p.parseSelectItem()
+		if err != nil {
+			return nil, err
+		}
+		statement.items = append(statement.items, item)
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	if err := p.expect("FROM"); err != nil {
+		return nil, err
+	}
+	table, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	statement.table = table
+
+	if err := p.parseWhere(statement); err != nil {
+		return nil, err
+	}
+
+	if p.accept("GROUP") {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			column, err := p.identifier()
+			if err != nil {
+				return nil, err
+			}
+			statement.groupBy = append(statement.groupBy, column)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+
+	if p.accept("ORDER") {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			column, err := p.identifier()
+			if err != nil {
+				return nil, err
+			}
+			order := memoryOrder{column: column}
+			if p.accept("DESC") {
+				order.descending = true
+			} else {
+				p.accept("ASC")
+			}
+			statement.orderBy = append(statement.orderBy, order)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+
+	if p.accept("LIMIT") {
+		limit, err := strconv.Atoi(p.next())
+		if err != nil {
+			return nil, fmt.Errorf("memdb: bad LIMIT: %v", err)
+		}
+		statement.limit = limit
+	}
+	return statement, nil
+}
+
+func (p *memoryParser) parseSelectItem() (memorySelectItem, error) {
+	if p.accept("*") {
+		return memorySelectItem{column: "*"}, nil
+	}
+
+	var item memorySelectItem
+	name, err := p.identifier()
+	if err != nil {
+		return item, err
+	}
+	if p.accept("(") {
+		item.function = strings.ToUpper(name)
+		switch item.function {
+		case "SUM", "COUNT", "MIN", "MAX":
+		default:
+			return item, fmt.Errorf("memdb: unsupported function %s", item.function)
+		}
+		if p.accept("*") {
+			item.column = "*"
+		} else if item.column, err = p.identifier(); err != nil {
+			return item, err
+		}
+		if err := p.expect(")"); err != nil {
+			return item, err
+		}
+	} else {
+		item.column = name
+	}
+
+	if p.accept("AS") {
+		if item.alias, err = p.identifier(); err != nil {
+			return item, err
+		}
+	}
+	return item, nil
+}
+
+func (p *memoryParser) parseWhere(statement *memoryStatement) error {
+	if !p.accept("WHERE") {
+		return nil
+	}
+	for {
+		column, err := p.identifier()
+		if err != nil {
+			return err
+		}
+		operator := p.next()
+		switch operator {
+		case "=", "!=", "<>", "<", "<=", ">", ">=":
+		default:
+			return fmt.Errorf("memdb: unsupported operator %q", operator)
+		}
+		operand, err := p.operand()
+		if err != nil {
+			return err
+		}
+		statement.where = append(statement.where, memoryCondition{column: column, operator: operator, operand: operand})
+		if !p.accept("AND") {
+			return nil
+		}
+	}
+}
+
+func (p *memoryParser) parseUpdate() (*memoryStatement, error) {
+	table, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	statement := &memoryStatement{kind: "UPDATE", table: table}
+	if err := p.expect("SET"); err != nil {
+		return nil, err
+	}
+	for {
+		column, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		operand, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		assignment := memoryAssignment{column: column, operand: operand}
+		if p.accept("+") {
+			addend, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			assignment.addend = &addend
+		}
+		statement.assignments = append(statement.assignments, assignment)
+		if !p.accept(",") {
+			break
+		}
+	}
+	return statement, p.parseWhere(statement)
+}
+
+func (p *memoryParser) parseDelete() (*memoryStatement, error) {
+	if err := p.expect("FROM"); err != nil {
+		return nil, err
+	}
+	table, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	statement := &memoryStatement{kind: "DELETE", table: table}
+	return statement, p.parseWhere(statement)
+}
+
+func isMemoryIdentifierStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func tokenizeMemoryQuery(query string) ([]string, error) {
+	var tokens []string
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case isMemoryIdentifierStart(r):
+			start := i
+			for i < len(runes) && (runes[i] == '_' || unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		case r == '$':
+			start := i
+			i++
+			for i < len(runes) && unicode.IsDigit(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		case r == '\'':
+			// String literals keep their opening quote as a type marker.
+			var literal strings.Builder
+			literal.WriteRune('\'')
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, errors.New("memdb: unterminated string literal")
+				}
+				if runes[i] == '\'' {
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						literal.WriteRune('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				literal.WriteRune(runes[i])
+				i++
+			}
+			tokens = append(tokens, literal.String())
+		case r == '<' || r == '>' || r == '!':
+			if i+1 < len(runes) && (runes[i+1] == '=' || (r == '<' && runes[i+1] == '>')) {
+				tokens = append(tokens, string(runes[i:i+2]))
+				i += 2
+			} else {
+				tokens = append(tokens, string(r))
+				i++
+			}
+		case strings.ContainsRune("(),*=?;+", r):
+			tokens = append(tokens, string(r))
+			i++
+		default:
+			return nil, fmt.Errorf("memdb: unexpected character %q", r)
+		}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestUpdateViolatingUniqueIndexChangesNoRows(t *testing.T) {
+	ctx := context.Background()
+	db, dialect := newTestDatabase(t)
+	for _, query := range []string{
+		"CREATE UNIQUE INDEX codes_code ON codes (code)",
+		"INSERT INTO codes (grp, code) VALUES ('x', 'a')",
+		"INSERT INTO codes (grp, code) VALUES ('x', 'b')",
+	} {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := db.ExecContext(ctx, "UPDATE codes SET code = ? WHERE grp = ?", "c", "x")
+	if !dialect.IsUniqueViolation(err) {
+		t.Fatalf("update = %v, want a unique violation", err)
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT code FROM codes ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var codes []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			t.Fatal(err)
+		}
+		codes = append(codes, code)
+	}
+	if fmt.Sprint(codes) != "[a b]" {
+		t.Errorf("codes after failed update = %v, want [a b]", codes)
+	}
+}
+
+type pgError struct{ code string }
+
+func (e *pgError) Error() string    { return "ERROR: duplicate key value (SQLSTATE " + e.code + ")" }
+func (e *pgError) SQLState() string { return e.code }
+
+func TestIsUniqueViolation(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		err     error
+		want    bool
+	}{
+		{"postgres unique", Postgres, fmt.Errorf("insert: %w", &pgError{"23505"}), true},
+		{"postgres foreign key", Postgres, &pgError{"23503"}, false},
+		{"postgres untyped", Postgres, errors.New("pq: 23505"), false},
+		{"mysql duplicate entry", MySQL, errors.New("Error 1062 (23000): Duplicate entry 'x' for key 'k'"), true},
+		{"mysql other", MySQL, errors.New("Error 1452 (23000): foreign key 1062"), false},
+		{"memdb", SQLite, fmt.Errorf("insert: %w", &memoryUniqueError{columns: []string{"code"}}), true},
+		{"nil", SQLite, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.dialect.IsUniqueViolation(tt.err); got != tt.want {
+				t.Errorf("IsUniqueViolation(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const defaultCurrency = "USD"
+
+var (
+	ErrCurrencyMismatch = errors.New("money: currency mismatch")
+	ErrNotFinite        = errors.New("money: value is not a finite number")
+)
+
+// RoundingMode decides what happens to fractions of a minor unit.
+type RoundingMode int
+
+const (
+	RoundHalfEven RoundingMode = iota // banker's rounding
+	RoundHalfUp                       // half away from zero
+	RoundHalfDown                     // half toward zero
+	RoundDown                         // truncate toward zero
+	RoundUp                           // away from zero
+	RoundFloor
+	RoundCeiling
+)
+
+// minorUnitDigits lists ISO 4217 currencies that do not use two decimals.
+var minorUnitDigits = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+	"BHD": 3,
+	"KWD": 3,
+}
+
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"JPY": "¥",
+}
+
+// Money is a fixed-point amount stored as an integer count of the
+// currency's minor unit (cents for USD), so sums never drift.
+type Money struct {
+	amount   int64
+	currency string
+}
+
+func NewMoney(minorUnits int64, currency string) Money {
+	return Money{amount: minorUnits, currency: strings.ToUpper(currency)}
+}
+
+// MoneyFromFloat converts a float at the edges of the system (database
+// columns, literals) using the float's shortest decimal representation.
+// NaN and infinities are rejected.
+func MoneyFromFloat(amount float64, currency string, mode RoundingMode) (Money, error) {
+	value, err := ratFromFloat(amount)
+	if err != nil {
+		return Money{}, err
+	}
+	return moneyFromRat(value, currency, mode), nil
+}
+
+func ratFromFloat(value float64) (*big.Rat, error) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("%w: %v", ErrNotFinite, value)
+	}
+	rat, _ := new(big.Rat).SetString(strconv.FormatFloat(value, 'f', -1, 64))
+	return rat, nil
+}
+
+// ParseMoney reads a decimal string such as "12.34" and rejects values
+// more precise than the currency's minor unit.
+func ParseMoney(amount, currency string) (Money, error) {
+	value, ok := new(big.Rat).SetString(strings.TrimSpace(amount))
+	if !ok {
+		return Money{}, fmt.Errorf("invalid amount: %q", amount)
+	}
+	scaled := new(big.Rat).Mul(value, new(big.Rat).SetInt(minorUnitScale(currency)))
+	if !scaled.IsInt() {
+		return Money{}, fmt.Errorf("amount %q is more precise than %s allows", amount, strings.ToUpper(currency))
+	}
+	return moneyFromRat(value, currency, RoundHalfEven), nil
+}
+
+func moneyFromRat(value *big.Rat, currency string, mode RoundingMode) Money {
+	scaled := new(big.Rat).Mul(value, new(big.Rat).SetInt(minorUnitScale(currency)))
+	return NewMoney(roundRat(scaled, mode), currency)
+}
+
+func minorUnitDigitsFor(currency string) int {
+	if digits, ok := minorUnitDigits[strings.ToUpper(currency)]; ok {
+		return digits
+	}
+	return 2
+}
+
+func minorUnitScale(currency string) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(minorUnitDigitsFor(currency))), nil)
+}
+
+func roundRat(value *big.Rat, mode RoundingMode) int64 {
+	quotient, remainder := new(big.Int).QuoRem(value.Num(), value.Denom(), new(big.Int))
+	if remainder.Sign() == 0 {
+		return quotient.Int64()
+	}
+
+	negative := value.Sign() < 0
+	twiceRemainder := new(big.Int).Lsh(new(big.Int).Abs(remainder), 1)
+	half := twiceRemainder.Cmp(value.Denom())
+
+	var awayFromZero bool
+	switch mode {
+	case RoundHalfEven:
+		awayFromZero = half > 0 || (half == 0 && quotient.Bit(0) == 1)
+	case RoundHalfUp:
+		awayFromZero = half >= 0
+	case RoundHalfDown:
+		awayFromZero = half > 0
+	case RoundDown:
+		awayFromZero = false
+	case RoundUp:
+		awayFromZero = true
+	case RoundFloor:
+		awayFromZero = negative
+	case RoundCeiling:
+		awayFromZero = !negative
+	}
+
+	if awayFromZero {
+		if negative {
+			quotient.Sub(quotient, big.NewInt(1))
+		} else {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+	}
+	return quotient.Int64()
+}
+
+func (m Money) MinorUnits() int64 {
+	return m.amount
+}
+
+func (m Money) Currency() string {
+	if m.currency == "" {
+		return defaultCurrency
+	}
+	return m.currency
+}
+
+func (m Money) IsZero() bool {
+	return m.amount == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.amount < 0
+}
+
+func (m Money) SameCurrency(other Money) bool {
+	return m.Currency() == other.Currency()
+}
+
+// Arithmetic across currencies fails with ErrCurrencyMismatch; convert
+// first.
+func (m Money) checkCurrency(other Money) error {
+	if !m.SameCurrency(other) {
+		return fmt.Errorf("%w: %s vs %s", ErrCurrencyMismatch, m.Currency(), other.Currency())
+	}
+	return nil
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if err := m.checkCurrency(other); err != nil {
+		return Money{}, err
+	}
+	return NewMoney(m.amount+other.amount, m.Currency()), nil
+}
+
+func (m Money) Subtract(other Money) (Money, error) {
+	if err := m.checkCurrency(other); err != nil {
+		return Money{}, err
+	}
+	return NewMoney(m.amount-other.amount, m.Currency()), nil
+}
+
+func (m Money) Negate() Money {
+	return NewMoney(-m.amount, m.Currency())
+}
+
+// Compare returns -1, 0 or 1 like strings.Compare.
+func (m Money) Compare(other Money) (int, error) {
+	if err := m.checkCurrency(other); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.amount < other.amount:
+		return -1, nil
+	case m.amount > other.amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Multiply scales the amount by a rate such as 0.0825, rounding the
+// result back to whole minor units with the given mode.
+func (m Money) Multiply(factor float64, mode RoundingMode) (Money, error) {
+	rate, err := ratFromFloat(factor)
+	if err != nil {
+		return Money{}, err
+	}
+	product := new(big.Rat).Mul(new(big.Rat).SetInt64(m.amount), rate)
+	return NewMoney(roundRat(product, mode), m.Currency()), nil
+}
+
+// Convert expresses the amount in another currency, where rate is how many
+// units of currency one unit of m buys.
+func (m Money) Convert(rate float64, currency string, mode RoundingMode) (Money, error) {
+	factor, err := ratFromFloat(rate)
+	if err != nil {
+		return Money{}, err
+	}
+	value := new(big.Rat).SetFrac(big.NewInt(m.amount), minorUnitScale(m.Currency()))
+	return moneyFromRat(value.Mul(value, factor), currency, mode), nil
+}
+
+// Float64 is for the database boundary only; do not do arithmetic on it.
+func (m Money) Float64() float64 {
+	value, _ := new(big.Rat).SetFrac(big.NewInt(m.amount), minorUnitScale(m.Currency())).Float64()
+	return value
+}
+
+// Decimal renders the amount without symbol or grouping, e.g. "-1234.50".
+func (m Money) Decimal() string {
+	return new(big.Rat).SetFrac(big.NewInt(m.amount), minorUnitScale(m.Currency())).
+		FloatString(minorUnitDigitsFor(m.Currency()))
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency()
+}
+
+type moneyJSON struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// Amounts are encoded as decimal strings so no precision is lost in
+// consumers that parse JSON numbers as floats.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.Decimal(), Currency: m.Currency()})
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var encoded moneyJSON
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	parsed, err := ParseMoney(encoded.Amount, encoded.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Format renders the amount for people, e.g. "$1,234.50" or "-€12.00".
+func (m Money) Format() string {
+	digits := strings.TrimPrefix(m.Decimal(), "-")
+	whole, fraction, _ := strings.Cut(digits, ".")
+
+	var grouped strings.Builder
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(r)
+	}
+	if fraction != "" {
+		grouped.WriteString("." + fraction)
+	}
+
+	symbol, ok := currencySymbols[m.Currency()]
+	if !ok {
+		symbol = m.Currency() + " "
+	}
+	sign := ""
+	if m.IsNegative() {
+		sign = "-"
+	}
+	return sign + symbol + grouped.String()
+}
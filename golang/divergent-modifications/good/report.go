@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+type TransactionSummary struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+	Total Money  `json:"total"`
+}
+
+type MonthlyReport struct {
+	UserId       int                  `json:"user_id"`
+	Month        int                  `json:"month"`
+	Year         int                  `json:"year"`
+	Transactions []TransactionSummary `json:"transactions"`
+}
+
+type TaxReport struct {
+	UserId           int             `json:"user_id"`
+	Year             int             `json:"year"`
+	TotalIncome      Money           `json:"total_income"`
+	TotalDeductions  Money           `json:"total_deductions"`
+	DeductionApplied Money           `json:"deduction_applied"`
+	DeductionKind    string          `json:"deduction_kind,omitempty"`
+	TaxableIncome    Money           `json:"taxable_income"`
+	Brackets         []BracketTax    `json:"brackets,omitempty"`
+	Credits          []AppliedCredit `json:"credits,omitempty"`
+	TaxOwed          Money           `json:"tax_owed"`
+	FxGains          []FxGainLine    `json:"fx_gains,omitempty"`
+}
+
+// ReportTable is the format-neutral shape every renderer works from.
+type ReportTable struct {
+	Title   string
+	Headers []string
+	Rows    [][]string
+}
+
+type TabularReport interface {
+	Table() ReportTable
+}
+
+func (r MonthlyReport) Title() string {
+	return fmt.Sprintf("Transactions for %s %d", time.Month(r.Month), r.Year)
+}
+
+func (r MonthlyReport) Table() ReportTable {
+	table := ReportTable{
+		Title:   r.Title(),
+		Headers: []string{"Type", "Count", "Total"},
+	}
+	for _, line := range r.Transactions {
+		table.Rows = append(table.Rows, []string{line.Type, strconv.Itoa(line.Count), line.Total.Format()})
+	}
+	return table
+}
+
+func (r TaxReport) Table() ReportTable {
+	table := ReportTable{
+		Title:   fmt.Sprintf("Tax report %d", r.Year),
+		Headers: []string{"Item", "Rate", "Amount"},
+	}
+	add := func(item, rate string, amount Money) {
+		table.Rows = append(table.Rows, []string{item, rate, amount.Format()})
+	}
+
+	add("Total income", "", r.TotalIncome)
+	add("Total deductions", "", r.TotalDeductions)
+	if r.DeductionKind != "" {
+		add("Deduction applied ("+r.DeductionKind+")", "", r.DeductionApplied)
+	}
+	add("Taxable income", "", r.TaxableIncome)
+	for _, bracket := range r.Brackets {
+		label := "Over " + bracket.Lower.Format()
+		if bracket.Upper != nil {
+			label = bracket.Lower.Format() + " - " + bracket.Upper.Format()
+		}
+		add(label, strconv.FormatFloat(bracket.Rate*100, 'f', -1, 64)+"%", bracket.Tax)
+	}
+	for _, credit := range r.Credits {
+		add("Credit: "+credit.Name, "", credit.Amount.Negate())
+	}
+	add("Tax owed", "", r.TaxOwed)
+	for _, fx := range r.FxGains {
+		add("Realized FX gain ("+fx.Currency+")", "", fx.Realized)
+		add("Unrealized FX gain ("+fx.Currency+", "+fx.Holding.Format()+" held)", "", fx.Unrealized)
+	}
+	return table
+}
@@ -1,30 +1,84 @@
 package main
 
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
 type reportGenerator struct {
 	transactionRepository TransactionRepository
-	calculator           FinancialCalculator
+	calculator            FinancialCalculator
+	rates                 ExchangeRateProvider
+	now                   func() time.Time
 }
 
-func NewReportGenerator(transactionRepo TransactionRepository, calculator FinancialCalculator) ReportGenerator {
+func NewReportGenerator(transactionRepo TransactionRepository, calculator FinancialCalculator, rates ExchangeRateProvider) ReportGenerator {
 	return &reportGenerator{
 		transactionRepository: transactionRepo,
-		calculator:           calculator,
+		calculator:            calculator,
+		rates:                 rates,
+		now:                   time.Now,
 	}
 }
 
-func (rg reportGenerator) GenerateMonthlyReport(userId, month, year int) []map[string]interface{} {
-	return rg.transactionRepository.GetMonthlyTransactions(userId, month, year)
+func (rg reportGenerator) GenerateMonthlyReport(ctx context.Context, userId, month, year int) (MonthlyReport, error) {
+	transactions, err := rg.transactionRepository.GetMonthlyTransactions(ctx, userId, month, year)
+	if err != nil {
+		return MonthlyReport{}, fmt.Errorf("monthly report for user %d: %w", userId, err)
+	}
+	return MonthlyReport{
+		UserId:       userId,
+		Month:        month,
+		Year:         year,
+		Transactions: transactions,
+	}, nil
 }
 
-func (rg reportGenerator) GenerateTaxReport(userId, year int) map[string]interface{} {
-	income := rg.transactionRepository.GetYearlyIncome(userId, year)
-	deductions := rg.transactionRepository.GetYearlyDeductions(userId, year)
-	taxOwed := rg.calculator.CalculateTax(income, deductions)
+func (rg reportGenerator) GenerateTaxReport(ctx context.Context, userId, year int) (TaxReport, error) {
+	income, err := rg.transactionRepository.GetYearlyIncome(ctx, userId, year)
+	if err != nil {
+		return TaxReport{}, fmt.Errorf("tax report for user %d: %w", userId, err)
+	}
+	deductions, err := rg.transactionRepository.GetYearlyDeductions(ctx, userId, year)
+	if err != nil {
+		return TaxReport{}, fmt.Errorf("tax report for user %d: %w", userId, err)
+	}
+	fxGains, err := rg.fxGains(ctx, userId, year, income.Currency())
+	if err != nil {
+		return TaxReport{}, fmt.Errorf("tax report for user %d: %w", userId, err)
+	}
+	computation, err := rg.calculator.CalculateTaxBreakdown(income, deductions, year)
+	if err != nil {
+		return TaxReport{}, fmt.Errorf("tax report for user %d: %w", userId, err)
+	}
+	return TaxReport{
+		UserId:           userId,
+		Year:             year,
+		TotalIncome:      income,
+		TotalDeductions:  deductions,
+		DeductionApplied: computation.Deduction,
+		DeductionKind:    computation.DeductionKind,
+		TaxableIncome:    computation.TaxableIncome,
+		Brackets:         computation.Brackets,
+		Credits:          computation.Credits,
+		TaxOwed:          computation.TaxOwed,
+		FxGains:          fxGains,
+	}, nil
+}
 
-	return map[string]interface{}{
-		"year":             year,
-		"total_income":     income,
-		"total_deductions": deductions,
-		"tax_owed":         taxOwed,
+// fxGains lists exchange gains on foreign holdings for the year. They are
+// reported alongside the tax computation rather than added to taxable
+// income, since their treatment differs between jurisdictions.
+func (rg reportGenerator) fxGains(ctx context.Context, userId, year int, baseCurrency string) ([]FxGainLine, error) {
+	from := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	asOf := from.AddDate(1, 0, 0)
+	if now := rg.now(); now.Before(asOf) {
+		asOf = now
+	}
+	movements, err := rg.transactionRepository.GetCurrencyMovements(ctx, userId, asOf)
+	if err != nil {
+		return nil, err
 	}
+	return CalculateFxGains(ctx, rg.rates, baseCurrency, movements, from, asOf)
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGenerateTaxReportFailsWithoutTaxTable(t *testing.T) {
+	db, dialect := newTestDatabase(t)
+	repo := NewTransactionRepository(db, dialect, nil)
+	if _, err := repo.SaveTransaction(context.Background(), 1, NewMoney(500000, defaultCurrency), "income"); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := NewTaxEngine()
+	if err := engine.LoadFile("tax_tables.json"); err != nil {
+		t.Fatal(err)
+	}
+	calculator, err := NewFinancialCalculatorWithTaxEngine(engine, "US-FED", FilingSingle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generator := NewReportGenerator(repo, calculator, nil)
+
+	// There is no US-FED table for 1999, so there is no tax figure to
+	// report rather than one from some other year.
+	report, err := generator.GenerateTaxReport(context.Background(), 1, 1999)
+	if err == nil {
+		t.Fatalf("GenerateTaxReport for a year without a table = %+v, want an error", report)
+	}
+
+	if _, err := generator.GenerateTaxReport(context.Background(), 1, 2024); err != nil {
+		t.Fatalf("GenerateTaxReport for 2024: %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+type ReportFormat string
+
+const (
+	FormatCSV  ReportFormat = "csv"
+	FormatJSON ReportFormat = "json"
+	FormatHTML ReportFormat = "html"
+	FormatText ReportFormat = "text"
+)
+
+type ReportRenderer interface {
+	Render(w io.Writer, report TabularReport) error
+	ContentType() string
+}
+
+func NewReportRenderer(format ReportFormat) (ReportRenderer, error) {
+	switch ReportFormat(strings.ToLower(string(format))) {
+	case FormatCSV:
+		return csvRenderer{}, nil
+	case FormatJSON:
+		return jsonRenderer{}, nil
+	case FormatHTML:
+		return htmlRenderer{}, nil
+	case FormatText, "txt":
+		return textRenderer{}, nil
+	}
+	return nil, fmt.Errorf("unsupported report format: %s", format)
+}
+
+type csvRenderer struct{}
+
+func (csvRenderer) ContentType() string { return "text/csv; charset=utf-8" }
+
+func (csvRenderer) Render(w io.Writer, report TabularReport) error {
+	table := report.Table()
+	writer := csv.NewWriter(w)
+	if err := writer.Write(table.Headers); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(table.Rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// jsonRenderer emits the typed report itself rather than its table, so
+// amounts keep their currency and consumers get real numbers.
+type jsonRenderer struct{}
+
+func (jsonRenderer) ContentType() string { return "application/json" }
+
+func (jsonRenderer) Render(w io.Writer, report TabularReport) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+var reportHTMLTemplate = template.Must(template.New("report").Parse(`<table>
+<caption>{{.Title}}</caption>
+<thead><tr>{{range .Headers}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>
+{{- range .Rows}}
+<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{- end}}
+</tbody>
+</table>
+`))
+
+type htmlRenderer struct{}
+
+func (htmlRenderer) ContentType() string { return "text/html; charset=utf-8" }
+
+func (htmlRenderer) Render(w io.Writer, report TabularReport) error {
+	return reportHTMLTemplate.Execute(w, report.Table())
+}
+
+type textRenderer struct{}
+
+func (textRenderer) ContentType() string { return "text/plain; charset=utf-8" }
+
+func (textRenderer) Render(w io.Writer, report TabularReport) error {
+	table := report.Table()
+	if table.Title != "" {
+		if _, err := fmt.Fprintf(w, "%s\n\n", table.Title); err != nil {
+			return err
+		}
+	}
+
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join(table.Headers, "\t"))
+	separators := make([]string, len(table.Headers))
+	for i, header := range table.Headers {
+		separators[i] = strings.Repeat("-", len(header))
+	}
+	fmt.Fprintln(writer, strings.Join(separators, "\t"))
+	for _, row := range table.Rows {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	return writer.Flush()
+}
+
+func RenderReport(w io.Writer, report TabularReport, format ReportFormat) error {
+	renderer, err := NewReportRenderer(format)
+	if err != nil {
+		return err
+	}
+	return renderer.Render(w, report)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func goldenReports() map[string]TabularReport {
+	upper := NewMoney(1160000, "USD")
+	return map[string]TabularReport{
+		"monthly": MonthlyReport{
+			UserId: 1,
+			Month:  3,
+			Year:   2024,
+			Transactions: []TransactionSummary{
+				{Type: "income", Count: 2, Total: NewMoney(1250000, "USD")},
+				// Exercises CSV quoting and HTML escaping.
+				{Type: `refund, "partial" <b>&</b>`, Count: 1, Total: NewMoney(-4599, "USD")},
+				{Type: "expense", Count: 12, Total: NewMoney(-123456, "USD")},
+			},
+		},
+		"tax": TaxReport{
+			UserId:           1,
+			Year:             2024,
+			TotalIncome:      NewMoney(2000000, "USD"),
+			TotalDeductions:  NewMoney(0, "USD"),
+			DeductionApplied: NewMoney(1460000, "USD"),
+			DeductionKind:    "standard",
+			TaxableIncome:    NewMoney(540000, "USD"),
+			Brackets: []BracketTax{
+				{Lower: NewMoney(0, "USD"), Upper: &upper, Rate: 0.1, Taxable: NewMoney(540000, "USD"), Tax: NewMoney(54000, "USD")},
+			},
+			Credits: []AppliedCredit{{Name: "child_tax_credit", Amount: NewMoney(54000, "USD")}},
+			TaxOwed: NewMoney(0, "USD"),
+			FxGains: []FxGainLine{{
+				Currency:    "EUR",
+				Holding:     NewMoney(50000, "EUR"),
+				CostBasis:   NewMoney(54000, "USD"),
+				MarketValue: NewMoney(55000, "USD"),
+				Realized:    NewMoney(1250, "USD"),
+				Unrealized:  NewMoney(1000, "USD"),
+			}},
+		},
+	}
+}
+
+func TestRenderersMatchGoldenFiles(t *testing.T) {
+	extensions := map[ReportFormat]string{FormatCSV: ".csv", FormatJSON: ".json", FormatHTML: ".html", FormatText: ".txt"}
+	for name, report := range goldenReports() {
+		for format, extension := range extensions {
+			t.Run(name+extension, func(t *testing.T) {
+				var got bytes.Buffer
+				if err := RenderReport(&got, report, format); err != nil {
+					t.Fatal(err)
+				}
+				path := filepath.Join("testdata", name+extension)
+				if *updateGolden {
+					if err := os.WriteFile(path, got.Bytes(), 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				want, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got.Bytes(), want) {
+					t.Errorf("%s output differs from %s:\n%s\nwant:\n%s", format, path, got.Bytes(), want)
+				}
+			})
+		}
+	}
+}
+
+func TestUnknownReportFormat(t *testing.T) {
+	if _, err := NewReportRenderer("pdf"); err == nil {
+		t.Error("NewReportRenderer(pdf) succeeded")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel kinds for repository failures. Callers match them with
+// errors.Is to tell an empty result from a read that never happened.
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrConnection = errors.New("database unavailable")
+	ErrScan       = errors.New("cannot read row")
+)
+
+// RepositoryError records which query failed and why. It matches both its
+// Kind and the underlying driver error, so errors.Is(err, ErrConnection)
+// and errors.Is(err, context.Canceled) can both hold.
+type RepositoryError struct {
+	Op   string
+	Kind error
+	Err  error
+}
+
+func (e *RepositoryError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s: %v", e.Op, e.Kind)
+	}
+	return fmt.Sprintf("%s: %v: %v", e.Op, e.Kind, e.Err)
+}
+
+func (e *RepositoryError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+func repositoryError(op string, kind, err error) error {
+	return &RepositoryError{Op: op, Kind: kind, Err: err}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dialect hides the differences between SQL servers from the repositories.
+// Queries are written once with "?" placeholders and rebound per dialect.
+type Dialect interface {
+	Name() string
+	Placeholder(position int) string
+	SupportsLastInsertId() bool
+	IsUniqueViolation(err error) bool
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string                    { return "mysql" }
+func (mysqlDialect) Placeholder(position int) string { return "?" }
+func (mysqlDialect) SupportsLastInsertId() bool      { return true }
+
+// The MySQL driver's error type exposes the code only as a struct field,
+// so this matches the "Error 1062" (ER_DUP_ENTRY) prefix it formats.
+func (mysqlDialect) IsUniqueViolation(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "Error 1062")
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string { return "postgres" }
+func (postgresDialect) Placeholder(position int) string {
+	return "$" + strconv.Itoa(position)
+}
+func (postgresDialect) SupportsLastInsertId() bool { return false }
+
+// sqlStateError is implemented by the Postgres drivers' error types
+// (pgconn.PgError, pq.Error).
+type sqlStateError interface {
+	SQLState() string
+}
+
+// SQLSTATE 23505 is unique_violation.
+func (postgresDialect) IsUniqueViolation(err error) bool {
+	var state sqlStateError
+	return errors.As(err, &state) && state.SQLState() == "23505"
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string                    { return "sqlite" }
+func (sqliteDialect) Placeholder(position int) string { return "?" }
+func (sqliteDialect) SupportsLastInsertId() bool      { return true }
+
+// sqliteCodeError is implemented by modernc.org/sqlite's error type.
+type sqliteCodeError interface {
+	Code() int
+}
+
+// SQLITE_CONSTRAINT_UNIQUE and SQLITE_CONSTRAINT_PRIMARYKEY.
+const (
+	sqliteConstraintUnique     = 2067
+	sqliteConstraintPrimaryKey = 1555
+)
+
+// The memory driver and modernc.org/sqlite are matched by type; cgo
+// SQLite only carries the code in a field, so its message is the fallback.
+func (sqliteDialect) IsUniqueViolation(err error) bool {
+	var unique *memoryUniqueError
+	if errors.As(err, &unique) {
+		return true
+	}
+	var coded sqliteCodeError
+	if errors.As(err, &coded) {
+		return coded.Code() == sqliteConstraintUnique || coded.Code() == sqliteConstraintPrimaryKey
+	}
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+var (
+	MySQL    Dialect = mysqlDialect{}
+	Postgres Dialect = postgresDialect{}
+	SQLite   Dialect = sqliteDialect{}
+)
+
+func DialectFor(name string) (Dialect, error) {
+	switch strings.ToLower(name) {
+	case "mysql":
+		return MySQL, nil
+	case "postgres", "postgresql", "pgx":
+		return Postgres, nil
+	case "sqlite", "sqlite3", memoryDriverName:
+		return SQLite, nil
+	}
+	return nil, fmt.Errorf("unsupported sql dialect: %s", name)
+}
+
+// rebind rewrites "?" placeholders into the dialect's own syntax,
+// leaving question marks inside string literals untouched.
+func rebind(dialect Dialect, query string) string {
+	var builder strings.Builder
+	position := 0
+	inLiteral := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inLiteral = !inLiteral
+			builder.WriteRune(r)
+		case r == '?' && !inLiteral:
+			position++
+			builder.WriteString(dialect.Placeholder(position))
+		default:
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
+}
+
+// insertReturningId runs an INSERT and returns the generated id, using
+// RETURNING on servers whose drivers do not implement LastInsertId.
+func insertReturningId(ctx context.Context, db Database, dialect Dialect, query string, args ...interface{}) (int64, error) {
+	if dialect.SupportsLastInsertId() {
+		result, err := db.ExecContext(ctx, rebind(dialect, query), args...)
+		if err != nil {
+			return 0, err
+		}
+		return result.LastInsertId()
+	}
+
+	rows, err := db.QueryContext(ctx, rebind(dialect, query+" RETURNING id"), args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var id int64
+	if rows.Next() {
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+	}
+	return id, rows.Err()
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	StatementSent   = "sent"
+	StatementFailed = "failed"
+)
+
+// StatementPeriod is the calendar month a statement covers.
+type StatementPeriod struct {
+	Month int
+	Year  int
+}
+
+// PreviousPeriod is the last month that has fully ended at the given time,
+// which is what a month-end run reports on.
+func PreviousPeriod(at time.Time) StatementPeriod {
+	previous := time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, at.Location()).AddDate(0, -1, 0)
+	return StatementPeriod{Month: int(previous.Month()), Year: previous.Year()}
+}
+
+// End is the last instant of the period, in UTC like the ledger's dates.
+func (p StatementPeriod) End() time.Time {
+	return time.Date(p.Year, time.Month(p.Month)+1, 1, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond)
+}
+
+func (p StatementPeriod) String() string {
+	return fmt.Sprintf("%04d-%02d", p.Year, p.Month)
+}
+
+// StatementRunResult is one user's outcome for one period.
+type StatementRunResult struct {
+	UserId     int
+	Status     string
+	Error      string
+	Attempts   int
+	FinishedAt time.Time
+}
+
+// StatementRunLog remembers per-user outcomes so a rerun of the same period
+// only retries users that have not been sent a statement yet.
+type StatementRunLog interface {
+	Results(ctx context.Context, period StatementPeriod) (map[int]StatementRunResult, error)
+	Record(ctx context.Context, period StatementPeriod, result StatementRunResult) error
+}
+
+type StatementSender interface {
+	SendStatementForPeriod(ctx context.Context, userId, month, year int) error
+}
+
+// StatementRunSummary describes what a single batch run did.
+type StatementRunSummary struct {
+	Period   StatementPeriod
+	Sent     int
+	Skipped  int
+	Failures map[int]error
+}
+
+type StatementBatch struct {
+	sender  StatementSender
+	users   UserRepository
+	runLog  StatementRunLog
+	workers int
+	now     func() time.Time
+}
+
+// NewStatementBatch sends statements through sender using at most workers
+// concurrent sends. A nil clock means time.Now.
+func NewStatementBatch(sender StatementSender, users UserRepository, runLog StatementRunLog, workers int, clock func() time.Time) *StatementBatch {
+	if workers < 1 {
+		workers = 1
+	}
+	if clock == nil {
+		clock = time.Now
+	}
+	return &StatementBatch{sender: sender, users: users, runLog: runLog, workers: workers, now: clock}
+}
+
+// RunMonthEnd sends statements for the month that ended before the clock's
+// current time.
+func (sb *StatementBatch) RunMonthEnd(ctx context.Context) (StatementRunSummary, error) {
+	return sb.Run(ctx, PreviousPeriod(sb.now()))
+}
+
+// Run sends the period's statement to every user not already marked sent.
+// Per-user failures are recorded and reported in the summary; the returned
+// error covers only problems with the batch itself. Cancelling ctx stops
+// workers from starting new sends; sends already finished are still
+// recorded, and users cut off by the cancellation are left for the next
+// run.
+func (sb *StatementBatch) Run(ctx context.Context, period StatementPeriod) (StatementRunSummary, error) {
+	summary := StatementRunSummary{Period: period, Failures: map[int]error{}}
+
+	userIds, err := sb.users.ListUserIds(ctx)
+	if err != nil {
+		return summary, fmt.Errorf("statement run %s: %w", period, err)
+	}
+	previous, err := sb.runLog.Results(ctx, period)
+	if err != nil {
+		return summary, fmt.Errorf("statement run %s: %w", period, err)
+	}
+
+	var pending []StatementRunResult
+	for _, userId := range userIds {
+		result := previous[userId]
+		if result.Status == StatementSent {
+			summary.Skipped++
+			continue
+		}
+		result.UserId = userId
+		pending = append(pending, result)
+	}
+
+	jobs := make(chan StatementRunResult)
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		recordErr []error
+	)
+	for i := 0; i < sb.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for result := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				sendErr := sb.sender.SendStatementForPeriod(ctx, result.UserId, period.Month, period.Year)
+				if sendErr != nil && ctx.Err() != nil {
+					// Cut off by the cancellation, not the user's fault.
+					continue
+				}
+				result.Attempts++
+				result.FinishedAt = sb.now()
+				result.Status, result.Error = StatementSent, ""
+				if sendErr != nil {
+					result.Status, result.Error = StatementFailed, sendErr.Error()
+				}
+				// A statement that went out must be logged even if the run
+				// was cancelled meanwhile, or the next run would resend it.
+				logErr := sb.runLog.Record(context.WithoutCancel(ctx), period, result)
+
+				mu.Lock()
+				if sendErr != nil {
+					summary.Failures[result.UserId] = sendErr
+				} else {
+					summary.Sent++
+				}
+				if logErr != nil {
+					recordErr = append(recordErr, fmt.Errorf("record user %d: %w", result.UserId, logErr))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, result := range pending {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case jobs <- result:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		recordErr = append(recordErr, err)
+	}
+	if len(recordErr) > 0 {
+		return summary, fmt.Errorf("statement run %s: %w", period, errors.Join(recordErr...))
+	}
+	return summary, nil
+}
+
+type statementRunLog struct {
+	db      Database
+	dialect Dialect
+}
+
+// NewStatementRunLog keeps the run log in the statement_runs table, one row
+// per user and period.
+func NewStatementRunLog(db Database, dialect Dialect) StatementRunLog {
+	return &statementRunLog{db: db, dialect: dialect}
+}
+
+func (rl statementRunLog) Results(ctx context.Context, period StatementPeriod) (map[int]StatementRunResult, error) {
+	const op = "read statement run log"
+	rows, err := rl.db.QueryContext(ctx, rebind(rl.dialect, `
+		SELECT user_id, status, error, attempts, finished_at
+		FROM statement_runs
+		WHERE month = ? AND year = ?
+	`), period.Month, period.Year)
+	if err != nil {
+		return nil, repositoryError(op, ErrConnection, err)
+	}
+	defer rows.Close()
+
+	results := map[int]StatementRunResult{}
+	for rows.Next() {
+		var result StatementRunResult
+		if err := rows.Scan(&result.UserId, &result.Status, &result.Error, &result.Attempts, &result.FinishedAt); err != nil {
+			return nil, repositoryError(op, ErrScan, err)
+		}
+		results[result.UserId] = result
+	}
+	if err := rows.Err(); err != nil {
+		return nil, repositoryError(op, ErrConnection, err)
+	}
+	return results, nil
+}
+
+func (rl statementRunLog) Record(ctx context.Context, period StatementPeriod, result StatementRunResult) error {
+	const op = "record statement run"
+	updated, err := rl.db.ExecContext(ctx, rebind(rl.dialect, `
+		UPDATE statement_runs SET status = ?, error = ?, attempts = ?, finished_at = ?
+		WHERE user_id = ? AND month = ? AND year = ?
+	`), result.Status, result.Error, result.Attempts, result.FinishedAt.UTC(), result.UserId, period.Month, period.Year)
+	if err != nil {
+		return repositoryError(op, ErrConnection, err)
+	}
+	if affected, err := updated.RowsAffected(); err == nil && affected > 0 {
+		return nil
+	}
+
+	_, err = rl.db.ExecContext(ctx, rebind(rl.dialect, `
+		INSERT INTO statement_runs (user_id, month, year, status, error, attempts, finished_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`), result.UserId, period.Month, period.Year, result.Status, result.Error, result.Attempts, result.FinishedAt.UTC())
+	if err != nil {
+		return repositoryError(op, ErrConnection, err)
+	}
+	return nil
+}
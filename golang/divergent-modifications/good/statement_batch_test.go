@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fixedUsers []int
+
+func (fixedUsers) UpdateUserProfile(ctx context.Context, userId int, name, email string) error {
+	return nil
+}
+
+func (fixedUsers) GetUserEmail(ctx context.Context, userId int) (string, error) {
+	return "", nil
+}
+
+func (u fixedUsers) ListUserIds(ctx context.Context) ([]int, error) {
+	return u, nil
+}
+
+// cancellingSender records who it sent to and cancels the run once it has
+// sent cancelAfter statements.
+type cancellingSender struct {
+	mu          sync.Mutex
+	cancel      context.CancelFunc
+	cancelAfter int
+	sent        map[int]int
+}
+
+func (s *cancellingSender) SendStatementForPeriod(ctx context.Context, userId, month, year int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent[userId]++
+	if s.cancel != nil && len(s.sent) == s.cancelAfter {
+		s.cancel()
+	}
+	return nil
+}
+
+func TestStatementBatchStopsWhenCancelled(t *testing.T) {
+	db, dialect := newTestDatabase(t)
+	runLog := NewStatementRunLog(db, dialect)
+	users := fixedUsers{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+	period := StatementPeriod{Month: 1, Year: 2024}
+	clock := func() time.Time { return time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC) }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sender := &cancellingSender{cancel: cancel, cancelAfter: 5, sent: map[int]int{}}
+	batch := NewStatementBatch(sender, users, runLog, 3, clock)
+
+	summary, err := batch.Run(ctx, period)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run = %v, want context.Canceled", err)
+	}
+	// Workers may be mid-send when the cancel lands, but none picks up a
+	// new user afterwards.
+	if len(sender.sent) > sender.cancelAfter+2 {
+		t.Errorf("sent %d statements after cancelling at %d", len(sender.sent), sender.cancelAfter)
+	}
+	if summary.Sent != len(sender.sent) || len(summary.Failures) != 0 {
+		t.Errorf("summary = %+v, sender saw %d", summary, len(sender.sent))
+	}
+
+	// Every statement that went out is in the run log, even though the
+	// context was already cancelled when it was recorded.
+	results, err := runLog.Results(context.Background(), period)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(sender.sent) {
+		t.Errorf("run log has %d results, sender sent %d", len(results), len(sender.sent))
+	}
+	for userId := range sender.sent {
+		if results[userId].Status != StatementSent {
+			t.Errorf("user %d: run log status %q, want sent", userId, results[userId].Status)
+		}
+	}
+
+	// A rerun finishes the batch without sending anyone a second statement.
+	sender.cancel = nil
+	summary, err = batch.Run(context.Background(), period)
+	if err != nil {
+		t.Fatalf("rerun: %v", err)
+	}
+	if summary.Skipped+summary.Sent != len(users) {
+		t.Errorf("rerun summary = %+v", summary)
+	}
+	for _, userId := range users {
+		if sender.sent[userId] != 1 {
+			t.Errorf("user %d received %d statements", userId, sender.sent[userId])
+		}
+	}
+}
+
+func TestPreviousPeriod(t *testing.T) {
+	tests := []struct {
+		at   time.Time
+		want StatementPeriod
+	}{
+		{time.Date(2024, time.March, 15, 12, 0, 0, 0, time.UTC), StatementPeriod{Month: 2, Year: 2024}},
+		{time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC), StatementPeriod{Month: 12, Year: 2023}},
+		{time.Date(2024, time.January, 31, 23, 59, 59, 0, time.UTC), StatementPeriod{Month: 12, Year: 2023}},
+		{time.Date(2024, time.December, 31, 23, 59, 59, 0, time.UTC), StatementPeriod{Month: 11, Year: 2024}},
+		// Just after midnight on the 1st in the clock's own zone, even
+		// though it is still the previous month in UTC.
+		{time.Date(2024, time.March, 1, 0, 30, 0, 0, time.FixedZone("CET", 3600)), StatementPeriod{Month: 2, Year: 2024}},
+	}
+	for _, tt := range tests {
+		if got := PreviousPeriod(tt.at); got != tt.want {
+			t.Errorf("PreviousPeriod(%s) = %s, want %s", tt.at.Format(time.RFC3339), got, tt.want)
+		}
+	}
+}
+
+func TestStatementPeriodEnd(t *testing.T) {
+	tests := []struct {
+		period StatementPeriod
+		want   time.Time
+	}{
+		{StatementPeriod{Month: 2, Year: 2024}, time.Date(2024, time.February, 29, 23, 59, 59, 999999999, time.UTC)},
+		{StatementPeriod{Month: 2, Year: 2023}, time.Date(2023, time.February, 28, 23, 59, 59, 999999999, time.UTC)},
+		{StatementPeriod{Month: 12, Year: 2023}, time.Date(2023, time.December, 31, 23, 59, 59, 999999999, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := tt.period.End(); !got.Equal(tt.want) {
+			t.Errorf("%s End() = %s, want %s", tt.period, got, tt.want)
+		}
+	}
+}
+
+// recordingSender records every send and fails the users in failing.
+type recordingSender struct {
+	mu      sync.Mutex
+	failing map[int]bool
+	sends   []StatementPeriod
+	sent    map[int]int
+}
+
+func (s *recordingSender) SendStatementForPeriod(ctx context.Context, userId, month, year int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sends = append(s.sends, StatementPeriod{Month: month, Year: year})
+	s.sent[userId]++
+	if s.failing[userId] {
+		return errors.New("mailbox full")
+	}
+	return nil
+}
+
+func TestRunMonthEndReportsThePreviousMonth(t *testing.T) {
+	db, dialect := newTestDatabase(t)
+	sender := &recordingSender{sent: map[int]int{}}
+	clock := func() time.Time { return time.Date(2024, time.January, 2, 3, 0, 0, 0, time.UTC) }
+	batch := NewStatementBatch(sender, fixedUsers{1, 2}, NewStatementRunLog(db, dialect), 2, clock)
+
+	summary, err := batch.RunMonthEnd(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := StatementPeriod{Month: 12, Year: 2023}
+	if summary.Period != want || summary.Sent != 2 {
+		t.Fatalf("summary = %+v, want 2 sent for %s", summary, want)
+	}
+	for _, period := range sender.sends {
+		if period != want {
+			t.Errorf("sent a statement for %s, want %s", period, want)
+		}
+	}
+}
+
+func TestRerunRetriesOnlyFailedUsers(t *testing.T) {
+	db, dialect := newTestDatabase(t)
+	runLog := NewStatementRunLog(db, dialect)
+	sender := &recordingSender{failing: map[int]bool{2: true, 4: true}, sent: map[int]int{}}
+	now := time.Date(2024, time.February, 1, 6, 0, 0, 0, time.UTC)
+	batch := NewStatementBatch(sender, fixedUsers{1, 2, 3, 4, 5}, runLog, 2, func() time.Time { return now })
+	ctx := context.Background()
+
+	summary, err := batch.RunMonthEnd(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Sent != 3 || len(summary.Failures) != 2 || summary.Failures[2] == nil || summary.Failures[4] == nil {
+		t.Fatalf("first run = %+v, want 3 sent and users 2 and 4 failed", summary)
+	}
+
+	sender.failing = nil
+	now = now.Add(time.Hour)
+	summary, err = batch.RunMonthEnd(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Sent != 2 || summary.Skipped != 3 || len(summary.Failures) != 0 {
+		t.Fatalf("rerun = %+v, want 2 sent and 3 skipped", summary)
+	}
+	for userId, want := range map[int]int{1: 1, 2: 2, 3: 1, 4: 2, 5: 1} {
+		if sender.sent[userId] != want {
+			t.Errorf("user %d sent %d times, want %d", userId, sender.sent[userId], want)
+		}
+	}
+
+	results, err := runLog.Results(ctx, StatementPeriod{Month: 1, Year: 2024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, userId := range []int{2, 4} {
+		result := results[userId]
+		if result.Status != StatementSent || result.Attempts != 2 || result.Error != "" || !result.FinishedAt.Equal(now) {
+			t.Errorf("user %d run log = %+v, want sent on the second attempt at %s", userId, result, now)
+		}
+	}
+}
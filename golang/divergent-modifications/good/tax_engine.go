@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type FilingStatus string
+
+const (
+	FilingSingle            FilingStatus = "single"
+	FilingMarriedJointly    FilingStatus = "married_jointly"
+	FilingMarriedSeparately FilingStatus = "married_separately"
+	FilingHeadOfHousehold   FilingStatus = "head_of_household"
+)
+
+const (
+	defaultTaxJurisdiction = "DEFAULT"
+	anyTaxYear             = 0
+)
+
+// TaxBracket taxes income up to UpTo at Rate; the top bracket leaves UpTo
+// empty. Amounts in tax tables are in whole currency units.
+type TaxBracket struct {
+	UpTo *float64 `json:"up_to,omitempty"`
+	Rate float64  `json:"rate"`
+}
+
+type TaxCredit struct {
+	Name       string  `json:"name"`
+	Amount     float64 `json:"amount"`
+	Refundable bool    `json:"refundable"`
+}
+
+type TaxSchedule struct {
+	StandardDeduction float64      `json:"standard_deduction"`
+	DeductionCap      *float64     `json:"deduction_cap,omitempty"`
+	TaxCap            *float64     `json:"tax_cap,omitempty"`
+	Brackets          []TaxBracket `json:"brackets"`
+	Credits           []TaxCredit  `json:"credits,omitempty"`
+}
+
+// TaxTable holds one jurisdiction's schedules for one year. Year 0 means
+// the table applies to any year without a more specific table.
+type TaxTable struct {
+	Jurisdiction string                       `json:"jurisdiction"`
+	Year         int                          `json:"year"`
+	Currency     string                       `json:"currency"`
+	Schedules    map[FilingStatus]TaxSchedule `json:"schedules"`
+}
+
+type TaxRequest struct {
+	Jurisdiction string
+	Year         int
+	FilingStatus FilingStatus
+	Income       Money
+	Deductions   Money
+	Credits      []string
+}
+
+type BracketTax struct {
+	Lower   Money   `json:"lower"`
+	Upper   *Money  `json:"upper,omitempty"`
+	Rate    float64 `json:"rate"`
+	Taxable Money   `json:"taxable"`
+	Tax     Money   `json:"tax"`
+}
+
+type AppliedCredit struct {
+	Name       string `json:"name"`
+	Amount     Money  `json:"amount"`
+	Refundable bool   `json:"refundable"`
+}
+
+type TaxComputation struct {
+	Jurisdiction     string
+	Year             int
+	FilingStatus     FilingStatus
+	Income           Money
+	Deduction        Money
+	DeductionKind    string
+	TaxableIncome    Money
+	Brackets         []BracketTax
+	TaxBeforeCredits Money
+	Credits          []AppliedCredit
+	TaxOwed          Money
+}
+
+type TaxEngine struct {
+	tables map[string]map[int]TaxTable
+}
+
+func NewTaxEngine() *TaxEngine {
+	return &TaxEngine{tables: map[string]map[int]TaxTable{}}
+}
+
+// NewDefaultTaxEngine knows only the historical flat three-bracket table
+// (10% to 50k, 20% to 100k, 30% above) with no deductions or credits.
+func NewDefaultTaxEngine() *TaxEngine {
+	first, second := 50000.0, 100000.0
+	engine := NewTaxEngine()
+	engine.Register(TaxTable{
+		Jurisdiction: defaultTaxJurisdiction,
+		Year:         anyTaxYear,
+		Currency:     defaultCurrency,
+		Schedules: map[FilingStatus]TaxSchedule{
+			FilingSingle: {
+				Brackets: []TaxBracket{
+					{UpTo: &first, Rate: 0.1},
+					{UpTo: &second, Rate: 0.2},
+					{Rate: 0.3},
+				},
+			},
+		},
+	})
+	return engine
+}
+
+func (te *TaxEngine) Register(table TaxTable) error {
+	table.Jurisdiction = strings.ToUpper(table.Jurisdiction)
+	if table.Jurisdiction == "" {
+		return fmt.Errorf("tax table has no jurisdiction")
+	}
+	if table.Currency == "" {
+		table.Currency = defaultCurrency
+	}
+	for status, schedule := range table.Schedules {
+		if err := validateTaxSchedule(schedule); err != nil {
+			return fmt.Errorf("%s %d %s: %w", table.Jurisdiction, table.Year, status, err)
+		}
+	}
+
+	if te.tables[table.Jurisdiction] == nil {
+		te.tables[table.Jurisdiction] = map[int]TaxTable{}
+	}
+	te.tables[table.Jurisdiction][table.Year] = table
+	return nil
+}
+
+func validateTaxSchedule(schedule TaxSchedule) error {
+	if len(schedule.Brackets) == 0 {
+		return fmt.Errorf("schedule has no brackets")
+	}
+	previous := 0.0
+	for i, bracket := range schedule.Brackets {
+		if bracket.Rate < 0 || bracket.Rate > 1 {
+			return fmt.Errorf("bracket %d has rate %v outside 0..1", i, bracket.Rate)
+		}
+		last := i == len(schedule.Brackets)-1
+		if bracket.UpTo == nil {
+			if !last {
+				return fmt.Errorf("only the top bracket may be unbounded")
+			}
+			continue
+		}
+		if *bracket.UpTo <= previous {
+			return fmt.Errorf("bracket %d does not increase", i)
+		}
+		previous = *bracket.UpTo
+	}
+	return nil
+}
+
+// LoadFile registers every table in a .json file. A file holds a list of
+// tables.
+func (te *TaxEngine) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tables, err := DecodeTaxTables(file, filepath.Ext(path))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, table := range tables {
+		if err := te.Register(table); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func DecodeTaxTables(r io.Reader, format string) ([]TaxTable, error) {
+	var tables []TaxTable
+	switch strings.TrimPrefix(strings.ToLower(format), ".") {
+	case "json":
+		if err := json.NewDecoder(r).Decode(&tables); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported tax table format %q", format)
+	}
+	return tables, nil
+}
+
+func (te *TaxEngine) Jurisdictions() []string {
+	var names []string
+	for name := range te.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (te *TaxEngine) table(jurisdiction string, year int) (TaxTable, error) {
+	years, ok := te.tables[strings.ToUpper(jurisdiction)]
+	if !ok {
+		return TaxTable{}, fmt.Errorf("no tax tables for jurisdiction %q", jurisdiction)
+	}
+	if table, ok := years[year]; ok {
+		return table, nil
+	}
+	if table, ok := years[anyTaxYear]; ok {
+		return table, nil
+	}
+	// Callers that do not know the year get the most recent table.
+	if year == anyTaxYear {
+		latest := anyTaxYear
+		for candidate := range years {
+			if candidate > latest {
+				latest = candidate
+			}
+		}
+		return years[latest], nil
+	}
+	return TaxTable{}, fmt.Errorf("no %s tax table for %d", strings.ToUpper(jurisdiction), year)
+}
+
+func defaultFilingStatus(status FilingStatus) FilingStatus {
+	if status == "" {
+		return FilingSingle
+	}
+	return status
+}
+
+func (te *TaxEngine) Calculate(request TaxRequest) (TaxComputation, error) {
+	table, err := te.table(request.Jurisdiction, request.Year)
+	if err != nil {
+		return TaxComputation{}, err
+	}
+	status := defaultFilingStatus(request.FilingStatus)
+	schedule, ok := table.Schedules[status]
+	if !ok {
+		return TaxComputation{}, fmt.Errorf("%s %d has no schedule for filing status %q", table.Jurisdiction, request.Year, status)
+	}
+	if request.Income.Currency() != table.Currency || request.Deductions.Currency() != table.Currency {
+		return TaxComputation{}, fmt.Errorf("%s tax table is in %s", table.Jurisdiction, table.Currency)
+	}
+
+	amount := func(value float64) (Money, error) {
+		return MoneyFromFloat(value, table.Currency, RoundHalfEven)
+	}
+	// Every amount below is in the table's currency, so Compare and the
+	// arithmetic only fail on a malformed table.
+	larger := func(a, b Money) (bool, error) {
+		cmp, err := a.Compare(b)
+		return cmp > 0, err
+	}
+	zero := NewMoney(0, table.Currency)
+
+	computation := TaxComputation{
+		Jurisdiction: table.Jurisdiction,
+		Year:         request.Year,
+		FilingStatus: status,
+		Income:       request.Income,
+		Deduction:    request.Deductions,
+	}
+	if schedule.DeductionCap != nil {
+		limit, err := amount(*schedule.DeductionCap)
+		if err != nil {
+			return TaxComputation{}, err
+		}
+		if over, err := larger(computation.Deduction, limit); err != nil {
+			return TaxComputation{}, err
+		} else if over {
+			computation.Deduction = limit
+		}
+	}
+	// DeductionKind stays empty when nothing is deducted at all.
+	if !computation.Deduction.IsZero() {
+		computation.DeductionKind = "itemized"
+	}
+	standard, err := amount(schedule.StandardDeduction)
+	if err != nil {
+		return TaxComputation{}, err
+	}
+	if better, err := larger(standard, computation.Deduction); err != nil {
+		return TaxComputation{}, err
+	} else if better {
+		computation.Deduction = standard
+		computation.DeductionKind = "standard"
+	}
+
+	if computation.TaxableIncome, err = request.Income.Subtract(computation.Deduction); err != nil {
+		return TaxComputation{}, err
+	}
+	if computation.TaxableIncome.IsNegative() {
+		computation.TaxableIncome = zero
+	}
+
+	tax := zero
+	lower := zero
+	for _, bracket := range schedule.Brackets {
+		if above, err := larger(computation.TaxableIncome, lower); err != nil {
+			return TaxComputation{}, err
+		} else if !above {
+			break
+		}
+		line := BracketTax{Lower: lower, Rate: bracket.Rate}
+		top := computation.TaxableIncome
+		if bracket.UpTo != nil {
+			upper, err := amount(*bracket.UpTo)
+			if err != nil {
+				return TaxComputation{}, err
+			}
+			line.Upper = &upper
+			if over, err := larger(top, upper); err != nil {
+				return TaxComputation{}, err
+			} else if over {
+				top = upper
+			}
+		}
+		if line.Taxable, err = top.Subtract(lower); err != nil {
+			return TaxComputation{}, err
+		}
+		if line.Tax, err = line.Taxable.Multiply(bracket.Rate, RoundHalfEven); err != nil {
+			return TaxComputation{}, err
+		}
+		computation.Brackets = append(computation.Brackets, line)
+
+		if tax, err = tax.Add(line.Tax); err != nil {
+			return TaxComputation{}, err
+		}
+		if line.Upper == nil {
+			break
+		}
+		lower = *line.Upper
+	}
+	if schedule.TaxCap != nil {
+		limit, err := amount(*schedule.TaxCap)
+		if err != nil {
+			return TaxComputation{}, err
+		}
+		if over, err := larger(tax, limit); err != nil {
+			return TaxComputation{}, err
+		} else if over {
+			tax = limit
+		}
+	}
+	computation.TaxBeforeCredits = tax
+
+	claimed := map[string]bool{}
+	for _, name := range request.Credits {
+		claimed[name] = true
+	}
+	// Non-refundable credits can only bring the liability down to zero, so
+	// they are applied before refundable ones.
+	for _, refundable := range []bool{false, true} {
+		for _, credit := range schedule.Credits {
+			if !claimed[credit.Name] || credit.Refundable != refundable {
+				continue
+			}
+			value, err := amount(credit.Amount)
+			if err != nil {
+				return TaxComputation{}, err
+			}
+			applied := AppliedCredit{Name: credit.Name, Amount: value, Refundable: credit.Refundable}
+			if over, err := larger(applied.Amount, tax); err != nil {
+				return TaxComputation{}, err
+			} else if !refundable && over {
+				applied.Amount = tax
+			}
+			if tax, err = tax.Subtract(applied.Amount); err != nil {
+				return TaxComputation{}, err
+			}
+			computation.Credits = append(computation.Credits, applied)
+		}
+	}
+	computation.TaxOwed = tax
+	return computation, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"testing"
+)
+
+func dollars(whole int64) Money {
+	return NewMoney(whole*100, "USD")
+}
+
+func newTestTaxEngine(t *testing.T) *TaxEngine {
+	t.Helper()
+	engine := NewDefaultTaxEngine()
+	if err := engine.LoadFile("tax_tables.json"); err != nil {
+		t.Fatal(err)
+	}
+	bracket, deductionCap, taxCap := 10000.0, 5000.0, 3000.0
+	err := engine.Register(TaxTable{
+		Jurisdiction: "TEST",
+		Year:         2024,
+		Currency:     "USD",
+		Schedules: map[FilingStatus]TaxSchedule{
+			FilingSingle: {
+				StandardDeduction: 1000,
+				DeductionCap:      &deductionCap,
+				TaxCap:            &taxCap,
+				Brackets:          []TaxBracket{{UpTo: &bracket, Rate: 0.1}, {Rate: 0.5}},
+				Credits: []TaxCredit{
+					{Name: "refund", Amount: 500, Refundable: true},
+					{Name: "nonref", Amount: 800},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return engine
+}
+
+func TestTaxEngineCalculate(t *testing.T) {
+	engine := newTestTaxEngine(t)
+	tests := []struct {
+		name          string
+		request       TaxRequest
+		deduction     Money
+		deductionKind string
+		taxable       Money
+		brackets      int
+		beforeCredits Money
+		owed          Money
+	}{
+		{
+			name:          "standard deduction beats none, split over three brackets",
+			request:       TaxRequest{Jurisdiction: "US-FED", Year: 2024, Income: dollars(100000), Deductions: dollars(0)},
+			deduction:     dollars(14600),
+			deductionKind: "standard",
+			taxable:       dollars(85400),
+			brackets:      3,
+			beforeCredits: dollars(13841),
+			owed:          dollars(13841),
+		},
+		{
+			name:          "larger itemized deduction is kept",
+			request:       TaxRequest{Jurisdiction: "US-FED", Year: 2024, Income: dollars(100000), Deductions: dollars(20000)},
+			deduction:     dollars(20000),
+			deductionKind: "itemized",
+			taxable:       dollars(80000),
+			brackets:      3,
+			beforeCredits: dollars(12653),
+			owed:          dollars(12653),
+		},
+		{
+			name:          "income ending on a bracket edge uses one bracket",
+			request:       TaxRequest{Jurisdiction: "US-FED", Year: 2024, Income: dollars(26200), Deductions: dollars(0)},
+			deduction:     dollars(14600),
+			deductionKind: "standard",
+			taxable:       dollars(11600),
+			brackets:      1,
+			beforeCredits: dollars(1160),
+			owed:          dollars(1160),
+		},
+		{
+			name:          "married jointly has its own schedule",
+			request:       TaxRequest{Jurisdiction: "US-FED", Year: 2024, FilingStatus: FilingMarriedJointly, Income: dollars(100000), Deductions: dollars(0)},
+			deduction:     dollars(29200),
+			deductionKind: "standard",
+			taxable:       dollars(70800),
+			brackets:      2,
+			beforeCredits: dollars(8032),
+			owed:          dollars(8032),
+		},
+		{
+			name:          "non-refundable credit stops at zero",
+			request:       TaxRequest{Jurisdiction: "US-FED", Year: 2024, Income: dollars(20000), Deductions: dollars(0), Credits: []string{"child_tax_credit"}},
+			deduction:     dollars(14600),
+			deductionKind: "standard",
+			taxable:       dollars(5400),
+			brackets:      1,
+			beforeCredits: dollars(540),
+			owed:          dollars(0),
+		},
+		{
+			name:          "no deduction at all is neither kind",
+			request:       TaxRequest{Jurisdiction: defaultTaxJurisdiction, Income: dollars(60000), Deductions: dollars(0)},
+			deduction:     dollars(0),
+			deductionKind: "",
+			taxable:       dollars(60000),
+			brackets:      2,
+			beforeCredits: dollars(7000),
+			owed:          dollars(7000),
+		},
+		{
+			name:          "itemized deduction is capped",
+			request:       TaxRequest{Jurisdiction: "TEST", Year: 2024, Income: dollars(15000), Deductions: dollars(9000)},
+			deduction:     dollars(5000),
+			deductionKind: "itemized",
+			taxable:       dollars(10000),
+			brackets:      1,
+			beforeCredits: dollars(1000),
+			owed:          dollars(1000),
+		},
+		{
+			name:          "tax is capped",
+			request:       TaxRequest{Jurisdiction: "TEST", Year: 2024, Income: dollars(100000), Deductions: dollars(0)},
+			deduction:     dollars(1000),
+			deductionKind: "standard",
+			taxable:       dollars(99000),
+			brackets:      2,
+			beforeCredits: dollars(3000),
+			owed:          dollars(3000),
+		},
+		{
+			// Applying the refundable credit first would leave nothing for
+			// the non-refundable one to offset and end at zero.
+			name:          "non-refundable credits go before refundable ones",
+			request:       TaxRequest{Jurisdiction: "TEST", Year: 2024, Income: dollars(6000), Deductions: dollars(0), Credits: []string{"refund", "nonref"}},
+			deduction:     dollars(1000),
+			deductionKind: "standard",
+			taxable:       dollars(5000),
+			brackets:      1,
+			beforeCredits: dollars(500),
+			owed:          dollars(-500),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := engine.Calculate(tt.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Deduction != tt.deduction || got.DeductionKind != tt.deductionKind {
+				t.Errorf("deduction = %s (%q), want %s (%q)", got.Deduction, got.DeductionKind, tt.deduction, tt.deductionKind)
+			}
+			if got.TaxableIncome != tt.taxable {
+				t.Errorf("taxable income = %s, want %s", got.TaxableIncome, tt.taxable)
+			}
+			if len(got.Brackets) != tt.brackets {
+				t.Errorf("%d brackets used, want %d", len(got.Brackets), tt.brackets)
+			}
+			if got.TaxBeforeCredits != tt.beforeCredits {
+				t.Errorf("tax before credits = %s, want %s", got.TaxBeforeCredits, tt.beforeCredits)
+			}
+			if got.TaxOwed != tt.owed {
+				t.Errorf("tax owed = %s, want %s", got.TaxOwed, tt.owed)
+			}
+		})
+	}
+}
+
+func TestTaxEngineCreditOrder(t *testing.T) {
+	engine := newTestTaxEngine(t)
+	got, err := engine.Calculate(TaxRequest{Jurisdiction: "TEST", Year: 2024, Income: dollars(6000), Deductions: dollars(0), Credits: []string{"refund", "nonref"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AppliedCredit{
+		{Name: "nonref", Amount: dollars(500)},
+		{Name: "refund", Amount: dollars(500), Refundable: true},
+	}
+	if len(got.Credits) != len(want) {
+		t.Fatalf("credits = %+v, want %+v", got.Credits, want)
+	}
+	for i := range want {
+		if got.Credits[i] != want[i] {
+			t.Errorf("credit %d = %+v, want %+v", i, got.Credits[i], want[i])
+		}
+	}
+}
+
+func TestBracketsSplitTaxableIncome(t *testing.T) {
+	engine := newTestTaxEngine(t)
+	got, err := engine.Calculate(TaxRequest{Jurisdiction: "US-FED", Year: 2024, Income: dollars(100000), Deductions: dollars(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		lower, taxable, tax Money
+	}{
+		{dollars(0), dollars(11600), dollars(1160)},
+		{dollars(11600), dollars(35550), dollars(4266)},
+		{dollars(47150), dollars(38250), dollars(8415)},
+	}
+	for i, line := range got.Brackets {
+		if line.Lower != want[i].lower || line.Taxable != want[i].taxable || line.Tax != want[i].tax {
+			t.Errorf("bracket %d = %s taxable from %s, tax %s; want %s from %s, tax %s",
+				i, line.Taxable, line.Lower, line.Tax, want[i].taxable, want[i].lower, want[i].tax)
+		}
+	}
+}
+
+func TestFinancialCalculatorRejectsUnknownFilingStatus(t *testing.T) {
+	engine := newTestTaxEngine(t)
+	if _, err := NewFinancialCalculatorWithTaxEngine(engine, "US-FED", "widowed"); err == nil {
+		t.Error("unknown filing status accepted")
+	}
+	if _, err := NewFinancialCalculatorWithTaxEngine(engine, "TEST", FilingHeadOfHousehold); err == nil {
+		t.Error("filing status missing from the table accepted")
+	}
+	if _, err := NewFinancialCalculatorWithTaxEngine(engine, "US-FED", ""); err != nil {
+		t.Errorf("empty filing status should mean single: %v", err)
+	}
+}
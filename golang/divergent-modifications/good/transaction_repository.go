@@ -1,118 +1,238 @@
 package main
 
 import (
-	"database/sql"
-	"log"
+	"context"
+	"fmt"
+	"time"
 )
 
+// transactionRepository keeps the single-sided transaction API on top of a
+// double-entry ledger: each saved transaction becomes a journal entry
+// between the user's account and the counterpart for its type. Amounts may
+// be in any currency; rates value them in the base currency.
 type transactionRepository struct {
-	db Database
+	db       Database
+	dialect  Dialect
+	ledger   Ledger
+	rates    ExchangeRateProvider
+	currency string
+	now      func() time.Time
 }
 
-func NewTransactionRepository(db Database) TransactionRepository {
-	return &transactionRepository{db: db}
+// NewTransactionRepository stores amounts in USD and converts other
+// currencies with rates. With nil rates only USD amounts are accepted.
+func NewTransactionRepository(db Database, dialect Dialect, rates ExchangeRateProvider) TransactionRepository {
+	return &transactionRepository{
+		db:       db,
+		dialect:  dialect,
+		ledger:   NewLedger(db, dialect, defaultCurrency),
+		rates:    rates,
+		currency: defaultCurrency,
+		now:      time.Now,
+	}
+}
+
+// Amounts cross the Database boundary as decimals and are rounded back to
+// whole minor units on the way in.
+func (tr transactionRepository) toMoney(amount *float64, currency string) (Money, error) {
+	if amount == nil {
+		return NewMoney(0, currency), nil
+	}
+	return MoneyFromFloat(*amount, currency, RoundHalfEven)
+}
+
+// Date filters compare yyyymm period keys rather than calling
+// MONTH()/YEAR(), so the same query runs on every dialect.
+func yearPeriods(year int) (int, int) {
+	return year*100 + 1, year*100 + 12
 }
 
-func (tr transactionRepository) SaveTransaction(userId int, amount float64, transactionType string) (int64, error) {
-	result, err := tr.db.Exec(
-		"INSERT INTO transactions (user_id, amount, type, created_at) VALUES (?, ?, ?, NOW())",
-		userId, amount, transactionType,
-	)
+func (tr transactionRepository) SaveTransaction(ctx context.Context, userId int, amount Money, transactionType string) (int64, error) {
+	createdAt := tr.now()
+	baseAmount, err := ConvertMoney(ctx, tr.rates, amount, tr.currency, createdAt)
 	if err != nil {
-		return 0, err
+		return 0, fmt.Errorf("save %s transaction: %w", amount.Currency(), err)
 	}
-	return result.LastInsertId()
+	return tr.ledger.Post(ctx, JournalEntry{
+		UserId:    userId,
+		Type:      transactionType,
+		CreatedAt: createdAt,
+		Postings: []Posting{
+			{Account: UserAccount(userId), Amount: amount, BaseAmount: baseAmount},
+			{Account: counterpartAccount(transactionType), Amount: amount.Negate(), BaseAmount: baseAmount.Negate()},
+		},
+	})
+}
+
+// ReverseTransaction undoes a saved transaction by posting its mirror image;
+// the original stays in the ledger.
+func (tr transactionRepository) ReverseTransaction(ctx context.Context, transactionId int64, reason string) (int64, error) {
+	return tr.ledger.Reverse(ctx, transactionId, reason)
+}
+
+func (tr transactionRepository) TrialBalance(ctx context.Context) (TrialBalance, error) {
+	return tr.ledger.TrialBalance(ctx)
 }
 
-func (tr transactionRepository) GetUserBalance(userId int) float64 {
-	rows, err := tr.db.Query("SELECT SUM(amount) as balance FROM transactions WHERE user_id = ?", userId)
+func (tr transactionRepository) ClosePeriod(ctx context.Context, period StatementPeriod) error {
+	return tr.ledger.ClosePeriod(ctx, period)
+}
+
+func (tr transactionRepository) VerifyLedger(ctx context.Context) ([]LedgerMismatch, error) {
+	return tr.ledger.Verify(ctx)
+}
+
+// sumAmount runs a single-row SUM query over the posting aggregates. A NULL
+// sum means no matching postings and is a genuine zero, unlike a failed
+// query.
+func (tr transactionRepository) sumAmount(ctx context.Context, op, query string, args ...interface{}) (Money, error) {
+	rows, err := tr.db.QueryContext(ctx, rebind(tr.dialect, query), args...)
 	if err != nil {
-		log.Printf("Error querying balance: %v", err)
-		return 0
+		return Money{}, repositoryError(op, ErrConnection, err)
 	}
 	defer rows.Close()
 
-	if rows.Next() {
-		var balance *float64
-		rows.Scan(&balance)
-		if balance != nil {
-			return *balance
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return Money{}, repositoryError(op, ErrConnection, err)
 		}
+		return Money{}, repositoryError(op, ErrNotFound, nil)
+	}
+	var total *int64
+	if err := rows.Scan(&total); err != nil {
+		return Money{}, repositoryError(op, ErrScan, err)
 	}
-	return 0
+	return moneyFromMinor(total, tr.currency), nil
 }
 
-func (tr transactionRepository) GetMonthlyTransactions(userId, month, year int) []map[string]interface{} {
-	rows, err := tr.db.Query(`
-		SELECT type, SUM(amount) as total, COUNT(*) as count
-		FROM transactions
-		WHERE user_id = ? AND MONTH(created_at) = ? AND YEAR(created_at) = ?
-		GROUP BY type
-	`, userId, month, year)
+// GetUserBalance is the user's total holdings valued in the base currency
+// at today's rates.
+func (tr transactionRepository) GetUserBalance(ctx context.Context, userId int) (Money, error) {
+	return tr.GetUserBalanceIn(ctx, userId, tr.currency)
+}
 
+func (tr transactionRepository) GetUserBalanceIn(ctx context.Context, userId int, currency string) (Money, error) {
+	holdings, err := tr.GetUserHoldings(ctx, userId)
 	if err != nil {
-		log.Printf("Error getting monthly transactions: %v", err)
-		return nil
+		return Money{}, err
 	}
-	defer rows.Close()
+	return tr.valueHoldings(ctx, userId, holdings, currency, tr.now())
+}
 
-	var results []map[string]interface{}
-	for rows.Next() {
-		var transactionType string
-		var total float64
-		var count int
-		rows.Scan(&transactionType, &total, &count)
-		results = append(results, map[string]interface{}{
-			"type":  transactionType,
-			"total": total,
-			"count": count,
-		})
+// GetUserBalanceAsOf is the balance at the end of the period, with foreign
+// holdings valued at that day's rates, so a statement shows the month it
+// covers rather than today.
+func (tr transactionRepository) GetUserBalanceAsOf(ctx context.Context, userId int, period StatementPeriod) (Money, error) {
+	holdings, err := tr.ledger.AccountBalancesThrough(ctx, UserAccount(userId), period)
+	if err != nil {
+		return Money{}, err
+	}
+	return tr.valueHoldings(ctx, userId, holdings, tr.currency, period.End())
+}
+
+func (tr transactionRepository) valueHoldings(ctx context.Context, userId int, holdings []Money, currency string, on time.Time) (Money, error) {
+	total := NewMoney(0, currency)
+	for _, holding := range holdings {
+		converted, err := ConvertMoney(ctx, tr.rates, holding, total.Currency(), on)
+		if err != nil {
+			return Money{}, fmt.Errorf("user %d balance in %s: %w", userId, total.Currency(), err)
+		}
+		if total, err = total.Add(converted); err != nil {
+			return Money{}, err
+		}
 	}
-	return results
+	return total, nil
 }
 
-func (tr transactionRepository) GetYearlyIncome(userId, year int) float64 {
-	rows, err := tr.db.Query(`
-		SELECT SUM(amount) as total_income
-		FROM transactions
-		WHERE user_id = ? AND type = 'income' AND YEAR(created_at) = ?
-	`, userId, year)
+// GetUserHoldings returns the user's balance in each currency separately.
+func (tr transactionRepository) GetUserHoldings(ctx context.Context, userId int) ([]Money, error) {
+	return tr.ledger.AccountBalances(ctx, UserAccount(userId))
+}
 
+// GetCurrencyMovements lists the user's foreign-currency postings dated
+// before the given time, oldest first, with the base value each was booked
+// at.
+func (tr transactionRepository) GetCurrencyMovements(ctx context.Context, userId int, before time.Time) ([]CurrencyMovement, error) {
+	const op = "get currency movements"
+	rows, err := tr.db.QueryContext(ctx, rebind(tr.dialect, `
+		SELECT currency, amount, base_amount, created_at FROM postings
+		WHERE account = ? AND currency <> ? AND created_at < ?
+		ORDER BY id
+	`), UserAccount(userId).Code, tr.currency, before.UTC())
 	if err != nil {
-		log.Printf("Error getting yearly income: %v", err)
-		return 0
+		return nil, repositoryError(op, ErrConnection, err)
 	}
 	defer rows.Close()
 
-	if rows.Next() {
-		var income *float64
-		rows.Scan(&income)
-		if income != nil {
-			return *income
+	var movements []CurrencyMovement
+	for rows.Next() {
+		var currency string
+		var amount, baseAmount float64
+		var createdAt time.Time
+		if err := rows.Scan(&currency, &amount, &baseAmount, &createdAt); err != nil {
+			return nil, repositoryError(op, ErrScan, err)
+		}
+		movement := CurrencyMovement{At: createdAt}
+		if movement.Amount, err = tr.toMoney(&amount, currency); err != nil {
+			return nil, repositoryError(op, ErrScan, err)
 		}
+		if movement.BaseAmount, err = tr.toMoney(&baseAmount, tr.currency); err != nil {
+			return nil, repositoryError(op, ErrScan, err)
+		}
+		movements = append(movements, movement)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, repositoryError(op, ErrConnection, err)
 	}
-	return 0
+	return movements, nil
 }
 
-func (tr transactionRepository) GetYearlyDeductions(userId, year int) float64 {
-	rows, err := tr.db.Query(`
-		SELECT SUM(amount) as total_deductions
-		FROM transactions
-		WHERE user_id = ? AND type = 'deduction' AND YEAR(created_at) = ?
-	`, userId, year)
+func (tr transactionRepository) GetMonthlyTransactions(ctx context.Context, userId, month, year int) ([]TransactionSummary, error) {
+	const op = "get monthly transactions"
+	rows, err := tr.db.QueryContext(ctx, rebind(tr.dialect, `
+		SELECT type, currency, total, postings_count
+		FROM posting_aggregates
+		WHERE account = ? AND period = ?
+	`), UserAccount(userId).Code, StatementPeriod{Month: month, Year: year}.key())
 
 	if err != nil {
-		log.Printf("Error getting yearly deductions: %v", err)
-		return 0
+		return nil, repositoryError(op, ErrConnection, err)
 	}
 	defer rows.Close()
 
-	if rows.Next() {
-		var deductions *float64
-		rows.Scan(&deductions)
-		if deductions != nil {
-			return *deductions
+	var results []TransactionSummary
+	for rows.Next() {
+		var transactionType, currency string
+		var total int64
+		var count int
+		if err := rows.Scan(&transactionType, &currency, &total, &count); err != nil {
+			return nil, repositoryError(op, ErrScan, err)
 		}
+		summary := TransactionSummary{Type: transactionType, Count: count, Total: NewMoney(total, currency)}
+		results = append(results, summary)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, repositoryError(op, ErrConnection, err)
 	}
-	return 0
+	return results, nil
+}
+
+// Yearly figures are in the base currency, valuing foreign amounts at the
+// rate of the day they were booked.
+func (tr transactionRepository) GetYearlyIncome(ctx context.Context, userId, year int) (Money, error) {
+	from, to := yearPeriods(year)
+	return tr.sumAmount(ctx, "get yearly income", `
+		SELECT SUM(base_total) as total_income
+		FROM posting_aggregates
+		WHERE account = ? AND type = 'income' AND period >= ? AND period <= ?
+	`, UserAccount(userId).Code, from, to)
+}
+
+func (tr transactionRepository) GetYearlyDeductions(ctx context.Context, userId, year int) (Money, error) {
+	from, to := yearPeriods(year)
+	return tr.sumAmount(ctx, "get yearly deductions", `
+		SELECT SUM(base_total) as total_deductions
+		FROM posting_aggregates
+		WHERE account = ? AND type = 'deduction' AND period >= ? AND period <= ?
+	`, UserAccount(userId).Code, from, to)
 }
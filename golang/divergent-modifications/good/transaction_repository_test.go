@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGetUserBalanceAsOfIgnoresLaterPostings(t *testing.T) {
+	ctx := context.Background()
+	db, dialect := newTestDatabase(t)
+	repo := NewTransactionRepository(db, dialect, nil).(*transactionRepository)
+
+	for _, deposit := range []struct {
+		on     time.Time
+		amount int64
+	}{
+		{time.Date(2024, time.January, 31, 23, 0, 0, 0, time.UTC), 10000},
+		{time.Date(2024, time.March, 2, 9, 0, 0, 0, time.UTC), 2500},
+	} {
+		on := deposit.on
+		repo.now = func() time.Time { return on }
+		repo.ledger.(*ledger).now = func() time.Time { return on }
+		if _, err := repo.SaveTransaction(ctx, 1, NewMoney(deposit.amount, defaultCurrency), "deposit"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A snapshot taken after March must not leak into earlier statements.
+	if err := repo.ledger.ClosePeriod(ctx, StatementPeriod{Month: 3, Year: 2024}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		period StatementPeriod
+		want   int64
+	}{
+		{StatementPeriod{Month: 12, Year: 2023}, 0},
+		{StatementPeriod{Month: 1, Year: 2024}, 10000},
+		{StatementPeriod{Month: 2, Year: 2024}, 10000},
+		{StatementPeriod{Month: 3, Year: 2024}, 12500},
+	} {
+		balance, err := repo.GetUserBalanceAsOf(ctx, 1, tc.period)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.period, err)
+		}
+		if balance.MinorUnits() != tc.want {
+			t.Errorf("balance as of %s = %s, want %s", tc.period, balance, NewMoney(tc.want, defaultCurrency))
+		}
+	}
+}
+
+func TestGetCurrencyMovementsReturnsEarlierForeignPostings(t *testing.T) {
+	ctx := context.Background()
+	db, dialect := newTestDatabase(t)
+	repo := NewTransactionRepository(db, dialect, nil).(*transactionRepository)
+
+	day := func(d int) time.Time { return time.Date(2024, time.May, d, 12, 0, 0, 0, time.UTC) }
+	post := func(at time.Time, amount, base Money) {
+		t.Helper()
+		_, err := repo.ledger.Post(ctx, JournalEntry{
+			UserId:    1,
+			Type:      "income",
+			CreatedAt: at,
+			Postings: []Posting{
+				{Account: UserAccount(1), Amount: amount, BaseAmount: base},
+				{Account: counterpartAccount("income"), Amount: amount.Negate(), BaseAmount: base.Negate()},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	post(day(1), NewMoney(10000, "EUR"), NewMoney(10800, defaultCurrency))
+	post(day(2), NewMoney(5000, defaultCurrency), NewMoney(5000, defaultCurrency))
+	post(day(3), NewMoney(-2000, "EUR"), NewMoney(-2150, defaultCurrency))
+	post(day(9), NewMoney(7000, "EUR"), NewMoney(7600, defaultCurrency))
+
+	movements, err := repo.GetCurrencyMovements(ctx, 1, day(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movements) != 2 {
+		t.Fatalf("got %d movements, want the two EUR postings before the cutoff: %+v", len(movements), movements)
+	}
+	if movements[0].Amount.MinorUnits() != 10000 || movements[1].Amount.MinorUnits() != -2000 {
+		t.Errorf("movements = %+v", movements)
+	}
+	if movements[1].BaseAmount.MinorUnits() != -2150 || movements[1].BaseAmount.Currency() != defaultCurrency {
+		t.Errorf("second movement base = %s, want -21.50 USD", movements[1].BaseAmount)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// TxDatabase is a Database that can start transactions; *sql.DB is one.
+type TxDatabase interface {
+	Database
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// Repositories are handed to a unit of work, all bound to the same
+// transaction.
+type Repositories struct {
+	Transactions TransactionRepository
+	Users        UserRepository
+}
+
+type UnitOfWork struct {
+	db      TxDatabase
+	dialect Dialect
+	rates   ExchangeRateProvider
+}
+
+func NewUnitOfWork(db TxDatabase, dialect Dialect, rates ExchangeRateProvider) *UnitOfWork {
+	return &UnitOfWork{db: db, dialect: dialect, rates: rates}
+}
+
+// WithTx runs fn inside one database transaction. The transaction commits
+// only if fn returns nil; an error or a panic rolls every write back.
+func (uow *UnitOfWork) WithTx(ctx context.Context, fn func(repos Repositories) error) error {
+	tx, err := uow.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			tx.Rollback()
+			panic(recovered)
+		}
+	}()
+
+	repos := Repositories{
+		Transactions: NewTransactionRepository(tx, uow.dialect, uow.rates),
+		Users:        NewUserRepository(tx, uow.dialect),
+	}
+	if err := fn(repos); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
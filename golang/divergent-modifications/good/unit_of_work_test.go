@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+var testDatabases atomic.Int64
+
+// newTestDatabase opens a private in-memory database with users 1 and 2.
+func newTestDatabase(t *testing.T) (*sql.DB, Dialect) {
+	t.Helper()
+	db, err := OpenMemoryDatabase(fmt.Sprintf("%s-%d", t.Name(), testDatabases.Add(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	dialect, err := DialectFor(memoryDriverName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateLedgerIndexes(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []struct {
+		id          int
+		name, email string
+	}{{1, "John Doe", "john@example.com"}, {2, "Jane Roe", "jane@example.com"}} {
+		if _, err := db.Exec("INSERT INTO users (id, name, email) VALUES (?, ?, ?)", user.id, user.name, user.email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db, dialect
+}
+
+func assertBalance(t *testing.T, repo TransactionRepository, userId int, want int64) {
+	t.Helper()
+	balance, err := repo.GetUserBalance(context.Background(), userId)
+	if err != nil {
+		t.Fatalf("balance of user %d: %v", userId, err)
+	}
+	if balance.MinorUnits() != want {
+		t.Errorf("user %d balance = %s, want %s", userId, balance, NewMoney(want, defaultCurrency))
+	}
+}
+
+func TestUnitOfWorkRollsBackAfterFirstWrite(t *testing.T) {
+	ctx := context.Background()
+	db, dialect := newTestDatabase(t)
+	repo := NewTransactionRepository(db, dialect, nil)
+	if _, err := repo.SaveTransaction(ctx, 1, NewMoney(10000, defaultCurrency), "deposit"); err != nil {
+		t.Fatal(err)
+	}
+
+	injected := errors.New("injected failure")
+	err := NewUnitOfWork(db, dialect, nil).WithTx(ctx, func(repos Repositories) error {
+		if _, err := repos.Transactions.SaveTransaction(ctx, 1, NewMoney(-2500, defaultCurrency), "transfer_out"); err != nil {
+			return err
+		}
+		return injected
+	})
+	if !errors.Is(err, injected) {
+		t.Fatalf("WithTx = %v, want the injected error", err)
+	}
+
+	assertBalance(t, repo, 1, 10000)
+	assertBalance(t, repo, 2, 0)
+}
+
+func TestUnitOfWorkRollsBackOnPanic(t *testing.T) {
+	ctx := context.Background()
+	db, dialect := newTestDatabase(t)
+	repo := NewTransactionRepository(db, dialect, nil)
+	if _, err := repo.SaveTransaction(ctx, 1, NewMoney(10000, defaultCurrency), "deposit"); err != nil {
+		t.Fatal(err)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("WithTx swallowed the panic")
+			}
+		}()
+		NewUnitOfWork(db, dialect, nil).WithTx(ctx, func(repos Repositories) error {
+			if _, err := repos.Transactions.SaveTransaction(ctx, 1, NewMoney(-2500, defaultCurrency), "transfer_out"); err != nil {
+				return err
+			}
+			panic("injected panic")
+		})
+	}()
+
+	assertBalance(t, repo, 1, 10000)
+	assertBalance(t, repo, 2, 0)
+}
+
+func TestTransferFundsCommitsBothSides(t *testing.T) {
+	ctx := context.Background()
+	db, dialect := newTestDatabase(t)
+	repo := NewTransactionRepository(db, dialect, nil)
+	if _, err := repo.SaveTransaction(ctx, 1, NewMoney(10000, defaultCurrency), "deposit"); err != nil {
+		t.Fatal(err)
+	}
+	fs := NewFinancialService(NewFinancialCalculator(), repo, NewUserRepository(db, dialect), nil, nil, NewUnitOfWork(db, dialect, nil))
+
+	if err := fs.TransferFunds(ctx, 1, 2, NewMoney(2500, defaultCurrency)); err != nil {
+		t.Fatalf("TransferFunds: %v", err)
+	}
+	assertBalance(t, repo, 1, 7500)
+	assertBalance(t, repo, 2, 2500)
+
+	// An unknown recipient fails before anything is written.
+	if err := fs.TransferFunds(ctx, 1, 99, NewMoney(2500, defaultCurrency)); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("transfer to unknown user = %v, want ErrNotFound", err)
+	}
+	assertBalance(t, repo, 1, 7500)
+}
+
+func TestUnitOfWorkRollsBackWhenCancelledMidway(t *testing.T) {
+	db, dialect := newTestDatabase(t)
+	repo := NewTransactionRepository(db, dialect, nil)
+	if _, err := repo.SaveTransaction(context.Background(), 1, NewMoney(10000, defaultCurrency), "deposit"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := NewUnitOfWork(db, dialect, nil).WithTx(ctx, func(repos Repositories) error {
+		if _, err := repos.Transactions.SaveTransaction(ctx, 1, NewMoney(-2500, defaultCurrency), "transfer_out"); err != nil {
+			return err
+		}
+		cancel()
+		_, err := repos.Transactions.SaveTransaction(ctx, 2, NewMoney(2500, defaultCurrency), "transfer_in")
+		return err
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("WithTx = %v, want context.Canceled", err)
+	}
+
+	assertBalance(t, repo, 1, 10000)
+	assertBalance(t, repo, 2, 0)
+}
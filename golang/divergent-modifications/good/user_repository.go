@@ -1,22 +1,29 @@
 package main
 
+import (
+	"context"
+	"fmt"
+)
+
 type userRepository struct {
-	db Database
+	db      Database
+	dialect Dialect
 }
 
-func NewUserRepository(db Database) UserRepository {
-	return &userRepository{db: db}
+func NewUserRepository(db Database, dialect Dialect) UserRepository {
+	return &userRepository{db: db, dialect: dialect}
 }
 
-func (ur userRepository) UpdateUserProfile(userId int, name, email string) error {
-	_, err := ur.db.Exec("UPDATE users SET name = ?, email = ? WHERE id = ?", name, email, userId)
+func (ur userRepository) UpdateUserProfile(ctx context.Context, userId int, name, email string) error {
+	_, err := ur.db.ExecContext(ctx, rebind(ur.dialect, "UPDATE users SET name = ?, email = ? WHERE id = ?"), name, email, userId)
 	return err
 }
 
-func (ur userRepository) GetUserEmail(userId int) (string, error) {
-	rows, err := ur.db.Query("SELECT email FROM users WHERE id = ?", userId)
+func (ur userRepository) GetUserEmail(ctx context.Context, userId int) (string, error) {
+	const op = "get user email"
+	rows, err := ur.db.QueryContext(ctx, rebind(ur.dialect, "SELECT email FROM users WHERE id = ?"), userId)
 	if err != nil {
-		return "", err
+		return "", repositoryError(op, ErrConnection, err)
 	}
 	defer rows.Close()
 
@@ -24,10 +31,35 @@ func (ur userRepository) GetUserEmail(userId int) (string, error) {
 		var email string
 		err := rows.Scan(&email)
 		if err != nil {
-			return "", err
+			return "", repositoryError(op, ErrScan, err)
 		}
 		return email, nil
 	}
+	if err := rows.Err(); err != nil {
+		return "", repositoryError(op, ErrConnection, err)
+	}
+
+	return "", repositoryError(op, ErrNotFound, fmt.Errorf("user %d", userId))
+}
 
-	return "", sql.ErrNoRows
+func (ur userRepository) ListUserIds(ctx context.Context) ([]int, error) {
+	const op = "list user ids"
+	rows, err := ur.db.QueryContext(ctx, "SELECT id FROM users ORDER BY id")
+	if err != nil {
+		return nil, repositoryError(op, ErrConnection, err)
+	}
+	defer rows.Close()
+
+	var userIds []int
+	for rows.Next() {
+		var userId int
+		if err := rows.Scan(&userId); err != nil {
+			return nil, repositoryError(op, ErrScan, err)
+		}
+		userIds = append(userIds, userId)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, repositoryError(op, ErrConnection, err)
+	}
+	return userIds, nil
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/url"
+)
+
+var (
+	welcomeTemplate = MustMailTemplate("welcome",
+		"Welcome to our platform!",
+		"Hello {{.Name}},\n\nWelcome to our platform!\n",
+		"<p>Hello {{.Name}},</p><p>Welcome to our platform!</p>")
+
+	passwordResetTemplate = MustMailTemplate("password_reset",
+		"Password Reset",
+		"Click here to reset your password: {{.ResetLink}}\n",
+		`<p><a href="{{.ResetLink}}">Click here to reset your password</a></p>`)
+
+	notificationTemplate = MustMailTemplate("notification",
+		"{{.Subject}}",
+		"{{.Message}}\n",
+		"<p>{{.Message}}</p>")
+)
+
+type emailService struct {
+	transport    MailTransport
+	from         string
+	resetBaseURL string
+}
+
+func NewEmailService(transport MailTransport, from, resetBaseURL string) EmailService {
+	return &emailService{transport: transport, from: from, resetBaseURL: resetBaseURL}
+}
+
+func (es *emailService) SendWelcomeEmail(ctx context.Context, email, name string) error {
+	return es.send(ctx, welcomeTemplate, email, map[string]string{"Name": name})
+}
+
+func (es *emailService) SendPasswordResetEmail(ctx context.Context, email, resetToken string) error {
+	link := es.resetBaseURL + "?token=" + url.QueryEscape(resetToken)
+	return es.send(ctx, passwordResetTemplate, email, map[string]string{"ResetLink": link})
+}
+
+func (es *emailService) SendNotificationEmail(ctx context.Context, email, subject, message string) error {
+	return es.send(ctx, notificationTemplate, email, map[string]string{"Subject": subject, "Message": message})
+}
+
+func (es *emailService) send(ctx context.Context, template *MailTemplate, email string, data interface{}) error {
+	message, err := template.Render(es.from, []string{email}, data)
+	if err != nil {
+		return err
+	}
+	return es.transport.Send(ctx, message)
+}
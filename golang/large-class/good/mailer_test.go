@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer accepts SMTP sessions on a local port and records every
+// message it is given. rejectRcpt makes the next n RCPT commands fail with
+// a temporary error.
+type fakeSMTPServer struct {
+	listener net.Listener
+
+	mu         sync.Mutex
+	silent     bool
+	rejectRcpt int
+	received   []receivedMail
+}
+
+type receivedMail struct {
+	from string
+	to   []string
+	data string
+}
+
+func startFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeSMTPServer{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) messages() []receivedMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]receivedMail(nil), s.received...)
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	s.mu.Lock()
+	silent := s.silent
+	s.mu.Unlock()
+	if silent {
+		// Hold the connection open without ever greeting the client.
+		conn.Read(make([]byte, 1))
+		return
+	}
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 fake.test ESMTP")
+	var mail receivedMail
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 fake.test")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			mail = receivedMail{from: strings.Trim(line[len("MAIL FROM:"):], "<>")}
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			s.mu.Lock()
+			reject := s.rejectRcpt > 0
+			if reject {
+				s.rejectRcpt--
+			}
+			s.mu.Unlock()
+			if reject {
+				reply("451 try again later")
+				continue
+			}
+			mail.to = append(mail.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 end with <CRLF>.<CRLF>")
+			var data strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(dataLine, "."))
+			}
+			mail.data = data.String()
+			s.mu.Lock()
+			s.received = append(s.received, mail)
+			s.mu.Unlock()
+			reply("250 queued")
+		case command == "RSET":
+			mail = receivedMail{}
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func testMessage() MailMessage {
+	return MailMessage{
+		From:     "statements@example.com",
+		To:       []string{"john@example.com"},
+		Subject:  "Your statement",
+		TextBody: "Balance: $12.00",
+		HTMLBody: "<p>Balance: <b>$12.00</b></p>",
+		Date:     time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestSMTPTransportDeliversMessage(t *testing.T) {
+	server := startFakeSMTPServer(t)
+	transport := NewSMTPTransport("127.0.0.1", server.port(), "", "")
+
+	if err := transport.Send(context.Background(), testMessage()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	received := server.messages()
+	if len(received) != 1 {
+		t.Fatalf("server received %d messages, want 1", len(received))
+	}
+	mail := received[0]
+	if mail.from != "statements@example.com" {
+		t.Errorf("MAIL FROM = %q", mail.from)
+	}
+	if len(mail.to) != 1 || mail.to[0] != "john@example.com" {
+		t.Errorf("RCPT TO = %v", mail.to)
+	}
+	for _, want := range []string{
+		"Subject: Your statement\r\n",
+		"Content-Type: multipart/alternative; boundary=",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Type: text/html; charset=utf-8",
+		"Balance: $12.00",
+	} {
+		if !strings.Contains(mail.data, want) {
+			t.Errorf("message is missing %q:\n%s", want, mail.data)
+		}
+	}
+}
+
+func TestSMTPTransportStopsWhenContextEnds(t *testing.T) {
+	server := startFakeSMTPServer(t)
+	server.mu.Lock()
+	server.silent = true
+	server.mu.Unlock()
+	transport := NewSMTPTransport("127.0.0.1", server.port(), "", "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := transport.Send(ctx, testMessage())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Send against a silent server = %v, want deadline exceeded", err)
+	}
+}
+
+func TestOutboxRetriesTemporaryFailures(t *testing.T) {
+	server := startFakeSMTPServer(t)
+	server.mu.Lock()
+	server.rejectRcpt = 1
+	server.mu.Unlock()
+	transport := NewSMTPTransport("127.0.0.1", server.port(), "", "")
+
+	outbox, err := NewOutbox(t.TempDir(), transport, 3, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC)
+	outbox.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	if err := outbox.Send(ctx, testMessage()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	pending, err := outbox.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Attempts != 1 || !strings.Contains(pending[0].LastError, "451") {
+		t.Fatalf("after a rejected delivery pending = %+v", pending)
+	}
+
+	if delivered, err := outbox.Flush(ctx); err != nil || delivered != 0 {
+		t.Fatalf("Flush before the retry is due = %d, %v", delivered, err)
+	}
+	now = now.Add(time.Minute)
+	if delivered, err := outbox.Flush(ctx); err != nil || delivered != 1 {
+		t.Fatalf("Flush after backoff = %d, %v", delivered, err)
+	}
+	if pending, _ := outbox.Pending(); len(pending) != 0 {
+		t.Errorf("%d messages still pending after delivery", len(pending))
+	}
+	if received := server.messages(); len(received) != 1 {
+		t.Errorf("server received %d messages, want 1", len(received))
+	}
+}
+
+type failingTransport struct{}
+
+func (failingTransport) Send(ctx context.Context, message MailMessage) error {
+	return errors.New("550 mailbox unavailable")
+}
+
+func TestOutboxParksMessagesThatKeepFailing(t *testing.T) {
+	dir := t.TempDir()
+	outbox, err := NewOutbox(dir, failingTransport{}, 2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC)
+	outbox.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	if err := outbox.Send(ctx, testMessage()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	now = now.Add(time.Minute)
+	if delivered, err := outbox.Flush(ctx); err != nil || delivered != 0 {
+		t.Fatalf("Flush of a message that fails for good = %d, %v; want 0 delivered", delivered, err)
+	}
+	if pending, _ := outbox.Pending(); len(pending) != 0 {
+		t.Errorf("%d messages still pending after the last attempt", len(pending))
+	}
+
+	failed, err := filepath.Glob(filepath.Join(dir, "failed", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 {
+		t.Fatalf("%d messages in failed/, want 1", len(failed))
+	}
+	data, err := os.ReadFile(failed[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry OutboxEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Attempts != 2 || !strings.Contains(entry.LastError, "550") {
+		t.Errorf("parked entry = %+v, want 2 attempts and the last error", entry)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Test vectors from RFC 7914, section 12. The largest (N=2^20) is left out
+// to keep the suite fast.
+func TestScryptMatchesRFC7914(t *testing.T) {
+	for _, tc := range []struct {
+		password, salt string
+		logN           uint8
+		r, p           int
+		want           string
+	}{
+		{"", "", 4, 1, 1, "77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+		{"password", "NaCl", 10, 8, 16, "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b3731622eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+		{"pleaseletmein", "SodiumChloride", 14, 8, 1, "7023bdcb3afd7348461c06cd81fd38ebfda8fbba904f8e3ea9b543f6545da1f2d5432955613f0fcf62d49705242a9af9e61e85dc0d651e40dfcf017b45575887"},
+	} {
+		got, err := scrypt([]byte(tc.password), []byte(tc.salt), tc.logN, tc.r, tc.p, 64)
+		if err != nil {
+			t.Fatalf("scrypt(%q, %q): %v", tc.password, tc.salt, err)
+		}
+		if hex.EncodeToString(got) != tc.want {
+			t.Errorf("scrypt(%q, %q, N=2^%d, r=%d, p=%d) = %x, want %s", tc.password, tc.salt, tc.logN, tc.r, tc.p, got, tc.want)
+		}
+	}
+}
+
+func TestScryptHasherRoundTrip(t *testing.T) {
+	hasher := NewScryptHasher(ScryptParams{LogN: 10, R: 8, P: 1, SaltLen: 16, KeyLen: 32})
+	encoded, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if needsRehash, err := hasher.Verify("correct horse", encoded); err != nil || needsRehash {
+		t.Errorf("Verify(right password) = %t, %v", needsRehash, err)
+	}
+	if _, err := hasher.Verify("battery staple", encoded); !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("Verify(wrong password) = %v, want ErrPasswordMismatch", err)
+	}
+
+	stronger := NewScryptHasher(ScryptParams{LogN: 11, R: 8, P: 1, SaltLen: 16, KeyLen: 32})
+	if needsRehash, err := stronger.Verify("correct horse", encoded); err != nil || !needsRehash {
+		t.Errorf("Verify with raised cost = %t, %v, want a rehash", needsRehash, err)
+	}
+}
+
+func TestScryptHasherAcceptsLegacySHA256(t *testing.T) {
+	hasher := NewScryptHasher(ScryptParams{LogN: 10, R: 8, P: 1, SaltLen: 16, KeyLen: 32})
+	// A bare SHA-256 digest, as the service used to store passwords.
+	sum := sha256.Sum256([]byte("correct horse"))
+	encoded := strings.ToUpper(hex.EncodeToString(sum[:]))
+	if needsRehash, err := hasher.Verify("correct horse", encoded); err != nil || !needsRehash {
+		t.Errorf("Verify(legacy digest) = %t, %v, want a match that needs a rehash", needsRehash, err)
+	}
+	if _, err := hasher.Verify("battery staple", encoded); !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("Verify(wrong password, legacy digest) = %v, want ErrPasswordMismatch", err)
+	}
+	if _, err := hasher.Verify("correct horse", encoded[:63]); !errors.Is(err, ErrUnknownHashFormat) {
+		t.Errorf("Verify(63 hex digits) = %v, want ErrUnknownHashFormat", err)
+	}
+}
+
+func TestScryptHasherRejectsCostlyStoredParameters(t *testing.T) {
+	hasher := NewScryptHasher(DefaultScryptParams)
+	const salt, key = "c2FsdHNhbHRzYWx0c2FsdA", "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	for _, params := range []string{
+		"ln=20,r=1024,p=1", // 128 GiB
+		"ln=17,r=8,p=1",    // 128 MiB
+		"ln=14,r=8,p=1000", // too many passes
+	} {
+		if _, err := hasher.Verify("x", "$scrypt$"+params+"$"+salt+"$"+key); !errors.Is(err, ErrUnknownHashFormat) {
+			t.Errorf("Verify with %s = %v, want ErrUnknownHashFormat", params, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidResetToken covers unknown, used and superseded tokens
+	// alike, so the error says nothing about which tokens exist.
+	ErrInvalidResetToken = errors.New("invalid password reset token")
+	ErrResetTokenExpired = errors.New("password reset token expired")
+	ErrResetRateLimited  = errors.New("too many password reset requests")
+)
+
+// PasswordReset is a stored reset request. The token itself is only ever
+// sent to the user; the store keeps its SHA-256 hash.
+type PasswordReset struct {
+	TokenHash string
+	UserId    int
+	Email     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+func (r PasswordReset) Used() bool {
+	return r.UsedAt != nil
+}
+
+// PasswordResetConfig limits each email address to MaxRequests resets per
+// Window.
+type PasswordResetConfig struct {
+	TokenTTL    time.Duration
+	MaxRequests int
+	Window      time.Duration
+}
+
+var DefaultPasswordResetConfig = PasswordResetConfig{
+	TokenTTL:    30 * time.Minute,
+	MaxRequests: 3,
+	Window:      time.Hour,
+}
+
+type PasswordResetManager struct {
+	store  PasswordResetStore
+	config PasswordResetConfig
+	now    func() time.Time
+}
+
+func NewPasswordResetManager(store PasswordResetStore, config PasswordResetConfig) *PasswordResetManager {
+	return &PasswordResetManager{store: store, config: config, now: time.Now}
+}
+
+// Issue creates a reset token for the user and returns it in plain form
+// for the email.
+func (pm *PasswordResetManager) Issue(ctx context.Context, userId int, email string) (string, error) {
+	now := pm.now()
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	reset := &PasswordReset{
+		TokenHash: hashToken(token),
+		UserId:    userId,
+		Email:     resetEmailKey(email),
+		CreatedAt: now,
+		ExpiresAt: now.Add(pm.config.TokenTTL),
+	}
+	created, err := pm.store.CreateLimited(ctx, reset, now.Add(-pm.config.Window), pm.config.MaxRequests)
+	if err != nil {
+		return "", err
+	}
+	if !created {
+		return "", ErrResetRateLimited
+	}
+	return token, nil
+}
+
+// Redeem uses up the token and hands the user it was issued to to apply.
+// If apply fails the token is released so the user can try it again;
+// otherwise any other outstanding tokens of that user stop working too.
+func (pm *PasswordResetManager) Redeem(ctx context.Context, token string, apply func(userId int) error) error {
+	reset, err := pm.store.Find(ctx, hashToken(token))
+	if errors.Is(err, ErrNotFound) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+	now := pm.now()
+	if reset.Used() {
+		return ErrInvalidResetToken
+	}
+	if !now.Before(reset.ExpiresAt) {
+		return ErrResetTokenExpired
+	}
+
+	// MarkUsed only succeeds for one caller, so a token clicked twice at
+	// once still resets the password once.
+	err = pm.store.MarkUsed(ctx, reset.TokenHash, now)
+	if errors.Is(err, ErrConflict) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+	if err := apply(reset.UserId); err != nil {
+		if releaseErr := pm.store.Release(ctx, reset.TokenHash); releaseErr != nil {
+			return errors.Join(err, releaseErr)
+		}
+		return err
+	}
+	return pm.store.InvalidateUser(ctx, reset.UserId, now)
+}
+
+func resetEmailKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PasswordResetStore persists reset tokens by hash.
+type PasswordResetStore interface {
+	// CreateLimited stores the reset unless its email already has max
+	// resets issued since the given time, used or not. Counting and storing
+	// happen as one step, so concurrent requests cannot overshoot max. It
+	// reports whether the reset was stored.
+	CreateLimited(ctx context.Context, reset *PasswordReset, since time.Time, max int) (bool, error)
+	Find(ctx context.Context, tokenHash string) (*PasswordReset, error)
+	// MarkUsed fails with ErrConflict if the token was already used.
+	MarkUsed(ctx context.Context, tokenHash string, at time.Time) error
+	// Release undoes MarkUsed for a reset that could not be completed.
+	Release(ctx context.Context, tokenHash string) error
+	// InvalidateUser marks every unused token of the user as used.
+	InvalidateUser(ctx context.Context, userId int, at time.Time) error
+}
+
+type memoryPasswordResetStore struct {
+	mu     sync.Mutex
+	resets map[string]*PasswordReset
+}
+
+func NewMemoryPasswordResetStore() PasswordResetStore {
+	return &memoryPasswordResetStore{resets: map[string]*PasswordReset{}}
+}
+
+func (s *memoryPasswordResetStore) CreateLimited(ctx context.Context, reset *PasswordReset, since time.Time, max int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.resets[reset.TokenHash]; exists {
+		return false, repositoryError("create password reset", ErrConflict, nil)
+	}
+	recent := 0
+	for _, stored := range s.resets {
+		if stored.Email == reset.Email && !stored.CreatedAt.Before(since) {
+			recent++
+		}
+	}
+	if recent >= max {
+		return false, nil
+	}
+	stored := *reset
+	s.resets[reset.TokenHash] = &stored
+	return true, nil
+}
+
+func (s *memoryPasswordResetStore) Find(ctx context.Context, tokenHash string) (*PasswordReset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.resets[tokenHash]
+	if !ok {
+		return nil, repositoryError("find password reset", ErrNotFound, nil)
+	}
+	reset := *stored
+	return &reset, nil
+}
+
+func (s *memoryPasswordResetStore) MarkUsed(ctx context.Context, tokenHash string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.resets[tokenHash]
+	if !ok {
+		return repositoryError("use password reset", ErrNotFound, nil)
+	}
+	if stored.Used() {
+		return repositoryError("use password reset", ErrConflict, fmt.Errorf("already used"))
+	}
+	stored.UsedAt = &at
+	return nil
+}
+
+func (s *memoryPasswordResetStore) Release(ctx context.Context, tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.resets[tokenHash]
+	if !ok {
+		return repositoryError("release password reset", ErrNotFound, nil)
+	}
+	stored.UsedAt = nil
+	return nil
+}
+
+func (s *memoryPasswordResetStore) InvalidateUser(ctx context.Context, userId int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, stored := range s.resets {
+		if stored.UserId == userId && !stored.Used() {
+			stored.UsedAt = &at
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// sqlPasswordResetStore keeps resets in a password_resets table keyed by
+// token_hash.
+type sqlPasswordResetStore struct {
+	db      Database
+	dialect Dialect
+}
+
+// passwordResetSchema creates the table NewSQLPasswordResetStore expects.
+var passwordResetSchema = []string{
+	`CREATE TABLE password_resets (
+		token_hash TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		email TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		used BOOLEAN NOT NULL,
+		used_at TIMESTAMP
+	)`,
+}
+
+func NewSQLPasswordResetStore(db Database, dialect Dialect) PasswordResetStore {
+	return &sqlPasswordResetStore{db: db, dialect: dialect}
+}
+
+// CreateLimited counts and inserts in one serializable transaction, so two
+// requests for the same email cannot both see room under max.
+func (s *sqlPasswordResetStore) CreateLimited(ctx context.Context, reset *PasswordReset, since time.Time, max int) (bool, error) {
+	const op = "create password reset"
+	created := false
+	err := inTx(ctx, s.db, &sql.TxOptions{Isolation: sql.LevelSerializable}, op, func(db Database) error {
+		count, err := s.countSince(ctx, db, reset.Email, since)
+		if err != nil {
+			return err
+		}
+		if count >= max {
+			return nil
+		}
+		_, err = db.ExecContext(ctx, rebind(s.dialect, `
+			INSERT INTO password_resets (token_hash, user_id, email, created_at, expires_at, used, used_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`),
+			reset.TokenHash, reset.UserId, reset.Email, reset.CreatedAt, reset.ExpiresAt, false, nil)
+		if err != nil {
+			return repositoryError(op, ErrConnection, err)
+		}
+		created = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return created, nil
+}
+
+func (s *sqlPasswordResetStore) Find(ctx context.Context, tokenHash string) (*PasswordReset, error) {
+	const op = "find password reset"
+	rows, err := s.db.QueryContext(ctx, rebind(s.dialect, `
+		SELECT token_hash, user_id, email, created_at, expires_at, used_at
+		FROM password_resets WHERE token_hash = ?`), tokenHash)
+	if err != nil {
+		return nil, repositoryError(op, ErrConnection, err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, repositoryError(op, ErrConnection, err)
+		}
+		return nil, repositoryError(op, ErrNotFound, nil)
+	}
+
+	var reset PasswordReset
+	var usedAt sql.NullTime
+	if err := rows.Scan(&reset.TokenHash, &reset.UserId, &reset.Email, &reset.CreatedAt, &reset.ExpiresAt, &usedAt); err != nil {
+		return nil, repositoryError(op, ErrScan, err)
+	}
+	if usedAt.Valid {
+		reset.UsedAt = &usedAt.Time
+	}
+	return &reset, nil
+}
+
+func (s *sqlPasswordResetStore) MarkUsed(ctx context.Context, tokenHash string, at time.Time) error {
+	const op = "use password reset"
+	result, err := s.db.ExecContext(ctx, rebind(s.dialect,
+		"UPDATE password_resets SET used = ?, used_at = ? WHERE token_hash = ? AND used = ?"),
+		true, at, tokenHash, false)
+	if err != nil {
+		return repositoryError(op, ErrConnection, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return repositoryError(op, ErrConnection, err)
+	}
+	if affected == 0 {
+		return repositoryError(op, ErrConflict, fmt.Errorf("already used"))
+	}
+	return nil
+}
+
+func (s *sqlPasswordResetStore) Release(ctx context.Context, tokenHash string) error {
+	const op = "release password reset"
+	result, err := s.db.ExecContext(ctx, rebind(s.dialect,
+		"UPDATE password_resets SET used = ?, used_at = ? WHERE token_hash = ?"), false, nil, tokenHash)
+	if err != nil {
+		return repositoryError(op, ErrConnection, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return repositoryError(op, ErrConnection, err)
+	}
+	if affected == 0 {
+		return repositoryError(op, ErrNotFound, nil)
+	}
+	return nil
+}
+
+func (s *sqlPasswordResetStore) InvalidateUser(ctx context.Context, userId int, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, rebind(s.dialect,
+		"UPDATE password_resets SET used = ?, used_at = ? WHERE user_id = ? AND used = ?"),
+		true, at, userId, false)
+	if err != nil {
+		return repositoryError("invalidate password resets", ErrConnection, err)
+	}
+	return nil
+}
+
+func (s *sqlPasswordResetStore) countSince(ctx context.Context, db Database, email string, since time.Time) (int, error) {
+	const op = "count password resets"
+	rows, err := db.QueryContext(ctx, rebind(s.dialect,
+		"SELECT COUNT(*) FROM password_resets WHERE email = ? AND created_at >= ?"), email, since)
+	if err != nil {
+		return 0, repositoryError(op, ErrConnection, err)
+	}
+	defer rows.Close()
+	var count int
+	if rows.Next() {
+		if err := rows.Scan(&count); err != nil {
+			return 0, repositoryError(op, ErrScan, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, repositoryError(op, ErrConnection, err)
+	}
+	return count, nil
+}
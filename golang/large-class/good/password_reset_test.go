@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentResetRequestsStayWithinLimit(t *testing.T) {
+	stores := map[string]func(t *testing.T) PasswordResetStore{
+		"memory": func(t *testing.T) PasswordResetStore { return NewMemoryPasswordResetStore() },
+		"sql": func(t *testing.T) PasswordResetStore {
+			return NewSQLPasswordResetStore(openTestDB(t, passwordResetSchema), Postgres)
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			pm := NewPasswordResetManager(newStore(t), DefaultPasswordResetConfig)
+
+			var (
+				wg      sync.WaitGroup
+				mu      sync.Mutex
+				issued  int
+				limited int
+			)
+			for range 20 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := pm.Issue(ctx, 1, "ada@example.com")
+					mu.Lock()
+					defer mu.Unlock()
+					switch {
+					case err == nil:
+						issued++
+					case errors.Is(err, ErrResetRateLimited):
+						limited++
+					default:
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if issued != DefaultPasswordResetConfig.MaxRequests {
+				t.Errorf("issued %d tokens, want %d", issued, DefaultPasswordResetConfig.MaxRequests)
+			}
+			if issued+limited != 20 {
+				t.Errorf("%d issued and %d limited, want 20 in all", issued, limited)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidToken   = errors.New("invalid session token")
+	ErrSessionExpired = errors.New("session expired")
+	ErrSessionRevoked = errors.New("session revoked")
+	// ErrRefreshReused means a refresh token was presented after it had
+	// already been rotated. Either the client raced itself or the token
+	// was stolen; the session is revoked either way.
+	ErrRefreshReused = errors.New("refresh token already used")
+)
+
+// Session is the server-side record behind a pair of opaque tokens. Only
+// SHA-256 hashes of the tokens are stored, so a leaked table cannot be
+// replayed.
+type Session struct {
+	Id              string
+	UserId          int
+	AccessHash      string
+	RefreshHash     string
+	Generation      int
+	CreatedAt       time.Time
+	LastSeenAt      time.Time
+	AccessExpiresAt time.Time
+	ExpiresAt       time.Time
+	RevokedAt       *time.Time
+	RevokeReason    string
+}
+
+func (s Session) Revoked() bool {
+	return s.RevokedAt != nil
+}
+
+// SessionTokens is what the client gets: a short-lived access token for
+// requests and a refresh token to trade for a new pair.
+type SessionTokens struct {
+	SessionId       string
+	AccessToken     string
+	RefreshToken    string
+	AccessExpiresAt time.Time
+	ExpiresAt       time.Time
+}
+
+// SessionConfig sets how long sessions live. A session ends when it has
+// been idle for IdleTimeout or has existed for AbsoluteTimeout, whichever
+// comes first; refreshing does not extend the absolute limit.
+type SessionConfig struct {
+	AccessTTL       time.Duration
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+}
+
+var DefaultSessionConfig = SessionConfig{
+	AccessTTL:       15 * time.Minute,
+	IdleTimeout:     7 * 24 * time.Hour,
+	AbsoluteTimeout: 30 * 24 * time.Hour,
+}
+
+type SessionManager struct {
+	store  SessionStore
+	config SessionConfig
+	now    func() time.Time
+}
+
+func NewSessionManager(store SessionStore, config SessionConfig) *SessionManager {
+	return &SessionManager{store: store, config: config, now: time.Now}
+}
+
+func (sm *SessionManager) Issue(ctx context.Context, userId int) (*SessionTokens, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	now := sm.now()
+	session := &Session{
+		Id:         id,
+		UserId:     userId,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(sm.config.AbsoluteTimeout),
+	}
+	tokens, err := sm.rotate(session, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := sm.store.Create(ctx, session); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Validate returns the live session for an access token and records the
+// activity for idle expiry.
+func (sm *SessionManager) Validate(ctx context.Context, accessToken string) (*Session, error) {
+	session, err := sm.store.FindByAccessHash(ctx, hashToken(accessToken))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := sm.now()
+	if err := sm.checkLive(session, now); err != nil {
+		return nil, err
+	}
+	if !now.Before(session.AccessExpiresAt) {
+		return nil, ErrSessionExpired
+	}
+	session.LastSeenAt = now
+	if err := sm.store.Touch(ctx, session.Id, now); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Refresh trades a refresh token for a new token pair. The old pair stops
+// working immediately; presenting the old refresh token again revokes the
+// session.
+func (sm *SessionManager) Refresh(ctx context.Context, refreshToken string) (*SessionTokens, error) {
+	presented := hashToken(refreshToken)
+	session, err := sm.store.FindByRefreshHash(ctx, presented)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := sm.now()
+	if err := sm.checkLive(session, now); err != nil {
+		return nil, err
+	}
+	if session.RefreshHash != presented {
+		return nil, sm.revokeReused(ctx, session, now)
+	}
+
+	tokens, err := sm.rotate(session, now)
+	if err != nil {
+		return nil, err
+	}
+	session.LastSeenAt = now
+	err = sm.store.Rotate(ctx, session)
+	if errors.Is(err, ErrConflict) {
+		// Another refresh with the same token got there first.
+		return nil, sm.revokeReused(ctx, session, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Logout revokes the session the access token belongs to. Expired access
+// tokens are accepted, so a client can always sign out.
+func (sm *SessionManager) Logout(ctx context.Context, accessToken string) (*Session, error) {
+	session, err := sm.store.FindByAccessHash(ctx, hashToken(accessToken))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := sm.store.Revoke(ctx, session.Id, "logout", sm.now()); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// RevokeUser ends every session of the user, e.g. after a password change.
+func (sm *SessionManager) RevokeUser(ctx context.Context, userId int, reason string) error {
+	return sm.store.RevokeUser(ctx, userId, reason, sm.now())
+}
+
+func (sm *SessionManager) revokeReused(ctx context.Context, session *Session, now time.Time) error {
+	if err := sm.store.Revoke(ctx, session.Id, "refresh token reused", now); err != nil {
+		return err
+	}
+	return ErrRefreshReused
+}
+
+func (sm *SessionManager) checkLive(session *Session, now time.Time) error {
+	switch {
+	case session.Revoked():
+		return ErrSessionRevoked
+	case !now.Before(session.ExpiresAt):
+		return ErrSessionExpired
+	case now.Sub(session.LastSeenAt) >= sm.config.IdleTimeout:
+		return ErrSessionExpired
+	}
+	return nil
+}
+
+// rotate gives the session a fresh token pair and returns the plain
+// tokens, which exist nowhere else after this.
+func (sm *SessionManager) rotate(session *Session, now time.Time) (*SessionTokens, error) {
+	access, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	session.AccessHash = hashToken(access)
+	session.RefreshHash = hashToken(refresh)
+	session.Generation++
+	session.AccessExpiresAt = now.Add(sm.config.AccessTTL)
+	if session.AccessExpiresAt.After(session.ExpiresAt) {
+		session.AccessExpiresAt = session.ExpiresAt
+	}
+	return &SessionTokens{
+		SessionId:       session.Id,
+		AccessToken:     access,
+		RefreshToken:    refresh,
+		AccessExpiresAt: session.AccessExpiresAt,
+		ExpiresAt:       session.ExpiresAt,
+	}, nil
+}
+
+func randomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SessionStore persists sessions. Lookups return an error matching
+// ErrNotFound when nothing matches.
+type SessionStore interface {
+	Create(ctx context.Context, session *Session) error
+	FindByAccessHash(ctx context.Context, accessHash string) (*Session, error)
+	// FindByRefreshHash also matches refresh tokens the session has since
+	// rotated away from, so a replayed one can be traced to its session.
+	FindByRefreshHash(ctx context.Context, refreshHash string) (*Session, error)
+	// Rotate saves the session's new tokens and expiry. It fails with
+	// ErrConflict unless the stored session is still at Generation-1.
+	Rotate(ctx context.Context, session *Session) error
+	Touch(ctx context.Context, sessionId string, at time.Time) error
+	Revoke(ctx context.Context, sessionId, reason string, at time.Time) error
+	RevokeUser(ctx context.Context, userId int, reason string, at time.Time) error
+}
+
+type memorySessionStore struct {
+	mu        sync.Mutex
+	sessions  map[string]*Session
+	byAccess  map[string]string
+	byRefresh map[string]string
+}
+
+func NewMemorySessionStore() SessionStore {
+	return &memorySessionStore{
+		sessions:  map[string]*Session{},
+		byAccess:  map[string]string{},
+		byRefresh: map[string]string{},
+	}
+}
+
+func (s *memorySessionStore) Create(ctx context.Context, session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.sessions[session.Id]; exists {
+		return repositoryError("create session", ErrConflict, fmt.Errorf("session %s exists", session.Id))
+	}
+	stored := *session
+	s.sessions[session.Id] = &stored
+	s.byAccess[session.AccessHash] = session.Id
+	s.byRefresh[session.RefreshHash] = session.Id
+	return nil
+}
+
+func (s *memorySessionStore) FindByAccessHash(ctx context.Context, accessHash string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.find("find session by access token", s.byAccess[accessHash])
+}
+
+func (s *memorySessionStore) FindByRefreshHash(ctx context.Context, refreshHash string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.find("find session by refresh token", s.byRefresh[refreshHash])
+}
+
+func (s *memorySessionStore) find(op, sessionId string) (*Session, error) {
+	stored, ok := s.sessions[sessionId]
+	if !ok {
+		return nil, repositoryError(op, ErrNotFound, nil)
+	}
+	session := *stored
+	return &session, nil
+}
+
+func (s *memorySessionStore) Rotate(ctx context.Context, session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.sessions[session.Id]
+	if !ok {
+		return repositoryError("rotate session", ErrNotFound, fmt.Errorf("session %s", session.Id))
+	}
+	if stored.Generation != session.Generation-1 {
+		return repositoryError("rotate session", ErrConflict, fmt.Errorf("session %s", session.Id))
+	}
+	// Only the current access token is valid, but every refresh token the
+	// session has had stays indexed for reuse detection.
+	delete(s.byAccess, stored.AccessHash)
+	s.byAccess[session.AccessHash] = session.Id
+	s.byRefresh[session.RefreshHash] = session.Id
+	stored.AccessHash = session.AccessHash
+	stored.RefreshHash = session.RefreshHash
+	stored.Generation = session.Generation
+	stored.AccessExpiresAt = session.AccessExpiresAt
+	stored.LastSeenAt = session.LastSeenAt
+	return nil
+}
+
+func (s *memorySessionStore) Touch(ctx context.Context, sessionId string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.sessions[sessionId]
+	if !ok {
+		return repositoryError("touch session", ErrNotFound, fmt.Errorf("session %s", sessionId))
+	}
+	if at.After(stored.LastSeenAt) {
+		stored.LastSeenAt = at
+	}
+	return nil
+}
+
+func (s *memorySessionStore) Revoke(ctx context.Context, sessionId, reason string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.sessions[sessionId]
+	if !ok {
+		return repositoryError("revoke session", ErrNotFound, fmt.Errorf("session %s", sessionId))
+	}
+	revoke(stored, reason, at)
+	return nil
+}
+
+func (s *memorySessionStore) RevokeUser(ctx context.Context, userId int, reason string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, stored := range s.sessions {
+		if stored.UserId == userId {
+			revoke(stored, reason, at)
+		}
+	}
+	return nil
+}
+
+// revoke keeps the first revocation; later ones would only blur why the
+// session ended.
+func revoke(session *Session, reason string, at time.Time) {
+	if session.Revoked() {
+		return
+	}
+	session.RevokedAt = &at
+	session.RevokeReason = reason
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// sqlSessionStore keeps sessions in a sessions table and every refresh
+// token hash a session has been given in session_refresh_tokens.
+type sqlSessionStore struct {
+	db      Database
+	dialect Dialect
+}
+
+// sessionSchema creates the tables NewSQLSessionStore expects.
+var sessionSchema = []string{
+	`CREATE TABLE sessions (
+		id TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		access_hash TEXT NOT NULL UNIQUE,
+		refresh_hash TEXT NOT NULL,
+		generation INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		last_seen_at TIMESTAMP NOT NULL,
+		access_expires_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		revoked BOOLEAN NOT NULL,
+		revoked_at TIMESTAMP,
+		revoke_reason TEXT NOT NULL
+	)`,
+	`CREATE TABLE session_refresh_tokens (
+		token_hash TEXT PRIMARY KEY,
+		session_id TEXT NOT NULL,
+		generation INTEGER NOT NULL
+	)`,
+}
+
+func NewSQLSessionStore(db Database, dialect Dialect) SessionStore {
+	return &sqlSessionStore{db: db, dialect: dialect}
+}
+
+func (s *sqlSessionStore) Create(ctx context.Context, session *Session) error {
+	return inTx(ctx, s.db, nil, "create session", func(db Database) error {
+		_, err := db.ExecContext(ctx, rebind(s.dialect, `
+			INSERT INTO sessions (id, user_id, access_hash, refresh_hash, generation, created_at,
+				last_seen_at, access_expires_at, expires_at, revoked, revoked_at, revoke_reason)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+			session.Id, session.UserId, session.AccessHash, session.RefreshHash, session.Generation, session.CreatedAt,
+			session.LastSeenAt, session.AccessExpiresAt, session.ExpiresAt, false, nil, "")
+		if err != nil {
+			return repositoryError("create session", ErrConnection, err)
+		}
+		return s.recordRefreshHash(ctx, db, session)
+	})
+}
+
+func (s *sqlSessionStore) recordRefreshHash(ctx context.Context, db Database, session *Session) error {
+	_, err := db.ExecContext(ctx, rebind(s.dialect,
+		"INSERT INTO session_refresh_tokens (token_hash, session_id, generation) VALUES (?, ?, ?)"),
+		session.RefreshHash, session.Id, session.Generation)
+	if err != nil {
+		return repositoryError("record refresh token", ErrConnection, err)
+	}
+	return nil
+}
+
+func (s *sqlSessionStore) FindByAccessHash(ctx context.Context, accessHash string) (*Session, error) {
+	return s.load(ctx, "find session by access token", "access_hash", accessHash)
+}
+
+func (s *sqlSessionStore) FindByRefreshHash(ctx context.Context, refreshHash string) (*Session, error) {
+	const op = "find session by refresh token"
+	rows, err := s.db.QueryContext(ctx, rebind(s.dialect,
+		"SELECT session_id FROM session_refresh_tokens WHERE token_hash = ?"), refreshHash)
+	if err != nil {
+		return nil, repositoryError(op, ErrConnection, err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, repositoryError(op, ErrConnection, err)
+		}
+		return nil, repositoryError(op, ErrNotFound, nil)
+	}
+	var sessionId string
+	if err := rows.Scan(&sessionId); err != nil {
+		return nil, repositoryError(op, ErrScan, err)
+	}
+	rows.Close()
+	return s.load(ctx, op, "id", sessionId)
+}
+
+// load reads one session by a column the caller controls; only the value
+// comes from outside.
+func (s *sqlSessionStore) load(ctx context.Context, op, column, value string) (*Session, error) {
+	rows, err := s.db.QueryContext(ctx, rebind(s.dialect, `
+		SELECT id, user_id, access_hash, refresh_hash, generation, created_at,
+			last_seen_at, access_expires_at, expires_at, revoked_at, revoke_reason
+		FROM sessions WHERE `+column+` = ?`), value)
+	if err != nil {
+		return nil, repositoryError(op, ErrConnection, err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, repositoryError(op, ErrConnection, err)
+		}
+		return nil, repositoryError(op, ErrNotFound, nil)
+	}
+
+	var session Session
+	var revokedAt sql.NullTime
+	err = rows.Scan(&session.Id, &session.UserId, &session.AccessHash, &session.RefreshHash, &session.Generation,
+		&session.CreatedAt, &session.LastSeenAt, &session.AccessExpiresAt, &session.ExpiresAt, &revokedAt, &session.RevokeReason)
+	if err != nil {
+		return nil, repositoryError(op, ErrScan, err)
+	}
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+	return &session, nil
+}
+
+func (s *sqlSessionStore) Rotate(ctx context.Context, session *Session) error {
+	const op = "rotate session"
+	return inTx(ctx, s.db, nil, op, func(db Database) error {
+		result, err := db.ExecContext(ctx, rebind(s.dialect, `
+			UPDATE sessions SET access_hash = ?, refresh_hash = ?, generation = ?, access_expires_at = ?, last_seen_at = ?
+			WHERE id = ? AND generation = ?`),
+			session.AccessHash, session.RefreshHash, session.Generation, session.AccessExpiresAt, session.LastSeenAt,
+			session.Id, session.Generation-1)
+		if err != nil {
+			return repositoryError(op, ErrConnection, err)
+		}
+		if err := s.checkAffected(ctx, db, op, session.Id, result, ErrConflict); err != nil {
+			return err
+		}
+		return s.recordRefreshHash(ctx, db, session)
+	})
+}
+
+func (s *sqlSessionStore) Touch(ctx context.Context, sessionId string, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, rebind(s.dialect,
+		"UPDATE sessions SET last_seen_at = ? WHERE id = ? AND last_seen_at < ?"), at, sessionId, at)
+	if err != nil {
+		return repositoryError("touch session", ErrConnection, err)
+	}
+	return nil
+}
+
+// Revocations only touch sessions that are still live, so the first reason
+// recorded is the one kept.
+func (s *sqlSessionStore) Revoke(ctx context.Context, sessionId, reason string, at time.Time) error {
+	const op = "revoke session"
+	result, err := s.db.ExecContext(ctx, rebind(s.dialect, `
+		UPDATE sessions SET revoked = ?, revoked_at = ?, revoke_reason = ?
+		WHERE id = ? AND revoked = ?`), true, at, reason, sessionId, false)
+	if err != nil {
+		return repositoryError(op, ErrConnection, err)
+	}
+	return s.checkAffected(ctx, s.db, op, sessionId, result, nil)
+}
+
+func (s *sqlSessionStore) RevokeUser(ctx context.Context, userId int, reason string, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, rebind(s.dialect, `
+		UPDATE sessions SET revoked = ?, revoked_at = ?, revoke_reason = ?
+		WHERE user_id = ? AND revoked = ?`), true, at, reason, userId, false)
+	if err != nil {
+		return repositoryError("revoke user sessions", ErrConnection, err)
+	}
+	return nil
+}
+
+// checkAffected turns an UPDATE that matched nothing into ErrNotFound when
+// the session is missing, or into otherwise (if non-nil) when it exists
+// but failed the WHERE guard.
+func (s *sqlSessionStore) checkAffected(ctx context.Context, db Database, op, sessionId string, result sql.Result, otherwise error) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return repositoryError(op, ErrConnection, err)
+	}
+	if affected > 0 {
+		return nil
+	}
+	rows, err := db.QueryContext(ctx, rebind(s.dialect, "SELECT id FROM sessions WHERE id = ?"), sessionId)
+	if err != nil {
+		return repositoryError(op, ErrConnection, err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return repositoryError(op, ErrNotFound, fmt.Errorf("session %s", sessionId))
+	}
+	if otherwise != nil {
+		return repositoryError(op, otherwise, fmt.Errorf("session %s", sessionId))
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// forEachSessionStore runs test against the memory store and against the
+// SQL store on the test driver, using Postgres placeholders.
+func forEachSessionStore(t *testing.T, test func(t *testing.T, store SessionStore)) {
+	t.Run("memory", func(t *testing.T) { test(t, NewMemorySessionStore()) })
+	t.Run("sql", func(t *testing.T) { test(t, NewSQLSessionStore(openTestDB(t, sessionSchema), Postgres)) })
+}
+
+func newTestSessionManager(store SessionStore) (*SessionManager, *time.Time) {
+	clock := time.Date(2026, time.March, 1, 9, 0, 0, 0, time.UTC)
+	sm := NewSessionManager(store, DefaultSessionConfig)
+	sm.now = func() time.Time { return clock }
+	return sm, &clock
+}
+
+func TestRefreshRotatesAndRevokesOnReuse(t *testing.T) {
+	forEachSessionStore(t, func(t *testing.T, store SessionStore) {
+		ctx := context.Background()
+		sm, clock := newTestSessionManager(store)
+
+		first, err := sm.Issue(ctx, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sm.Validate(ctx, first.AccessToken); err != nil {
+			t.Fatalf("fresh access token: %v", err)
+		}
+
+		*clock = clock.Add(time.Minute)
+		second, err := sm.Refresh(ctx, first.RefreshToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if second.SessionId != first.SessionId {
+			t.Fatalf("refresh started session %s, want %s", second.SessionId, first.SessionId)
+		}
+		if _, err := sm.Validate(ctx, first.AccessToken); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("rotated-away access token: got %v, want ErrInvalidToken", err)
+		}
+		session, err := sm.Validate(ctx, second.AccessToken)
+		if err != nil {
+			t.Fatalf("rotated access token: %v", err)
+		}
+		if session.Generation != 2 {
+			t.Fatalf("generation = %d, want 2", session.Generation)
+		}
+
+		if _, err := sm.Refresh(ctx, first.RefreshToken); !errors.Is(err, ErrRefreshReused) {
+			t.Fatalf("replayed refresh token: got %v, want ErrRefreshReused", err)
+		}
+		if _, err := sm.Validate(ctx, second.AccessToken); !errors.Is(err, ErrSessionRevoked) {
+			t.Fatalf("access token after reuse: got %v, want ErrSessionRevoked", err)
+		}
+		if _, err := sm.Refresh(ctx, second.RefreshToken); !errors.Is(err, ErrSessionRevoked) {
+			t.Fatalf("current refresh token after reuse: got %v, want ErrSessionRevoked", err)
+		}
+	})
+}
+
+func TestRevokeUserEndsOnlyThatUsersSessions(t *testing.T) {
+	forEachSessionStore(t, func(t *testing.T, store SessionStore) {
+		ctx := context.Background()
+		sm, _ := newTestSessionManager(store)
+
+		laptop, err := sm.Issue(ctx, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		phone, err := sm.Issue(ctx, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		other, err := sm.Issue(ctx, 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := sm.RevokeUser(ctx, 7, "password changed"); err != nil {
+			t.Fatal(err)
+		}
+		for _, tokens := range []*SessionTokens{laptop, phone} {
+			if _, err := sm.Validate(ctx, tokens.AccessToken); !errors.Is(err, ErrSessionRevoked) {
+				t.Errorf("session %s: got %v, want ErrSessionRevoked", tokens.SessionId, err)
+			}
+			if _, err := sm.Refresh(ctx, tokens.RefreshToken); !errors.Is(err, ErrSessionRevoked) {
+				t.Errorf("refresh of session %s: got %v, want ErrSessionRevoked", tokens.SessionId, err)
+			}
+		}
+		if _, err := sm.Validate(ctx, other.AccessToken); err != nil {
+			t.Errorf("other user's session: %v", err)
+		}
+
+		session, err := sm.Logout(ctx, laptop.AccessToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if session.RevokeReason != "password changed" {
+			t.Errorf("revoke reason = %q, want the first one kept", session.RevokeReason)
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Database is satisfied by *sql.DB and *sql.Tx for any driver.
+type Database interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// TxDatabase is a Database that can start transactions; *sql.DB is one.
+type TxDatabase interface {
+	Database
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// inTx runs fn in a transaction when db can start one. Handed a *sql.Tx,
+// fn joins the caller's transaction instead.
+func inTx(ctx context.Context, db Database, opts *sql.TxOptions, op string, fn func(db Database) error) error {
+	txDB, ok := db.(TxDatabase)
+	if !ok {
+		return fn(db)
+	}
+	tx, err := txDB.BeginTx(ctx, opts)
+	if err != nil {
+		return repositoryError(op, ErrConnection, err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return repositoryError(op, ErrConflict, err)
+	}
+	return nil
+}
+
+// Dialect hides the differences between SQL servers from the stores.
+// Queries are written once with "?" placeholders and rebound per dialect.
+type Dialect interface {
+	Name() string
+	Placeholder(position int) string
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string                    { return "mysql" }
+func (mysqlDialect) Placeholder(position int) string { return "?" }
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string { return "postgres" }
+func (postgresDialect) Placeholder(position int) string {
+	return "$" + strconv.Itoa(position)
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string                    { return "sqlite" }
+func (sqliteDialect) Placeholder(position int) string { return "?" }
+
+var (
+	MySQL    Dialect = mysqlDialect{}
+	Postgres Dialect = postgresDialect{}
+	SQLite   Dialect = sqliteDialect{}
+)
+
+func DialectFor(name string) (Dialect, error) {
+	switch strings.ToLower(name) {
+	case "mysql":
+		return MySQL, nil
+	case "postgres", "postgresql", "pgx":
+		return Postgres, nil
+	case "sqlite", "sqlite3":
+		return SQLite, nil
+	}
+	return nil, fmt.Errorf("unsupported sql dialect: %s", name)
+}
+
+// rebind rewrites "?" placeholders into the dialect's own syntax,
+// leaving question marks inside string literals untouched.
+func rebind(dialect Dialect, query string) string {
+	var builder strings.Builder
+	position := 0
+	inLiteral := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inLiteral = !inLiteral
+			builder.WriteRune(r)
+		case r == '?' && !inLiteral:
+			position++
+			builder.WriteString(dialect.Placeholder(position))
+		default:
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode"
+)
+
+// sqltest is a database/sql driver over in-memory tables with just enough
+// SQL for the stores in this package: CREATE TABLE, INSERT, UPDATE ... SET
+// and SELECT of columns or COUNT(*), filtered by "column op ?" terms joined
+// with AND. Placeholders may be written "?" or "$n". A transaction holds the
+// whole database until it ends, so every transaction is serializable.
+func init() {
+	sql.Register("sqltest", testDriver{})
+}
+
+var testDatabases sync.Map // DSN -> *testDatabase
+
+type testDatabase struct {
+	mu     sync.Mutex
+	tables map[string]*testTable
+}
+
+type testTable struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+// openTestDB opens an empty database private to t and runs the schemas on it.
+func openTestDB(t *testing.T, schemas ...[]string) *sql.DB {
+	t.Helper()
+	testDatabases.Store(t.Name(), &testDatabase{tables: map[string]*testTable{}})
+	db, err := sql.Open("sqltest", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		testDatabases.Delete(t.Name())
+	})
+	for _, schema := range schemas {
+		for _, statement := range schema {
+			if _, err := db.Exec(statement); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return db
+}
+
+func (d *testDatabase) copyTables() map[string]*testTable {
+	tables := make(map[string]*testTable, len(d.tables))
+	for name, table := range d.tables {
+		rows := make([][]driver.Value, len(table.rows))
+		for i, row := range table.rows {
+			rows[i] = append([]driver.Value(nil), row...)
+		}
+		tables[name] = &testTable{columns: table.columns, rows: rows}
+	}
+	return tables
+}
+
+type testDriver struct{}
+
+func (testDriver) Open(name string) (driver.Conn, error) {
+	database, ok := testDatabases.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("sqltest: no database %q", name)
+	}
+	return &testConn{database: database.(*testDatabase)}, nil
+}
+
+type testConn struct {
+	database *testDatabase
+	// snapshot holds the tables as they were when the open transaction
+	// began; it is nil outside a transaction.
+	snapshot map[string]*testTable
+}
+
+func (c *testConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("sqltest: prepared statements are not supported")
+}
+
+func (c *testConn) Close() error {
+	return nil
+}
+
+func (c *testConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *testConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.database.mu.Lock()
+	c.snapshot = c.database.copyTables()
+	return testTx{conn: c}, nil
+}
+
+func (c *testConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	_, affected, err := c.execute(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(affected), nil
+}
+
+func (c *testConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, _, err := c.execute(query, args)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		return nil, fmt.Errorf("sqltest: %q returns no rows", query)
+	}
+	return rows, nil
+}
+
+func (c *testConn) execute(query string, args []driver.NamedValue) (*testRows, int64, error) {
+	if c.snapshot == nil {
+		c.database.mu.Lock()
+		defer c.database.mu.Unlock()
+	}
+	p := &testParser{tokens: tokenize(query), args: args}
+	return p.run(c.database)
+}
+
+type testTx struct {
+	conn *testConn
+}
+
+func (tx testTx) Commit() error {
+	tx.conn.snapshot = nil
+	tx.conn.database.mu.Unlock()
+	return nil
+}
+
+func (tx testTx) Rollback() error {
+	tx.conn.database.tables = tx.conn.snapshot
+	tx.conn.snapshot = nil
+	tx.conn.database.mu.Unlock()
+	return nil
+}
+
+type testRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *testRows) Columns() []string {
+	return r.columns
+}
+
+func (r *testRows) Close() error {
+	return nil
+}
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func tokenize(query string) []string {
+	var tokens []string
+	runes := []rune(query)
+	isWord := func(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) }
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case unicode.IsSpace(r):
+			i++
+		case isWord(r) || r == '$':
+			j := i + 1
+			for j < len(runes) && isWord(runes[j]) {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		case (r == '<' || r == '>') && i+1 < len(runes) && runes[i+1] == '=':
+			tokens = append(tokens, string(runes[i:i+2]))
+			i += 2
+		default:
+			tokens = append(tokens, string(r))
+			i++
+		}
+	}
+	return tokens
+}
+
+// testParser executes one statement as it reads it. Syntax errors panic
+// with a testParseError, which run turns back into an error.
+type testParser struct {
+	tokens  []string
+	pos     int
+	args    []driver.NamedValue
+	nextArg int
+}
+
+type testParseError struct {
+	error
+}
+
+func (p *testParser) fail(format string, args ...interface{}) {
+	panic(testParseError{fmt.Errorf("sqltest: "+format, args...)})
+}
+
+func (p *testParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *testParser) next() string {
+	token := p.peek()
+	if token == "" {
+		p.fail("unexpected end of statement")
+	}
+	p.pos++
+	return token
+}
+
+func (p *testParser) accept(keyword string) bool {
+	if strings.EqualFold(p.peek(), keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *testParser) expect(keyword string) {
+	if !p.accept(keyword) {
+		p.fail("expected %s, found %q", keyword, p.peek())
+	}
+}
+
+func (p *testParser) run(database *testDatabase) (rows *testRows, affected int64, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			parseErr, ok := r.(testParseError)
+			if !ok {
+				panic(r)
+			}
+			err = parseErr.error
+		}
+	}()
+	switch strings.ToUpper(p.next()) {
+	case "CREATE":
+		p.create(database)
+	case "INSERT":
+		affected = p.insert(database)
+	case "UPDATE":
+		affected = p.update(database)
+	case "SELECT":
+		rows = p.selectRows(database)
+	default:
+		p.fail("unsupported statement %q", strings.Join(p.tokens, " "))
+	}
+	if p.pos < len(p.tokens) {
+		p.fail("unexpected %q", p.peek())
+	}
+	return rows, affected, nil
+}
+
+// create records only the column names; types and constraints are not
+// enforced.
+func (p *testParser) create(database *testDatabase) {
+	p.expect("TABLE")
+	name := p.next()
+	if _, ok := database.tables[name]; ok {
+		p.fail("table %s already exists", name)
+	}
+	p.expect("(")
+	table := &testTable{}
+	for {
+		table.columns = append(table.columns, p.next())
+		for p.peek() != "," && p.peek() != ")" {
+			p.next()
+		}
+		if p.next() == ")" {
+			break
+		}
+	}
+	database.tables[name] = table
+}
+
+func (p *testParser) insert(database *testDatabase) int64 {
+	p.expect("INTO")
+	table := p.table(database)
+	p.expect("(")
+	var columns []int
+	for {
+		columns = append(columns, p.column(table, p.next()))
+		if !p.accept(",") {
+			break
+		}
+	}
+	p.expect(")")
+	p.expect("VALUES")
+	p.expect("(")
+	row := make([]driver.Value, len(table.columns))
+	for i, column := range columns {
+		if i > 0 {
+			p.expect(",")
+		}
+		row[column] = p.value()
+	}
+	p.expect(")")
+	table.rows = append(table.rows, row)
+	return 1
+}
+
+func (p *testParser) update(database *testDatabase) int64 {
+	table := p.table(database)
+	p.expect("SET")
+	values := map[int]driver.Value{}
+	for {
+		column := p.column(table, p.next())
+		p.expect("=")
+		values[column] = p.value()
+		if !p.accept(",") {
+			break
+		}
+	}
+	conditions := p.where(table)
+	var affected int64
+	for _, row := range table.rows {
+		if matches(row, conditions) {
+			for column, value := range values {
+				row[column] = value
+			}
+			affected++
+		}
+	}
+	return affected
+}
+
+func (p *testParser) selectRows(database *testDatabase) *testRows {
+	count := p.accept("COUNT")
+	var names []string
+	if count {
+		p.expect("(")
+		p.expect("*")
+		p.expect(")")
+	} else {
+		for {
+			names = append(names, p.next())
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	p.expect("FROM")
+	table := p.table(database)
+	columns := make([]int, len(names))
+	for i, name := range names {
+		columns[i] = p.column(table, name)
+	}
+	conditions := p.where(table)
+
+	var matched [][]driver.Value
+	for _, row := range table.rows {
+		if matches(row, conditions) {
+			projected := make([]driver.Value, len(columns))
+			for i, column := range columns {
+				projected[i] = row[column]
+			}
+			matched = append(matched, projected)
+		}
+	}
+	if count {
+		return &testRows{columns: []string{"count"}, values: [][]driver.Value{{int64(len(matched))}}}
+	}
+	return &testRows{columns: names, values: matched}
+}
+
+func (p *testParser) table(database *testDatabase) *testTable {
+	name := p.next()
+	table, ok := database.tables[name]
+	if !ok {
+		p.fail("no table %s", name)
+	}
+	return table
+}
+
+func (p *testParser) column(table *testTable, name string) int {
+	for i, column := range table.columns {
+		if strings.EqualFold(column, name) {
+			return i
+		}
+	}
+	p.fail("no column %s", name)
+	return -1
+}
+
+// value reads a placeholder and returns its argument.
+func (p *testParser) value() driver.Value {
+	token := p.next()
+	index := p.nextArg
+	switch {
+	case token == "?":
+		p.nextArg++
+	case strings.HasPrefix(token, "$"):
+		n, err := strconv.Atoi(token[1:])
+		if err != nil {
+			p.fail("bad placeholder %q", token)
+		}
+		index = n - 1
+	default:
+		p.fail("expected a placeholder, found %q", token)
+	}
+	if index < 0 || index >= len(p.args) {
+		p.fail("no argument for placeholder %d", index+1)
+	}
+	return p.args[index].Value
+}
+
+type testCondition struct {
+	column int
+	op     string
+	value  driver.Value
+}
+
+func (p *testParser) where(table *testTable) []testCondition {
+	if !p.accept("WHERE") {
+		return nil
+	}
+	var conditions []testCondition
+	for {
+		condition := testCondition{column: p.column(table, p.next()), op: p.next()}
+		switch condition.op {
+		case "=", "<", ">=":
+		default:
+			p.fail("unsupported operator %q", condition.op)
+		}
+		condition.value = p.value()
+		conditions = append(conditions, condition)
+		if !p.accept("AND") {
+			return conditions
+		}
+	}
+}
+
+func matches(row []driver.Value, conditions []testCondition) bool {
+	for _, condition := range conditions {
+		order, ok := compareValues(row[condition.column], condition.value)
+		if !ok {
+			return false
+		}
+		switch condition.op {
+		case "=":
+			ok = order == 0
+		case "<":
+			ok = order < 0
+		case ">=":
+			ok = order >= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// compareValues orders two values of the same type. NULL, or a mix of
+// types, compares as nothing.
+func compareValues(a, b driver.Value) (int, bool) {
+	switch a := a.(type) {
+	case int64:
+		if b, ok := b.(int64); ok {
+			return cmp.Compare(a, b), true
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			if a == b {
+				return 0, true
+			}
+			return 1, true
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return a.Compare(b), true
+		}
+	}
+	return 0, false
+}
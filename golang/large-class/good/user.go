@@ -0,0 +1,44 @@
+package main
+
+type User struct {
+	id           int
+	email        string
+	name         string
+	balance      float64
+	passwordHash string
+}
+
+func NewUser(id int, email, name string, balance float64, passwordHash string) *User {
+	return &User{
+		id:           id,
+		email:        email,
+		name:         name,
+		balance:      balance,
+		passwordHash: passwordHash,
+	}
+}
+
+func (u User) GetId() int {
+	return u.id
+}
+
+func (u User) GetEmail() string {
+	return u.email
+}
+
+func (u User) GetName() string {
+	return u.name
+}
+
+func (u User) GetBalance() float64 {
+	return u.balance
+}
+
+func (u User) GetPasswordHash() string {
+	return u.passwordHash
+}
+
+func (u *User) UpdateProfile(name, email string) {
+	u.name = name
+	u.email = email
+}
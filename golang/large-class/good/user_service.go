@@ -1,37 +1,46 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-	"fmt"
+	"context"
+	"errors"
+	"sync"
 )
 
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrWeakPassword       = errors.New("password must be at least 8 characters")
+)
+
+const minPasswordLength = 8
+
 type UserRepository interface {
-	Create(email, name, hashedPassword string) (int, error)
-	Authenticate(email, password string) (*User, error)
-	FindById(id int) (*User, error)
-	Update(user *User) error
+	Create(ctx context.Context, email, name, hashedPassword string) (int, error)
+	// FindByEmail returns nil, nil when no user has the email.
+	FindByEmail(ctx context.Context, email string) (*User, error)
+	UpdatePasswordHash(ctx context.Context, userId int, passwordHash string) error
+	FindById(ctx context.Context, id int) (*User, error)
+	Update(ctx context.Context, user *User) error
 }
 
 type EmailService interface {
-	SendWelcomeEmail(email, name string) error
-	SendPasswordResetEmail(email, resetToken string) error
-	SendNotificationEmail(email, subject, message string) error
+	SendWelcomeEmail(ctx context.Context, email, name string) error
+	SendPasswordResetEmail(ctx context.Context, email, resetToken string) error
+	SendNotificationEmail(ctx context.Context, email, subject, message string) error
 }
 
 type PaymentService interface {
-	ProcessStripePayment(amount float64, token string) map[string]interface{}
-	ProcessPayPalPayment(amount float64, paypalToken string) map[string]interface{}
-	RefundPayment(transactionId string, amount float64) map[string]interface{}
+	ProcessStripePayment(ctx context.Context, amount float64, token string) map[string]interface{}
+	ProcessPayPalPayment(ctx context.Context, amount float64, paypalToken string) map[string]interface{}
+	RefundPayment(ctx context.Context, transactionId string, amount float64) map[string]interface{}
 }
 
 type ReportService interface {
-	GenerateUserReport(userId int) map[string]interface{}
-	GenerateSalesReport(startDate, endDate string) []map[string]interface{}
+	GenerateUserReport(ctx context.Context, userId int) map[string]interface{}
+	GenerateSalesReport(ctx context.Context, startDate, endDate string) []map[string]interface{}
 }
 
 type ActivityLogger interface {
-	LogActivity(userId int, action string) error
+	LogActivity(ctx context.Context, userId int, action string) error
 }
 
 type UserService struct {
@@ -40,6 +49,12 @@ type UserService struct {
 	paymentService PaymentService
 	reportService  ReportService
 	activityLogger ActivityLogger
+	passwordHasher PasswordHasher
+	sessions       *SessionManager
+	passwordResets *PasswordResetManager
+
+	dummyHashOnce sync.Once
+	dummyHash     string
 }
 
 func NewUserService(
@@ -48,6 +63,9 @@ func NewUserService(
 	paymentSvc PaymentService,
 	reportSvc ReportService,
 	activityLog ActivityLogger,
+	passwordHasher PasswordHasher,
+	sessions *SessionManager,
+	passwordResets *PasswordResetManager,
 ) *UserService {
 	return &UserService{
 		userRepository: userRepo,
@@ -55,56 +73,189 @@ func NewUserService(
 		paymentService: paymentSvc,
 		reportService:  reportSvc,
 		activityLogger: activityLog,
+		passwordHasher: passwordHasher,
+		sessions:       sessions,
+		passwordResets: passwordResets,
 	}
 }
 
 // User management methods
-func (us *UserService) CreateUser(email, name, password string) (int, error) {
-	hashedPassword := hashPassword(password)
-	userId, err := us.userRepository.Create(email, name, hashedPassword)
+func (us *UserService) CreateUser(ctx context.Context, email, name, password string) (int, error) {
+	if len(password) < minPasswordLength {
+		return 0, ErrWeakPassword
+	}
+	hashedPassword, err := us.passwordHasher.Hash(password)
+	if err != nil {
+		return 0, err
+	}
+	userId, err := us.userRepository.Create(ctx, email, name, hashedPassword)
 	if err != nil {
 		return 0, err
 	}
 
-	us.emailService.SendWelcomeEmail(email, name)
-	us.activityLogger.LogActivity(userId, "user_created")
+	us.emailService.SendWelcomeEmail(ctx, email, name)
+	us.activityLogger.LogActivity(ctx, userId, "user_created")
 
 	return userId, nil
 }
 
-func (us *UserService) AuthenticateUser(email, password string) (*User, error) {
-	user, err := us.userRepository.Authenticate(email, password)
+// verifyDummy spends as long as checking a real password would, so the
+// response time does not tell an unknown email from a wrong password.
+func (us *UserService) verifyDummy(password string) {
+	us.dummyHashOnce.Do(func() {
+		us.dummyHash, _ = us.passwordHasher.Hash("not a real password")
+	})
+	us.passwordHasher.Verify(password, us.dummyHash)
+}
+
+// AuthenticateUser checks the password and, when the stored hash is from
+// an older scheme, replaces it while the plain password is at hand.
+func (us *UserService) AuthenticateUser(ctx context.Context, email, password string) (*User, error) {
+	user, err := us.userRepository.FindByEmail(ctx, email)
 	if err != nil {
 		return nil, err
 	}
+	if user == nil {
+		us.verifyDummy(password)
+		return nil, ErrInvalidCredentials
+	}
 
-	if user != nil {
-		us.activityLogger.LogActivity(user.GetId(), "user_login")
+	needsRehash, err := us.passwordHasher.Verify(password, user.GetPasswordHash())
+	if errors.Is(err, ErrPasswordMismatch) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if needsRehash {
+		// The login stands even if the upgrade fails; it is retried next time.
+		if hashedPassword, err := us.passwordHasher.Hash(password); err == nil {
+			if us.userRepository.UpdatePasswordHash(ctx, user.GetId(), hashedPassword) == nil {
+				us.activityLogger.LogActivity(ctx, user.GetId(), "password_rehashed")
+			}
+		}
 	}
 
+	us.activityLogger.LogActivity(ctx, user.GetId(), "user_login")
+
+	return user, nil
+}
+
+// Login authenticates the user and opens a session for them.
+func (us *UserService) Login(ctx context.Context, email, password string) (*User, *SessionTokens, error) {
+	user, err := us.AuthenticateUser(ctx, email, password)
+	if err != nil {
+		return nil, nil, err
+	}
+	tokens, err := us.sessions.Issue(ctx, user.GetId())
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, tokens, nil
+}
+
+// CurrentUser resolves an access token to its user.
+func (us *UserService) CurrentUser(ctx context.Context, accessToken string) (*User, error) {
+	session, err := us.sessions.Validate(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	user, err := us.userRepository.FindById(ctx, session.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidToken
+	}
 	return user, nil
 }
 
-func (us *UserService) UpdateUserProfile(userId int, name, email string) error {
-	user, err := us.userRepository.FindById(userId)
+func (us *UserService) RefreshSession(ctx context.Context, refreshToken string) (*SessionTokens, error) {
+	return us.sessions.Refresh(ctx, refreshToken)
+}
+
+func (us *UserService) Logout(ctx context.Context, accessToken string) error {
+	session, err := us.sessions.Logout(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+	us.activityLogger.LogActivity(ctx, session.UserId, "user_logout")
+	return nil
+}
+
+// RequestPasswordReset emails a reset link. Unknown addresses and
+// rate-limited ones succeed silently, so the endpoint cannot be used to
+// probe for accounts.
+func (us *UserService) RequestPasswordReset(ctx context.Context, email string) error {
+	user, err := us.userRepository.FindByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+	token, err := us.passwordResets.Issue(ctx, user.GetId(), user.GetEmail())
+	if errors.Is(err, ErrResetRateLimited) {
+		us.activityLogger.LogActivity(ctx, user.GetId(), "password_reset_rate_limited")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := us.emailService.SendPasswordResetEmail(ctx, user.GetEmail(), token); err != nil {
+		return err
+	}
+	us.activityLogger.LogActivity(ctx, user.GetId(), "password_reset_requested")
+	return nil
+}
+
+// ResetPassword redeems a reset token, sets the new password and signs the
+// user out everywhere. The token stays usable if the password cannot be
+// saved.
+func (us *UserService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	if len(newPassword) < minPasswordLength {
+		return ErrWeakPassword
+	}
+	hashedPassword, err := us.passwordHasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	var userId int
+	err = us.passwordResets.Redeem(ctx, token, func(id int) error {
+		userId = id
+		return us.userRepository.UpdatePasswordHash(ctx, id, hashedPassword)
+	})
+	if err != nil {
+		return err
+	}
+	if err := us.sessions.RevokeUser(ctx, userId, "password reset"); err != nil {
+		return err
+	}
+	us.activityLogger.LogActivity(ctx, userId, "password_reset")
+	return nil
+}
+
+func (us *UserService) UpdateUserProfile(ctx context.Context, userId int, name, email string) error {
+	user, err := us.userRepository.FindById(ctx, userId)
 	if err != nil {
 		return err
 	}
 
 	if user != nil {
 		user.UpdateProfile(name, email)
-		err = us.userRepository.Update(user)
+		err = us.userRepository.Update(ctx, user)
 		if err != nil {
 			return err
 		}
-		us.activityLogger.LogActivity(userId, "profile_updated")
+		us.activityLogger.LogActivity(ctx, userId, "profile_updated")
 	}
 
 	return nil
 }
 
-func (us *UserService) GetUserBalance(userId int) float64 {
-	user, err := us.userRepository.FindById(userId)
+func (us *UserService) GetUserBalance(ctx context.Context, userId int) float64 {
+	user, err := us.userRepository.FindById(ctx, userId)
 	if err != nil {
 		return 0.0
 	}
@@ -115,42 +266,36 @@ func (us *UserService) GetUserBalance(userId int) float64 {
 }
 
 // Email methods - delegated to EmailService
-func (us *UserService) SendWelcomeEmail(email, name string) error {
-	return us.emailService.SendWelcomeEmail(email, name)
+func (us *UserService) SendWelcomeEmail(ctx context.Context, email, name string) error {
+	return us.emailService.SendWelcomeEmail(ctx, email, name)
 }
 
-func (us *UserService) SendPasswordResetEmail(email, resetToken string) error {
-	return us.emailService.SendPasswordResetEmail(email, resetToken)
+func (us *UserService) SendPasswordResetEmail(ctx context.Context, email, resetToken string) error {
+	return us.emailService.SendPasswordResetEmail(ctx, email, resetToken)
 }
 
-func (us *UserService) SendNotificationEmail(email, subject, message string) error {
-	return us.emailService.SendNotificationEmail(email, subject, message)
+func (us *UserService) SendNotificationEmail(ctx context.Context, email, subject, message string) error {
+	return us.emailService.SendNotificationEmail(ctx, email, subject, message)
 }
 
 // Payment methods - delegated to PaymentService
-func (us *UserService) ProcessStripePayment(amount float64, token string) map[string]interface{} {
-	return us.paymentService.ProcessStripePayment(amount, token)
+func (us *UserService) ProcessStripePayment(ctx context.Context, amount float64, token string) map[string]interface{} {
+	return us.paymentService.ProcessStripePayment(ctx, amount, token)
 }
 
-func (us *UserService) ProcessPayPalPayment(amount float64, paypalToken string) map[string]interface{} {
-	return us.paymentService.ProcessPayPalPayment(amount, paypalToken)
+func (us *UserService) ProcessPayPalPayment(ctx context.Context, amount float64, paypalToken string) map[string]interface{} {
+	return us.paymentService.ProcessPayPalPayment(ctx, amount, paypalToken)
 }
 
-func (us *UserService) RefundPayment(transactionId string, amount float64) map[string]interface{} {
-	return us.paymentService.RefundPayment(transactionId, amount)
+func (us *UserService) RefundPayment(ctx context.Context, transactionId string, amount float64) map[string]interface{} {
+	return us.paymentService.RefundPayment(ctx, transactionId, amount)
 }
 
 // Reporting methods - delegated to ReportService
-func (us *UserService) GenerateUserReport(userId int) map[string]interface{} {
-	return us.reportService.GenerateUserReport(userId)
-}
-
-func (us *UserService) GenerateSalesReport(startDate, endDate string) []map[string]interface{} {
-	return us.reportService.GenerateSalesReport(startDate, endDate)
+func (us *UserService) GenerateUserReport(ctx context.Context, userId int) map[string]interface{} {
+	return us.reportService.GenerateUserReport(ctx, userId)
 }
 
-// Utility function
-func hashPassword(password string) string {
-	hash := sha256.Sum256([]byte(password))
-	return hex.EncodeToString(hash[:])
+func (us *UserService) GenerateSalesReport(ctx context.Context, startDate, endDate string) []map[string]interface{} {
+	return us.reportService.GenerateSalesReport(ctx, startDate, endDate)
 }
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeUsers is a UserRepository over a map.
+type fakeUsers struct {
+	mu    sync.Mutex
+	users map[int]*User
+}
+
+func newFakeUsers(users ...*User) *fakeUsers {
+	f := &fakeUsers{users: map[int]*User{}}
+	for _, user := range users {
+		f.users[user.GetId()] = user
+	}
+	return f
+}
+
+func (f *fakeUsers) Create(ctx context.Context, email, name, hashedPassword string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := len(f.users) + 1
+	f.users[id] = NewUser(id, email, name, 0, hashedPassword)
+	return id, nil
+}
+
+func (f *fakeUsers) FindByEmail(ctx context.Context, email string) (*User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, user := range f.users {
+		if strings.EqualFold(user.GetEmail(), email) {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeUsers) UpdatePasswordHash(ctx context.Context, userId int, passwordHash string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user, ok := f.users[userId]
+	if !ok {
+		return errors.New("no such user")
+	}
+	user.passwordHash = passwordHash
+	return nil
+}
+
+func (f *fakeUsers) FindById(ctx context.Context, id int) (*User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user, ok := f.users[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (f *fakeUsers) Update(ctx context.Context, user *User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	copied := *user
+	f.users[user.GetId()] = &copied
+	return nil
+}
+
+type discardActivity struct{}
+
+func (discardActivity) LogActivity(ctx context.Context, userId int, action string) error {
+	return nil
+}
+
+// countingHasher counts Verify calls on the hasher it wraps.
+type countingHasher struct {
+	PasswordHasher
+	mu       sync.Mutex
+	verifies int
+}
+
+func (h *countingHasher) Verify(password, encoded string) (bool, error) {
+	h.mu.Lock()
+	h.verifies++
+	h.mu.Unlock()
+	return h.PasswordHasher.Verify(password, encoded)
+}
+
+var testScryptParams = ScryptParams{LogN: 10, R: 8, P: 1, SaltLen: 16, KeyLen: 32}
+
+func TestAuthenticateUnknownEmailStillVerifiesAPassword(t *testing.T) {
+	ctx := context.Background()
+	hasher := &countingHasher{PasswordHasher: NewScryptHasher(testScryptParams)}
+	hash, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := NewUserService(newFakeUsers(NewUser(1, "ada@example.com", "Ada", 0, hash)), nil, nil, nil, discardActivity{}, hasher, nil, nil)
+
+	if _, err := us.AuthenticateUser(ctx, "nobody@example.com", "correct horse"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("unknown email = %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := us.AuthenticateUser(ctx, "ada@example.com", "wrong horse"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("wrong password = %v, want ErrInvalidCredentials", err)
+	}
+	if hasher.verifies != 2 {
+		t.Errorf("%d password checks for two failed logins, want one each", hasher.verifies)
+	}
+	if user, err := us.AuthenticateUser(ctx, "ada@example.com", "correct horse"); err != nil || user.GetId() != 1 {
+		t.Errorf("right password = %v, %v", user, err)
+	}
+}
+
+// sentMail records the messages a MailTransport was asked to deliver.
+type sentMail struct {
+	mu       sync.Mutex
+	messages []MailMessage
+}
+
+func (s *sentMail) Send(ctx context.Context, message MailMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, message)
+	return nil
+}
+
+func TestRequestPasswordResetHidesRateLimit(t *testing.T) {
+	ctx := context.Background()
+	mail := &sentMail{}
+	resets := NewPasswordResetManager(NewMemoryPasswordResetStore(), DefaultPasswordResetConfig)
+	us := NewUserService(newFakeUsers(NewUser(1, "ada@example.com", "Ada", 0, "")),
+		NewEmailService(mail, "noreply@example.com", "https://example.com/reset"),
+		nil, nil, discardActivity{}, nil, nil, resets)
+
+	for i := 0; i < DefaultPasswordResetConfig.MaxRequests+2; i++ {
+		if err := us.RequestPasswordReset(ctx, "ada@example.com"); err != nil {
+			t.Fatalf("request %d for a known email: %v", i+1, err)
+		}
+		if err := us.RequestPasswordReset(ctx, "nobody@example.com"); err != nil {
+			t.Fatalf("request %d for an unknown email: %v", i+1, err)
+		}
+	}
+	if len(mail.messages) != DefaultPasswordResetConfig.MaxRequests {
+		t.Errorf("%d reset emails sent, want %d", len(mail.messages), DefaultPasswordResetConfig.MaxRequests)
+	}
+}
+
+// failingPasswordUpdates fails the next n password updates.
+type failingPasswordUpdates struct {
+	*fakeUsers
+	failures int
+}
+
+func (f *failingPasswordUpdates) UpdatePasswordHash(ctx context.Context, userId int, passwordHash string) error {
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("connection reset")
+	}
+	return f.fakeUsers.UpdatePasswordHash(ctx, userId, passwordHash)
+}
+
+func TestResetPasswordKeepsTheTokenWhenTheUpdateFails(t *testing.T) {
+	ctx := context.Background()
+	hasher := NewScryptHasher(testScryptParams)
+	users := &failingPasswordUpdates{fakeUsers: newFakeUsers(NewUser(1, "ada@example.com", "Ada", 0, "")), failures: 1}
+	resets := NewPasswordResetManager(NewMemoryPasswordResetStore(), DefaultPasswordResetConfig)
+	sessions := NewSessionManager(NewMemorySessionStore(), DefaultSessionConfig)
+	us := NewUserService(users, nil, nil, nil, discardActivity{}, hasher, sessions, resets)
+
+	token, err := resets.Issue(ctx, 1, "ada@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := us.ResetPassword(ctx, token, "new horse battery"); err == nil {
+		t.Fatal("reset with a failing update succeeded")
+	}
+	if err := us.ResetPassword(ctx, token, "new horse battery"); err != nil {
+		t.Fatalf("retry with the same token: %v", err)
+	}
+	if _, err := us.AuthenticateUser(ctx, "ada@example.com", "new horse battery"); err != nil {
+		t.Errorf("sign in with the new password: %v", err)
+	}
+	if err := us.ResetPassword(ctx, token, "another horse"); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("third use of the token = %v, want ErrInvalidResetToken", err)
+	}
+}
+
+func TestCreateUserRejectsShortPasswords(t *testing.T) {
+	ctx := context.Background()
+	users := newFakeUsers()
+	us := NewUserService(users, nil, nil, nil, discardActivity{}, NewScryptHasher(testScryptParams), nil, nil)
+
+	if _, err := us.CreateUser(ctx, "ada@example.com", "Ada", "short"); !errors.Is(err, ErrWeakPassword) {
+		t.Fatalf("CreateUser with a 5 character password = %v, want ErrWeakPassword", err)
+	}
+	if len(users.users) != 0 {
+		t.Errorf("%d users stored after a rejected sign-up", len(users.users))
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrInvalidCredentials       = errors.New("invalid email or password")
+	ErrEmailNotVerified         = errors.New("email address not verified")
+	ErrAccountLocked            = errors.New("account locked")
+	ErrInvalidVerificationToken = errors.New("invalid verification token")
+	ErrVerificationTokenExpired = errors.New("verification token expired")
+	ErrInvalidStatusChange      = errors.New("invalid account status change")
+)
+
+// AccountStatus is where a user is in the account lifecycle. New accounts
+// are pending until their email is verified; only active accounts can
+// sign in.
+type AccountStatus string
+
+const (
+	AccountPending AccountStatus = "pending"
+	AccountActive  AccountStatus = "active"
+	AccountLocked  AccountStatus = "locked"
+	AccountDeleted AccountStatus = "deleted"
+)
+
+var accountTransitions = map[AccountStatus][]AccountStatus{
+	AccountPending: {AccountActive, AccountLocked, AccountDeleted},
+	AccountActive:  {AccountLocked, AccountDeleted},
+	AccountLocked:  {AccountPending, AccountActive, AccountDeleted},
+}
+
+func (s AccountStatus) CanTransitionTo(next AccountStatus) bool {
+	for _, allowed := range accountTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+func checkAccountTransition(from, to AccountStatus) error {
+	if !from.CanTransitionTo(to) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidStatusChange, from, to)
+	}
+	return nil
+}
+
+// Account is the stored user as authentication and verification see it.
+// The verification token is kept only as a SHA-256 hash.
+type Account struct {
+	Id                    int64
+	Email                 string
+	FirstName             string
+	PasswordHash          string
+	Status                AccountStatus
+	EmailVerified         bool
+	VerificationHash      string
+	VerificationSentAt    time.Time
+	VerificationExpiresAt time.Time
+}
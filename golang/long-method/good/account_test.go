@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckAccountTransition(t *testing.T) {
+	statuses := []AccountStatus{AccountPending, AccountActive, AccountLocked, AccountDeleted}
+	allowed := map[[2]AccountStatus]bool{
+		{AccountPending, AccountActive}:  true,
+		{AccountPending, AccountLocked}:  true,
+		{AccountPending, AccountDeleted}: true,
+		{AccountActive, AccountLocked}:   true,
+		{AccountActive, AccountDeleted}:  true,
+		{AccountLocked, AccountPending}:  true,
+		{AccountLocked, AccountActive}:   true,
+		{AccountLocked, AccountDeleted}:  true,
+	}
+	for _, from := range statuses {
+		for _, to := range statuses {
+			err := checkAccountTransition(from, to)
+			if allowed[[2]AccountStatus{from, to}] {
+				if err != nil {
+					t.Errorf("%s -> %s: %v", from, to, err)
+				}
+			} else if !errors.Is(err, ErrInvalidStatusChange) {
+				t.Errorf("%s -> %s = %v, want ErrInvalidStatusChange", from, to, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+var (
+	ErrPasswordMismatch  = errors.New("password does not match")
+	ErrUnknownHashFormat = errors.New("unrecognised password hash format")
+)
+
+// PasswordHasher hashes passwords for storage. Hashes are self-describing,
+// so parameters can be raised later without invalidating stored hashes.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify returns ErrPasswordMismatch when the password is wrong. On a
+	// match, needsRehash reports that encoded was made with an older
+	// algorithm or weaker parameters and should be replaced with Hash.
+	Verify(password, encoded string) (needsRehash bool, err error)
+}
+
+// ScryptParams are the scrypt cost parameters. Memory use is
+// 128 * r * 2^LogN bytes: 32 MiB for the defaults.
+type ScryptParams struct {
+	LogN    uint8
+	R       int
+	P       int
+	SaltLen int
+	KeyLen  int
+}
+
+var DefaultScryptParams = ScryptParams{LogN: 15, R: 8, P: 1, SaltLen: 16, KeyLen: 32}
+
+// Upper bounds on parameters read back from stored hashes, so a tampered
+// row cannot make Verify allocate more than 64 MiB or loop for minutes.
+const (
+	maxScryptMemory = 64 << 20
+	maxScryptP      = 16
+)
+
+// scryptParamsInRange checks the memory a hash would need, 128 * r * 2^ln
+// bytes, rather than each parameter on its own.
+func scryptParamsInRange(logN uint8, r, p int) bool {
+	if logN < 1 || logN > 30 || r < 1 || r > maxScryptMemory/128 || p < 1 || p > maxScryptP {
+		return false
+	}
+	return uint64(128*r)<<logN <= maxScryptMemory
+}
+
+// ScryptHasher encodes hashes as
+//
+//	$scrypt$ln=15,r=8,p=1$<salt>$<key>
+//
+// with salt and key in unpadded base64. It also verifies the bare SHA-256
+// hex digests the service used to store, always asking for a rehash.
+type ScryptHasher struct {
+	params ScryptParams
+}
+
+func NewScryptHasher(params ScryptParams) *ScryptHasher {
+	return &ScryptHasher{params: params}
+}
+
+func (h *ScryptHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := scrypt([]byte(password), salt, h.params.LogN, h.params.R, h.params.P, h.params.KeyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", h.params.LogN, h.params.R, h.params.P,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *ScryptHasher) Verify(password, encoded string) (bool, error) {
+	if isLegacySHA256(encoded) {
+		sum := sha256.Sum256([]byte(password))
+		if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(encoded))) != 1 {
+			return false, ErrPasswordMismatch
+		}
+		return true, nil
+	}
+
+	params, salt, want, err := decodeScryptHash(encoded)
+	if err != nil {
+		return false, err
+	}
+	got, err := scrypt([]byte(password), salt, params.LogN, params.R, params.P, len(want))
+	if err != nil {
+		return false, err
+	}
+	if subtle.ConstantTimeCompare(got, want) != 1 {
+		return false, ErrPasswordMismatch
+	}
+	needsRehash := params.LogN < h.params.LogN || params.R < h.params.R || params.P < h.params.P ||
+		len(salt) < h.params.SaltLen || len(want) < h.params.KeyLen
+	return needsRehash, nil
+}
+
+func isLegacySHA256(encoded string) bool {
+	if len(encoded) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(encoded)
+	return err == nil
+}
+
+func decodeScryptHash(encoded string) (ScryptParams, []byte, []byte, error) {
+	var params ScryptParams
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[0] != "" || parts[1] != "scrypt" {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &params.LogN, &params.R, &params.P); err != nil {
+		return params, nil, nil, fmt.Errorf("%w: %v", ErrUnknownHashFormat, err)
+	}
+	if !scryptParamsInRange(params.LogN, params.R, params.P) {
+		return params, nil, nil, fmt.Errorf("%w: scrypt parameters out of range", ErrUnknownHashFormat)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("%w: salt: %v", ErrUnknownHashFormat, err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("%w: bad key", ErrUnknownHashFormat)
+	}
+	params.SaltLen, params.KeyLen = len(salt), len(key)
+	return params, salt, key, nil
+}
+
+// scrypt derives a key as specified in RFC 7914.
+func scrypt(password, salt []byte, logN uint8, r, p, keyLen int) ([]byte, error) {
+	if logN < 1 || logN > 30 || r < 1 || p < 1 || r*p >= 1<<30 {
+		return nil, errors.New("scrypt: invalid parameters")
+	}
+	blockWords := 32 * r
+	b, err := pbkdf2.Key(sha256.New, string(password), salt, 1, p*4*blockWords)
+	if err != nil {
+		return nil, err
+	}
+
+	x := make([]uint32, blockWords)
+	y := make([]uint32, blockWords)
+	v := make([]uint32, blockWords<<logN)
+	for i := 0; i < p; i++ {
+		block := b[i*4*blockWords : (i+1)*4*blockWords]
+		for j := range x {
+			x[j] = binary.LittleEndian.Uint32(block[j*4:])
+		}
+		scryptROMix(x, y, v, r, logN)
+		for j, word := range x {
+			binary.LittleEndian.PutUint32(block[j*4:], word)
+		}
+	}
+	return pbkdf2.Key(sha256.New, string(password), b, 1, keyLen)
+}
+
+func scryptROMix(x, y, v []uint32, r int, logN uint8) {
+	n := 1 << logN
+	blockWords := 32 * r
+	for i := 0; i < n; i++ {
+		copy(v[i*blockWords:], x)
+		scryptBlockMix(x, y, r)
+	}
+	for i := 0; i < n; i++ {
+		j := int(x[(2*r-1)*16] & uint32(n-1))
+		for k, word := range v[j*blockWords : (j+1)*blockWords] {
+			x[k] ^= word
+		}
+		scryptBlockMix(x, y, r)
+	}
+}
+
+// scryptBlockMix replaces b with BlockMix(b), using y as scratch space.
+func scryptBlockMix(b, y []uint32, r int) {
+	var t [16]uint32
+	copy(t[:], b[(2*r-1)*16:])
+	for i := 0; i < 2*r; i++ {
+		for k := range t {
+			t[k] ^= b[i*16+k]
+		}
+		salsa208(&t)
+		// Even blocks go to the first half of the output, odd to the second.
+		out := (i/2 + (i%2)*r) * 16
+		copy(y[out:], t[:])
+	}
+	copy(b, y)
+}
+
+func salsa208(b *[16]uint32) {
+	x := *b
+	for round := 0; round < 8; round += 2 {
+		x[4] ^= bits.RotateLeft32(x[0]+x[12], 7)
+		x[8] ^= bits.RotateLeft32(x[4]+x[0], 9)
+		x[12] ^= bits.RotateLeft32(x[8]+x[4], 13)
+		x[0] ^= bits.RotateLeft32(x[12]+x[8], 18)
+		x[9] ^= bits.RotateLeft32(x[5]+x[1], 7)
+		x[13] ^= bits.RotateLeft32(x[9]+x[5], 9)
+		x[1] ^= bits.RotateLeft32(x[13]+x[9], 13)
+		x[5] ^= bits.RotateLeft32(x[1]+x[13], 18)
+		x[14] ^= bits.RotateLeft32(x[10]+x[6], 7)
+		x[2] ^= bits.RotateLeft32(x[14]+x[10], 9)
+		x[6] ^= bits.RotateLeft32(x[2]+x[14], 13)
+		x[10] ^= bits.RotateLeft32(x[6]+x[2], 18)
+		x[3] ^= bits.RotateLeft32(x[15]+x[11], 7)
+		x[7] ^= bits.RotateLeft32(x[3]+x[15], 9)
+		x[11] ^= bits.RotateLeft32(x[7]+x[3], 13)
+		x[15] ^= bits.RotateLeft32(x[11]+x[7], 18)
+
+		x[1] ^= bits.RotateLeft32(x[0]+x[3], 7)
+		x[2] ^= bits.RotateLeft32(x[1]+x[0], 9)
+		x[3] ^= bits.RotateLeft32(x[2]+x[1], 13)
+		x[0] ^= bits.RotateLeft32(x[3]+x[2], 18)
+		x[6] ^= bits.RotateLeft32(x[5]+x[4], 7)
+		x[7] ^= bits.RotateLeft32(x[6]+x[5], 9)
+		x[4] ^= bits.RotateLeft32(x[7]+x[6], 13)
+		x[5] ^= bits.RotateLeft32(x[4]+x[7], 18)
+		x[11] ^= bits.RotateLeft32(x[10]+x[9], 7)
+		x[8] ^= bits.RotateLeft32(x[11]+x[10], 9)
+		x[9] ^= bits.RotateLeft32(x[8]+x[11], 13)
+		x[10] ^= bits.RotateLeft32(x[9]+x[8], 18)
+		x[12] ^= bits.RotateLeft32(x[15]+x[14], 7)
+		x[13] ^= bits.RotateLeft32(x[12]+x[15], 9)
+		x[14] ^= bits.RotateLeft32(x[13]+x[12], 13)
+		x[15] ^= bits.RotateLeft32(x[14]+x[13], 18)
+	}
+	for i := range b {
+		b[i] += x[i]
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SagaStep is one unit of a multi-step workflow. Compensate undoes Action
+// and runs only if Action succeeded and a later step failed; steps whose
+// effects cannot be undone, like sending an email, leave it nil.
+type SagaStep struct {
+	Name       string
+	Action     func(ctx context.Context) error
+	Compensate func(ctx context.Context) error
+}
+
+type StepOutcome string
+
+const (
+	StepCompleted          StepOutcome = "completed"
+	StepFailed             StepOutcome = "failed"
+	StepCompensated        StepOutcome = "compensated"
+	StepCompensationFailed StepOutcome = "compensation_failed"
+	StepNotCompensable     StepOutcome = "not_compensable"
+)
+
+// StepTrace records one thing the saga did. A step that is rolled back
+// appears twice: once completed and once for its compensation.
+type StepTrace struct {
+	Step     string        `json:"step"`
+	Outcome  StepOutcome   `json:"outcome"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+}
+
+type SagaTrace struct {
+	Saga     string        `json:"saga"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration_ns"`
+	Steps    []StepTrace   `json:"steps"`
+	Error    string        `json:"error,omitempty"`
+}
+
+func (t *SagaTrace) String() string {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Sprintf("saga %s: %v", t.Saga, err)
+	}
+	return string(data)
+}
+
+// SagaError reports the step that failed and any compensations that
+// failed while rolling back. It unwraps to all of them.
+type SagaError struct {
+	Saga          string
+	Step          string
+	Err           error
+	Compensations []error
+}
+
+func (e *SagaError) Error() string {
+	msg := fmt.Sprintf("%s: step %s failed: %v", e.Saga, e.Step, e.Err)
+	if len(e.Compensations) > 0 {
+		msg += fmt.Sprintf(" (rollback incomplete: %v)", errors.Join(e.Compensations...))
+	}
+	return msg
+}
+
+func (e *SagaError) Unwrap() []error {
+	return append([]error{e.Err}, e.Compensations...)
+}
+
+type Saga struct {
+	name  string
+	steps []SagaStep
+	now   func() time.Time
+}
+
+func NewSaga(name string, steps ...SagaStep) *Saga {
+	return &Saga{name: name, steps: steps, now: time.Now}
+}
+
+// Run executes the steps in order. When one fails, or ctx is cancelled
+// between steps, the completed steps are compensated in reverse order.
+// Compensations still run after cancellation, since leaving the work half
+// done is what the saga exists to prevent. The trace is returned either
+// way.
+func (s *Saga) Run(ctx context.Context) (*SagaTrace, error) {
+	trace := &SagaTrace{Saga: s.name, Started: s.now()}
+	defer func() { trace.Duration = s.now().Sub(trace.Started) }()
+
+	for i, step := range s.steps {
+		err := ctx.Err()
+		if err == nil {
+			err = s.record(ctx, trace, step.Name, StepCompleted, StepFailed, step.Action)
+		} else {
+			trace.Steps = append(trace.Steps, StepTrace{Step: step.Name, Outcome: StepFailed, Started: s.now(), Error: err.Error()})
+		}
+		if err != nil {
+			sagaErr := &SagaError{Saga: s.name, Step: step.Name, Err: err}
+			sagaErr.Compensations = s.compensate(context.WithoutCancel(ctx), trace, s.steps[:i])
+			trace.Error = sagaErr.Error()
+			return trace, sagaErr
+		}
+	}
+	return trace, nil
+}
+
+func (s *Saga) compensate(ctx context.Context, trace *SagaTrace, completed []SagaStep) []error {
+	var errs []error
+	for i := len(completed) - 1; i >= 0; i-- {
+		step := completed[i]
+		if step.Compensate == nil {
+			trace.Steps = append(trace.Steps, StepTrace{Step: step.Name, Outcome: StepNotCompensable, Started: s.now()})
+			continue
+		}
+		if err := s.record(ctx, trace, step.Name, StepCompensated, StepCompensationFailed, step.Compensate); err != nil {
+			errs = append(errs, fmt.Errorf("compensate %s: %w", step.Name, err))
+		}
+	}
+	return errs
+}
+
+func (s *Saga) record(ctx context.Context, trace *SagaTrace, name string, ok, failed StepOutcome, fn func(context.Context) error) error {
+	entry := StepTrace{Step: name, Outcome: ok, Started: s.now()}
+	err := fn(ctx)
+	entry.Duration = s.now().Sub(entry.Started)
+	if err != nil {
+		entry.Outcome = failed
+		entry.Error = err.Error()
+	}
+	trace.Steps = append(trace.Steps, entry)
+	return err
+}
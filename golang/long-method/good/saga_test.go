@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sagaLog records the actions and compensations a test saga runs.
+type sagaLog struct {
+	calls []string
+}
+
+func (l *sagaLog) step(name string, actionErr, compensateErr error) SagaStep {
+	return SagaStep{
+		Name: name,
+		Action: func(ctx context.Context) error {
+			l.calls = append(l.calls, name)
+			return actionErr
+		},
+		Compensate: func(ctx context.Context) error {
+			l.calls = append(l.calls, "undo "+name)
+			return compensateErr
+		},
+	}
+}
+
+func outcomes(trace *SagaTrace) []string {
+	var got []string
+	for _, step := range trace.Steps {
+		got = append(got, step.Step+" "+string(step.Outcome))
+	}
+	return got
+}
+
+func TestSagaCompensatesInReverseOrder(t *testing.T) {
+	log := &sagaLog{}
+	failure := errors.New("card declined")
+	email := log.step("email", nil, nil)
+	email.Compensate = nil
+	saga := NewSaga("checkout",
+		log.step("reserve", nil, nil),
+		email,
+		log.step("invoice", nil, nil),
+		log.step("charge", failure, nil),
+		log.step("ship", nil, nil),
+	)
+
+	trace, err := saga.Run(context.Background())
+	if !errors.Is(err, failure) {
+		t.Fatalf("Run = %v, want the charge error", err)
+	}
+	var sagaErr *SagaError
+	if !errors.As(err, &sagaErr) || sagaErr.Step != "charge" || len(sagaErr.Compensations) != 0 {
+		t.Errorf("error = %#v, want step charge with a complete rollback", err)
+	}
+	if want := []string{"reserve", "email", "invoice", "charge", "undo invoice", "undo reserve"}; !reflect.DeepEqual(log.calls, want) {
+		t.Errorf("calls = %v, want %v", log.calls, want)
+	}
+	want := []string{
+		"reserve completed", "email completed", "invoice completed", "charge failed",
+		"invoice compensated", "email not_compensable", "reserve compensated",
+	}
+	if got := outcomes(trace); !reflect.DeepEqual(got, want) {
+		t.Errorf("trace = %v, want %v", got, want)
+	}
+	if trace.Error != err.Error() {
+		t.Errorf("trace error = %q, want %q", trace.Error, err.Error())
+	}
+}
+
+func TestSagaKeepsCompensatingPastAFailedCompensation(t *testing.T) {
+	log := &sagaLog{}
+	failure := errors.New("card declined")
+	stuck := errors.New("warehouse offline")
+	saga := NewSaga("checkout",
+		log.step("reserve", nil, nil),
+		log.step("hold", nil, stuck),
+		log.step("charge", failure, nil),
+	)
+
+	trace, err := saga.Run(context.Background())
+	if !errors.Is(err, failure) || !errors.Is(err, stuck) {
+		t.Fatalf("Run = %v, want both the step and the compensation error", err)
+	}
+	if !strings.Contains(err.Error(), "rollback incomplete") {
+		t.Errorf("error %q does not say the rollback is incomplete", err)
+	}
+	if want := []string{"reserve", "hold", "charge", "undo hold", "undo reserve"}; !reflect.DeepEqual(log.calls, want) {
+		t.Errorf("calls = %v, want %v", log.calls, want)
+	}
+	want := []string{
+		"reserve completed", "hold completed", "charge failed",
+		"hold compensation_failed", "reserve compensated",
+	}
+	if got := outcomes(trace); !reflect.DeepEqual(got, want) {
+		t.Errorf("trace = %v, want %v", got, want)
+	}
+}
+
+func TestSagaCompensatesAfterCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var compensated []string
+	step := func(name string, action func()) SagaStep {
+		return SagaStep{
+			Name: name,
+			Action: func(ctx context.Context) error {
+				action()
+				return nil
+			},
+			Compensate: func(ctx context.Context) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				compensated = append(compensated, name)
+				return nil
+			},
+		}
+	}
+	shipped := false
+	saga := NewSaga("checkout",
+		step("reserve", func() {}),
+		step("charge", cancel),
+		step("ship", func() { shipped = true }),
+	)
+
+	trace, err := saga.Run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run = %v, want context.Canceled", err)
+	}
+	if shipped {
+		t.Error("a step ran after the context was cancelled")
+	}
+	if want := []string{"charge", "reserve"}; !reflect.DeepEqual(compensated, want) {
+		t.Errorf("compensated %v, want %v", compensated, want)
+	}
+	want := []string{
+		"reserve completed", "charge completed", "ship failed",
+		"charge compensated", "reserve compensated",
+	}
+	if got := outcomes(trace); !reflect.DeepEqual(got, want) {
+		t.Errorf("trace = %v, want %v", got, want)
+	}
+}
@@ -1,32 +1,50 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
-	"database/sql"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 )
 
 type UserValidator interface {
-	ValidateRegistrationData(userData map[string]string) error
+	ValidateRegistrationData(ctx context.Context, userData map[string]string) error
 }
 
 type UserRepository interface {
-	UserExists(email string) bool
-	CreateUser(userData map[string]string) (int64, error)
-	CreateUserProfile(userId int64, userData map[string]string) error
-	CreateUserSettings(userId int64) error
+	UserExists(ctx context.Context, email string) bool
+	CreateUser(ctx context.Context, userData map[string]string) (int64, error)
+	CreateUserProfile(ctx context.Context, userId int64, userData map[string]string) error
+	CreateUserSettings(ctx context.Context, userId int64) error
+	// The Remove methods undo the Create methods when registration fails
+	// partway; removing something already gone is not an error.
+	RemoveUser(ctx context.Context, userId int64) error
+	RemoveUserProfile(ctx context.Context, userId int64) error
+	RemoveUserSettings(ctx context.Context, userId int64) error
+	// The Find methods return nil, nil when no user matches. UserExists
+	// and FindByEmail do not see deleted accounts.
+	FindById(ctx context.Context, userId int64) (*Account, error)
+	FindByEmail(ctx context.Context, email string) (*Account, error)
+	FindByVerificationHash(ctx context.Context, tokenHash string) (*Account, error)
+	SaveVerification(ctx context.Context, userId int64, tokenHash string, sentAt, expiresAt time.Time) error
+	// MarkEmailVerified activates a pending account and forgets its
+	// verification token.
+	MarkEmailVerified(ctx context.Context, userId int64) error
+	SetStatus(ctx context.Context, userId int64, status AccountStatus) error
+	UpdatePasswordHash(ctx context.Context, userId int64, passwordHash string) error
 }
 
 type EmailService interface {
-	SendVerificationEmail(email, firstName, verificationToken string) error
+	SendVerificationEmail(ctx context.Context, email, firstName, verificationToken string) error
 }
 
 type NotificationService interface {
-	SendWelcomeNotification(userId int64) error
+	SendWelcomeNotification(ctx context.Context, userId int64) error
 }
 
 type Logger struct{}
@@ -36,84 +54,255 @@ func (l Logger) LogRegistration(email string) {
 	log.Println(logMessage)
 }
 
+func (l Logger) LogSaga(trace *SagaTrace) {
+	log.Println(trace.String())
+}
+
+func (l Logger) LogUnreadableHash(userId int64, err error) {
+	log.Printf("User %d has an unreadable password hash: %v", userId, err)
+}
+
 type UserManager struct {
 	validator           UserValidator
 	repository          UserRepository
 	emailService        EmailService
 	notificationService NotificationService
+	passwordHasher      PasswordHasher
 	logger              Logger
+	now                 func() time.Time
+
+	dummyHashOnce sync.Once
+	dummyHash     string
 }
 
+const (
+	verificationTTL            = 48 * time.Hour
+	verificationResendCooldown = 2 * time.Minute
+)
+
 func NewUserManager(
 	validator UserValidator,
 	repository UserRepository,
 	emailService EmailService,
 	notificationService NotificationService,
+	passwordHasher PasswordHasher,
 ) *UserManager {
 	return &UserManager{
 		validator:           validator,
 		repository:          repository,
 		emailService:        emailService,
 		notificationService: notificationService,
+		passwordHasher:      passwordHasher,
 		logger:              Logger{},
+		now:                 time.Now,
 	}
 }
 
-func (um *UserManager) RegisterUser(userData map[string]string) (int64, error) {
-	err := um.validator.ValidateRegistrationData(userData)
+func (um *UserManager) RegisterUser(ctx context.Context, userData map[string]string) (int64, error) {
+	err := um.validator.ValidateRegistrationData(ctx, userData)
 	if err != nil {
 		return 0, err
 	}
 
-	if um.repository.UserExists(userData["email"]) {
+	if um.repository.UserExists(ctx, userData["email"]) {
 		return 0, fmt.Errorf("user already exists")
 	}
 
-	userData = um.prepareUserData(userData)
-
-	userId, err := um.repository.CreateUser(userData)
+	userData, err = um.prepareUserData(userData)
 	if err != nil {
 		return 0, err
 	}
 
-	err = um.repository.CreateUserProfile(userId, userData)
+	var userId int64
+	registration := NewSaga("register_user",
+		SagaStep{
+			Name: "create_user",
+			Action: func(ctx context.Context) error {
+				var err error
+				userId, err = um.repository.CreateUser(ctx, userData)
+				return err
+			},
+			Compensate: func(ctx context.Context) error {
+				return um.repository.RemoveUser(ctx, userId)
+			},
+		},
+		SagaStep{
+			Name: "create_profile",
+			Action: func(ctx context.Context) error {
+				return um.repository.CreateUserProfile(ctx, userId, userData)
+			},
+			Compensate: func(ctx context.Context) error {
+				return um.repository.RemoveUserProfile(ctx, userId)
+			},
+		},
+		SagaStep{
+			Name: "create_settings",
+			Action: func(ctx context.Context) error {
+				return um.repository.CreateUserSettings(ctx, userId)
+			},
+			Compensate: func(ctx context.Context) error {
+				return um.repository.RemoveUserSettings(ctx, userId)
+			},
+		},
+		// Emails cannot be recalled; once the user is removed the token in
+		// it simply stops working.
+		SagaStep{
+			Name: "send_verification_email",
+			Action: func(ctx context.Context) error {
+				return um.sendVerification(ctx, userId, userData["email"], userData["firstName"])
+			},
+		},
+		SagaStep{
+			Name: "send_welcome_notification",
+			Action: func(ctx context.Context) error {
+				return um.notificationService.SendWelcomeNotification(ctx, userId)
+			},
+		},
+	)
+
+	trace, err := registration.Run(ctx)
+	um.logger.LogSaga(trace)
 	if err != nil {
 		return 0, err
 	}
 
-	err = um.repository.CreateUserSettings(userId)
+	um.logger.LogRegistration(userData["email"])
+
+	return userId, nil
+}
+
+func (um *UserManager) prepareUserData(userData map[string]string) (map[string]string, error) {
+	hashedPassword, err := um.passwordHasher.Hash(userData["password"])
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	userData["password"] = hashedPassword
+	userData["status"] = string(AccountPending)
+	return userData, nil
+}
 
-	err = um.emailService.SendVerificationEmail(
-		userData["email"],
-		userData["firstName"],
-		userData["verificationToken"],
-	)
+// sendVerification replaces any earlier verification token with a new one
+// and emails it.
+func (um *UserManager) sendVerification(ctx context.Context, userId int64, email, firstName string) error {
+	token := um.generateToken()
+	now := um.now()
+	err := um.repository.SaveVerification(ctx, userId, hashVerificationToken(token), now, now.Add(verificationTTL))
 	if err != nil {
-		return 0, err
+		return err
 	}
+	return um.emailService.SendVerificationEmail(ctx, email, firstName, token)
+}
 
-	err = um.notificationService.SendWelcomeNotification(userId)
+func (um *UserManager) VerifyEmail(ctx context.Context, token string) error {
+	account, err := um.repository.FindByVerificationHash(ctx, hashVerificationToken(token))
 	if err != nil {
-		return 0, err
+		return err
+	}
+	if account == nil || account.Status != AccountPending {
+		return ErrInvalidVerificationToken
 	}
+	if !um.now().Before(account.VerificationExpiresAt) {
+		return ErrVerificationTokenExpired
+	}
+	return um.repository.MarkEmailVerified(ctx, account.Id)
+}
 
-	um.logger.LogRegistration(userData["email"])
+// ResendVerificationEmail sends a fresh token, which makes the previous
+// one unusable. Unknown and already verified addresses are ignored, and so
+// are requests within the cooldown, so the result does not reveal who has
+// an account.
+func (um *UserManager) ResendVerificationEmail(ctx context.Context, email string) error {
+	account, err := um.repository.FindByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if account == nil || account.Status != AccountPending {
+		return nil
+	}
+	if um.now().Before(account.VerificationSentAt.Add(verificationResendCooldown)) {
+		return nil
+	}
+	return um.sendVerification(ctx, account.Id, account.Email, account.FirstName)
+}
 
-	return userId, nil
+// verifyDummy spends as long as checking a real password would, so the
+// response time does not reveal whether an account exists.
+func (um *UserManager) verifyDummy(password string) {
+	um.dummyHashOnce.Do(func() {
+		um.dummyHash, _ = um.passwordHasher.Hash("not a real password")
+	})
+	um.passwordHasher.Verify(password, um.dummyHash)
 }
 
-func (um *UserManager) prepareUserData(userData map[string]string) map[string]string {
-	userData["password"] = um.hashPassword(userData["password"])
-	userData["verificationToken"] = um.generateToken()
-	return userData
+// Authenticate signs in active accounts only. The account's state is
+// revealed only to someone who knows the password. A hash stored with
+// older parameters, or as a bare SHA-256 digest, is replaced on sign-in.
+func (um *UserManager) Authenticate(ctx context.Context, email, password string) (*Account, error) {
+	account, err := um.repository.FindByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil || account.Status == AccountDeleted {
+		um.verifyDummy(password)
+		return nil, ErrInvalidCredentials
+	}
+	needsRehash, err := um.passwordHasher.Verify(password, account.PasswordHash)
+	switch {
+	case errors.Is(err, ErrPasswordMismatch):
+		return nil, ErrInvalidCredentials
+	case errors.Is(err, ErrUnknownHashFormat):
+		// Nobody can sign in with this hash; the caller sees a wrong
+		// password so the reply does not single the account out.
+		um.logger.LogUnreadableHash(account.Id, err)
+		return nil, ErrInvalidCredentials
+	case err != nil:
+		return nil, err
+	}
+
+	switch account.Status {
+	case AccountPending:
+		return nil, ErrEmailNotVerified
+	case AccountLocked:
+		return nil, ErrAccountLocked
+	}
+
+	if needsRehash {
+		// The login stands even if the upgrade fails; it is retried next time.
+		if hashedPassword, err := um.passwordHasher.Hash(password); err == nil {
+			if um.repository.UpdatePasswordHash(ctx, account.Id, hashedPassword) == nil {
+				account.PasswordHash = hashedPassword
+			}
+		}
+	}
+	return account, nil
 }
 
-func (um *UserManager) hashPassword(password string) string {
-	hash := sha256.Sum256([]byte(password))
-	return hex.EncodeToString(hash[:])
+func (um *UserManager) LockUser(ctx context.Context, userId int64) error {
+	return um.repository.SetStatus(ctx, userId, AccountLocked)
+}
+
+// UnlockUser returns the account to pending if its email was never
+// verified, so unlocking cannot skip verification.
+func (um *UserManager) UnlockUser(ctx context.Context, userId int64) error {
+	account, err := um.repository.FindById(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if account == nil {
+		return fmt.Errorf("user %d not found", userId)
+	}
+	if account.Status != AccountLocked {
+		return fmt.Errorf("%w: %s account cannot be unlocked", ErrInvalidStatusChange, account.Status)
+	}
+	status := AccountPending
+	if account.EmailVerified {
+		status = AccountActive
+	}
+	return um.repository.SetStatus(ctx, account.Id, status)
+}
+
+func (um *UserManager) DeleteUser(ctx context.Context, userId int64) error {
+	return um.repository.SetStatus(ctx, userId, AccountDeleted)
 }
 
 func (um *UserManager) generateToken() string {
@@ -122,6 +311,46 @@ func (um *UserManager) generateToken() string {
 	return hex.EncodeToString(bytes)
 }
 
+func hashVerificationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type requiredFieldsValidator struct{}
+
+func (requiredFieldsValidator) ValidateRegistrationData(ctx context.Context, userData map[string]string) error {
+	for _, field := range []string{"email", "password", "firstName"} {
+		if userData[field] == "" {
+			return fmt.Errorf("%s is required", field)
+		}
+	}
+	return nil
+}
+
+// consoleEmailService prints emails and remembers the last token sent, so
+// the demo can click the link.
+type consoleEmailService struct {
+	lastToken string
+}
+
+func (es *consoleEmailService) SendVerificationEmail(ctx context.Context, email, firstName, verificationToken string) error {
+	es.lastToken = verificationToken
+	fmt.Printf("  email to %s: Hi %s, verify at http://example.com/verify?token=%s...\n", email, firstName, verificationToken[:8])
+	return nil
+}
+
+type consoleNotifications struct {
+	down bool
+}
+
+func (n consoleNotifications) SendWelcomeNotification(ctx context.Context, userId int64) error {
+	if n.down {
+		return errors.New("notification service unavailable")
+	}
+	fmt.Printf("  welcome notification for user %d\n", userId)
+	return nil
+}
+
 func main() {
 	// This would normally use real implementations of the interfaces
 	fmt.Println("Long method has been refactored into smaller, focused methods and separate classes:")
@@ -131,4 +360,76 @@ func main() {
 	fmt.Println("- Emails by EmailService")
 	fmt.Println("- Notifications by NotificationService")
 	fmt.Println("- Logging by Logger")
+
+	ctx := context.Background()
+	emails := &consoleEmailService{}
+	repository := NewMemoryUserRepository()
+	hasher := NewScryptHasher(DefaultScryptParams)
+	um := NewUserManager(requiredFieldsValidator{}, repository, emails, consoleNotifications{}, hasher)
+
+	userId, err := um.RegisterUser(ctx, map[string]string{
+		"email": "ada@example.com", "password": "correct horse", "firstName": "Ada", "lastName": "Lovelace",
+	})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	firstToken := emails.lastToken
+
+	if _, err := um.Authenticate(ctx, "ada@example.com", "correct horse"); err != nil {
+		fmt.Println("Login before verifying:", err)
+	}
+	if err := um.ResendVerificationEmail(ctx, "ada@example.com"); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if emails.lastToken == firstToken {
+		fmt.Println("Resend within the cooldown: no new email sent")
+	}
+
+	// Pretend the cooldown has passed.
+	um.now = func() time.Time { return time.Now().Add(verificationResendCooldown) }
+	if err := um.ResendVerificationEmail(ctx, "ada@example.com"); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if err := um.VerifyEmail(ctx, firstToken); err != nil {
+		fmt.Println("Superseded token:", err)
+	}
+	if err := um.VerifyEmail(ctx, emails.lastToken); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	account, err := um.Authenticate(ctx, "ada@example.com", "correct horse")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Signed in user %d (%s)\n", account.Id, account.Status)
+
+	if err := um.LockUser(ctx, userId); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if _, err := um.Authenticate(ctx, "ada@example.com", "correct horse"); err != nil {
+		fmt.Println("Login while locked:", err)
+	}
+	if err := um.DeleteUser(ctx, userId); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if err := um.UnlockUser(ctx, userId); err != nil {
+		fmt.Println("Unlock deleted:", err)
+	}
+	if _, err := um.Authenticate(ctx, "ada@example.com", "correct horse"); err != nil {
+		fmt.Println("Login after delete:", err)
+	}
+
+	// A failure in the last step removes everything registration created.
+	outage := NewUserManager(requiredFieldsValidator{}, repository, emails, consoleNotifications{down: true}, hasher)
+	bob := map[string]string{"email": "bob@example.com", "password": "hunter2hunter2", "firstName": "Bob"}
+	if _, err := outage.RegisterUser(ctx, bob); err != nil {
+		fmt.Println("Registration failed:", err)
+	}
+	fmt.Printf("bob@example.com left behind: %t\n", repository.UserExists(ctx, "bob@example.com"))
 }
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testScryptParams = ScryptParams{LogN: 10, R: 8, P: 1, SaltLen: 16, KeyLen: 32}
+
+// countingHasher counts Verify calls on the hasher it wraps.
+type countingHasher struct {
+	PasswordHasher
+	mu       sync.Mutex
+	verifies int
+}
+
+func (h *countingHasher) Verify(password, encoded string) (bool, error) {
+	h.mu.Lock()
+	h.verifies++
+	h.mu.Unlock()
+	return h.PasswordHasher.Verify(password, encoded)
+}
+
+// recordingEmails keeps the verification tokens it is asked to send.
+type recordingEmails struct {
+	tokens []string
+}
+
+func (es *recordingEmails) SendVerificationEmail(ctx context.Context, email, firstName, verificationToken string) error {
+	es.tokens = append(es.tokens, verificationToken)
+	return nil
+}
+
+type discardNotifications struct{}
+
+func (discardNotifications) SendWelcomeNotification(ctx context.Context, userId int64) error {
+	return nil
+}
+
+func newTestUserManager(t *testing.T) (*UserManager, UserRepository, *recordingEmails, *countingHasher, *time.Time) {
+	t.Helper()
+	clock := time.Date(2026, time.April, 1, 12, 0, 0, 0, time.UTC)
+	repository := NewMemoryUserRepository()
+	emails := &recordingEmails{}
+	hasher := &countingHasher{PasswordHasher: NewScryptHasher(testScryptParams)}
+	um := NewUserManager(requiredFieldsValidator{}, repository, emails, discardNotifications{}, hasher)
+	um.now = func() time.Time { return clock }
+	return um, repository, emails, hasher, &clock
+}
+
+// createAccount stores an account directly, bypassing registration.
+func createAccount(t *testing.T, repository UserRepository, email, passwordHash string, status AccountStatus) int64 {
+	t.Helper()
+	id, err := repository.CreateUser(context.Background(), map[string]string{
+		"email": email, "firstName": "Ada", "password": passwordHash, "status": string(status),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestAuthenticateUpgradesOldHashes(t *testing.T) {
+	ctx := context.Background()
+	um, repository, _, _, _ := newTestUserManager(t)
+
+	sum := sha256.Sum256([]byte("correct horse"))
+	weak, err := NewScryptHasher(ScryptParams{LogN: 4, R: 8, P: 1, SaltLen: 16, KeyLen: 32}).Hash("battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		email, password, hash string
+	}{
+		{"legacy@example.com", "correct horse", hex.EncodeToString(sum[:])},
+		{"weak@example.com", "battery staple", weak},
+	} {
+		id := createAccount(t, repository, tc.email, tc.hash, AccountActive)
+		if _, err := um.Authenticate(ctx, tc.email, tc.password); err != nil {
+			t.Fatalf("%s: %v", tc.email, err)
+		}
+		stored, _ := repository.FindById(ctx, id)
+		if stored.PasswordHash == tc.hash || !strings.HasPrefix(stored.PasswordHash, "$scrypt$ln=10,") {
+			t.Errorf("%s: hash after sign-in = %q, want a current scrypt hash", tc.email, stored.PasswordHash)
+		}
+
+		upgraded := stored.PasswordHash
+		if _, err := um.Authenticate(ctx, tc.email, tc.password); err != nil {
+			t.Fatalf("%s: second sign-in: %v", tc.email, err)
+		}
+		if stored, _ = repository.FindById(ctx, id); stored.PasswordHash != upgraded {
+			t.Errorf("%s: a current hash was replaced again", tc.email)
+		}
+	}
+}
+
+func TestAuthenticateDoesNotRehashOnFailedSignIn(t *testing.T) {
+	ctx := context.Background()
+	um, repository, _, _, _ := newTestUserManager(t)
+	sum := sha256.Sum256([]byte("correct horse"))
+	legacy := hex.EncodeToString(sum[:])
+	id := createAccount(t, repository, "ada@example.com", legacy, AccountLocked)
+
+	if _, err := um.Authenticate(ctx, "ada@example.com", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("wrong password: got %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := um.Authenticate(ctx, "ada@example.com", "correct horse"); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("locked account: got %v, want ErrAccountLocked", err)
+	}
+	if stored, _ := repository.FindById(ctx, id); stored.PasswordHash != legacy {
+		t.Errorf("hash of a locked account changed to %q", stored.PasswordHash)
+	}
+}
+
+func TestAuthenticateWithoutAnAccountStillVerifiesAPassword(t *testing.T) {
+	ctx := context.Background()
+	um, repository, _, hasher, _ := newTestUserManager(t)
+	hash, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := createAccount(t, repository, "ada@example.com", hash, AccountActive)
+	if err := repository.SetStatus(ctx, id, AccountDeleted); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, email := range []string{"nobody@example.com", "ada@example.com"} {
+		before := hasher.verifies
+		if _, err := um.Authenticate(ctx, email, "correct horse"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%s: got %v, want ErrInvalidCredentials", email, err)
+		}
+		if hasher.verifies != before+1 {
+			t.Errorf("%s: %d passwords verified, want 1", email, hasher.verifies-before)
+		}
+	}
+}
+
+func TestAuthenticateHidesUnreadableHashes(t *testing.T) {
+	ctx := context.Background()
+	um, repository, _, _, _ := newTestUserManager(t)
+	createAccount(t, repository, "ada@example.com", "$md5$not-supported", AccountActive)
+
+	_, err := um.Authenticate(ctx, "ada@example.com", "correct horse")
+	if !errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrUnknownHashFormat) {
+		t.Errorf("got %v, want only ErrInvalidCredentials", err)
+	}
+}
+
+func registerTestUser(t *testing.T, um *UserManager, email string) int64 {
+	t.Helper()
+	id, err := um.RegisterUser(context.Background(), map[string]string{
+		"email": email, "password": "correct horse", "firstName": "Ada",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestVerifyEmailTokenExpiresAndWorksOnce(t *testing.T) {
+	ctx := context.Background()
+	um, repository, emails, _, clock := newTestUserManager(t)
+
+	registerTestUser(t, um, "late@example.com")
+	*clock = clock.Add(verificationTTL)
+	if err := um.VerifyEmail(ctx, emails.tokens[0]); !errors.Is(err, ErrVerificationTokenExpired) {
+		t.Fatalf("token at its expiry = %v, want ErrVerificationTokenExpired", err)
+	}
+
+	id := registerTestUser(t, um, "ada@example.com")
+	*clock = clock.Add(verificationTTL - time.Second)
+	if err := um.VerifyEmail(ctx, emails.tokens[1]); err != nil {
+		t.Fatalf("token a second before expiry: %v", err)
+	}
+	if account, _ := repository.FindById(ctx, id); account.Status != AccountActive || !account.EmailVerified {
+		t.Errorf("after verification status = %s, verified = %v", account.Status, account.EmailVerified)
+	}
+	if err := um.VerifyEmail(ctx, emails.tokens[1]); !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Errorf("second use of the token = %v, want ErrInvalidVerificationToken", err)
+	}
+}
+
+func TestResendVerificationEmailWaitsForTheCooldown(t *testing.T) {
+	ctx := context.Background()
+	um, _, emails, _, clock := newTestUserManager(t)
+	registerTestUser(t, um, "ada@example.com")
+
+	*clock = clock.Add(verificationResendCooldown - time.Second)
+	if err := um.ResendVerificationEmail(ctx, "ada@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(emails.tokens) != 1 {
+		t.Fatalf("%d emails sent within the cooldown, want only the first", len(emails.tokens))
+	}
+
+	*clock = clock.Add(time.Second)
+	if err := um.ResendVerificationEmail(ctx, "ada@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(emails.tokens) != 2 {
+		t.Fatalf("%d emails sent once the cooldown is over, want 2", len(emails.tokens))
+	}
+	if err := um.VerifyEmail(ctx, emails.tokens[0]); !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Errorf("replaced token = %v, want ErrInvalidVerificationToken", err)
+	}
+	if err := um.VerifyEmail(ctx, emails.tokens[1]); err != nil {
+		t.Errorf("new token: %v", err)
+	}
+}
+
+func TestAuthenticateChecksTheAccountStatus(t *testing.T) {
+	ctx := context.Background()
+	um, repository, _, hasher, _ := newTestUserManager(t)
+	hash, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		status AccountStatus
+		want   error
+	}{
+		{AccountPending, ErrEmailNotVerified},
+		{AccountLocked, ErrAccountLocked},
+		{AccountDeleted, ErrInvalidCredentials},
+	} {
+		email := string(tc.status) + "@example.com"
+		id := createAccount(t, repository, email, hash, AccountPending)
+		if tc.status != AccountPending {
+			if err := repository.SetStatus(ctx, id, tc.status); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := um.Authenticate(ctx, email, "correct horse"); !errors.Is(err, tc.want) {
+			t.Errorf("%s account, right password = %v, want %v", tc.status, err, tc.want)
+		}
+		if _, err := um.Authenticate(ctx, email, "wrong horse"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%s account, wrong password = %v, want ErrInvalidCredentials", tc.status, err)
+		}
+	}
+}
+
+func TestDeletedAccountsFreeTheirEmail(t *testing.T) {
+	ctx := context.Background()
+	um, repository, _, _, _ := newTestUserManager(t)
+	first := registerTestUser(t, um, "ada@example.com")
+	if err := um.DeleteUser(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	if repository.UserExists(ctx, "ada@example.com") {
+		t.Fatal("deleted account still holds its email")
+	}
+
+	second := registerTestUser(t, um, "ada@example.com")
+	if account, _ := repository.FindByEmail(ctx, "ada@example.com"); account == nil || account.Id != second {
+		t.Errorf("FindByEmail = %+v, want the new account %d", account, second)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryUserRepository struct {
+	mu       sync.Mutex
+	nextId   int64
+	accounts map[int64]*Account
+	profiles map[int64]map[string]string
+	settings map[int64]bool
+}
+
+// NewMemoryUserRepository keeps users in process memory, for demos and
+// tests.
+func NewMemoryUserRepository() UserRepository {
+	return &memoryUserRepository{
+		nextId:   1,
+		accounts: map[int64]*Account{},
+		profiles: map[int64]map[string]string{},
+		settings: map[int64]bool{},
+	}
+}
+
+func (r *memoryUserRepository) UserExists(ctx context.Context, email string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.byEmail(email) != nil
+}
+
+// byEmail skips deleted accounts, so their address can be registered again.
+func (r *memoryUserRepository) byEmail(email string) *Account {
+	for _, account := range r.accounts {
+		if account.Status != AccountDeleted && strings.EqualFold(account.Email, strings.TrimSpace(email)) {
+			return account
+		}
+	}
+	return nil
+}
+
+func (r *memoryUserRepository) CreateUser(ctx context.Context, userData map[string]string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.byEmail(userData["email"]) != nil {
+		return 0, fmt.Errorf("user %s already exists", userData["email"])
+	}
+	status := AccountStatus(userData["status"])
+	if status == "" {
+		status = AccountPending
+	}
+	id := r.nextId
+	r.nextId++
+	r.accounts[id] = &Account{
+		Id:           id,
+		Email:        strings.TrimSpace(userData["email"]),
+		FirstName:    userData["firstName"],
+		PasswordHash: userData["password"],
+		Status:       status,
+	}
+	return id, nil
+}
+
+func (r *memoryUserRepository) CreateUserProfile(ctx context.Context, userId int64, userData map[string]string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.profiles[userId] = map[string]string{"firstName": userData["firstName"], "lastName": userData["lastName"]}
+	return nil
+}
+
+func (r *memoryUserRepository) CreateUserSettings(ctx context.Context, userId int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.settings[userId] = true
+	return nil
+}
+
+func (r *memoryUserRepository) RemoveUser(ctx context.Context, userId int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.accounts, userId)
+	return nil
+}
+
+func (r *memoryUserRepository) RemoveUserProfile(ctx context.Context, userId int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.profiles, userId)
+	return nil
+}
+
+func (r *memoryUserRepository) RemoveUserSettings(ctx context.Context, userId int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.settings, userId)
+	return nil
+}
+
+func (r *memoryUserRepository) FindById(ctx context.Context, userId int64) (*Account, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return copyAccount(r.accounts[userId]), nil
+}
+
+func (r *memoryUserRepository) FindByEmail(ctx context.Context, email string) (*Account, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return copyAccount(r.byEmail(email)), nil
+}
+
+func (r *memoryUserRepository) FindByVerificationHash(ctx context.Context, tokenHash string) (*Account, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, account := range r.accounts {
+		if account.VerificationHash != "" && account.VerificationHash == tokenHash {
+			return copyAccount(account), nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *memoryUserRepository) SaveVerification(ctx context.Context, userId int64, tokenHash string, sentAt, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	account, ok := r.accounts[userId]
+	if !ok {
+		return fmt.Errorf("user %d not found", userId)
+	}
+	account.VerificationHash = tokenHash
+	account.VerificationSentAt = sentAt
+	account.VerificationExpiresAt = expiresAt
+	return nil
+}
+
+func (r *memoryUserRepository) MarkEmailVerified(ctx context.Context, userId int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	account, ok := r.accounts[userId]
+	if !ok {
+		return fmt.Errorf("user %d not found", userId)
+	}
+	if err := checkAccountTransition(account.Status, AccountActive); err != nil {
+		return err
+	}
+	account.Status = AccountActive
+	account.EmailVerified = true
+	account.VerificationHash = ""
+	return nil
+}
+
+func (r *memoryUserRepository) SetStatus(ctx context.Context, userId int64, status AccountStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	account, ok := r.accounts[userId]
+	if !ok {
+		return fmt.Errorf("user %d not found", userId)
+	}
+	if err := checkAccountTransition(account.Status, status); err != nil {
+		return err
+	}
+	account.Status = status
+	return nil
+}
+
+func (r *memoryUserRepository) UpdatePasswordHash(ctx context.Context, userId int64, passwordHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	account, ok := r.accounts[userId]
+	if !ok {
+		return fmt.Errorf("user %d not found", userId)
+	}
+	account.PasswordHash = passwordHash
+	return nil
+}
+
+func copyAccount(account *Account) *Account {
+	if account == nil {
+		return nil
+	}
+	copied := *account
+	return &copied
+}
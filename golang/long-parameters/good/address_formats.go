@@ -0,0 +1,136 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// usStatesCSV is the offline table of US states and the three-digit ZIP
+// prefixes each one owns.
+//
+//go:embed us_states.csv
+var usStatesCSV string
+
+type usState struct {
+	code     string
+	name     string
+	prefixes [][2]int
+}
+
+func (s usState) ownsZip(zip string) bool {
+	prefix, err := strconv.Atoi(zip[:3])
+	if err != nil {
+		return false
+	}
+	for _, r := range s.prefixes {
+		if prefix >= r[0] && prefix <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+var usStates, usStateNames = mustLoadUSStates(usStatesCSV)
+
+func mustLoadUSStates(data string) (map[string]usState, map[string]string) {
+	records, err := csv.NewReader(strings.NewReader(data)).ReadAll()
+	if err != nil {
+		panic(fmt.Sprintf("us_states.csv: %v", err))
+	}
+	states := map[string]usState{}
+	names := map[string]string{}
+	for i, record := range records[1:] {
+		state := usState{code: record[0], name: record[1]}
+		for _, part := range strings.Split(record[2], ";") {
+			from, to, found := strings.Cut(part, "-")
+			if !found {
+				to = from
+			}
+			low, errLow := strconv.Atoi(from)
+			high, errHigh := strconv.Atoi(to)
+			if errLow != nil || errHigh != nil || low > high {
+				panic(fmt.Sprintf("us_states.csv line %d: bad zip prefix range %q", i+2, part))
+			}
+			state.prefixes = append(state.prefixes, [2]int{low, high})
+		}
+		states[state.code] = state
+		names[strings.ToUpper(state.name)] = state.code
+	}
+	return states, names
+}
+
+// LabelLayout orders the locality line the way the country's post expects.
+type LabelLayout int
+
+const (
+	CityRegionPostal LabelLayout = iota // ANYTOWN CA 12345
+	PostalCity                          // 10115 BERLIN
+	CityThenPostal                      // LONDON / SW1A 1AA
+)
+
+// CountryFormat describes one country's postal conventions. Formatting
+// turns a bare code ("k1a0b1") into its printed form ("K1A 0B1").
+type CountryFormat struct {
+	Code           string
+	Name           string
+	PostalPattern  *regexp.Regexp
+	RegionRequired bool
+	Regions        map[string]string
+	Layout         LabelLayout
+	Format         func(code string) string
+}
+
+var canadianProvinces = map[string]string{
+	"AB": "T", "BC": "V", "MB": "R", "NB": "E", "NL": "A", "NS": "B", "NT": "X",
+	"NU": "X", "ON": "KLMNP", "PE": "C", "QC": "GHJ", "SK": "S", "YT": "Y",
+}
+
+var australianStates = map[string]string{
+	"ACT": "", "NSW": "", "NT": "", "QLD": "", "SA": "", "TAS": "", "VIC": "", "WA": "",
+}
+
+func splitBeforeLast(n int) func(string) string {
+	return func(code string) string {
+		code = strings.ReplaceAll(code, " ", "")
+		if len(code) <= n {
+			return code
+		}
+		return code[:len(code)-n] + " " + code[len(code)-n:]
+	}
+}
+
+func formatZip(code string) string {
+	digits := strings.NewReplacer("-", "", " ", "").Replace(code)
+	if len(digits) == 9 {
+		return digits[:5] + "-" + digits[5:]
+	}
+	return digits
+}
+
+func noSpaces(code string) string {
+	return strings.ReplaceAll(code, " ", "")
+}
+
+var countryFormats = map[string]CountryFormat{
+	"US": {Code: "US", Name: "United States", PostalPattern: regexp.MustCompile(`^\d{5}(-\d{4})?$`), RegionRequired: true, Layout: CityRegionPostal, Format: formatZip},
+	"CA": {Code: "CA", Name: "Canada", PostalPattern: regexp.MustCompile(`^[ABCEGHJ-NPRSTVXY]\d[A-Z] \d[A-Z]\d$`), RegionRequired: true, Regions: canadianProvinces, Layout: CityRegionPostal, Format: splitBeforeLast(3)},
+	"AU": {Code: "AU", Name: "Australia", PostalPattern: regexp.MustCompile(`^\d{4}$`), RegionRequired: true, Regions: australianStates, Layout: CityRegionPostal, Format: noSpaces},
+	"GB": {Code: "GB", Name: "United Kingdom", PostalPattern: regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? \d[A-Z]{2}$`), Layout: CityThenPostal, Format: splitBeforeLast(3)},
+	"DE": {Code: "DE", Name: "Germany", PostalPattern: regexp.MustCompile(`^\d{5}$`), Layout: PostalCity, Format: noSpaces},
+	"FR": {Code: "FR", Name: "France", PostalPattern: regexp.MustCompile(`^\d{5}$`), Layout: PostalCity, Format: noSpaces},
+	"NL": {Code: "NL", Name: "Netherlands", PostalPattern: regexp.MustCompile(`^\d{4} [A-Z]{2}$`), Layout: PostalCity, Format: splitBeforeLast(2)},
+	"ES": {Code: "ES", Name: "Spain", PostalPattern: regexp.MustCompile(`^\d{5}$`), Layout: PostalCity, Format: noSpaces},
+	"IT": {Code: "IT", Name: "Italy", PostalPattern: regexp.MustCompile(`^\d{5}$`), Layout: PostalCity, Format: noSpaces},
+}
+
+var countryAliases = map[string]string{
+	"USA": "US", "UNITED STATES": "US", "UNITED STATES OF AMERICA": "US",
+	"CANADA": "CA", "AUSTRALIA": "AU",
+	"UK": "GB", "UNITED KINGDOM": "GB", "GREAT BRITAIN": "GB",
+	"GERMANY": "DE", "DEUTSCHLAND": "DE", "FRANCE": "FR",
+	"NETHERLANDS": "NL", "THE NETHERLANDS": "NL", "SPAIN": "ES", "ITALY": "IT",
+}
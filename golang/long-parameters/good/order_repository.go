@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("order not found")
+	ErrConflict = errors.New("order was changed by someone else")
+)
+
+type OrderLineRecord struct {
+	ProductId       int
+	ProductName     string
+	UnitPrice       Money
+	Quantity        float64
+	DiscountPercent float64
+	TaxRate         float64
+	Subtotal        Money
+	LineDiscount    Money
+	Promotion       string
+	OrderDiscount   Money
+	TaxAmount       Money
+	Total           Money
+}
+
+// Order is the stored form of an order. Version goes up by one on every
+// write and is what optimistic locking compares against.
+type Order struct {
+	Id              int64
+	Version         int
+	Status          OrderStatus
+	CustomerId      int
+	CustomerName    string
+	CustomerEmail   string
+	CustomerPhone   string
+	ShippingAddress Address
+	BillingAddress  Address
+	Lines           []OrderLineRecord
+	Coupons         []string
+	ShippingMethod  string
+	PaymentMethod   string
+	Notes           string
+	Subtotal        Money
+	DiscountAmount  Money
+	TaxAmount       Money
+	ShippingCost    Money
+	Total           Money
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+const (
+	OrderCreated      = "created"
+	OrderUpdated      = "updated"
+	OrderStatusChange = "status_changed"
+)
+
+// OrderEvent is one entry in an order's audit history.
+type OrderEvent struct {
+	OrderId int64
+	Version int
+	Action  string
+	From    OrderStatus
+	To      OrderStatus
+	Reason  string
+	At      time.Time
+}
+
+type OrderRepository interface {
+	Create(ctx context.Context, order *Order) error
+	Get(ctx context.Context, id int64) (*Order, error)
+	// Update saves order if it is still at order.Version, returning
+	// ErrConflict if someone else saved it first.
+	Update(ctx context.Context, order *Order) error
+	Transition(ctx context.Context, id int64, version int, to OrderStatus, reason string) (*Order, error)
+	History(ctx context.Context, id int64) ([]OrderEvent, error)
+}
+
+type memoryOrderRepository struct {
+	mu      sync.Mutex
+	nextId  int64
+	orders  map[int64]*Order
+	history map[int64][]OrderEvent
+	now     func() time.Time
+}
+
+// NewMemoryOrderRepository keeps orders in process memory, for demos and
+// tests. Every read and write goes through a copy, so callers never share
+// an order with the store.
+func NewMemoryOrderRepository() OrderRepository {
+	return &memoryOrderRepository{
+		nextId:  1,
+		orders:  map[int64]*Order{},
+		history: map[int64][]OrderEvent{},
+		now:     time.Now,
+	}
+}
+
+func (r *memoryOrderRepository) Create(ctx context.Context, order *Order) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	order.Id = r.nextId
+	r.nextId++
+	order.Status = OrderPending
+	order.Version = 1
+	order.CreatedAt = now
+	order.UpdatedAt = now
+	r.orders[order.Id] = order.clone()
+	r.record(OrderEvent{OrderId: order.Id, Version: order.Version, Action: OrderCreated, To: order.Status, At: now})
+	return nil
+}
+
+func (r *memoryOrderRepository) Update(ctx context.Context, order *Order) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, err := r.find(order.Id, order.Version)
+	if err != nil {
+		return err
+	}
+	now := r.now()
+	order.Version++
+	order.Status = stored.Status
+	order.CreatedAt = stored.CreatedAt
+	order.UpdatedAt = now
+	r.orders[order.Id] = order.clone()
+	r.record(OrderEvent{OrderId: order.Id, Version: order.Version, Action: OrderUpdated, From: order.Status, To: order.Status, At: now})
+	return nil
+}
+
+// Transition moves an order to a new status if the state machine allows
+// it and the caller saw the latest version.
+func (r *memoryOrderRepository) Transition(ctx context.Context, id int64, version int, to OrderStatus, reason string) (*Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, err := r.find(id, version)
+	if err != nil {
+		return nil, err
+	}
+	from := stored.Status
+	if err := checkTransition(from, to); err != nil {
+		return nil, err
+	}
+	stored.Status = to
+	stored.Version++
+	stored.UpdatedAt = r.now()
+	r.record(OrderEvent{OrderId: id, Version: stored.Version, Action: OrderStatusChange, From: from, To: to, Reason: reason, At: stored.UpdatedAt})
+	return stored.clone(), nil
+}
+
+func (r *memoryOrderRepository) Get(ctx context.Context, id int64) (*Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.orders[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrNotFound, id)
+	}
+	return stored.clone(), nil
+}
+
+func (r *memoryOrderRepository) History(ctx context.Context, id int64) ([]OrderEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events, ok := r.history[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrNotFound, id)
+	}
+	return append([]OrderEvent(nil), events...), nil
+}
+
+// find returns the stored order if the caller saw its latest version.
+func (r *memoryOrderRepository) find(id int64, version int) (*Order, error) {
+	stored, ok := r.orders[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrNotFound, id)
+	}
+	if stored.Version != version {
+		return nil, fmt.Errorf("%w: order %d is at version %d, not %d", ErrConflict, id, stored.Version, version)
+	}
+	return stored, nil
+}
+
+func (r *memoryOrderRepository) record(event OrderEvent) {
+	r.history[event.OrderId] = append(r.history[event.OrderId], event)
+}
+
+func (o *Order) clone() *Order {
+	copied := *o
+	copied.Lines = append([]OrderLineRecord(nil), o.Lines...)
+	copied.Coupons = append([]string(nil), o.Coupons...)
+	return &copied
+}
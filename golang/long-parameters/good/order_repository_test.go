@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestOrderRepository() *memoryOrderRepository {
+	repository := NewMemoryOrderRepository().(*memoryOrderRepository)
+	clock := time.Date(2026, time.May, 4, 10, 0, 0, 0, time.UTC)
+	repository.now = func() time.Time {
+		clock = clock.Add(time.Minute)
+		return clock
+	}
+	return repository
+}
+
+func TestStaleVersionIsAConflict(t *testing.T) {
+	ctx := context.Background()
+	repository := newTestOrderRepository()
+	order := &Order{CustomerName: "John Doe", Notes: "first"}
+	if err := repository.Create(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+
+	mine, _ := repository.Get(ctx, order.Id)
+	theirs, _ := repository.Get(ctx, order.Id)
+	theirs.Notes = "theirs"
+	if err := repository.Update(ctx, theirs); err != nil {
+		t.Fatal(err)
+	}
+	if theirs.Version != 2 {
+		t.Fatalf("version after update = %d, want 2", theirs.Version)
+	}
+
+	mine.Notes = "mine"
+	if err := repository.Update(ctx, mine); !errors.Is(err, ErrConflict) {
+		t.Fatalf("update from version 1: got %v, want ErrConflict", err)
+	}
+	if _, err := repository.Transition(ctx, order.Id, 1, OrderPaid, ""); !errors.Is(err, ErrConflict) {
+		t.Fatalf("transition from version 1: got %v, want ErrConflict", err)
+	}
+	stored, _ := repository.Get(ctx, order.Id)
+	if stored.Notes != "theirs" || stored.Version != 2 || stored.Status != OrderPending {
+		t.Errorf("stored order = %q v%d %s, want the first writer's change", stored.Notes, stored.Version, stored.Status)
+	}
+
+	if err := repository.Update(ctx, &Order{Id: 99, Version: 1}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("update of a missing order: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestUpdateCannotChangeStatus(t *testing.T) {
+	ctx := context.Background()
+	repository := newTestOrderRepository()
+	order := &Order{CustomerName: "John Doe"}
+	if err := repository.Create(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+	order.Status = OrderDelivered
+	if err := repository.Update(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := repository.Get(ctx, order.Id)
+	if stored.Status != OrderPending {
+		t.Errorf("status after update = %s, want pending", stored.Status)
+	}
+}
+
+func TestStoredOrdersAreCopies(t *testing.T) {
+	ctx := context.Background()
+	repository := newTestOrderRepository()
+	order := &Order{Coupons: []string{"WELCOME10"}, Lines: []OrderLineRecord{{ProductName: "Widget"}}}
+	if err := repository.Create(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+	order.Coupons[0] = "changed"
+	order.Lines[0].ProductName = "changed"
+
+	stored, _ := repository.Get(ctx, order.Id)
+	if stored.Coupons[0] != "WELCOME10" || stored.Lines[0].ProductName != "Widget" {
+		t.Errorf("changing the caller's order reached the store: %v %v", stored.Coupons, stored.Lines)
+	}
+}
@@ -1,31 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"time"
+	"strings"
 )
 
-type Address struct {
-	street  string
-	city    string
-	state   string
-	zipCode string
-}
-
-func NewAddress(street, city, state, zipCode string) *Address {
-	return &Address{
-		street:  street,
-		city:    city,
-		state:   state,
-		zipCode: zipCode,
-	}
-}
-
 type Customer struct {
-	id             int
-	name           string
-	email          string
-	phone          string
+	id              int
+	name            string
+	email           string
+	phone           string
 	shippingAddress *Address
 	billingAddress  *Address
 }
@@ -44,10 +30,10 @@ func NewCustomer(id int, name, email, phone string, shippingAddress, billingAddr
 type Product struct {
 	id    int
 	name  string
-	price float64
+	price Money
 }
 
-func NewProduct(id int, name string, price float64) *Product {
+func NewProduct(id int, name string, price Money) *Product {
 	return &Product{
 		id:    id,
 		name:  name,
@@ -55,154 +41,437 @@ func NewProduct(id int, name string, price float64) *Product {
 	}
 }
 
-type OrderDetails struct {
+// OrderLine is one product on an order. discountPercent is a manual
+// discount agreed for this line; taxRate is a percentage such as 8.25.
+type OrderLine struct {
 	product         *Product
 	quantity        float64
 	taxRate         float64
 	discountPercent float64
-	shippingMethod  string
-	paymentMethod   string
-	notes           string
 }
 
-func NewOrderDetails(product *Product, quantity, taxRate, discountPercent float64, shippingMethod, paymentMethod, notes string) *OrderDetails {
-	return &OrderDetails{
+func NewOrderLine(product *Product, quantity, taxRate, discountPercent float64) *OrderLine {
+	return &OrderLine{
 		product:         product,
 		quantity:        quantity,
 		taxRate:         taxRate,
 		discountPercent: discountPercent,
-		shippingMethod:  shippingMethod,
-		paymentMethod:   paymentMethod,
-		notes:           notes,
 	}
 }
 
-type OrderCalculator struct{}
+type OrderDetails struct {
+	lines          []*OrderLine
+	couponCodes    []string
+	shippingMethod string
+	paymentMethod  string
+	notes          string
+}
+
+func NewOrderDetails(lines []*OrderLine, couponCodes []string, shippingMethod, paymentMethod, notes string) *OrderDetails {
+	return &OrderDetails{
+		lines:          lines,
+		couponCodes:    couponCodes,
+		shippingMethod: shippingMethod,
+		paymentMethod:  paymentMethod,
+		notes:          notes,
+	}
+}
+
+type LineTotals struct {
+	Line          *OrderLine
+	Subtotal      Money
+	LineDiscount  Money
+	Promotion     string
+	OrderDiscount Money
+	TaxAmount     Money
+	Total         Money
+}
+
+type OrderTotals struct {
+	Lines          []LineTotals
+	Coupons        []string
+	Subtotal       Money
+	DiscountAmount Money
+	TaxAmount      Money
+	ShippingCost   Money
+	Total          Money
+}
+
+type OrderCalculator struct {
+	promotions []LinePromotion
+	coupons    map[string]Coupon
+}
+
+func NewOrderCalculator(promotions []LinePromotion, coupons []Coupon) OrderCalculator {
+	oc := OrderCalculator{promotions: promotions, coupons: map[string]Coupon{}}
+	for _, coupon := range coupons {
+		oc.coupons[strings.ToUpper(coupon.Code)] = coupon
+	}
+	return oc
+}
+
+// CalculateTotals prices each line with its best line promotion, spreads
+// coupon discounts across the lines, then taxes every line at its own rate
+// on what is left.
+func (oc OrderCalculator) CalculateTotals(orderDetails *OrderDetails) (OrderTotals, error) {
+	if len(orderDetails.lines) == 0 {
+		return OrderTotals{}, fmt.Errorf("order has no lines")
+	}
+	currency := orderDetails.lines[0].product.price.Currency()
+	for i, line := range orderDetails.lines {
+		switch {
+		case line.product.price.Currency() != currency:
+			return OrderTotals{}, fmt.Errorf("line %d: %s price on a %s order", i+1, line.product.price.Currency(), currency)
+		case line.quantity <= 0:
+			return OrderTotals{}, fmt.Errorf("line %d: quantity must be positive", i+1)
+		case line.discountPercent < 0 || line.discountPercent > 100:
+			return OrderTotals{}, fmt.Errorf("line %d: discount must be between 0 and 100 percent", i+1)
+		case line.taxRate < 0:
+			return OrderTotals{}, fmt.Errorf("line %d: tax rate cannot be negative", i+1)
+		}
+	}
+	coupons, err := oc.resolveCoupons(orderDetails.couponCodes)
+	if err != nil {
+		return OrderTotals{}, err
+	}
 
-func (oc OrderCalculator) CalculateTotals(orderDetails *OrderDetails) map[string]float64 {
-	subtotal := orderDetails.product.price * orderDetails.quantity
-	discountAmount := subtotal * (orderDetails.discountPercent / 100)
-	taxableAmount := subtotal - discountAmount
-	taxAmount := taxableAmount * (orderDetails.taxRate / 100)
+	totals := OrderTotals{
+		Subtotal:       NewMoney(0, currency),
+		DiscountAmount: NewMoney(0, currency),
+		TaxAmount:      NewMoney(0, currency),
+	}
+	remaining := make([]Money, len(orderDetails.lines))
+	discounted := NewMoney(0, currency)
+	for i, line := range orderDetails.lines {
+		lineTotals, err := oc.priceLine(line)
+		if err != nil {
+			return OrderTotals{}, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if remaining[i], err = lineTotals.Subtotal.Subtract(lineTotals.LineDiscount); err != nil {
+			return OrderTotals{}, err
+		}
+		if discounted, err = discounted.Add(remaining[i]); err != nil {
+			return OrderTotals{}, err
+		}
+		totals.Lines = append(totals.Lines, lineTotals)
+	}
+
+	for _, coupon := range coupons {
+		if coupon.MinSubtotal.SameCurrency(discounted) && discounted.MinorUnits() < coupon.MinSubtotal.MinorUnits() {
+			return OrderTotals{}, fmt.Errorf("%w: %s needs %s after line discounts", ErrCouponNotEligible, coupon.Code, coupon.MinSubtotal.Format())
+		}
+		left := NewMoney(0, currency)
+		for _, amount := range remaining {
+			if left, err = left.Add(amount); err != nil {
+				return OrderTotals{}, err
+			}
+		}
+		discount, err := left.Multiply(coupon.Percent/100, RoundHalfEven)
+		if err != nil {
+			return OrderTotals{}, err
+		}
+		if coupon.Percent == 0 {
+			if !coupon.Amount.SameCurrency(left) {
+				return OrderTotals{}, fmt.Errorf("%w: %s is in %s", ErrCouponNotEligible, coupon.Code, coupon.Amount.Currency())
+			}
+			discount = coupon.Amount
+		}
+		if discount.MinorUnits() > left.MinorUnits() {
+			discount = left
+		}
+		for i, share := range allocate(discount, remaining) {
+			if totals.Lines[i].OrderDiscount, err = totals.Lines[i].OrderDiscount.Add(share); err != nil {
+				return OrderTotals{}, err
+			}
+			if remaining[i], err = remaining[i].Subtract(share); err != nil {
+				return OrderTotals{}, err
+			}
+		}
+		totals.Coupons = append(totals.Coupons, coupon.Code)
+	}
+
+	taxable := NewMoney(0, currency)
+	for i := range totals.Lines {
+		lineTotals := &totals.Lines[i]
+		if lineTotals.TaxAmount, err = remaining[i].Multiply(lineTotals.Line.taxRate/100, RoundHalfUp); err != nil {
+			return OrderTotals{}, err
+		}
+		if lineTotals.Total, err = remaining[i].Add(lineTotals.TaxAmount); err != nil {
+			return OrderTotals{}, err
+		}
+		if totals.Subtotal, err = totals.Subtotal.Add(lineTotals.Subtotal); err != nil {
+			return OrderTotals{}, err
+		}
+		lineDiscount, err := lineTotals.LineDiscount.Add(lineTotals.OrderDiscount)
+		if err != nil {
+			return OrderTotals{}, err
+		}
+		if totals.DiscountAmount, err = totals.DiscountAmount.Add(lineDiscount); err != nil {
+			return OrderTotals{}, err
+		}
+		if totals.TaxAmount, err = totals.TaxAmount.Add(lineTotals.TaxAmount); err != nil {
+			return OrderTotals{}, err
+		}
+		if taxable, err = taxable.Add(remaining[i]); err != nil {
+			return OrderTotals{}, err
+		}
+	}
 
 	// Simplified shipping cost calculation
-	shippingCost := 9.99
+	totals.ShippingCost = NewMoney(999, currency)
 	if orderDetails.shippingMethod == "express" {
-		shippingCost = 19.99
+		totals.ShippingCost = NewMoney(1999, currency)
 	}
 
-	total := taxableAmount + taxAmount + shippingCost
+	if totals.Total, err = taxable.Add(totals.TaxAmount); err != nil {
+		return OrderTotals{}, err
+	}
+	if totals.Total, err = totals.Total.Add(totals.ShippingCost); err != nil {
+		return OrderTotals{}, err
+	}
+	return totals, nil
+}
 
-	return map[string]float64{
-		"subtotal":       subtotal,
-		"discount_amount": discountAmount,
-		"tax_amount":     taxAmount,
-		"shipping_cost":  shippingCost,
-		"total":          total,
+// priceLine applies the line's own discount or its best promotion,
+// whichever takes more off.
+func (oc OrderCalculator) priceLine(line *OrderLine) (LineTotals, error) {
+	currency := line.product.price.Currency()
+	lineTotals := LineTotals{Line: line, OrderDiscount: NewMoney(0, currency)}
+	var err error
+	if lineTotals.Subtotal, err = line.product.price.Multiply(line.quantity, RoundHalfEven); err != nil {
+		return LineTotals{}, err
+	}
+	if lineTotals.LineDiscount, err = lineTotals.Subtotal.Multiply(line.discountPercent/100, RoundHalfEven); err != nil {
+		return LineTotals{}, err
+	}
+	if line.discountPercent > 0 {
+		lineTotals.Promotion = "line discount"
 	}
+	for _, promotion := range oc.promotions {
+		discount, err := promotion.Discount(line, lineTotals.Subtotal)
+		if err != nil {
+			return LineTotals{}, fmt.Errorf("%s: %w", promotion.Name(), err)
+		}
+		if better, err := discount.Compare(lineTotals.LineDiscount); err != nil {
+			return LineTotals{}, fmt.Errorf("%s: %w", promotion.Name(), err)
+		} else if better > 0 {
+			lineTotals.LineDiscount = discount
+			lineTotals.Promotion = promotion.Name()
+		}
+	}
+	if lineTotals.LineDiscount.MinorUnits() > lineTotals.Subtotal.MinorUnits() {
+		lineTotals.LineDiscount = lineTotals.Subtotal
+	}
+	return lineTotals, nil
 }
 
+var ErrOrderLocked = errors.New("order can no longer be edited")
+
 type OrderService struct {
 	calculator OrderCalculator
+	orders     OrderRepository
 }
 
-func NewOrderService() *OrderService {
+func NewOrderService(calculator OrderCalculator, orders OrderRepository) *OrderService {
 	return &OrderService{
-		calculator: OrderCalculator{},
-	}
-}
-
-func (os OrderService) CreateOrder(customer *Customer, orderDetails *OrderDetails) map[string]interface{} {
-	totals := os.calculator.CalculateTotals(orderDetails)
-
-	orderData := map[string]interface{}{
-		"customer_id":       customer.id,
-		"customer_name":     customer.name,
-		"customer_email":    customer.email,
-		"customer_phone":    customer.phone,
-		"customer_address":  customer.shippingAddress.street,
-		"customer_city":     customer.shippingAddress.city,
-		"customer_state":    customer.shippingAddress.state,
-		"customer_zip":      customer.shippingAddress.zipCode,
-		"product_id":        orderDetails.product.id,
-		"product_name":      orderDetails.product.name,
-		"product_price":     orderDetails.product.price,
-		"quantity":          orderDetails.quantity,
-		"subtotal":          totals["subtotal"],
-		"discount_percent":  orderDetails.discountPercent,
-		"discount_amount":   totals["discount_amount"],
-		"tax_rate":          orderDetails.taxRate,
-		"tax_amount":        totals["tax_amount"],
-		"shipping_method":   orderDetails.shippingMethod,
-		"shipping_cost":     totals["shipping_cost"],
-		"payment_method":    orderDetails.paymentMethod,
-		"billing_address":   customer.billingAddress.street,
-		"billing_city":      customer.billingAddress.city,
-		"billing_state":     customer.billingAddress.state,
-		"billing_zip":       customer.billingAddress.zipCode,
-		"total":             totals["total"],
-		"notes":             orderDetails.notes,
-		"created_at":        time.Now().Format("2006-01-02 15:04:05"),
-	}
-
-	// In a real application, this would save to database
-	return orderData
-}
-
-func (os OrderService) UpdateOrder(orderId int, customer *Customer, orderDetails *OrderDetails) map[string]interface{} {
-	totals := os.calculator.CalculateTotals(orderDetails)
-
-	orderData := map[string]interface{}{
-		"id":                 orderId,
-		"customer_id":        customer.id,
-		"customer_name":      customer.name,
-		"customer_email":     customer.email,
-		"customer_phone":     customer.phone,
-		"customer_address":   customer.shippingAddress.street,
-		"customer_city":      customer.shippingAddress.city,
-		"customer_state":     customer.shippingAddress.state,
-		"customer_zip":       customer.shippingAddress.zipCode,
-		"product_id":         orderDetails.product.id,
-		"product_name":       orderDetails.product.name,
-		"product_price":     orderDetails.product.price,
-		"quantity":          orderDetails.quantity,
-		"subtotal":          totals["subtotal"],
-		"discount_percent":  orderDetails.discountPercent,
-		"discount_amount":   totals["discount_amount"],
-		"tax_rate":          orderDetails.taxRate,
-		"tax_amount":        totals["tax_amount"],
-		"shipping_method":   orderDetails.shippingMethod,
-		"shipping_cost":     totals["shipping_cost"],
-		"payment_method":    orderDetails.paymentMethod,
-		"billing_address":   customer.billingAddress.street,
-		"billing_city":      customer.billingAddress.city,
-		"billing_state":     customer.billingAddress.state,
-		"billing_zip":       customer.billingAddress.zipCode,
-		"total":             totals["total"],
-		"notes":             orderDetails.notes,
-		"updated_at":        time.Now().Format("2006-01-02 15:04:05"),
-	}
-
-	return orderData
+		calculator: calculator,
+		orders:     orders,
+	}
+}
+
+func (os OrderService) CreateOrder(ctx context.Context, customer *Customer, orderDetails *OrderDetails) (*Order, error) {
+	if err := validateAddresses(customer); err != nil {
+		return nil, err
+	}
+	totals, err := os.calculator.CalculateTotals(orderDetails)
+	if err != nil {
+		return nil, err
+	}
+
+	order := &Order{}
+	fillOrder(order, customer, orderDetails, totals)
+	if err := os.orders.Create(ctx, order); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// UpdateOrder reprices and saves a pending order. version is the one the
+// caller last read; a stale version fails with ErrConflict.
+func (os OrderService) UpdateOrder(ctx context.Context, orderId int64, version int, customer *Customer, orderDetails *OrderDetails) (*Order, error) {
+	if err := validateAddresses(customer); err != nil {
+		return nil, err
+	}
+	totals, err := os.calculator.CalculateTotals(orderDetails)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := os.orders.Get(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != OrderPending {
+		return nil, fmt.Errorf("%w: order %d is %s", ErrOrderLocked, orderId, order.Status)
+	}
+	order.Version = version
+	fillOrder(order, customer, orderDetails, totals)
+	if err := os.orders.Update(ctx, order); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+func (os OrderService) ChangeStatus(ctx context.Context, orderId int64, version int, status OrderStatus, reason string) (*Order, error) {
+	return os.orders.Transition(ctx, orderId, version, status, reason)
+}
+
+func (os OrderService) History(ctx context.Context, orderId int64) ([]OrderEvent, error) {
+	return os.orders.History(ctx, orderId)
+}
+
+func validateAddresses(customer *Customer) error {
+	if err := customer.shippingAddress.Validate(); err != nil {
+		return fmt.Errorf("shipping address: %w", err)
+	}
+	if err := customer.billingAddress.Validate(); err != nil {
+		return fmt.Errorf("billing address: %w", err)
+	}
+	return nil
+}
+
+// fillOrder is shared by CreateOrder and UpdateOrder so both always store
+// the same fields and the same totals.
+func fillOrder(order *Order, customer *Customer, orderDetails *OrderDetails, totals OrderTotals) {
+	order.CustomerId = customer.id
+	order.CustomerName = customer.name
+	order.CustomerEmail = customer.email
+	order.CustomerPhone = customer.phone
+	order.ShippingAddress = *customer.shippingAddress.Normalize()
+	order.BillingAddress = *customer.billingAddress.Normalize()
+	order.Coupons = totals.Coupons
+	order.ShippingMethod = orderDetails.shippingMethod
+	order.PaymentMethod = orderDetails.paymentMethod
+	order.Notes = orderDetails.notes
+	order.Subtotal = totals.Subtotal
+	order.DiscountAmount = totals.DiscountAmount
+	order.TaxAmount = totals.TaxAmount
+	order.ShippingCost = totals.ShippingCost
+	order.Total = totals.Total
+
+	order.Lines = order.Lines[:0]
+	for _, line := range totals.Lines {
+		order.Lines = append(order.Lines, OrderLineRecord{
+			ProductId:       line.Line.product.id,
+			ProductName:     line.Line.product.name,
+			UnitPrice:       line.Line.product.price,
+			Quantity:        line.Line.quantity,
+			DiscountPercent: line.Line.discountPercent,
+			TaxRate:         line.Line.taxRate,
+			Subtotal:        line.Subtotal,
+			LineDiscount:    line.LineDiscount,
+			Promotion:       line.Promotion,
+			OrderDiscount:   line.OrderDiscount,
+			TaxAmount:       line.TaxAmount,
+			Total:           line.Total,
+		})
+	}
 }
 
 func main() {
-	os := NewOrderService()
+	calculator := NewOrderCalculator(
+		[]LinePromotion{
+			BuyXGetY{ProductId: 102, Buy: 2, Free: 1},
+			TieredDiscount{ProductId: 101, Tiers: []QuantityTier{{MinQuantity: 5, Percent: 5}, {MinQuantity: 10, Percent: 12}}},
+		},
+		[]Coupon{
+			{Code: "WELCOME10", Percent: 10, Stackable: true},
+			{Code: "SAVE5", Amount: NewMoney(500, "USD"), MinSubtotal: NewMoney(5000, "USD"), Stackable: true},
+			{Code: "HALFOFF", Percent: 50},
+		},
+	)
+	ctx := context.Background()
+	os := NewOrderService(calculator, NewMemoryOrderRepository())
 
 	// Create objects instead of long parameter lists
-	shippingAddr := NewAddress("123 Main St", "Anytown", "CA", "12345")
-	billingAddr := NewAddress("123 Main St", "Anytown", "CA", "12345")
+	shippingAddr := NewAddress("123 Main Street", "Anytown", "CA", "95401")
+	billingAddr := NewAddress("123 Main Street", "Anytown", "California", "95401")
 	customer := NewCustomer(1, "John Doe", "john@example.com", "555-1234", shippingAddr, billingAddr)
 
-	product := NewProduct(101, "Widget", 29.99)
-	orderDetails := NewOrderDetails(product, 2, 8.25, 10, "standard", "credit_card", "Handle with care")
+	widget := NewProduct(101, "Widget", NewMoney(2999, "USD"))
+	gadget := NewProduct(102, "Gadget", NewMoney(1250, "USD"))
+	book := NewProduct(103, "Manual", NewMoney(1999, "USD"))
+	orderDetails := NewOrderDetails([]*OrderLine{
+		NewOrderLine(widget, 10, 8.25, 10),
+		NewOrderLine(gadget, 3, 8.25, 0),
+		NewOrderLine(book, 1, 0, 0),
+	}, []string{"WELCOME10", "SAVE5"}, "standard", "credit_card", "Handle with care")
 
 	// Much cleaner method calls!
-	order := os.CreateOrder(customer, orderDetails)
+	order, err := os.CreateOrder(ctx, customer, orderDetails)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	for _, line := range order.Lines {
+		fmt.Printf("  %-7s x%-3v %10s", line.ProductName, line.Quantity, line.Subtotal.Format())
+		if !line.LineDiscount.IsZero() {
+			fmt.Printf("  -%s %s", line.LineDiscount.Format(), line.Promotion)
+		}
+		if !line.OrderDiscount.IsZero() {
+			fmt.Printf("  -%s coupons", line.OrderDiscount.Format())
+		}
+		fmt.Printf("  tax %s\n", line.TaxAmount.Format())
+	}
+	fmt.Printf("Order %d total: %s (%s, v%d)\n", order.Id, order.Total.Format(), order.Status, order.Version)
+	fmt.Printf("Ship to:\n%s\n", order.ShippingAddress.ToLabelFormat())
+
+	shortZip := NewCustomer(2, "Jane Roe", "jane@example.com", "555-9876", NewAddress("9 Elm St", "Austin", "TX", "7870"), billingAddr)
+	if _, err := os.CreateOrder(ctx, shortZip, orderDetails); errors.Is(err, ErrInvalidAddress) {
+		fmt.Println("Rejected:", err)
+	}
+
+	updated, err := os.UpdateOrder(ctx, order.Id, order.Version, customer, NewOrderDetails(orderDetails.lines[:1], []string{"HALFOFF"}, "express", "credit_card", ""))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Updated order total: %s (v%d)\n", updated.Total.Format(), updated.Version)
+
+	if _, err := os.UpdateOrder(ctx, order.Id, order.Version, customer, orderDetails); errors.Is(err, ErrConflict) {
+		fmt.Println("Stale update rejected:", err)
+	}
+	if _, err := os.CreateOrder(ctx, customer, NewOrderDetails(orderDetails.lines, []string{"HALFOFF", "WELCOME10"}, "standard", "credit_card", "")); err != nil {
+		fmt.Println("Rejected:", err)
+	}
+
+	current := updated
+	for _, status := range []OrderStatus{OrderPaid, OrderShipped, OrderDelivered} {
+		if current, err = os.ChangeStatus(ctx, current.Id, current.Version, status, ""); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+	if _, err := os.ChangeStatus(ctx, current.Id, current.Version, OrderCancelled, "customer changed mind"); errors.Is(err, ErrInvalidTransition) {
+		fmt.Println("Rejected:", err)
+	}
+	if _, err := os.ChangeStatus(ctx, current.Id, current.Version, OrderRefunded, "arrived damaged"); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	history, err := os.History(ctx, order.Id)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("History of order %d:\n", order.Id)
+	for _, event := range history {
+		fmt.Printf("  v%d %-14s %-9s -> %-9s %s\n", event.Version, event.Action, event.From, event.To, event.Reason)
+	}
 
-	fmt.Printf("Order total: $%.2f\n", order["total"])
 	fmt.Println("Long parameter lists have been replaced with objects:")
 	fmt.Println("- Customer object contains customer data and addresses")
-	fmt.Println("- OrderDetails object contains order-specific data")
+	fmt.Println("- OrderDetails object contains order lines, coupons and delivery data")
 	fmt.Println("- Product object contains product information")
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestOrderStatusTransitions(t *testing.T) {
+	statuses := []OrderStatus{OrderPending, OrderPaid, OrderShipped, OrderDelivered, OrderCancelled, OrderRefunded}
+	allowed := map[[2]OrderStatus]bool{
+		{OrderPending, OrderPaid}:       true,
+		{OrderPending, OrderCancelled}:  true,
+		{OrderPaid, OrderShipped}:       true,
+		{OrderPaid, OrderRefunded}:      true,
+		{OrderShipped, OrderDelivered}:  true,
+		{OrderDelivered, OrderRefunded}: true,
+	}
+	for _, from := range statuses {
+		for _, to := range statuses {
+			if got := from.CanTransitionTo(to); got != allowed[[2]OrderStatus{from, to}] {
+				t.Errorf("%s to %s allowed = %v", from, to, got)
+			}
+		}
+	}
+	for _, status := range []OrderStatus{OrderCancelled, OrderRefunded} {
+		if !status.Terminal() {
+			t.Errorf("%s is not terminal", status)
+		}
+	}
+}
+
+func TestRejectedTransitionLeavesNoHistory(t *testing.T) {
+	ctx := context.Background()
+	repository := newTestOrderRepository()
+	order := &Order{CustomerName: "John Doe"}
+	if err := repository.Create(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repository.Transition(ctx, order.Id, 1, OrderShipped, "skip payment"); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("pending to shipped: got %v, want ErrInvalidTransition", err)
+	}
+	paid, err := repository.Transition(ctx, order.Id, 1, OrderPaid, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repository.Transition(ctx, order.Id, paid.Version, OrderCancelled, "too late"); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("paid to cancelled: got %v, want ErrInvalidTransition", err)
+	}
+	refunded, err := repository.Transition(ctx, order.Id, paid.Version, OrderRefunded, "out of stock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refunded.Status != OrderRefunded || refunded.Version != 3 {
+		t.Fatalf("after refund order is %s v%d, want refunded v3", refunded.Status, refunded.Version)
+	}
+	if _, err := repository.Transition(ctx, order.Id, refunded.Version, OrderPaid, ""); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("leaving refunded: got %v, want ErrInvalidTransition", err)
+	}
+
+	history, err := repository.History(ctx, order.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []OrderEvent{
+		{Version: 1, Action: OrderCreated, To: OrderPending},
+		{Version: 2, Action: OrderStatusChange, From: OrderPending, To: OrderPaid},
+		{Version: 3, Action: OrderStatusChange, From: OrderPaid, To: OrderRefunded, Reason: "out of stock"},
+	}
+	if len(history) != len(want) {
+		t.Fatalf("history has %d events, want %d: %+v", len(history), len(want), history)
+	}
+	for i, event := range history {
+		if event.OrderId != order.Id || event.Version != want[i].Version || event.Action != want[i].Action ||
+			event.From != want[i].From || event.To != want[i].To || event.Reason != want[i].Reason {
+			t.Errorf("event %d = %+v, want %+v", i, event, want[i])
+		}
+		if i > 0 && !event.At.After(history[i-1].At) {
+			t.Errorf("event %d at %s is not after the one before", i, event.At)
+		}
+	}
+}
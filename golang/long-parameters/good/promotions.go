@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrUnknownCoupon     = errors.New("unknown coupon code")
+	ErrCouponConflict    = errors.New("coupon cannot be combined with other coupons")
+	ErrCouponNotEligible = errors.New("order does not qualify for coupon")
+)
+
+// LinePromotion discounts a single order line automatically. A line gets
+// at most one: whichever promotion, or the line's own discount percent, is
+// worth the most.
+type LinePromotion interface {
+	Name() string
+	Discount(line *OrderLine, subtotal Money) (Money, error)
+}
+
+// BuyXGetY gives Free units away for every Buy+Free units of a product.
+type BuyXGetY struct {
+	ProductId int
+	Buy       int
+	Free      int
+}
+
+func (p BuyXGetY) Name() string {
+	return fmt.Sprintf("buy %d get %d free", p.Buy, p.Free)
+}
+
+func (p BuyXGetY) Discount(line *OrderLine, subtotal Money) (Money, error) {
+	if line.product.id != p.ProductId || p.Buy <= 0 || p.Free <= 0 {
+		return NewMoney(0, subtotal.Currency()), nil
+	}
+	free := math.Floor(line.quantity/float64(p.Buy+p.Free)) * float64(p.Free)
+	return line.product.price.Multiply(free, RoundHalfEven)
+}
+
+type QuantityTier struct {
+	MinQuantity float64
+	Percent     float64
+}
+
+// TieredDiscount takes a larger percentage off as the quantity of a line
+// grows. ProductId 0 applies to every product.
+type TieredDiscount struct {
+	ProductId int
+	Tiers     []QuantityTier
+}
+
+func (p TieredDiscount) Name() string {
+	return "volume discount"
+}
+
+func (p TieredDiscount) Discount(line *OrderLine, subtotal Money) (Money, error) {
+	percent := 0.0
+	if p.ProductId == 0 || line.product.id == p.ProductId {
+		for _, tier := range p.Tiers {
+			if line.quantity >= tier.MinQuantity && tier.Percent > percent {
+				percent = tier.Percent
+			}
+		}
+	}
+	return subtotal.Multiply(percent/100, RoundHalfEven)
+}
+
+// Coupon is an order-level discount entered by the customer, either a
+// percentage or a fixed amount. Stackable coupons combine with each other;
+// one that is not stackable must be the only coupon on the order.
+type Coupon struct {
+	Code        string
+	Percent     float64
+	Amount      Money
+	MinSubtotal Money
+	Stackable   bool
+}
+
+// resolveCoupons looks up the codes on an order and enforces the stacking
+// rules. Percentage coupons come first so fixed amounts are taken off the
+// already reduced total.
+func (oc OrderCalculator) resolveCoupons(codes []string) ([]Coupon, error) {
+	seen := map[string]bool{}
+	var coupons []Coupon
+	for _, code := range codes {
+		key := strings.ToUpper(strings.TrimSpace(code))
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		coupon, ok := oc.coupons[key]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownCoupon, code)
+		}
+		coupons = append(coupons, coupon)
+	}
+	if len(coupons) > 1 {
+		for _, coupon := range coupons {
+			if !coupon.Stackable {
+				return nil, fmt.Errorf("%w: %s", ErrCouponConflict, coupon.Code)
+			}
+		}
+	}
+	sort.SliceStable(coupons, func(i, j int) bool {
+		return coupons[i].Percent > 0 && coupons[j].Percent == 0
+	})
+	return coupons, nil
+}
+
+// allocate splits an order-level discount across lines in proportion to
+// their amounts, handing leftover cents to the largest lines so the parts
+// always add up to the whole.
+func allocate(amount Money, weights []Money) []Money {
+	shares := make([]Money, len(weights))
+	total := big.NewInt(0)
+	for i, weight := range weights {
+		shares[i] = NewMoney(0, amount.Currency())
+		total.Add(total, big.NewInt(weight.MinorUnits()))
+	}
+	if total.Sign() == 0 {
+		return shares
+	}
+
+	remaining := amount.MinorUnits()
+	for i, weight := range weights {
+		share := new(big.Int).Mul(big.NewInt(amount.MinorUnits()), big.NewInt(weight.MinorUnits()))
+		share.Quo(share, total)
+		shares[i] = NewMoney(share.Int64(), amount.Currency())
+		remaining -= share.Int64()
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return weights[order[a]].MinorUnits() > weights[order[b]].MinorUnits() })
+	for i := 0; remaining > 0; i = (i + 1) % len(order) {
+		shares[order[i]] = NewMoney(shares[order[i]].MinorUnits()+1, amount.Currency())
+		remaining--
+	}
+	return shares
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func testCalculator() OrderCalculator {
+	return NewOrderCalculator(
+		[]LinePromotion{
+			BuyXGetY{ProductId: 102, Buy: 2, Free: 1},
+			TieredDiscount{ProductId: 101, Tiers: []QuantityTier{{MinQuantity: 5, Percent: 5}, {MinQuantity: 10, Percent: 12}}},
+		},
+		[]Coupon{
+			{Code: "WELCOME10", Percent: 10, Stackable: true},
+			{Code: "SAVE5", Amount: NewMoney(500, "USD"), MinSubtotal: NewMoney(5000, "USD"), Stackable: true},
+			{Code: "BIG100", Amount: NewMoney(10000, "USD"), Stackable: true},
+			{Code: "HALFOFF", Percent: 50},
+		},
+	)
+}
+
+func TestBestLinePromotionWins(t *testing.T) {
+	widget := NewProduct(101, "Widget", NewMoney(1000, "USD"))
+	gadget := NewProduct(102, "Gadget", NewMoney(1250, "USD"))
+
+	tests := []struct {
+		name      string
+		line      *OrderLine
+		discount  int64
+		promotion string
+	}{
+		{"free unit beats the line discount", NewOrderLine(gadget, 3, 0, 10), 1250, "buy 2 get 1 free"},
+		{"free units only for complete sets", NewOrderLine(gadget, 5, 0, 0), 1250, "buy 2 get 1 free"},
+		{"highest tier reached", NewOrderLine(widget, 10, 0, 10), 1200, "volume discount"},
+		{"line discount beats the tier", NewOrderLine(widget, 10, 0, 20), 2000, "line discount"},
+		{"no promotion applies", NewOrderLine(widget, 1, 0, 0), 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			totals, err := testCalculator().CalculateTotals(NewOrderDetails([]*OrderLine{tt.line}, nil, "standard", "card", ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			line := totals.Lines[0]
+			if line.LineDiscount.MinorUnits() != tt.discount || line.Promotion != tt.promotion {
+				t.Errorf("discount %s %q, want %d cents %q", line.LineDiscount.Format(), line.Promotion, tt.discount, tt.promotion)
+			}
+		})
+	}
+}
+
+func TestCouponStacking(t *testing.T) {
+	a := NewProduct(1, "A", NewMoney(1000, "USD"))
+	b := NewProduct(2, "B", NewMoney(2000, "USD"))
+	lines := func(discountPercent float64) []*OrderLine {
+		return []*OrderLine{NewOrderLine(a, 3, 0, discountPercent), NewOrderLine(b, 1, 0, 0)}
+	}
+
+	tests := []struct {
+		name     string
+		lines    []*OrderLine
+		codes    []string
+		discount int64
+		coupons  []string
+		err      error
+	}{
+		{"percent taken before a fixed amount", lines(0), []string{"SAVE5", "WELCOME10"}, 1000, []string{"WELCOME10", "SAVE5"}, nil},
+		{"repeated codes count once", lines(0), []string{"welcome10", " WELCOME10 ", ""}, 500, []string{"WELCOME10"}, nil},
+		{"fixed amount capped at the order", lines(0), []string{"BIG100"}, 5000, []string{"BIG100"}, nil},
+		{"non-stackable coupon alone", lines(0), []string{"HALFOFF"}, 2500, []string{"HALFOFF"}, nil},
+		{"non-stackable coupon with another", lines(0), []string{"HALFOFF", "WELCOME10"}, 0, nil, ErrCouponConflict},
+		{"minimum checked after line discounts", lines(10), []string{"SAVE5"}, 0, nil, ErrCouponNotEligible},
+		{"unknown code", lines(0), []string{"FREE"}, 0, nil, ErrUnknownCoupon},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			totals, err := testCalculator().CalculateTotals(NewOrderDetails(tt.lines, tt.codes, "standard", "card", ""))
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if totals.DiscountAmount.MinorUnits() != tt.discount {
+				t.Errorf("discount = %s, want %d cents", totals.DiscountAmount.Format(), tt.discount)
+			}
+			if len(totals.Coupons) != len(tt.coupons) {
+				t.Fatalf("coupons = %v, want %v", totals.Coupons, tt.coupons)
+			}
+			for i := range tt.coupons {
+				if totals.Coupons[i] != tt.coupons[i] {
+					t.Errorf("coupons = %v, want %v", totals.Coupons, tt.coupons)
+				}
+			}
+		})
+	}
+}
+
+func TestCouponSharesAddUpToTheDiscount(t *testing.T) {
+	var lines []*OrderLine
+	for id := 1; id <= 3; id++ {
+		lines = append(lines, NewOrderLine(NewProduct(id, "Item", NewMoney(333, "USD")), 1, 8.25, 0))
+	}
+	totals, err := testCalculator().CalculateTotals(NewOrderDetails(lines, []string{"WELCOME10"}, "standard", "card", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares := NewMoney(0, "USD")
+	for _, line := range totals.Lines {
+		if shares, err = shares.Add(line.OrderDiscount); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if shares != totals.DiscountAmount || shares.MinorUnits() != 100 {
+		t.Errorf("line shares add up to %s, order discount is %s, want $1.00", shares.Format(), totals.DiscountAmount.Format())
+	}
+}
+
+func TestAllocateRounding(t *testing.T) {
+	cents := func(amounts ...int64) []Money {
+		var money []Money
+		for _, amount := range amounts {
+			money = append(money, NewMoney(amount, "USD"))
+		}
+		return money
+	}
+
+	tests := []struct {
+		name    string
+		amount  int64
+		weights []Money
+		want    []int64
+	}{
+		{"exact split", 1000, cents(100, 200, 700), []int64{100, 200, 700}},
+		{"leftover cent to the first of equal lines", 100, cents(300, 300, 300), []int64{34, 33, 33}},
+		{"leftover cents to the largest lines first", 5, cents(333, 333, 334), []int64{2, 1, 2}},
+		{"zero weight gets nothing", 99, cents(0, 500), []int64{0, 99}},
+		{"nothing to weigh by", 100, cents(0, 0), []int64{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shares := allocate(NewMoney(tt.amount, "USD"), tt.weights)
+			for i, share := range shares {
+				if share.MinorUnits() != tt.want[i] {
+					t.Errorf("share %d = %d, want %d", i, share.MinorUnits(), tt.want[i])
+				}
+			}
+		})
+	}
+}
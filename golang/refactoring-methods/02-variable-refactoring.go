@@ -186,33 +186,52 @@ func (a AccountBefore) CalculateInterest(principal, rate, time, compoundingFrequ
 	return interest
 }
 
-// AFTER: Extract to a method object
+// AFTER: Extract to a method object. The formula itself becomes a
+// strategy with the same shape as the InterestModel in
+// divergent-modifications, so compound, simple or continuous interest can
+// be swapped without touching the method object.
+type InterestModel interface {
+	Interest(principal, annualRate, years float64) float64
+}
+
+// CompoundInterest compounds PeriodsPerYear times a year (12 for monthly,
+// 365 for daily).
+type CompoundInterest struct {
+	PeriodsPerYear int
+}
+
+func (ci CompoundInterest) Interest(principal, annualRate, years float64) float64 {
+	periods := float64(ci.PeriodsPerYear)
+	if periods <= 0 {
+		periods = 1
+	}
+	return principal * (math.Pow(1+annualRate/periods, periods*years) - 1)
+}
+
 type InterestCalculation struct {
-	principal            float64
-	rate                 float64
-	time                 float64
-	compoundingFrequency float64
+	principal float64
+	rate      float64
+	years     float64
+	model     InterestModel
 }
 
-func NewInterestCalculation(principal, rate, time, compoundingFrequency float64) *InterestCalculation {
+func NewInterestCalculation(principal, rate, years float64, model InterestModel) *InterestCalculation {
 	return &InterestCalculation{
-		principal:            principal,
-		rate:                 rate,
-		time:                 time,
-		compoundingFrequency: compoundingFrequency,
+		principal: principal,
+		rate:      rate,
+		years:     years,
+		model:     model,
 	}
 }
 
 func (ic InterestCalculation) Calculate() float64 {
-	amount := ic.principal * math.Pow(1+(ic.rate/ic.compoundingFrequency),
-		ic.compoundingFrequency*ic.time)
-	return amount - ic.principal
+	return ic.model.Interest(ic.principal, ic.rate, ic.years)
 }
 
 type AccountAfter struct{}
 
-func (a AccountAfter) CalculateInterest(principal, rate, time, compoundingFrequency float64) float64 {
-	calculation := NewInterestCalculation(principal, rate, time, compoundingFrequency)
+func (a AccountAfter) CalculateInterest(principal, rate, years float64, compoundingFrequency int) float64 {
+	calculation := NewInterestCalculation(principal, rate, years, CompoundInterest{PeriodsPerYear: compoundingFrequency})
 	return calculation.Calculate()
 }
 
@@ -269,7 +288,7 @@ func demonstrateVariableRefactoring() {
 	accountBefore := AccountBefore{}
 	accountAfter := AccountAfter{}
 
-	principal, rate, time, freq := 1000.0, 0.05, 2.0, 12.0
-	fmt.Printf("Interest before: $%.2f\n", accountBefore.CalculateInterest(principal, rate, time, freq))
-	fmt.Printf("Interest after: $%.2f\n", accountAfter.CalculateInterest(principal, rate, time, freq))
+	principal, rate, years, freq := 1000.0, 0.05, 2.0, 12
+	fmt.Printf("Interest before: $%.2f\n", accountBefore.CalculateInterest(principal, rate, years, float64(freq)))
+	fmt.Printf("Interest after: $%.2f\n", accountAfter.CalculateInterest(principal, rate, years, freq))
 }